@@ -0,0 +1,146 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	appealDataKey        = "appeal.json"
+	appealNamespaceLabel = "namespace-guard.io/appealed-namespace"
+	appealNamePrefix     = "appeal-"
+)
+
+// appealTicket records a request to review a denied namespace deletion
+type appealTicket struct {
+	Ticket    string    `json:"ticket"`
+	Namespace string    `json:"namespace"`
+	User      string    `json:"user"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// newTicketID returns a random ticket ID, valid in a ConfigMap name
+func newTicketID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// appealHint returns the sentence appended to the denials pointing to the /appeal endpoint, empty
+// unless --appealURL is set
+func appealHint(namespace string) string {
+	if *appealURL == "" {
+		return ""
+	}
+	return fmt.Sprintf(" To request a review by the cluster administrators, POST a reason to %s/appeal?namespace=%s",
+		strings.TrimSuffix(*appealURL, "/"), url.QueryEscape(namespace))
+}
+
+// createAppeal stores the appeal in a ConfigMap of the appeal namespace named after its ticket
+func createAppeal(appeal appealTicket, appealNamespace string) error {
+	data, err := json.Marshal(appeal)
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      appealNamePrefix + appeal.Ticket,
+			Namespace: appealNamespace,
+			Labels:    map[string]string{appealNamespaceLabel: appeal.Namespace},
+		},
+		Data: map[string]string{appealDataKey: string(data)},
+	}
+	_, err = clientset.CoreV1().ConfigMaps(appealNamespace).Create(configMap)
+	return err
+}
+
+// getAppeal reads back the appeal of the ticket from the appeal namespace
+func getAppeal(ticket, appealNamespace string) (appealTicket, error) {
+	appeal := appealTicket{}
+	configMap, err := clientset.CoreV1().ConfigMaps(appealNamespace).Get(appealNamePrefix+ticket, v1.GetOptions{})
+	if err != nil {
+		return appeal, err
+	}
+	err = json.Unmarshal([]byte(configMap.Data[appealDataKey]), &appeal)
+	return appeal, err
+}
+
+// appealHandler serves the /appeal endpoint recording an appeal of the authenticated user for the
+// namespace= and reason= parameters on POST and returning its ticket, and reading back the appeal of
+// the ticket= parameter on GET. It is only enabled with --appealNamespace.
+func appealHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+
+	appealNamespace := *appealNamespace
+	if appealNamespace == "" {
+		writeJSON(rw, http.StatusNotFound, explainError{"The appeal endpoint is not enabled"})
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		writeJSON(rw, http.StatusMethodNotAllowed, explainError{fmt.Sprintf("Incoming request method %s is not supported, only GET and POST are supported", req.Method)})
+		return
+	}
+	user, err := authenticateRequest(req)
+	if err != nil {
+		log.Warnf("Rejected the appeal request from %s: %s", req.RemoteAddr, err.Error())
+		writeJSON(rw, http.StatusUnauthorized, explainError{err.Error()})
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		ticket := req.FormValue("ticket")
+		if ticket == "" {
+			writeJSON(rw, http.StatusBadRequest, explainError{"The ticket parameter is required"})
+			return
+		}
+		appeal, err := getAppeal(ticket, appealNamespace)
+		switch {
+		case apiErrors.IsNotFound(err):
+			writeJSON(rw, http.StatusNotFound, explainError{fmt.Sprintf("Appeal %s not found", ticket)})
+		case err != nil:
+			writeJSON(rw, http.StatusInternalServerError, explainError{fmt.Sprintf("Error occurred while reading the appeal %s: %s", ticket, err.Error())})
+		default:
+			writeJSON(rw, http.StatusOK, appeal)
+		}
+		return
+	}
+
+	namespace, reason := req.FormValue("namespace"), strings.TrimSpace(req.FormValue("reason"))
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		writeJSON(rw, http.StatusBadRequest, explainError{fmt.Sprintf("Invalid namespace %q: %s", namespace, strings.Join(errs, ", "))})
+		return
+	}
+	if reason == "" {
+		writeJSON(rw, http.StatusBadRequest, explainError{"The reason parameter is required"})
+		return
+	}
+	ticket, err := newTicketID()
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, explainError{fmt.Sprintf("Error occurred while generating the ticket: %s", err.Error())})
+		return
+	}
+	appeal := appealTicket{Ticket: ticket, Namespace: namespace, User: user, Reason: reason, Timestamp: time.Now().UTC()}
+	if err := createAppeal(appeal, appealNamespace); err != nil {
+		log.Errorf("Error occurred while recording the appeal of %s for namespace %s: %s", user, namespace, err.Error())
+		writeJSON(rw, http.StatusInternalServerError, explainError{fmt.Sprintf("Error occurred while recording the appeal: %s", err.Error())})
+		return
+	}
+	log.Warnf("Appeal %s recorded by %s for the deletion of namespace %s: %s", ticket, user, namespace, reason)
+	writeJSON(rw, http.StatusCreated, appeal)
+}
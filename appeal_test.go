@@ -0,0 +1,88 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// appealRequest sends a request to /appeal with the query and bearer token
+func appealRequest(method, query, token string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "http://localhost:8080/appeal?"+query, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	appealHandler(rw, req)
+	return rw
+}
+
+func TestAppealHandlerDisabled(t *testing.T) {
+	rw := appealRequest("POST", "namespace=test-namespace&reason=test", "alice-token")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestAppealHandler(t *testing.T) {
+	waitForBackgroundTasks(t)
+	*appealNamespace = "kube-system"
+	defer func() { *appealNamespace = "" }()
+	clientset = tokenReviewClientset(map[string]string{"alice-token": "alice"})
+
+	rw := appealRequest("POST", "namespace=test-namespace&reason=test", "")
+	assert.Equal(t, http.StatusUnauthorized, rw.Code, "should require a bearer token")
+	rw = appealRequest("POST", "namespace=Not_A_Namespace&reason=test", "alice-token")
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	rw = appealRequest("POST", "namespace=test-namespace", "alice-token")
+	assert.Equal(t, http.StatusBadRequest, rw.Code, "should require a reason")
+
+	rw = appealRequest("POST", "namespace=test-namespace&reason=the+pods+are+leftovers", "alice-token")
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	created := appealTicket{}
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&created))
+	assert.Regexp(t, "^[0-9a-f]{16}$", created.Ticket)
+	assert.Equal(t, "test-namespace", created.Namespace)
+	assert.Equal(t, "alice", created.User)
+	assert.Equal(t, "the pods are leftovers", created.Reason)
+
+	configMaps, err := clientset.CoreV1().ConfigMaps("kube-system").List(v1.ListOptions{LabelSelector: appealNamespaceLabel + "=test-namespace"})
+	assert.Nil(t, err)
+	assert.Len(t, configMaps.Items, 1, "should record the appeal for the admins to review")
+
+	rw = appealRequest("GET", "ticket="+created.Ticket, "alice-token")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	read := appealTicket{}
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&read))
+	assert.Equal(t, created.Ticket, read.Ticket)
+	assert.Equal(t, created.Reason, read.Reason)
+	assert.True(t, created.Timestamp.Equal(read.Timestamp))
+
+	rw = appealRequest("GET", "ticket=0123456789abcdef", "alice-token")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestAppealHint(t *testing.T) {
+	assert.Equal(t, "", appealHint("test-namespace"))
+
+	*appealURL = "https://namespace-guard.example.com/"
+	defer func() { *appealURL = "" }()
+	assert.Equal(t, " To request a review by the cluster administrators, POST a reason to https://namespace-guard.example.com/appeal?namespace=test-namespace",
+		appealHint("test-namespace"))
+
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(testPod, cloneNamespace(templateNamespace))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+	status := getAdmissionReview(rw).Status
+	assert.False(t, status.Allowed)
+	assert.True(t, strings.HasSuffix(status.Result.Message, appealHint("test-namespace")), "should point the denials to the appeal endpoint")
+}
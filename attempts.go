@@ -0,0 +1,176 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	attemptDecisionAllowed = "Allowed"
+	attemptDecisionDenied  = "Denied"
+)
+
+var (
+	// attemptResource is the cluster-scoped resource of the NamespaceDeletionAttempt objects, defined
+	// by the CRD of example/namespacedeletionattempts-crd.yaml
+	attemptResource = schema.GroupVersionResource{Group: "namespace-guard.io", Version: "v1alpha1", Resource: "namespacedeletionattempts"}
+
+	// attempts records the deletion attempts as NamespaceDeletionAttempt objects, nil unless
+	// --recordDeletionAttempts is set
+	attempts *attemptRecorder
+)
+
+// namespaceDeletionAttempt is a NamespaceDeletionAttempt object recording a namespace deletion and the
+// decision of the guard
+type namespaceDeletionAttempt struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+	Spec          namespaceDeletionAttemptSpec   `json:"spec"`
+	Status        namespaceDeletionAttemptStatus `json:"status"`
+}
+
+// namespaceDeletionAttemptSpec is who tried to delete which namespace and when
+type namespaceDeletionAttemptSpec struct {
+	Namespace string  `json:"namespace"`
+	User      string  `json:"user"`
+	Timestamp v1.Time `json:"timestamp"`
+}
+
+// namespaceDeletionAttemptStatus is what the guard decided
+type namespaceDeletionAttemptStatus struct {
+	// Decision is either Allowed or Denied
+	Decision string `json:"decision"`
+	// Reasons are the rejection message or the warning of the allowed deletion, if any
+	Reasons []string `json:"reasons,omitempty"`
+	// Bypass is the bypass used to allow the deletion, if any
+	Bypass string `json:"bypass,omitempty"`
+}
+
+// newNamespaceDeletionAttempt returns the attempt object of the decision, named after the namespace by
+// the apiserver
+func newNamespaceDeletionAttempt(namespace, user string, allowed bool, reason, bypass string, now time.Time) namespaceDeletionAttempt {
+	attempt := namespaceDeletionAttempt{
+		TypeMeta:   v1.TypeMeta{Kind: "NamespaceDeletionAttempt", APIVersion: attemptResource.GroupVersion().String()},
+		ObjectMeta: v1.ObjectMeta{GenerateName: namespace + "-"},
+		Spec:       namespaceDeletionAttemptSpec{Namespace: namespace, User: user, Timestamp: v1.NewTime(now.UTC())},
+		Status:     namespaceDeletionAttemptStatus{Decision: attemptDecisionDenied, Bypass: bypass},
+	}
+	if allowed {
+		attempt.Status.Decision = attemptDecisionAllowed
+	}
+	if reason != "" {
+		attempt.Status.Reasons = []string{reason}
+	}
+	return attempt
+}
+
+// attemptRecorder creates the NamespaceDeletionAttempt objects and prunes them past their retention
+type attemptRecorder struct {
+	clients dynamic.ClientPool
+	// maxAge is the age past which the attempts are pruned, none when 0
+	maxAge time.Duration
+	// maxCount is the number of attempts kept, the oldest being pruned first, all when 0
+	maxCount int
+	now      func() time.Time
+}
+
+// resourceClient returns the client of the NamespaceDeletionAttempt objects
+func (r *attemptRecorder) resourceClient() (*dynamic.ResourceClient, error) {
+	client, err := r.clients.ClientForGroupVersionResource(attemptResource)
+	if err != nil {
+		return nil, err
+	}
+	return client.Resource(&v1.APIResource{Name: attemptResource.Resource, Namespaced: false}, ""), nil
+}
+
+// create creates the attempt object
+func (r *attemptRecorder) create(attempt namespaceDeletionAttempt) error {
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(data, &obj.Object); err != nil {
+		return err
+	}
+	client, err := r.resourceClient()
+	if err != nil {
+		return err
+	}
+	_, err = client.Create(obj)
+	return err
+}
+
+// record creates the attempt object of the decision in the background, so that neither its latency
+// nor its errors, which are only logged, affect the admission response
+func (r *attemptRecorder) record(namespace, user string, allowed bool, reason, bypass string) {
+	attempt := newNamespaceDeletionAttempt(namespace, user, allowed, reason, bypass, r.now())
+	runInBackground(func() {
+		if err := r.create(attempt); err != nil {
+			log.Errorf("Error occurred while recording the deletion attempt of namespace %s by %s: %s", namespace, user, err.Error())
+		}
+	})
+}
+
+// prune deletes the attempts older than maxAge and the oldest ones beyond maxCount, returning the
+// number deleted
+func (r *attemptRecorder) prune() (int, error) {
+	client, err := r.resourceClient()
+	if err != nil {
+		return 0, err
+	}
+	obj, err := client.List(v1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	list, ok := obj.(*unstructured.UnstructuredList)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %T listing %s", obj, attemptResource.Resource)
+	}
+
+	items := list.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].GetCreationTimestamp().Time.After(items[j].GetCreationTimestamp().Time)
+	})
+	pruned := 0
+	for i, item := range items {
+		expired := r.maxAge > 0 && r.now().Sub(item.GetCreationTimestamp().Time) > r.maxAge
+		if !expired && (r.maxCount <= 0 || i < r.maxCount) {
+			continue
+		}
+		if err := client.Delete(item.GetName(), &v1.DeleteOptions{}); err != nil {
+			log.Errorf("Error occurred while pruning the deletion attempt %s: %s", item.GetName(), err.Error())
+			continue
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// run prunes the attempts every interval until the stop channel is closed
+func (r *attemptRecorder) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pruned, err := r.prune()
+			if err != nil {
+				log.Errorf("Error occurred while listing the deletion attempts to prune: %s", err.Error())
+			} else if pruned > 0 {
+				log.Infof("Pruned %d deletion attempts", pruned)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+const attemptsPath = "/apis/namespace-guard.io/v1alpha1/namespacedeletionattempts"
+
+// fakeAttemptServer stores the NamespaceDeletionAttempt objects created, listed and deleted through it
+type fakeAttemptServer struct {
+	sync.Mutex
+	objects map[string]map[string]interface{}
+	created int
+	// fail makes every request fail with an internal error
+	fail bool
+}
+
+func (s *fakeAttemptServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	rw.Header().Set("Content-Type", "application/json")
+	if s.fail {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(rw, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"etcd is down","code":500}`)
+		return
+	}
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == attemptsPath:
+		items := []map[string]interface{}{}
+		for _, obj := range s.objects {
+			items = append(items, obj)
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"apiVersion": "namespace-guard.io/v1alpha1", "kind": "NamespaceDeletionAttemptList", "metadata": map[string]interface{}{}, "items": items,
+		})
+	case req.Method == http.MethodPost && req.URL.Path == attemptsPath:
+		obj := map[string]interface{}{}
+		json.NewDecoder(req.Body).Decode(&obj)
+		s.created++
+		metadata := obj["metadata"].(map[string]interface{})
+		metadata["name"] = fmt.Sprintf("%s%d", metadata["generateName"], s.created)
+		metadata["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+		s.objects[metadata["name"].(string)] = obj
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(obj)
+	case req.Method == http.MethodDelete && strings.HasPrefix(req.URL.Path, attemptsPath+"/"):
+		delete(s.objects, strings.TrimPrefix(req.URL.Path, attemptsPath+"/"))
+		fmt.Fprint(rw, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+// add stores an attempt of the namespace created at the time
+func (s *fakeAttemptServer) add(name string, created time.Time) {
+	s.objects[name] = map[string]interface{}{
+		"apiVersion": "namespace-guard.io/v1alpha1",
+		"kind":       "NamespaceDeletionAttempt",
+		"metadata":   map[string]interface{}{"name": name, "creationTimestamp": created.UTC().Format(time.RFC3339)},
+	}
+}
+
+// names returns the sorted names of the attempts stored
+func (s *fakeAttemptServer) names() []string {
+	s.Lock()
+	defer s.Unlock()
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTestAttemptRecorder returns a recorder of the attempts to the fake server, and the function
+// shutting it down
+func newTestAttemptRecorder(now time.Time) (*attemptRecorder, *fakeAttemptServer, func()) {
+	fakeServer := &fakeAttemptServer{objects: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(fakeServer)
+	recorder := &attemptRecorder{
+		clients: dynamic.NewDynamicClientPool(&rest.Config{Host: server.URL}),
+		now:     func() time.Time { return now },
+	}
+	return recorder, fakeServer, server.Close
+}
+
+func TestNewNamespaceDeletionAttempt(t *testing.T) {
+	now := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	attempt := newNamespaceDeletionAttempt("test-namespace", "alice", true, "", bypassAnnotation, now)
+	assert.Equal(t, "NamespaceDeletionAttempt", attempt.Kind)
+	assert.Equal(t, "namespace-guard.io/v1alpha1", attempt.APIVersion)
+	assert.Equal(t, "test-namespace-", attempt.GenerateName)
+	assert.Equal(t, namespaceDeletionAttemptSpec{Namespace: "test-namespace", User: "alice", Timestamp: attempt.Spec.Timestamp}, attempt.Spec)
+	assert.True(t, now.Equal(attempt.Spec.Timestamp.Time))
+	assert.Equal(t, namespaceDeletionAttemptStatus{Decision: attemptDecisionAllowed, Bypass: bypassAnnotation}, attempt.Status)
+
+	attempt = newNamespaceDeletionAttempt("test-namespace", "alice", false, "it holds pods", "", now)
+	assert.Equal(t, namespaceDeletionAttemptStatus{Decision: attemptDecisionDenied, Reasons: []string{"it holds pods"}}, attempt.Status)
+}
+
+func TestAttemptsWebhookHandler(t *testing.T) {
+	recorder, fakeServer, shutdown := newTestAttemptRecorder(time.Now())
+	defer shutdown()
+	attempts = recorder
+	defer func() { attempts = nil }()
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+	assert.False(t, getAdmissionReview(rw).Status.Allowed)
+	waitForBackgroundTasks(t)
+
+	if assert.Equal(t, []string{"test-namespace-1"}, fakeServer.names()) {
+		attempt := namespaceDeletionAttempt{}
+		data, _ := json.Marshal(fakeServer.objects["test-namespace-1"])
+		assert.Nil(t, json.Unmarshal(data, &attempt))
+		assert.Equal(t, "test-namespace", attempt.Spec.Namespace)
+		assert.Equal(t, attemptDecisionDenied, attempt.Status.Decision)
+		if assert.Len(t, attempt.Status.Reasons, 1) {
+			assert.Contains(t, attempt.Status.Reasons[0], "these resources: [pods(1)]")
+		}
+		assert.Empty(t, attempt.Status.Bypass)
+	}
+
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true"}
+	clientset = fake.NewSimpleClientset(testNamespace)
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed)
+	waitForBackgroundTasks(t)
+
+	assert.Len(t, fakeServer.names(), 2)
+	status := fakeServer.objects["test-namespace-2"]["status"].(map[string]interface{})
+	assert.Equal(t, attemptDecisionAllowed, status["decision"])
+	assert.Equal(t, bypassAnnotation, status["bypass"], "should record the bypass used")
+}
+
+func TestAttemptsFailureWebhookHandler(t *testing.T) {
+	recorder, fakeServer, shutdown := newTestAttemptRecorder(time.Now())
+	defer shutdown()
+	fakeServer.fail = true
+	attempts = recorder
+	defer func() { attempts = nil }()
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+	waitForBackgroundTasks(t)
+
+	admReview := getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should not fail the admission when the attempt can't be recorded")
+	assert.Empty(t, fakeServer.names())
+}
+
+func TestPruneAttempts(t *testing.T) {
+	now := time.Date(2017, 9, 30, 12, 0, 0, 0, time.UTC)
+	recorder, fakeServer, shutdown := newTestAttemptRecorder(now)
+	defer shutdown()
+	fakeServer.add("expired", now.Add(-48*time.Hour))
+	fakeServer.add("oldest", now.Add(-3*time.Hour))
+	fakeServer.add("older", now.Add(-2*time.Hour))
+	fakeServer.add("newest", now.Add(-time.Hour))
+
+	recorder.maxAge = 24 * time.Hour
+	pruned, err := recorder.prune()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pruned, "should prune the attempts older than the max age")
+	assert.Equal(t, []string{"newest", "older", "oldest"}, fakeServer.names())
+
+	recorder.maxCount = 2
+	pruned, err = recorder.prune()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pruned, "should prune the oldest attempts beyond the max count")
+	assert.Equal(t, []string{"newest", "older"}, fakeServer.names())
+
+	recorder.maxAge, recorder.maxCount = 0, 0
+	pruned, err = recorder.prune()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pruned, "should keep every attempt without a retention")
+
+	fakeServer.fail = true
+	_, err = recorder.prune()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// degradedMessage is the message of the decisions made while the circuit is open
+	degradedMessage = "guard degraded: apiserver unreachable"
+
+	// the degraded decisions of --circuitBreakerDecision
+	degradedFailOpen   = "failOpen"
+	degradedFailClosed = "failClosed"
+)
+
+// circuitState is the state of the circuit breaker, as reported by the circuit_breaker_state gauge
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+var (
+	// breaker short circuits the apiserver calls while it is unreachable, nil when
+	// --circuitBreakerFailures is not set
+	breaker *circuitBreaker
+)
+
+// circuitBreaker opens after consecutive apiserver failures within the window, so that the admission
+// requests are answered without waiting on a sick apiserver. Once open, a single request is let
+// through every probe interval to find out whether the apiserver is reachable again.
+type circuitBreaker struct {
+	sync.Mutex
+	threshold     int
+	window        time.Duration
+	probeInterval time.Duration
+	now           func() time.Time
+	state         circuitState
+	failures      int
+	// firstFailure is the time of the first of the consecutive failures
+	firstFailure time.Time
+	openedAt     time.Time
+}
+
+// newCircuitBreaker returns a breaker opening after threshold consecutive failures within the window
+// and probing the apiserver every probeInterval while open
+func newCircuitBreaker(threshold int, window, probeInterval time.Duration, now func() time.Time) *circuitBreaker {
+	circuitBreakerState.Set(float64(circuitClosed))
+	return &circuitBreaker{threshold: threshold, window: window, probeInterval: probeInterval, now: now}
+}
+
+// allow returns true if the request may call the apiserver, which is always the case while closed.
+// While open, only the first request after the probe interval is allowed, as the probe whose outcome
+// closes or reopens the circuit.
+func (b *circuitBreaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.probeInterval {
+			return false
+		}
+		log.Infof("Probing the apiserver after the circuit was open for %v.", b.now().Sub(b.openedAt))
+		b.setState(circuitHalfOpen)
+		return true
+	case circuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+// record records the outcome of an allowed apiserver call. Only transient errors count as failures,
+// any other response shows the apiserver is reachable and closes the circuit.
+func (b *circuitBreaker) record(err error) {
+	b.Lock()
+	defer b.Unlock()
+	if err == nil || !isTransientError(err) {
+		if b.state != circuitClosed {
+			log.Warnf("The apiserver is reachable again, closing the circuit.")
+		}
+		b.failures, b.firstFailure = 0, time.Time{}
+		b.setState(circuitClosed)
+		return
+	}
+
+	now := b.now()
+	switch b.state {
+	case circuitHalfOpen:
+		log.Errorf("The apiserver probe failed: %s. Keeping the circuit open for another %v.", err.Error(), b.probeInterval)
+		b.openedAt = now
+		b.setState(circuitOpen)
+	case circuitClosed:
+		if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+			b.failures, b.firstFailure = 0, now
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			log.Errorf("The apiserver failed %d consecutive times since %s, opening the circuit: %s.",
+				b.failures, b.firstFailure.UTC().Format(time.RFC3339), err.Error())
+			b.openedAt = now
+			b.setState(circuitOpen)
+		}
+	}
+}
+
+// setState changes the state and its gauge, the lock must be held
+func (b *circuitBreaker) setState(state circuitState) {
+	b.state = state
+	circuitBreakerState.Set(float64(state))
+}
+
+// current returns the state of the circuit
+func (b *circuitBreaker) current() circuitState {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// degradedDecision returns the --circuitBreakerDecision made while the circuit is open
+func degradedDecision() admissionDecision {
+	if *circuitBreakerDecision == degradedFailOpen {
+		return allow(degradedMessage)
+	}
+	return internalError(degradedMessage)
+}
+
+// readyzHandler serves the /readyz response which is 503 while the circuit breaker is open or probing,
+// or with --rbacReadiness while permissions are missing
+func readyzHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Debugf("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+	if breaker != nil {
+		if state := breaker.current(); state != circuitClosed {
+			http.Error(rw, degradedMessage+", the circuit breaker is "+state.String(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if rbacGate != nil {
+		if err := rbacGate.ready(); err != nil {
+			http.Error(rw, "not ready, "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if spiffeSource != nil {
+		if err := spiffeSource.ready(); err != nil {
+			http.Error(rw, "not ready, "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	io.WriteString(rw, "OK")
+}
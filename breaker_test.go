@@ -0,0 +1,129 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func circuitBreakerStateValue() float64 {
+	m := &dto.Metric{}
+	circuitBreakerState.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(3, time.Minute, 10*time.Second, func() time.Time { return now })
+	unreachable := errors.New("dial tcp 10.0.0.1:443: connection refused")
+
+	b.record(unreachable)
+	b.record(unreachable)
+	now = now.Add(2 * time.Minute)
+	b.record(unreachable)
+	assert.True(t, b.allow(), "should not open on failures spread across windows")
+
+	b.record(unreachable)
+	b.record(apiErrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "test-namespace"))
+	b.record(unreachable)
+	b.record(unreachable)
+	assert.True(t, b.allow(), "should not open when a response breaks the consecutive failures")
+
+	b.record(unreachable)
+	assert.Equal(t, circuitOpen, b.current(), "should open after the consecutive failures within the window")
+	assert.Equal(t, float64(circuitOpen), circuitBreakerStateValue())
+	assert.False(t, b.allow(), "should short circuit while open")
+
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.allow(), "should let a probe through after the probe interval")
+	assert.Equal(t, circuitHalfOpen, b.current())
+	assert.False(t, b.allow(), "should only let a single probe through")
+	b.record(unreachable)
+	assert.Equal(t, circuitOpen, b.current(), "should reopen when the probe fails")
+	now = now.Add(5 * time.Second)
+	assert.False(t, b.allow(), "should wait another probe interval after a failed probe")
+
+	now = now.Add(5 * time.Second)
+	assert.True(t, b.allow())
+	b.record(nil)
+	assert.Equal(t, circuitClosed, b.current(), "should close when the probe succeeds")
+	assert.Equal(t, float64(circuitClosed), circuitBreakerStateValue())
+	b.record(unreachable)
+	assert.True(t, b.allow(), "should count the failures from scratch once closed")
+}
+
+func TestCircuitBreakerWebhookHandler(t *testing.T) {
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	breaker = newCircuitBreaker(2, time.Minute, 10*time.Second, func() time.Time { return now })
+	defer func() {
+		breaker = nil
+		*circuitBreakerDecision = degradedFailClosed
+	}()
+
+	var getErr error = apiErrors.NewServiceUnavailable("apiserver is shutting down")
+	gets := 0
+	testPod := &corev1.Pod{}
+	testPod.Name = "test-pod"
+	testPod.Namespace = "test-namespace"
+	fakeClientset := fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+	fakeClientset.PrependReactor("get", "namespaces", func(action ktesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return getErr != nil, nil, getErr
+	})
+	clientset = fakeClientset
+
+	review := func() (bool, string) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		status := getAdmissionReview(rw).Status
+		if status.Result == nil {
+			return status.Allowed, ""
+		}
+		return status.Allowed, status.Result.Message
+	}
+	readyz := func() int {
+		rw := httptest.NewRecorder()
+		readyzHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/readyz", nil))
+		return rw.Code
+	}
+
+	assert.Equal(t, http.StatusOK, readyz())
+	for i := 0; i < 2; i++ {
+		allowed, message := review()
+		assert.False(t, allowed)
+		assert.Contains(t, message, "Error occurred while retrieving the namespace")
+	}
+	assert.Equal(t, 2, gets)
+	assert.Equal(t, http.StatusServiceUnavailable, readyz(), "should not be ready while the circuit is open")
+
+	allowed, message := review()
+	assert.False(t, allowed, "should fail closed by default")
+	assert.Equal(t, degradedMessage, message)
+	*circuitBreakerDecision = degradedFailOpen
+	allowed, message = review()
+	assert.True(t, allowed, "should fail open when configured")
+	assert.Equal(t, degradedMessage, message)
+	assert.Equal(t, 2, gets, "should not call the apiserver while the circuit is open")
+
+	now = now.Add(10 * time.Second)
+	getErr = nil
+	allowed, _ = review()
+	assert.False(t, allowed, "should validate the probe request")
+	assert.Equal(t, 3, gets)
+	assert.Equal(t, circuitClosed, breaker.current())
+	assert.Equal(t, http.StatusOK, readyz(), "should be ready again once the circuit is closed")
+}
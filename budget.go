@@ -0,0 +1,135 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// streamedCounter returns a counter of the resource reading the names of at most --maxCountedObjects+1
+// items as the list response is streamed, so that the memory used doesn't grow with the size of the
+// namespace. The client is resolved on every call, as the clientset may change.
+func streamedCounter(resource string, client func() rest.Interface) func(namespace string) ([]string, error) {
+	return func(namespace string) ([]string, error) {
+		options := counterListOptions()
+		body, err := client().Get().
+			Namespace(namespace).
+			Resource(resource).
+			VersionedParams(&options, scheme.ParameterCodec).
+			SetHeader("Accept", "application/json").
+			Stream()
+		if err != nil {
+			return nil, err
+		}
+		// closing the body early aborts the transfer of the remaining items
+		defer body.Close()
+		return readListNames(body, *maxCountedObjects+1)
+	}
+}
+
+// readListNames decodes the names of the items of a JSON list object one item at a time, stopping
+// once limit names are read
+func readListNames(r io.Reader, limit int) ([]string, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var names []string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "items" {
+			if err := dec.Decode(&json.RawMessage{}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if token, err := dec.Token(); err != nil || token == nil {
+			// a null list holds no items
+			return names, err
+		} else if token != json.Delim('[') {
+			return nil, fmt.Errorf("unexpected %v instead of the items of the list", token)
+		}
+		for dec.More() {
+			item := struct {
+				Metadata struct {
+					Name              string  `json:"name"`
+					CreationTimestamp v1.Time `json:"creationTimestamp"`
+				} `json:"metadata"`
+			}{}
+			if err := dec.Decode(&item); err != nil {
+				return nil, err
+			}
+			if !countedAge(item.Metadata.CreationTimestamp) {
+				continue
+			}
+			names = append(names, item.Metadata.Name)
+			if len(names) >= limit {
+				return names, nil
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// expectDelim reads the next token, failing unless it is the delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("unexpected %v instead of %v in the list", token, delim)
+	}
+	return nil
+}
+
+// streamedCounters returns the counters of the kinds holding the bulk of the objects of large
+// namespaces, replacing the regular counters when --maxCountedObjects is set
+func streamedCounters(client kubernetes.Interface) map[string]func(namespace string) ([]string, error) {
+	core := func() rest.Interface { return client.CoreV1().RESTClient() }
+	extensions := func() rest.Interface { return client.ExtensionsV1beta1().RESTClient() }
+	apps := func() rest.Interface { return client.AppsV1beta1().RESTClient() }
+	return map[string]func(namespace string) ([]string, error){
+		"pods":         streamedCounter("pods", core),
+		"services":     streamedCounter("services", core),
+		"replicasets":  streamedCounter("replicasets", extensions),
+		"daemonsets":   streamedCounter("daemonsets", extensions),
+		"deployments":  streamedCounter("deployments", apps),
+		"statefulsets": streamedCounter("statefulsets", apps),
+	}
+}
+
+// applyCountBudget truncates the finding to --maxCountedObjects names, marking it as exceeding the
+// budget if it held more
+func applyCountBudget(finding resourceFinding) resourceFinding {
+	if *maxCountedObjects > 0 && finding.Count > *maxCountedObjects {
+		finding.Names = finding.Names[:*maxCountedObjects:*maxCountedObjects]
+		finding.Count = *maxCountedObjects
+		finding.Exceeded = true
+	}
+	return finding
+}
+
+// countBudgetExceeded returns true if a kind exceeded --maxCountedObjects, or the kinds together
+// --maxTotalCountedObjects
+func countBudgetExceeded(findings []resourceFinding) bool {
+	for _, f := range findings {
+		if f.Exceeded {
+			return true
+		}
+	}
+	return *maxTotalCountedObjects > 0 && totalResourceCount(findings) > *maxTotalCountedObjects
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestReadListNames(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		list  string
+		limit int
+		names []string
+		err   bool
+	}{
+		{"items", `{"kind":"PodList","metadata":{"resourceVersion":"1"},"items":[{"metadata":{"name":"a"}},{"metadata":{"name":"b"},"spec":{}}]}`, 10, []string{"a", "b"}, false},
+		{"limit", `{"items":[{"metadata":{"name":"a"}},{"metadata":{"name":"b"}},{"metadata":{"name":"c"}}]}`, 2, []string{"a", "b"}, false},
+		{"items before metadata", `{"items":[{"metadata":{"name":"a"}}],"metadata":{}}`, 10, []string{"a"}, false},
+		{"empty", `{"items":[]}`, 10, nil, false},
+		{"null", `{"items":null}`, 10, nil, false},
+		{"not a list", `["a"]`, 10, nil, true},
+		{"truncated", `{"items":[{"metadata":{"name":"a"}},{"meta`, 10, nil, true},
+	} {
+		names, err := readListNames(strings.NewReader(c.list), c.limit)
+		assert.Equal(t, c.err, err != nil, c.name)
+		assert.Equal(t, c.names, names, c.name)
+	}
+}
+
+// hugeNamespaceServer returns an apiserver streaming the pods of a namespace holding millions of
+// them, generated as they are written, and empty lists of the other kinds. The number of pods
+// written before the client went away is counted.
+func hugeNamespaceServer(written *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/api/v1/namespaces/test-namespace":
+			io.WriteString(rw, `{"kind":"Namespace","apiVersion":"v1","metadata":{"name":"test-namespace"}}`)
+		case "/api/v1/namespaces/test-namespace/pods":
+			io.WriteString(rw, `{"kind":"PodList","apiVersion":"v1","metadata":{},"items":[`)
+			for i := 0; i < 5000000; i++ {
+				separator := ","
+				if i == 0 {
+					separator = ""
+				}
+				if _, err := fmt.Fprintf(rw, `%s{"metadata":{"name":"pod-%d","namespace":"test-namespace"}}`, separator, i); err != nil {
+					return
+				}
+				atomic.AddInt64(written, 1)
+			}
+			io.WriteString(rw, `]}`)
+		default:
+			io.WriteString(rw, `{"metadata":{},"items":[]}`)
+		}
+	}))
+}
+
+func TestStreamedCounterHugeNamespace(t *testing.T) {
+	var written int64
+	srv := hugeNamespaceServer(&written)
+	defer srv.Close()
+	*maxCountedObjects = 1000
+	defer func() { *maxCountedObjects = 0 }()
+	waitForBackgroundTasks(t)
+	realClientset, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	assert.Nil(t, err)
+	clientset = realClientset
+
+	names, err := streamedCounters(clientset)["pods"]("test-namespace")
+	assert.Nil(t, err)
+	assert.Len(t, names, 1001, "should stop reading past the budget")
+	assert.Equal(t, "pod-1000", names[1000])
+	srv.CloseClientConnections()
+	assert.True(t, atomic.LoadInt64(&written) < 5000000, "should not transfer the whole list")
+
+	findings, errList := findNamespaceResources("test-namespace")
+	assert.Empty(t, errList)
+	assert.Equal(t, resourceFinding{Kind: "pods", Count: 1000, Names: names[:1000], Exceeded: true}, findings[0])
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed)
+	assert.Contains(t, admReview.Status.Result.Message, "The namespace test-namespace you are trying to remove is far too large to delete without cleanup, it holds [pods(exceeds 1000)]. Please delete its resources and try again.")
+}
+
+func TestTotalCountBudget(t *testing.T) {
+	*maxTotalCountedObjects = 3
+	defer func() { *maxTotalCountedObjects = 0 }()
+
+	listed := []string{}
+	counter := func(names ...string) func(string) ([]string, error) {
+		return func(namespace string) ([]string, error) {
+			listed = append(listed, names...)
+			return names, nil
+		}
+	}
+	findings, _ := findResources("test-namespace", []resourceCounter{
+		{"pods", counter("a", "b")},
+		{"services", counter("c", "d")},
+		{"deployments", counter("e")},
+	})
+	assert.Len(t, findings, 2, "should stop listing once the total budget is exceeded")
+	assert.Equal(t, []string{"a", "b", "c", "d"}, listed)
+	assert.True(t, policyViolated(findings, 10))
+	err := deletionError("test-namespace", findings, nil, 10)
+	assert.Contains(t, err.Error(), "is far too large to delete without cleanup, it holds [pods(2) services(2)], more than the 3 objects counted at most.")
+}
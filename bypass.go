@@ -0,0 +1,117 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	authorizationv1 "k8s.io/client-go/pkg/apis/authorization/v1"
+)
+
+const (
+	// bypassSetByAnnotationKey optionally names the user who set the bypass annotation
+	bypassSetByAnnotationKey = "namespace-guard.io/bypass-set-by"
+	bypassUsedReason         = "NamespaceGuardBypassed"
+
+	// bypassVerb and bypassGroup are the RBAC verb and API group on namespaces authorizing a user to
+	// set the bypass annotation with --authorizeBypass
+	bypassVerb  = "bypass"
+	bypassGroup = "k8s-namespace-guard.admission.yahoo.com"
+)
+
+// bypassRecord is the audit record of a namespace deletion allowed through the bypass annotation
+type bypassRecord struct {
+	Namespace  string    `json:"namespace"`
+	User       string    `json:"user"`
+	Annotation string    `json:"annotation"`
+	Value      string    `json:"value"`
+	SetBy      string    `json:"setBy,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// newBypassRecord returns the audit record of the namespace deletion by the user
+func newBypassRecord(namespace *corev1.Namespace, user string, now time.Time) bypassRecord {
+	return bypassRecord{
+		Namespace:  namespace.Name,
+		User:       user,
+		Annotation: *bypassKey,
+		Value:      namespace.Annotations[*bypassKey],
+		SetBy:      namespace.Annotations[bypassSetByAnnotationKey],
+		Timestamp:  now.UTC(),
+	}
+}
+
+// message returns the human readable description of the bypass
+func (r bypassRecord) message() string {
+	setBy := "an unknown user"
+	if r.SetBy != "" {
+		setBy = r.SetBy
+	}
+	return fmt.Sprintf("The namespace %s was deleted by %s through the bypass annotation %s=%s set by %s.", r.Namespace, r.User, r.Annotation, r.Value, setBy)
+}
+
+// writeBypassRecord logs the audit record and creates an Event in the event namespace, if given,
+// since the bypassed namespace and its events are going away
+func writeBypassRecord(namespace *corev1.Namespace, record bypassRecord, eventNamespace string) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	log.Infof("Bypass audit record of namespace %s: %s", record.Namespace, data)
+	if eventNamespace == "" {
+		return nil
+	}
+	event := newNamespaceEvent(namespace, eventNamespace, corev1.EventTypeWarning, bypassUsedReason, record.message())
+	_, err = clientset.CoreV1().Events(eventNamespace).Create(event)
+	return err
+}
+
+// recordBypass writes the audit record of a namespace deletion allowed through the bypass annotation
+// in the background, errors are only logged
+func recordBypass(namespace *corev1.Namespace, user string) {
+	record := newBypassRecord(namespace, user, time.Now())
+	eventNamespace := *bypassEventNamespace
+	runInBackground(func() {
+		err := writeBypassRecord(namespace, record, eventNamespace)
+		if err != nil {
+			log.Errorf("Error occurred while recording the bypass of namespace %s: %s", namespace.Name, err.Error())
+		}
+	})
+}
+
+// validateBypassChange checks a namespace UPDATE by the requester comparing the old and new annotations.
+// Removing the bypass annotation restores the protection of the namespace and is always allowed, while
+// setting it lifts the protection and requires the requester to be authorized the bypass verb on the
+// namespace, as reviewed with a SubjectAccessReview.
+func validateBypassChange(oldAnnotations, newAnnotations map[string]string, namespace string, userInfo authenticationv1.UserInfo) (bool, error) {
+	wasBypassed, bypassed := hasBypassAnnotation(oldAnnotations), hasBypassAnnotation(newAnnotations)
+	if wasBypassed && !bypassed {
+		log.Infof("User %s removed the bypass annotation of namespace %s, its deletion is guarded again.", userInfo.Username, namespace)
+	}
+	if wasBypassed || !bypassed {
+		return true, nil
+	}
+	allowed, err := reviewBypassAccess(namespace, userInfo)
+	if err != nil {
+		return false, err
+	}
+	if allowed {
+		log.Warnf("User %s set the bypass annotation of namespace %s.", userInfo.Username, namespace)
+	}
+	return allowed, nil
+}
+
+// reviewBypassAccess returns true if the requester is authorized the bypass verb on the namespace
+func reviewBypassAccess(namespace string, userInfo authenticationv1.UserInfo) (bool, error) {
+	return reviewRequesterAccess(userInfo, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      bypassVerb,
+		Group:     bypassGroup,
+		Resource:  "namespaces",
+		Name:      namespace,
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	authorizationv1 "k8s.io/client-go/pkg/apis/authorization/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// bypassReviewClientset returns a clientset authorizing the bypass verb for the users only
+func bypassReviewClientset(t *testing.T, users ...string) *fake.Clientset {
+	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset.PrependReactor("create", "subjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		assert.Equal(t, authorizationv1.ResourceAttributes{
+			Namespace: "test-namespace",
+			Verb:      bypassVerb,
+			Group:     bypassGroup,
+			Resource:  "namespaces",
+			Name:      "test-namespace",
+		}, *review.Spec.ResourceAttributes)
+		for _, user := range users {
+			review.Status.Allowed = review.Status.Allowed || review.Spec.User == user
+		}
+		return true, review, nil
+	})
+	return fakeClientset
+}
+
+// waitForBypassEvent returns the bypass event created in the namespace once the background records
+// are written
+func waitForBypassEvent(t *testing.T, eventNamespace string) corev1.Event {
+	waitForBackgroundTasks(t)
+	list, err := clientset.CoreV1().Events(eventNamespace).List(v1.ListOptions{})
+	assert.Nil(t, err)
+	if len(list.Items) == 0 {
+		t.Fatal("no bypass event was created")
+	}
+	return list.Items[0]
+}
+
+func TestNewBypassRecord(t *testing.T) {
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true", bypassSetByAnnotationKey: "bob"}
+
+	record := newBypassRecord(testNamespace, "alice", now)
+	assert.Equal(t, bypassRecord{
+		Namespace:  "test-namespace",
+		User:       "alice",
+		Annotation: bypassAnnotationKey,
+		Value:      "true",
+		SetBy:      "bob",
+		Timestamp:  now,
+	}, record)
+	assert.Equal(t, "The namespace test-namespace was deleted by alice through the bypass annotation "+bypassAnnotationKey+"=true set by bob.", record.message())
+
+	delete(testNamespace.Annotations, bypassSetByAnnotationKey)
+	record = newBypassRecord(testNamespace, "alice", now)
+	assert.Equal(t, "", record.SetBy)
+	assert.Contains(t, record.message(), "set by an unknown user.")
+}
+
+func TestWriteBypassRecordAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Out
+	log.Out = &buf
+	defer func() { log.Out = out }()
+
+	clientset = fake.NewSimpleClientset()
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true", bypassSetByAnnotationKey: "bob"}
+	record := newBypassRecord(testNamespace, "alice", time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC))
+
+	err := writeBypassRecord(testNamespace, record, "")
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), `Bypass audit record of namespace test-namespace: {"namespace":"test-namespace","user":"alice","annotation":"`+bypassAnnotationKey+`","value":"true","setBy":"bob","timestamp":"2017-09-01T00:00:00Z"}`)
+
+	events, err := clientset.CoreV1().Events("").List(v1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, events.Items, "should not create an event without an event namespace")
+}
+
+func TestBypassEventWebhookHandler(t *testing.T) {
+	*bypassEventNamespace = "guard-ops"
+	defer func() { *bypassEventNamespace = "" }()
+
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true", bypassSetByAnnotationKey: "bob"}
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the bypass annotation is set")
+
+	event := waitForBypassEvent(t, "guard-ops")
+	assert.Equal(t, corev1.EventTypeWarning, event.Type)
+	assert.Equal(t, bypassUsedReason, event.Reason)
+	assert.Equal(t, "Namespace", event.InvolvedObject.Kind)
+	assert.Equal(t, "test-namespace", event.InvolvedObject.Name)
+	assert.Equal(t, "The namespace test-namespace was deleted by alice through the bypass annotation "+bypassAnnotationKey+"=true set by bob.", event.Message)
+}
+
+func TestNoBypassEventWithoutAnnotation(t *testing.T) {
+	*bypassEventNamespace = "guard-ops"
+	defer func() { *bypassEventNamespace = "" }()
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the namespace is empty")
+
+	waitForBackgroundTasks(t)
+	events, err := clientset.CoreV1().Events("guard-ops").List(v1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, events.Items, "should only record deletions allowed through the bypass annotation")
+}
+
+func TestValidateBypassChange(t *testing.T) {
+	clientset = bypassReviewClientset(t, "alice")
+	bypassed := map[string]string{bypassAnnotationKey: "true"}
+	unrelated := map[string]string{"team": "a"}
+
+	for _, c := range []struct {
+		name     string
+		old, new map[string]string
+		user     string
+		allowed  bool
+	}{
+		{"unrelated change", nil, unrelated, "bob", true},
+		{"bypass removed", bypassed, unrelated, "bob", true},
+		{"bypass kept", bypassed, bypassed, "bob", true},
+		{"bypass set without authorization", unrelated, bypassed, "bob", false},
+		{"bypass set with authorization", nil, bypassed, "alice", true},
+		{"bypass set to false", nil, map[string]string{bypassAnnotationKey: "false"}, "bob", true},
+	} {
+		userInfo := requester
+		userInfo.Username = c.user
+		allowed, err := validateBypassChange(c.old, c.new, "test-namespace", userInfo)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.allowed, allowed, c.name)
+	}
+}
+
+func TestAuthorizeBypassUpdateWebhookHandler(t *testing.T) {
+	clientset = bypassReviewClientset(t, "alice")
+	review := func(user string, oldAnnotations, newAnnotations map[string]string) *v1alpha1.AdmissionReview {
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.Operation = v1alpha1.Update
+		testSpec.Spec.UserInfo.Username = user
+		testSpec.Spec.OldObject = rawNamespace(oldAnnotations)
+		testSpec.Spec.Object = rawNamespace(newAnnotations)
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+		webhookHandler(rw, req)
+		return getAdmissionReview(rw)
+	}
+	bypassed := map[string]string{bypassAnnotationKey: "true"}
+
+	assert.True(t, review("bob", nil, bypassed).Status.Allowed, "should not review the UPDATEs unless authorizeBypass is set")
+
+	*authorizeBypass = true
+	defer func() { *authorizeBypass = false }()
+
+	admReview := review("bob", nil, bypassed)
+	assert.False(t, admReview.Status.Allowed, "should reject setting the bypass annotation without authorization")
+	assert.Equal(t, "User bob is not authorized to set the bypass annotation k8s-namespace-guard.admission.yahoo.com/allow-cascade-delete on namespace test-namespace, it requires the bypass verb on namespaces in the k8s-namespace-guard.admission.yahoo.com API group.",
+		admReview.Status.Result.Message)
+	assert.True(t, review("alice", nil, bypassed).Status.Allowed, "should allow authorized users to set the bypass annotation")
+	assert.True(t, review("bob", bypassed, nil).Status.Allowed, "should always allow removing the bypass annotation")
+}
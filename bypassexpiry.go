@@ -0,0 +1,114 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// bypassSetAtAnnotationKey is the RFC3339 time the bypass annotation was set at, from which its
+	// age is compared to --bypassMaxAge
+	bypassSetAtAnnotationKey = "namespace-guard.io/bypass-set-at"
+	bypassExpiredReason      = "NamespaceGuardBypassExpired"
+)
+
+// bypassExpirer removes the bypass annotations older than the max age, so that the namespaces are
+// guarded again once the emergency has passed
+type bypassExpirer struct {
+	maxAge time.Duration
+	dryRun bool
+	now    func() time.Time
+}
+
+// expire checks the bypass annotation of every namespace. The namespaces without the set-at annotation
+// get it set to the current time, starting the clock of their bypass. The annotations of terminating
+// namespaces are left alone, as are those whose set-at annotation is malformed.
+func (e *bypassExpirer) expire() error {
+	namespaces, err := clientset.CoreV1().Namespaces().List(v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range namespaces.Items {
+		namespace := &namespaces.Items[i]
+		if namespace.Status.Phase == corev1.NamespaceTerminating || !hasBypassAnnotation(namespace.Annotations) {
+			continue
+		}
+		setAt, ok := namespace.Annotations[bypassSetAtAnnotationKey]
+		if !ok {
+			message := fmt.Sprintf("Stamping the bypass annotation of namespace %s with %s, it expires in %v.", namespace.Name, bypassSetAtAnnotationKey, e.maxAge)
+			if e.dryRun {
+				log.Infof("Dry run: %s", message)
+			} else if e.patch(namespace, map[string]interface{}{bypassSetAtAnnotationKey: e.now().UTC().Format(time.RFC3339)}) {
+				log.Info(message)
+			}
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, setAt)
+		if err != nil {
+			log.Warnf("Not expiring the bypass annotation of namespace %s, its %s annotation %q is not an RFC3339 timestamp.", namespace.Name, bypassSetAtAnnotationKey, setAt)
+			continue
+		}
+		if e.now().Sub(t) <= e.maxAge {
+			continue
+		}
+		message := fmt.Sprintf("The bypass annotation %s of namespace %s was set on %s, more than %v ago. It was removed and the deletion of the namespace is guarded again.",
+			*bypassKey, namespace.Name, setAt, e.maxAge)
+		if e.dryRun {
+			log.Infof("Dry run: %s", message)
+		} else if e.patch(namespace, map[string]interface{}{*bypassKey: nil, bypassSetAtAnnotationKey: nil, bypassSetByAnnotationKey: nil}) {
+			log.Warn(message)
+			recordNamespaceEvent(clientset, namespace, corev1.EventTypeNormal, bypassExpiredReason, message)
+		}
+	}
+	return nil
+}
+
+// patch merges the annotations into the namespace, null values removing them, and returns true once
+// patched. The patch is conditioned on the listed resourceVersion, a namespace changed meanwhile is
+// checked again on the next round.
+func (e *bypassExpirer) patch(namespace *corev1.Namespace, annotations map[string]interface{}) bool {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": namespace.ResourceVersion,
+			"annotations":     annotations,
+		},
+	})
+	if err != nil {
+		log.Errorf("Error occurred while encoding the annotations patch of namespace %s: %s", namespace.Name, err.Error())
+		return false
+	}
+	_, err = clientset.CoreV1().Namespaces().Patch(namespace.Name, types.MergePatchType, patch)
+	if apiErrors.IsConflict(err) || apiErrors.IsNotFound(err) {
+		log.Infof("Namespace %s changed while expiring its bypass annotation, checking it again on the next round: %s", namespace.Name, err.Error())
+		return false
+	}
+	if err != nil {
+		log.Errorf("Error occurred while patching the annotations of namespace %s: %s", namespace.Name, err.Error())
+		return false
+	}
+	return true
+}
+
+// run expires the bypass annotations every interval until the stop channel is closed
+func (e *bypassExpirer) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.expire(); err != nil {
+				log.Errorf("Error occurred while listing the namespaces to expire their bypass annotations: %s", err.Error())
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestBypassExpirer(t *testing.T) {
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	namespace := func(name string, annotations map[string]string, phase corev1.NamespacePhase) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: v1.ObjectMeta{Name: name, Annotations: annotations, ResourceVersion: "7"},
+			Status:     corev1.NamespaceStatus{Phase: phase},
+		}
+	}
+	bypassed := func(setAt string) map[string]string {
+		annotations := map[string]string{bypassAnnotationKey: "true", bypassSetByAnnotationKey: "alice"}
+		if setAt != "" {
+			annotations[bypassSetAtAnnotationKey] = setAt
+		}
+		return annotations
+	}
+	fakeClientset := fake.NewSimpleClientset(
+		namespace("expired", bypassed("2017-08-01T00:00:00Z"), corev1.NamespaceActive),
+		namespace("fresh", bypassed("2017-08-31T00:00:00Z"), corev1.NamespaceActive),
+		namespace("malformed", bypassed("last tuesday"), corev1.NamespaceActive),
+		namespace("unstamped", bypassed(""), corev1.NamespaceActive),
+		namespace("terminating", bypassed("2017-08-01T00:00:00Z"), corev1.NamespaceTerminating),
+		namespace("guarded", nil, corev1.NamespaceActive),
+	)
+	patches := map[string]map[string]interface{}{}
+	fakeClientset.PrependReactor("patch", "namespaces", func(action ktesting.Action) (bool, runtime.Object, error) {
+		patch := action.(ktesting.PatchActionImpl)
+		decoded := map[string]map[string]interface{}{}
+		json.Unmarshal(patch.GetPatch(), &decoded)
+		patches[patch.GetName()] = decoded["metadata"]
+		return true, &corev1.Namespace{}, nil
+	})
+	var events []*corev1.Event
+	fakeClientset.PrependReactor("create", "events", func(action ktesting.Action) (bool, runtime.Object, error) {
+		event := action.(ktesting.CreateAction).GetObject().(*corev1.Event)
+		events = append(events, event)
+		return true, event, nil
+	})
+	clientset = fakeClientset
+	expirer := &bypassExpirer{maxAge: 7 * 24 * time.Hour, now: func() time.Time { return now }}
+
+	expirer.dryRun = true
+	assert.Nil(t, expirer.expire())
+	assert.Empty(t, patches, "should only log in dry-run mode")
+
+	expirer.dryRun = false
+	assert.Nil(t, expirer.expire())
+	assert.Len(t, patches, 2, "should leave the fresh, malformed, terminating and guarded namespaces alone")
+	assert.Equal(t, map[string]interface{}{
+		"resourceVersion": "7",
+		"annotations":     map[string]interface{}{bypassAnnotationKey: nil, bypassSetAtAnnotationKey: nil, bypassSetByAnnotationKey: nil},
+	}, patches["expired"], "should remove the expired bypass conditioned on the resource version")
+	assert.Equal(t, map[string]interface{}{
+		"resourceVersion": "7",
+		"annotations":     map[string]interface{}{bypassSetAtAnnotationKey: "2017-09-01T00:00:00Z"},
+	}, patches["unstamped"], "should stamp the bypass without set-at annotation")
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "expired", events[0].InvolvedObject.Name)
+		assert.Equal(t, bypassExpiredReason, events[0].Reason)
+		assert.Contains(t, events[0].Message, "was set on 2017-08-01T00:00:00Z, more than 168h0m0s ago")
+	}
+}
+
+func TestBypassExpirerConflict(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: v1.ObjectMeta{
+		Name:        "expired",
+		Annotations: map[string]string{bypassAnnotationKey: "true", bypassSetAtAnnotationKey: "2017-08-01T00:00:00Z"},
+	}})
+	fakeClientset.PrependReactor("patch", "namespaces", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apiErrors.NewConflict(schema.GroupResource{Resource: "namespaces"}, "expired", nil)
+	})
+	events := 0
+	fakeClientset.PrependReactor("create", "events", func(action ktesting.Action) (bool, runtime.Object, error) {
+		events++
+		return true, nil, nil
+	})
+	clientset = fakeClientset
+
+	expirer := &bypassExpirer{maxAge: time.Hour, now: time.Now}
+	assert.Nil(t, expirer.expire(), "should not fail on conflicts")
+	assert.Equal(t, 0, events, "should not record an event for a namespace changed meanwhile")
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"hash/fnv"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// enforcementLabelKey overrides the --enforcementPercent bucket of a namespace
+	enforcementLabelKey = "namespace-guard.io/enforcement"
+
+	enforceBucket = "enforce"
+	warnBucket    = "warn"
+)
+
+// namespaceHashBucket returns the stable bucket, between 0 and 99, of the namespace name
+func namespaceHashBucket(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % 100)
+}
+
+// enforcementBucket returns whether the deletion of the namespace is enforced or only warned about.
+// The enforcement label of the namespace takes precedence over the --enforcementPercent of names, but
+// only lets a namespace out of the enforcement during a canary, i.e. with a percent below 100.
+func enforcementBucket(namespace *corev1.Namespace, percent int) string {
+	switch namespace.Labels[enforcementLabelKey] {
+	case enforceBucket:
+		return enforceBucket
+	case warnBucket:
+		if percent < 100 {
+			return warnBucket
+		}
+	}
+	if namespaceHashBucket(namespace.Name) < percent {
+		return enforceBucket
+	}
+	return warnBucket
+}
+
+// validateEnforcementChange checks a namespace UPDATE by the requester comparing the old and new labels.
+// Setting the enforcement label to warn lifts the enforcement of the namespace like the bypass
+// annotation, and requires the requester to be authorized the bypass verb on the namespace.
+func validateEnforcementChange(oldLabels, newLabels map[string]string, namespace string, userInfo authenticationv1.UserInfo) (bool, error) {
+	if oldLabels[enforcementLabelKey] == warnBucket || newLabels[enforcementLabelKey] != warnBucket {
+		return true, nil
+	}
+	allowed, err := reviewBypassAccess(namespace, userInfo)
+	if err != nil {
+		return false, err
+	}
+	if allowed {
+		log.Warnf("User %s set the enforcement label %s=%s of namespace %s.", userInfo.Username, enforcementLabelKey, warnBucket, namespace)
+	}
+	return allowed, nil
+}
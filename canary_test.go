@@ -0,0 +1,148 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1alpha1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestEnforcementBucketDeterminism(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "team-a-prod"}}
+	bucket := enforcementBucket(namespace, 50)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, bucket, enforcementBucket(namespace, 50))
+	}
+	assert.Equal(t, enforceBucket, enforcementBucket(namespace, 100))
+	assert.Equal(t, warnBucket, enforcementBucket(namespace, 0))
+}
+
+func TestEnforcementBucketDistribution(t *testing.T) {
+	enforced := 0
+	for i := 0; i < 10000; i++ {
+		namespace := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("namespace-%d", i)}}
+		if enforcementBucket(namespace, 30) == enforceBucket {
+			enforced++
+		}
+	}
+	assert.InDelta(t, 3000, enforced, 300)
+}
+
+func TestEnforcementBucketLabelOverride(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{
+		Name:   "team-a-prod",
+		Labels: map[string]string{enforcementLabelKey: enforceBucket},
+	}}
+	assert.Equal(t, enforceBucket, enforcementBucket(namespace, 0))
+
+	namespace.Labels[enforcementLabelKey] = warnBucket
+	assert.Equal(t, warnBucket, enforcementBucket(namespace, 99))
+	assert.Equal(t, enforceBucket, enforcementBucket(namespace, 100), "should only honor the warn label during a canary")
+}
+
+func TestValidateEnforcementChange(t *testing.T) {
+	clientset = bypassReviewClientset(t, "alice")
+	warned := map[string]string{enforcementLabelKey: warnBucket}
+	enforced := map[string]string{enforcementLabelKey: enforceBucket}
+
+	for _, c := range []struct {
+		name     string
+		old, new map[string]string
+		user     string
+		allowed  bool
+	}{
+		{"enforce set", nil, enforced, "bob", true},
+		{"warn removed", warned, nil, "bob", true},
+		{"warn kept", warned, warned, "bob", true},
+		{"warn set without authorization", enforced, warned, "bob", false},
+		{"warn set with authorization", nil, warned, "alice", true},
+	} {
+		userInfo := requester
+		userInfo.Username = c.user
+		allowed, err := validateEnforcementChange(c.old, c.new, "test-namespace", userInfo)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.allowed, allowed, c.name)
+	}
+}
+
+func TestWarnLabelEnforcedWebhookHandler(t *testing.T) {
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Labels = map[string]string{enforcementLabelKey: warnBucket}
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject a namespace labeled warn at an enforcementPercent of 100")
+	assert.NotContains(t, admReview.Status.Result.Message, "Warn-only")
+}
+
+func TestWarnBucketCounterErrorWebhookHandler(t *testing.T) {
+	*enforcementPercent = 0
+	defer func() { *enforcementPercent = 100 }()
+
+	clientset = failingServicesClientset(apiErrors.NewServerTimeout(schema.GroupResource{Resource: "services"}, "list", 1), cloneNamespace(templateNamespace))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should never let a deletion that could not be validated through the warn bucket")
+	assert.Contains(t, admReview.Status.Result.Message, "error listing services")
+}
+
+func TestAuthorizeEnforcementLabelWebhookHandler(t *testing.T) {
+	*authorizeBypass = true
+	defer func() { *authorizeBypass = false }()
+	clientset = bypassReviewClientset(t, "alice")
+	review := func(user string, labels map[string]string) *v1alpha1.AdmissionReview {
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.Operation = v1alpha1.Update
+		testSpec.Spec.UserInfo.Username = user
+		testSpec.Spec.OldObject = rawNamespace(nil)
+		newNamespace := cloneNamespace(templateNamespace)
+		newNamespace.Labels = labels
+		raw, err := json.Marshal(newNamespace)
+		assert.Nil(t, err)
+		testSpec.Spec.Object = runtime.RawExtension{Raw: raw}
+		rw := httptest.NewRecorder()
+		webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec)))
+		return getAdmissionReview(rw)
+	}
+	warned := map[string]string{enforcementLabelKey: warnBucket}
+
+	admReview := review("bob", warned)
+	assert.False(t, admReview.Status.Allowed, "should reject setting the warn label without authorization")
+	assert.Equal(t, "User bob is not authorized to set the enforcement label namespace-guard.io/enforcement=warn on namespace test-namespace, it requires the bypass verb on namespaces in the k8s-namespace-guard.admission.yahoo.com API group.",
+		admReview.Status.Result.Message)
+	assert.True(t, review("alice", warned).Status.Allowed, "should allow authorized users to set the warn label")
+}
+
+func TestWarnBucketWebhookHandler(t *testing.T) {
+	*enforcementPercent = 0
+	defer func() { *enforcementPercent = 100 }()
+
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should approve with a warning in the warn bucket")
+	assert.Contains(t, admReview.Status.Result.Message, "Warn-only, this deletion will be rejected once enforced: The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1)].")
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/google/cel-go/cel"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+var (
+	// celRules are the rules of the --configFile, evaluated on every deletion passing the resource checks
+	celRules []celRule
+)
+
+// celRule is a named CEL expression of the config file denying the deletion when it evaluates to true.
+// The expression and the message template are evaluated against the namespace object, the request
+// holding the userInfo and profile of the admission request, and the findings counting the resources
+// found by kind.
+type celRule struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	// Message is the text/template of the denial message, e.g. {{.namespace.metadata.name}} has no owner
+	Message string `json:"message"`
+
+	program cel.Program
+	message *template.Template
+}
+
+// newCELEnv returns the environment the rules are compiled in, declaring their variables
+func newCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("namespace", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("findings", cel.MapType(cel.StringType, cel.IntType)),
+	)
+}
+
+// compile type checks the expression, which must evaluate to a bool, and parses the message template.
+// The evaluation of the program is capped at --celCostLimit.
+func (r *celRule) compile(env *cel.Env) error {
+	if r.Name == "" {
+		return fmt.Errorf("a rule has no name")
+	}
+	ast, issues := env.Compile(r.Expression)
+	if issues != nil && issues.Err() != nil {
+		return fmt.Errorf("invalid expression in rule %s: %v", r.Name, issues.Err())
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) {
+		return fmt.Errorf("invalid expression in rule %s: it must evaluate to a bool, not %v", r.Name, ast.OutputType())
+	}
+	program, err := env.Program(ast, cel.CostLimit(*celCostLimit))
+	if err != nil {
+		return fmt.Errorf("invalid expression in rule %s: %v", r.Name, err)
+	}
+	message, err := template.New(r.Name).Option("missingkey=zero").Parse(r.Message)
+	if err != nil {
+		return fmt.Errorf("invalid message in rule %s: %v", r.Name, err)
+	}
+	r.program, r.message = program, message
+	return nil
+}
+
+// compileCELRules compiles the rules, failing on the first invalid one
+func compileCELRules(rules []celRule) error {
+	env, err := newCELEnv()
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for i := range rules {
+		if err := rules[i].compile(env); err != nil {
+			return err
+		}
+		if names[rules[i].Name] {
+			return fmt.Errorf("duplicate rule name %s", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+	return nil
+}
+
+// celVariables returns the variables the rules are evaluated against. The namespace is passed as plain
+// JSON values, so that the expressions use the field names of the API.
+func celVariables(namespace *corev1.Namespace, userInfo authenticationv1.UserInfo, profile string, findings []resourceFinding) (map[string]interface{}, error) {
+	data, err := json.Marshal(struct {
+		Namespace *corev1.Namespace         `json:"namespace"`
+		UserInfo  authenticationv1.UserInfo `json:"userInfo"`
+	}{namespace, userInfo})
+	if err != nil {
+		return nil, err
+	}
+	var document struct {
+		Namespace map[string]interface{} `json:"namespace"`
+		UserInfo  map[string]interface{} `json:"userInfo"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, f := range findings {
+		counts[f.Kind] += int64(f.Count)
+	}
+	return map[string]interface{}{
+		"namespace": document.Namespace,
+		"request":   map[string]interface{}{"userInfo": document.UserInfo, "profile": profile},
+		"findings":  counts,
+	}, nil
+}
+
+// evaluateCELRules returns the <name>: <message> of the rules evaluating to true, in their order. The
+// evaluation fails on the first rule failing to evaluate, e.g. on a missing key or above the cost limit.
+func evaluateCELRules(rules []celRule, variables map[string]interface{}) ([]string, error) {
+	var denials []string
+	for _, r := range rules {
+		val, _, err := r.program.Eval(variables)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s failed: %v", r.Name, err)
+		}
+		denied, ok := val.Value().(bool)
+		if !ok {
+			return nil, fmt.Errorf("rule %s evaluated to %v instead of a bool", r.Name, val.Value())
+		}
+		if !denied {
+			continue
+		}
+		message := new(bytes.Buffer)
+		if err := r.message.Execute(message, variables); err != nil {
+			return nil, fmt.Errorf("rule %s failed to render its message: %v", r.Name, err)
+		}
+		denials = append(denials, r.Name+": "+strings.TrimSpace(message.String()))
+	}
+	return denials, nil
+}
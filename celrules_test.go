@@ -0,0 +1,107 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testRulesConfig = `
+rules:
+- name: owner-required
+  expression: "!has(namespace.metadata.annotations) || !('example.com/owner' in namespace.metadata.annotations)"
+  message: "the namespace {{.namespace.metadata.name}} has no example.com/owner annotation"
+- name: configmaps-admins-only
+  expression: "'configmaps' in findings && findings['configmaps'] > 0 && !('platform-admins' in request.userInfo.groups)"
+  message: "only the platform-admins may delete the namespace while it holds configmaps"
+`
+
+func TestParseCELRules(t *testing.T) {
+	config, err := parseConfig([]byte(testRulesConfig))
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, config.Rules, 2)
+
+	owned := cloneNamespace(templateNamespace)
+	owned.Annotations = map[string]string{"example.com/owner": "team-a"}
+	admin := authenticationv1.UserInfo{Username: "admin", Groups: []string{"platform-admins"}}
+	user := authenticationv1.UserInfo{Username: "user", Groups: []string{"developers"}}
+	findings := []resourceFinding{{Kind: "configmaps", Count: 2}, {Kind: "pods"}}
+
+	for _, c := range []struct {
+		description string
+		owned       bool
+		userInfo    authenticationv1.UserInfo
+		denials     []string
+	}{
+		{"should allow the admins to delete an owned namespace", true, admin, nil},
+		{"should deny the deletion of a namespace without owner", false, admin, []string{"owner-required: the namespace test-namespace has no example.com/owner annotation"}},
+		{"should deny the configmaps to the other users", true, user, []string{"configmaps-admins-only: only the platform-admins may delete the namespace while it holds configmaps"}},
+	} {
+		namespace := cloneNamespace(templateNamespace)
+		if c.owned {
+			namespace = owned
+		}
+		variables, err := celVariables(namespace, c.userInfo, "", findings)
+		assert.Nil(t, err)
+		denials, err := evaluateCELRules(config.Rules, variables)
+		assert.Nil(t, err, c.description)
+		assert.Equal(t, c.denials, denials, c.description)
+	}
+}
+
+func TestParseInvalidCELRules(t *testing.T) {
+	for config, expected := range map[string]string{
+		"rules:\n- expression: 'true'\n":                                                      "a rule has no name",
+		"rules:\n- name: broken\n  expression: 'namespace.metadata.'\n":                       "invalid expression in rule broken",
+		"rules:\n- name: undeclared\n  expression: 'user.name == \"bob\"'\n":                  "invalid expression in rule undeclared",
+		"rules:\n- name: count\n  expression: 'findings[\"pods\"]'\n":                         "invalid expression in rule count: it must evaluate to a bool",
+		"rules:\n- name: template\n  expression: 'true'\n  message: '{{.oops'\n":              "invalid message in rule template",
+		"rules:\n- name: twice\n  expression: 'true'\n- name: twice\n  expression: 'false'\n": "duplicate rule name twice",
+	} {
+		_, err := parseConfig([]byte(config))
+		if assert.NotNil(t, err, "should reject config %q", config) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
+
+func TestCELRulesWebhookHandler(t *testing.T) {
+	config, err := parseConfig([]byte(testRulesConfig + `
+- name: retired
+  expression: "namespace.metadata.labels['lifecycle'] == 'retired'"
+  message: unreachable
+`))
+	assert.Nil(t, err, "Error should be nil")
+	celRules = config.Rules[:2]
+	defer func() { celRules = nil }()
+
+	review := func() (bool, string) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	allowed, message := review()
+	assert.False(t, allowed, "should reject the deletions the rules deny")
+	assert.Contains(t, message, "The deletion of the namespace test-namespace is denied by the rules: owner-required: the namespace test-namespace has no example.com/owner annotation.")
+
+	owned := cloneNamespace(templateNamespace)
+	owned.Annotations = map[string]string{"example.com/owner": "team-a"}
+	clientset = fake.NewSimpleClientset(owned)
+	allowed, _ = review()
+	assert.True(t, allowed, "should allow the deletions no rule denies")
+
+	// the namespace has no labels, failing the evaluation of the rule
+	celRules = config.Rules
+	allowed, message = review()
+	assert.False(t, allowed, "should reject the deletion when a rule fails to evaluate")
+	assert.Contains(t, message, "Error occurred while evaluating the rules for the namespace test-namespace: rule retired failed")
+}
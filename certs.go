@@ -0,0 +1,135 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	servingCertificateName  = "serving"
+	clientCACertificateName = "clientCA"
+
+	// certExpiryCheckInterval is how often the certificate expiries are refreshed
+	certExpiryCheckInterval = time.Hour
+)
+
+var (
+	// monitoredCerts holds the expiry times of the loaded certificates, keyed by certificate name
+	monitoredCerts = &certificateMonitor{expiries: map[string]time.Time{}}
+)
+
+// certificateMonitor tracks the expiry times of the loaded certificates
+type certificateMonitor struct {
+	sync.Mutex
+	expiries map[string]time.Time
+	expired  bool
+}
+
+// set records the expiry time of the named certificate
+func (m *certificateMonitor) set(name string, notAfter time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.expiries[name] = notAfter
+}
+
+// anyExpired returns true if a certificate had expired at the last check
+func (m *certificateMonitor) anyExpired() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.expired
+}
+
+// check updates the expiry gauges as of now and logs a warning for each certificate expiring within
+// the thresholds, escalating to an error within the smallest threshold and once expired
+func (m *certificateMonitor) check(now time.Time, thresholds []time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.expired = false
+	for name, notAfter := range m.expiries {
+		remaining := notAfter.Sub(now)
+		certificateExpirySeconds.WithLabelValues(name).Set(remaining.Seconds())
+		if remaining <= 0 {
+			m.expired = true
+			log.Errorf("The %s certificate expired on %s", name, notAfter.Format(time.RFC3339))
+			continue
+		}
+		for i := len(thresholds) - 1; i >= 0; i-- {
+			if remaining > thresholds[i] {
+				continue
+			}
+			if i == len(thresholds)-1 {
+				log.Errorf("The %s certificate expires in less than %v, on %s", name, thresholds[i], notAfter.Format(time.RFC3339))
+			} else {
+				log.Warnf("The %s certificate expires in less than %v, on %s", name, thresholds[i], notAfter.Format(time.RFC3339))
+			}
+			break
+		}
+	}
+}
+
+// monitor periodically checks the certificate expiries until the stop channel is closed
+func (m *certificateMonitor) monitor(thresholds []time.Duration, stopCh <-chan struct{}) {
+	m.check(time.Now(), thresholds)
+	ticker := time.NewTicker(certExpiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.check(time.Now(), thresholds)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// earliestExpiry returns the earliest NotAfter time of the PEM encoded certificates
+func earliestExpiry(pemData []byte) (time.Time, error) {
+	var earliest time.Time
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+	if earliest.IsZero() {
+		return time.Time{}, fmt.Errorf("no certificate found")
+	}
+	return earliest, nil
+}
+
+// parseExpiryThresholds parses a comma separated list of durations, returned from the largest to the
+// smallest
+func parseExpiryThresholds(value string) ([]time.Duration, error) {
+	var thresholds []time.Duration
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate expiry threshold %q: %v", s, err)
+		}
+		thresholds = append(thresholds, d)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+	return thresholds, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// generateCert returns a PEM encoded self-signed certificate valid until notAfter
+func generateCert(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "k8s-namespace-guard"},
+		NotBefore:    notAfter.Add(-72 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func certificateExpiryValue(name string) float64 {
+	m := &dto.Metric{}
+	certificateExpirySeconds.WithLabelValues(name).Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestEarliestExpiry(t *testing.T) {
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	bundle := append(generateCert(t, now.Add(48*time.Hour)), generateCert(t, now.Add(24*time.Hour))...)
+
+	expiry, err := earliestExpiry(bundle)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(24*time.Hour), expiry)
+
+	_, err = earliestExpiry([]byte("not a cert"))
+	assert.EqualError(t, err, "no certificate found")
+}
+
+func TestParseExpiryThresholds(t *testing.T) {
+	thresholds, err := parseExpiryThresholds("24h, 720h,168h")
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{720 * time.Hour, 168 * time.Hour, 24 * time.Hour}, thresholds)
+
+	_, err = parseExpiryThresholds("30d")
+	assert.NotNil(t, err)
+}
+
+func TestCertificateMonitorCheck(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Out
+	log.Out = &buf
+	defer func() { log.Out = out }()
+
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	thresholds := []time.Duration{720 * time.Hour, 168 * time.Hour, 24 * time.Hour}
+	monitor := &certificateMonitor{expiries: map[string]time.Time{}}
+	monitor.set("test", now.Add(72*time.Hour))
+
+	monitor.check(now, thresholds)
+	assert.Equal(t, float64(72*3600), certificateExpiryValue("test"))
+	assert.Regexp(t, "WARNING .* The test certificate expires in less than 168h0m0s", buf.String())
+	assert.False(t, monitor.anyExpired())
+
+	buf.Reset()
+	monitor.check(now.Add(60*time.Hour), thresholds)
+	assert.Regexp(t, "ERROR .* The test certificate expires in less than 24h0m0s", buf.String())
+
+	buf.Reset()
+	monitor.check(now.Add(73*time.Hour), thresholds)
+	assert.Equal(t, float64(-3600), certificateExpiryValue("test"))
+	assert.Regexp(t, "ERROR .* The test certificate expired on 2017-09-04T00:00:00Z", buf.String())
+	assert.True(t, monitor.anyExpired())
+}
+
+func TestStatusHandlerExpiredCert(t *testing.T) {
+	*failStatusOnExpiredCert = true
+	defer func() { *failStatusOnExpiredCert = false }()
+	monitoredCerts.set("test", time.Now().Add(-time.Hour))
+	monitoredCerts.check(time.Now(), nil)
+	defer func() {
+		delete(monitoredCerts.expiries, "test")
+		monitoredCerts.check(time.Now(), nil)
+	}()
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:8080/status.html", nil)
+	statusHandler(rw, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/api/admission/v1alpha1"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventType         = "com.datadoghq.namespace-guard.decision"
+	cloudEventsContentType = "application/cloudevents+json"
+	cloudEventsTimeout     = 10 * time.Second
+	// cloudEventSchemaVersion is the version of the data of the decision events, to be bumped on any
+	// incompatible change of decisionEventData
+	cloudEventSchemaVersion = "1"
+	// cloudEventsMaxBackoff bounds the time waited between two deliveries of an event
+	cloudEventsMaxBackoff = 30 * time.Second
+)
+
+var (
+	// cloudEvents publishes the admission decisions to --cloudEventsSink, nil when not set
+	cloudEvents *cloudEventsPublisher
+)
+
+// cloudEvent is a CloudEvent in the JSON structured content mode
+type cloudEvent struct {
+	SpecVersion     string            `json:"specversion"`
+	ID              string            `json:"id"`
+	Source          string            `json:"source"`
+	Type            string            `json:"type"`
+	Subject         string            `json:"subject"`
+	Time            time.Time         `json:"time"`
+	DataContentType string            `json:"datacontenttype"`
+	Data            decisionEventData `json:"data"`
+}
+
+// decisionEventData is the data of the decision events, versioned by SchemaVersion
+type decisionEventData struct {
+	SchemaVersion string   `json:"schemaVersion"`
+	Namespace     string   `json:"namespace"`
+	Operation     string   `json:"operation"`
+	Decision      string   `json:"decision"`
+	Reasons       []string `json:"reasons,omitempty"`
+	User          string   `json:"user"`
+	Groups        []string `json:"groups,omitempty"`
+	Profile       string   `json:"profile"`
+}
+
+// newEventID returns a random event ID
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// cloudEventsSource returns the source of the events, identifying the cluster with --clusterName
+func cloudEventsSource() string {
+	if *clusterName == "" {
+		return "/k8s-namespace-guard"
+	}
+	return "/k8s-namespace-guard/" + *clusterName
+}
+
+// newDecisionEvent returns the event of the admission decision
+func newDecisionEvent(admReview *v1alpha1.AdmissionReview, profile string, decision admissionDecision, now time.Time) (cloudEvent, error) {
+	id, err := newEventID()
+	if err != nil {
+		return cloudEvent{}, err
+	}
+	data := decisionEventData{
+		SchemaVersion: cloudEventSchemaVersion,
+		Namespace:     admReview.Spec.Name,
+		Operation:     string(admReview.Spec.Operation),
+		Decision:      "denied",
+		User:          admReview.Spec.UserInfo.Username,
+		Groups:        admReview.Spec.UserInfo.Groups,
+		Profile:       profile,
+	}
+	if decision.allowed {
+		data.Decision = "allowed"
+	}
+	if decision.message != "" {
+		data.Reasons = []string{decision.message}
+	}
+	return cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              id,
+		Source:          cloudEventsSource(),
+		Type:            cloudEventType,
+		Subject:         admReview.Spec.Name,
+		Time:            now.UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// cloudEventsPublisher delivers the events to the sink from a bounded queue, so that a slow or
+// unavailable sink never delays the admission responses
+type cloudEventsPublisher struct {
+	sink       string
+	client     *http.Client
+	queue      chan cloudEvent
+	maxRetries int
+	// backoff is the time waited before the first retry, doubled on every retry
+	backoff time.Duration
+}
+
+// newCloudEventsPublisher returns a publisher to the sink queuing up to queueSize events
+func newCloudEventsPublisher(sink string, queueSize, maxRetries int) *cloudEventsPublisher {
+	return &cloudEventsPublisher{
+		sink:       sink,
+		client:     &http.Client{Timeout: cloudEventsTimeout},
+		queue:      make(chan cloudEvent, queueSize),
+		maxRetries: maxRetries,
+		backoff:    time.Second,
+	}
+}
+
+// publish queues the event, dropping it if the queue is full
+func (p *cloudEventsPublisher) publish(event cloudEvent) {
+	select {
+	case p.queue <- event:
+	default:
+		log.Warnf("Dropping the decision event for namespace %s, the queue of the CloudEvents sink %s is full", event.Subject, p.sink)
+	}
+}
+
+// send posts the event to the sink, returning whether a failed delivery may be retried
+func (p *cloudEventsPublisher) send(event cloudEvent) (bool, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.sink, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", cloudEventsContentType)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// deliver sends the event, retrying with an exponential backoff up to maxRetries times unless the
+// stop channel is closed
+func (p *cloudEventsPublisher) deliver(event cloudEvent, stopCh <-chan struct{}) error {
+	backoff := p.backoff
+	for retry := 0; ; retry++ {
+		retryable, err := p.send(event)
+		if err == nil || !retryable || retry >= p.maxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-stopCh:
+			return err
+		}
+		if backoff *= 2; backoff > cloudEventsMaxBackoff {
+			backoff = cloudEventsMaxBackoff
+		}
+	}
+}
+
+// run delivers the queued events until the stop channel is closed, the events still queued then being
+// sent once without retry
+func (p *cloudEventsPublisher) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case event := <-p.queue:
+			if err := p.deliver(event, stopCh); err != nil {
+				log.Errorf("Error occurred while sending the decision event %s to %s: %s", event.ID, p.sink, err.Error())
+			}
+		case <-stopCh:
+			for {
+				select {
+				case event := <-p.queue:
+					if _, err := p.send(event); err != nil {
+						log.Errorf("Error occurred while sending the decision event %s to %s: %s", event.ID, p.sink, err.Error())
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// publishDecision publishes the admission decision to the CloudEvents sink if --cloudEventsSink is
+// set. The v1alpha1 admission review has no dryRun, every decision is published.
+func publishDecision(admReview *v1alpha1.AdmissionReview, profile string, decision admissionDecision) {
+	if cloudEvents == nil {
+		return
+	}
+	event, err := newDecisionEvent(admReview, profile, decision, time.Now())
+	if err != nil {
+		log.Errorf("Error occurred while creating the decision event for namespace %s: %s", admReview.Spec.Name, err.Error())
+		return
+	}
+	cloudEvents.publish(event)
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// cloudEventsRequest is an event received by the mock sink
+type cloudEventsRequest struct {
+	contentType string
+	body        []byte
+}
+
+// mockCloudEventsSink returns a sink recording the events and responding with the statuses in turn,
+// the last one once they are exhausted
+func mockCloudEventsSink(statuses ...int) (*httptest.Server, chan cloudEventsRequest) {
+	requests := make(chan cloudEventsRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests <- cloudEventsRequest{req.Header.Get("Content-Type"), body}
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		rw.WriteHeader(status)
+	}))
+	return server, requests
+}
+
+// receiveEvent returns the next event received by the sink, failing the test after a second
+func receiveEvent(t *testing.T, requests chan cloudEventsRequest) (cloudEventsRequest, map[string]interface{}) {
+	select {
+	case req := <-requests:
+		var envelope map[string]interface{}
+		assert.Nil(t, json.Unmarshal(req.body, &envelope))
+		return req, envelope
+	case <-time.After(time.Second):
+		t.Fatal("no event received by the sink")
+		return cloudEventsRequest{}, nil
+	}
+}
+
+func TestCloudEventsWebhookHandler(t *testing.T) {
+	server, requests := mockCloudEventsSink(http.StatusAccepted)
+	defer server.Close()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	cloudEvents = newCloudEventsPublisher(server.URL, 10, 0)
+	defer func() { cloudEvents = nil }()
+	go cloudEvents.run(stopCh)
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	testSpec.Spec.UserInfo.Groups = []string{"developers"}
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.False(t, getAdmissionReview(rw).Status.Allowed)
+
+	received, envelope := receiveEvent(t, requests)
+	assert.Equal(t, cloudEventsContentType, received.contentType)
+	assert.Equal(t, "1.0", envelope["specversion"])
+	assert.Equal(t, "com.datadoghq.namespace-guard.decision", envelope["type"])
+	assert.Equal(t, "/k8s-namespace-guard", envelope["source"])
+	assert.Equal(t, "test-namespace", envelope["subject"])
+	assert.Equal(t, "application/json", envelope["datacontenttype"])
+	assert.Len(t, envelope["id"], 32)
+	_, err := time.Parse(time.RFC3339, envelope["time"].(string))
+	assert.Nil(t, err)
+
+	data := envelope["data"].(map[string]interface{})
+	assert.Equal(t, cloudEventSchemaVersion, data["schemaVersion"], "should version the schema of the data")
+	assert.Equal(t, "test-namespace", data["namespace"])
+	assert.Equal(t, "DELETE", data["operation"])
+	assert.Equal(t, "denied", data["decision"])
+	assert.Equal(t, "alice", data["user"])
+	assert.Equal(t, []interface{}{"developers"}, data["groups"])
+	assert.Equal(t, "default", data["profile"])
+	if reasons, ok := data["reasons"].([]interface{}); assert.True(t, ok) && assert.Len(t, reasons, 1) {
+		assert.Contains(t, reasons[0], "contains one or more of these resources: [pods(1)]")
+	}
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	_, envelope = receiveEvent(t, requests)
+	data = envelope["data"].(map[string]interface{})
+	assert.Equal(t, "allowed", data["decision"])
+	assert.NotContains(t, data, "reasons")
+}
+
+func TestCloudEventsRetries(t *testing.T) {
+	server, requests := mockCloudEventsSink(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+	publisher := newCloudEventsPublisher(server.URL, 10, 2)
+	publisher.backoff = time.Millisecond
+	event, err := newDecisionEvent(cloneAdmissionReview(templateAdmReview), "default", allow(""), time.Now())
+	assert.Nil(t, err)
+
+	assert.Nil(t, publisher.deliver(event, make(chan struct{})), "should deliver the event on the last retry")
+	assert.Len(t, requests, 3)
+
+	server, requests = mockCloudEventsSink(http.StatusBadRequest)
+	defer server.Close()
+	publisher.sink = server.URL
+	err = publisher.deliver(event, make(chan struct{}))
+	assert.Contains(t, err.Error(), "400 Bad Request")
+	assert.Len(t, requests, 1, "should not retry the events rejected by the sink")
+}
+
+func TestCloudEventsQueueBound(t *testing.T) {
+	server, requests := mockCloudEventsSink(http.StatusOK)
+	defer server.Close()
+	publisher := newCloudEventsPublisher(server.URL, 1, 0)
+	first, _ := newDecisionEvent(cloneAdmissionReview(templateAdmReview), "default", allow(""), time.Now())
+	second, _ := newDecisionEvent(cloneAdmissionReview(templateAdmReview), "default", deny("denied"), time.Now())
+	publisher.publish(first)
+	publisher.publish(second)
+	assert.Len(t, publisher.queue, 1, "should drop the events once the queue is full")
+
+	// the events still queued on shutdown are sent
+	stopCh := make(chan struct{})
+	close(stopCh)
+	publisher.run(stopCh)
+	_, envelope := receiveEvent(t, requests)
+	assert.Equal(t, first.ID, envelope["id"])
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+// clusterScopedCounters returns the counters of the cluster-scoped resources referencing a namespace.
+// The apiserver does not support field selectors on the referencing fields, so every counter lists
+// all objects of its kind and filters them on the namespace.
+func clusterScopedCounters() []resourceCounter {
+	return []resourceCounter{
+		{"clusterrolebindings", clusterRoleBindingCounter},
+		{"persistentvolumes", persistentVolumeCounter},
+	}
+}
+
+// clusterRoleBindingCounter returns the ClusterRoleBindings with a subject in the namespace
+func clusterRoleBindingCounter(namespace string) ([]string, error) {
+	list, err := clientset.RbacV1beta1().ClusterRoleBindings().List(counterListOptions())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, binding := range list.Items {
+		for _, subject := range binding.Subjects {
+			if subject.Namespace == namespace {
+				names = append(names, binding.Name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// persistentVolumeCounter returns the PersistentVolumes claimed from the namespace
+func persistentVolumeCounter(namespace string) ([]string, error) {
+	list, err := clientset.CoreV1().PersistentVolumes().List(counterListOptions())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, pv := range list.Items {
+		if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.Namespace == namespace {
+			names = append(names, pv.Name)
+		}
+	}
+	return names, nil
+}
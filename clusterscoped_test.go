@@ -0,0 +1,77 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	rbacv1beta1 "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clusterScopedClientset() *fake.Clientset {
+	testBinding := &rbacv1beta1.ClusterRoleBinding{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "test-binding",
+		},
+		Subjects: []rbacv1beta1.Subject{
+			{Kind: "ServiceAccount", Name: "default", Namespace: "test-namespace"},
+		},
+	}
+	otherBinding := &rbacv1beta1.ClusterRoleBinding{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "other-binding",
+		},
+		Subjects: []rbacv1beta1.Subject{
+			{Kind: "ServiceAccount", Name: "default", Namespace: "other-namespace"},
+		},
+	}
+	testPV := &corev1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "test-pv",
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "data", Namespace: "test-namespace"},
+		},
+	}
+	unclaimedPV := &corev1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "unclaimed-pv",
+		},
+	}
+	return fake.NewSimpleClientset(cloneNamespace(templateNamespace), testBinding, otherBinding, testPV, unclaimedPV)
+}
+
+func TestClusterScopedResourcesWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*checkClusterScopedResources = true
+	defer func() { *checkClusterScopedResources = false }()
+
+	clientset = clusterScopedClientset()
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace is referenced by cluster-scoped resources")
+	assert.Contains(t, admReview.Status.Result.Message, "The namespace test-namespace you are trying to remove is referenced by these resources outside of it (external dependencies): [clusterrolebindings[test-binding] persistentvolumes[test-pv]].")
+	assert.NotContains(t, admReview.Status.Result.Message, "contains one or more of these resources")
+}
+
+func TestClusterScopedResourcesDisabledWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	clientset = clusterScopedClientset()
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should ignore cluster-scoped resources unless checkClusterScopedResources is set")
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+
+// rollback restores a version of the k8s-namespace-guard config file recorded in the policy history
+// Secret by patching it into the ConfigMap the config file is mounted from.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// policyVersionKeyPrefix prefixes the history Secret keys holding a policy version, it must match
+	// the one used by k8s-namespace-guard
+	policyVersionKeyPrefix = "version-"
+)
+
+var (
+	kubeconfig    = flag.String("kubeconfig", "", "The kubeconfig file, the in-cluster config is used when empty.")
+	historySecret = flag.String("historySecret", "", "The <namespace>/<name> of the policy history Secret.")
+	configMap     = flag.String("configMap", "", "The <namespace>/<name> of the ConfigMap holding the config file.")
+	configMapKey  = flag.String("configMapKey", "config.yaml", "The ConfigMap key of the config file.")
+	version       = flag.Int("version", 0, "The policy version to roll back to.")
+)
+
+// policyVersion is a config file content recorded in the policy history Secret
+type policyVersion struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Config    string    `json:"config"`
+}
+
+// splitNamespacedName splits a <namespace>/<name> reference
+func splitNamespacedName(ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid reference %q, it must be <namespace>/<name>", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+func main() {
+	flag.Parse()
+	if *version <= 0 {
+		log.Fatalf("The version flag is required and must be positive")
+	}
+	secretNamespace, secretName, err := splitNamespacedName(*historySecret)
+	if err != nil {
+		log.Fatalf("Invalid historySecret: %s", err.Error())
+	}
+	configMapNamespace, configMapName, err := splitNamespacedName(*configMap)
+	if err != nil {
+		log.Fatalf("Invalid configMap: %s", err.Error())
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		log.Fatalf("Error occurred while building the kube-config: %s", err.Error())
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Error occurred while initializing the client set: %s", err.Error())
+	}
+
+	secret, err := clientset.CoreV1().Secrets(secretNamespace).Get(secretName, v1.GetOptions{})
+	if err != nil {
+		log.Fatalf("Error occurred while retrieving the policy history Secret %s: %s", *historySecret, err.Error())
+	}
+	data, ok := secret.Data[fmt.Sprintf("%s%d", policyVersionKeyPrefix, *version)]
+	if !ok {
+		log.Fatalf("Policy version %d not found in the policy history Secret %s", *version, *historySecret)
+	}
+	recorded := policyVersion{}
+	err = json.Unmarshal(data, &recorded)
+	if err != nil {
+		log.Fatalf("Error occurred while decoding the policy version %d: %s", *version, err.Error())
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{*configMapKey: recorded.Config},
+	})
+	if err != nil {
+		log.Fatalf("Error occurred while encoding the ConfigMap patch: %s", err.Error())
+	}
+	_, err = clientset.CoreV1().ConfigMaps(configMapNamespace).Patch(configMapName, types.StrategicMergePatchType, patch)
+	if err != nil {
+		log.Fatalf("Error occurred while patching the ConfigMap %s: %s", *configMap, err.Error())
+	}
+	log.Infof("Rolled back the ConfigMap %s to the policy version %d recorded on %s", *configMap, recorded.Version, recorded.Timestamp.Format(time.RFC3339))
+}
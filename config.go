@@ -0,0 +1,208 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	enforceMode  = "enforce"
+	admitAllMode = "admitAll"
+
+	defaultProfileName = "default"
+	profilePathPrefix  = "/validate/"
+)
+
+var (
+	profileNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	// profiles are the policy profiles of the --configFile by name
+	profiles = map[string]policyProfile{}
+)
+
+// policyProfile is a named set of validation settings served on its own webhook path
+type policyProfile struct {
+	// Name of the profile, the profile is served on /validate/<name>
+	Name string `json:"name"`
+	// Resources are the kinds checked for existence, all supported kinds when empty
+	Resources []string `json:"resources,omitempty"`
+	// MaxResourceCount is the number of resources a namespace may hold and still be deleted
+	MaxResourceCount int `json:"maxResourceCount,omitempty"`
+	// AllowBypass is false to ignore the bypass annotation, it is honored by default
+	AllowBypass *bool `json:"allowBypass,omitempty"`
+	// Mode is either enforce (the default) or admitAll
+	Mode string `json:"mode,omitempty"`
+}
+
+// guardConfig is the content of the --configFile
+type guardConfig struct {
+	Profiles []policyProfile `json:"profiles"`
+	// Weights are the impact score contributions of a single resource of each kind, overriding
+	// --kindWeights, kinds not listed weigh 1
+	Weights map[string]int `json:"weights,omitempty"`
+	// Rules are the CEL expressions denying the deletions passing the resource checks
+	Rules []celRule `json:"rules,omitempty"`
+}
+
+// defaultProfile returns the profile built from the command line flags
+func defaultProfile() policyProfile {
+	mode := enforceMode
+	if *admitAll {
+		mode = admitAllMode
+	}
+	return policyProfile{
+		Name:             defaultProfileName,
+		MaxResourceCount: *maxResourceCount,
+		Mode:             mode,
+	}
+}
+
+// lookupProfile returns the profile of the --configFile with the name, the default profile when the
+// name is empty
+func lookupProfile(name string) (policyProfile, error) {
+	if name == "" {
+		return defaultProfile(), nil
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return policyProfile{}, fmt.Errorf("unknown profile %s", name)
+	}
+	return profile, nil
+}
+
+// admitAllConflicts returns the validation flags set along with --admitAll, which takes precedence and
+// silently disables them
+func admitAllConflicts() []string {
+	if !*admitAll {
+		return nil
+	}
+	var conflicts []string
+	for name, set := range map[string]bool{
+		"maxResourceCount":        *maxResourceCount > 0,
+		"kindThresholds":          *kindThresholdList != "",
+		"kindWeights":             *kindWeightList != "",
+		"maxImpactScore":          *maxImpactScore > 0,
+		"userDeletionQuota":       *userDeletionQuota > 0,
+		"denyImpersonatedDeletes": *denyImpersonatedDeletes,
+		"confirmIdle":             *confirmIdle > 0,
+		"preDeleteHook":           *preDeleteHook != "",
+	} {
+		if set {
+			conflicts = append(conflicts, name)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// bypassAllowed returns true if the profile honors the bypass annotation
+func (p policyProfile) bypassAllowed() bool {
+	return p.AllowBypass == nil || *p.AllowBypass
+}
+
+// counters returns the resource counters of the kinds checked by the profile
+func (p policyProfile) counters() []resourceCounter {
+	if len(p.Resources) == 0 {
+		return resourceCounters()
+	}
+	selected := map[string]bool{}
+	for _, kind := range p.Resources {
+		selected[kind] = true
+	}
+	var counters []resourceCounter
+	for _, c := range resourceCounters() {
+		if selected[c.kind] {
+			counters = append(counters, c)
+		}
+	}
+	return counters
+}
+
+// validate returns an error if the profile is not usable
+func (p *policyProfile) validate() error {
+	if !profileNameRegex.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %q, it must consist of lower case alphanumeric characters or '-'", p.Name)
+	}
+	if p.Mode == "" {
+		p.Mode = enforceMode
+	}
+	if p.Mode != enforceMode && p.Mode != admitAllMode {
+		return fmt.Errorf("invalid mode %q in profile %s, it must be one of %s or %s", p.Mode, p.Name, enforceMode, admitAllMode)
+	}
+	if p.MaxResourceCount < 0 {
+		return fmt.Errorf("invalid maxResourceCount %d in profile %s, it must not be negative", p.MaxResourceCount, p.Name)
+	}
+	known := map[string]bool{}
+	for _, c := range resourceCounters() {
+		known[c.kind] = true
+	}
+	for _, kind := range p.Resources {
+		if !known[kind] {
+			return fmt.Errorf("unknown resource %q in profile %s", kind, p.Name)
+		}
+	}
+	return nil
+}
+
+// loadConfig reads and validates the config file, returning the config and the file content
+func loadConfig(filename string) (*guardConfig, []byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := parseConfig(data)
+	return config, data, err
+}
+
+// parseConfig parses and validates the YAML or JSON config
+func parseConfig(data []byte) (*guardConfig, error) {
+	config := &guardConfig{}
+	err := yaml.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the config: %v", err)
+	}
+
+	names := map[string]bool{}
+	for i := range config.Profiles {
+		p := &config.Profiles[i]
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate profile name %s", p.Name)
+		}
+		names[p.Name] = true
+	}
+	known := map[string]bool{}
+	for _, c := range resourceCounters() {
+		known[c.kind] = true
+	}
+	for kind, weight := range config.Weights {
+		if !known[kind] {
+			return nil, fmt.Errorf("unknown kind %q in weights, it must be one of %v", kind, counterKinds(resourceCounters()))
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("invalid weight %d for kind %s, it must not be negative", weight, kind)
+		}
+	}
+	if err := compileCELRules(config.Rules); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// registerProfiles binds a validator for each profile of the config to /validate/<name>
+func registerProfiles(mux *http.ServeMux, config *guardConfig) {
+	for _, profile := range config.Profiles {
+		path := profilePathPrefix + profile.Name
+		mux.Handle(path, newValidator(path, profile))
+		profiles[profile.Name] = profile
+		log.Infof("Serving policy profile %s on %s", profile.Name, path)
+	}
+}
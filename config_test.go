@@ -0,0 +1,136 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+profiles:
+- name: strict
+  allowBypass: false
+- name: lenient
+  resources:
+  - deployments
+  - statefulsets
+  maxResourceCount: 2
+`
+
+func TestParseConfig(t *testing.T) {
+	config, err := parseConfig([]byte(testConfig))
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, config.Profiles, 2)
+
+	strict, lenient := config.Profiles[0], config.Profiles[1]
+	assert.Equal(t, "strict", strict.Name)
+	assert.Equal(t, enforceMode, strict.Mode, "should default to the enforce mode")
+	assert.False(t, strict.bypassAllowed())
+	assert.Len(t, strict.counters(), len(resourceCounters()), "should check every kind when no resources are listed")
+
+	assert.True(t, lenient.bypassAllowed(), "should honor the bypass annotation by default")
+	assert.Equal(t, 2, lenient.MaxResourceCount)
+	assert.Len(t, lenient.counters(), 2)
+}
+
+func TestParseInvalidConfig(t *testing.T) {
+	for config, expected := range map[string]string{
+		"profiles:\n- name: Strict\n":                       `invalid profile name "Strict"`,
+		"profiles:\n- name: strict\n  mode: warn\n":         `invalid mode "warn" in profile strict`,
+		"profiles:\n- name: strict\n  resources: [secrets]": `unknown resource "secrets" in profile strict`,
+		"profiles:\n- name: strict\n- name: strict\n":       "duplicate profile name strict",
+		"profiles:\n- name: strict\n  maxResourceCount: -1": "invalid maxResourceCount -1 in profile strict",
+		"weights:\n  persistentvolumeclaims: 10\n":          `unknown kind "persistentvolumeclaims" in weights`,
+		"weights:\n  pods: -1\n":                            "invalid weight -1 for kind pods",
+	} {
+		_, err := parseConfig([]byte(config))
+		if assert.NotNil(t, err, "should reject config %q", config) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
+
+func TestAdmitAllConflicts(t *testing.T) {
+	*maxResourceCount = 3
+	*preDeleteHook = "http://approver"
+	defer func() { *maxResourceCount, *preDeleteHook = 0, "" }()
+	assert.Empty(t, admitAllConflicts(), "should not report conflicts without admitAll")
+
+	*admitAll = true
+	defer func() { *admitAll = false }()
+	assert.Equal(t, []string{"maxResourceCount", "preDeleteHook"}, admitAllConflicts())
+}
+
+func TestProfilesWebhookHandler(t *testing.T) {
+	config, err := parseConfig([]byte(testConfig))
+	assert.Nil(t, err, "Error should be nil")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", webhookHandler)
+	registerProfiles(mux, config)
+
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	testNamespace := cloneNamespace(templateNamespace)
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+
+	decisions := map[string]bool{}
+	for _, path := range []string{"/validate/strict", "/validate/lenient"} {
+		rw := httptest.NewRecorder()
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		req := httptest.NewRequest("POST", "http://localhost:8080"+path, constructPostBody(testSpec))
+		mux.ServeHTTP(rw, req)
+		decisions[path] = getAdmissionReview(rw).Status.Allowed
+	}
+
+	assert.False(t, decisions["/validate/strict"], "strict profile should reject a namespace with pods")
+	assert.True(t, decisions["/validate/lenient"], "lenient profile should not check pods")
+}
+
+func TestProfilesBypassWebhookHandler(t *testing.T) {
+	config, err := parseConfig([]byte(testConfig))
+	assert.Nil(t, err, "Error should be nil")
+	mux := http.NewServeMux()
+	registerProfiles(mux, config)
+
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true"}
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/validate/strict", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	mux.ServeHTTP(rw, req)
+
+	assert.False(t, getAdmissionReview(rw).Status.Allowed, "strict profile should ignore the bypass annotation")
+}
+
+func TestUnknownProfileWebhookHandler(t *testing.T) {
+	config, err := parseConfig([]byte(testConfig))
+	assert.Nil(t, err, "Error should be nil")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", webhookHandler)
+	registerProfiles(mux, config)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/validate/unknown", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	mux.ServeHTTP(rw, req)
+
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
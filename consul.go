@@ -0,0 +1,61 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	consulServicesKind = "consulservices"
+
+	// consulNamespaceTagPrefix prefixes the tag of the Consul services backed by a namespace
+	consulNamespaceTagPrefix = "k8s-namespace="
+	consulTimeout            = 10 * time.Second
+)
+
+var (
+	consulClient = &http.Client{Timeout: consulTimeout}
+)
+
+// consulCatalogServices returns the tags of every service registered in the Consul catalog
+func consulCatalogServices(endpoint string) (map[string][]string, error) {
+	resp, err := consulClient.Get(strings.TrimRight(endpoint, "/") + "/v1/catalog/services")
+	if err != nil {
+		return nil, fmt.Errorf("error listing the Consul services: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the Consul catalog responded %s", resp.Status)
+	}
+	services := map[string][]string{}
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, fmt.Errorf("error decoding the Consul services: %v", err)
+	}
+	return services, nil
+}
+
+// consulServiceCounter returns the Consul services tagged k8s-namespace=<namespace> in the catalog
+// of the --consulEndpoint
+func consulServiceCounter(namespace string) ([]string, error) {
+	services, err := consulCatalogServices(*consulEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, tags := range services {
+		for _, tag := range tags {
+			if tag == consulNamespaceTagPrefix+namespace {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
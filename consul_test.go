@@ -0,0 +1,81 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// mockConsulCatalog serves the services of a Consul catalog
+func mockConsulCatalog(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "GET", req.Method)
+		assert.Equal(t, "/v1/catalog/services", req.URL.Path)
+		io.WriteString(rw, `{
+			"consul": [],
+			"web": ["k8s-namespace=test-namespace", "v2"],
+			"api": ["k8s-namespace=test-namespace"],
+			"billing": ["k8s-namespace=other-namespace"],
+			"cache": ["k8s-namespace=test-namespace-2"]
+		}`)
+	}))
+}
+
+func TestConsulServiceCounter(t *testing.T) {
+	server := mockConsulCatalog(t)
+	defer server.Close()
+	*consulEndpoint = server.URL + "/"
+	defer func() { *consulEndpoint = "http://consul:8500" }()
+
+	names, err := consulServiceCounter("test-namespace")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api", "web"}, names)
+
+	names, err = consulServiceCounter("unknown-namespace")
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+}
+
+func TestConsulServiceCounterError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "No cluster leader", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	*consulEndpoint = server.URL
+	defer func() { *consulEndpoint = "http://consul:8500" }()
+
+	_, err := consulServiceCounter("test-namespace")
+	assert.EqualError(t, err, "the Consul catalog responded 500 Internal Server Error")
+}
+
+func TestConsulServicesWebhookHandler(t *testing.T) {
+	server := mockConsulCatalog(t)
+	defer server.Close()
+	*consulEndpoint = server.URL
+	defer func() { *consulEndpoint = "http://consul:8500" }()
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	testSpec := cloneAdmissionReview(templateAdmReview)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if guardConsulServices is not set")
+
+	*guardConsulServices = true
+	defer func() { *guardConsulServices = false }()
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if Consul services reference the namespace")
+	assert.Contains(t, admReview.Status.Result.Message, "is referenced by these resources outside of it (external dependencies): [consulservices[api web]].")
+	waitForBackgroundTasks(t)
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	datadogEventsPath   = "/api/v1/events"
+	datadogEventTimeout = 10 * time.Second
+)
+
+var (
+	datadogClient = &http.Client{Timeout: datadogEventTimeout}
+)
+
+// datadogEvent is the body of a Datadog events API request
+type datadogEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	AlertType      string   `json:"alert_type"`
+	SourceTypeName string   `json:"source_type_name"`
+	Tags           []string `json:"tags"`
+}
+
+// newDeletionDeniedEvent returns the Datadog event reporting a denied namespace deletion
+func newDeletionDeniedEvent(namespace, username, reason string) datadogEvent {
+	return datadogEvent{
+		Title:          fmt.Sprintf("Deletion of namespace %s denied", namespace),
+		Text:           reason,
+		AlertType:      "warning",
+		SourceTypeName: eventSourceComponent,
+		Tags:           []string{"namespace:" + namespace, "user:" + username},
+	}
+}
+
+// postDatadogEvent posts the event to the Datadog events API
+func postDatadogEvent(apiURL, apiKey string, event datadogEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, apiURL+datadogEventsPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", apiKey)
+	resp, err := datadogClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyDeletionDenied posts a Datadog event for the denied namespace deletion in the background if
+// --datadogApiKey is set, failures are only logged
+func notifyDeletionDenied(namespace, username, reason string) {
+	if *datadogAPIKey == "" {
+		return
+	}
+	event := newDeletionDeniedEvent(namespace, username, reason)
+	url, apiKey := *datadogAPIURL, *datadogAPIKey
+	runInBackground(func() {
+		err := postDatadogEvent(url, apiKey, event)
+		if err != nil {
+			log.Errorf("Error occurred while posting the Datadog event for namespace %s: %s", namespace, err.Error())
+		}
+	})
+}
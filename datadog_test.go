@@ -0,0 +1,82 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// datadogAPIRequest is a request received by the mock Datadog API
+type datadogAPIRequest struct {
+	path   string
+	apiKey string
+	event  datadogEvent
+}
+
+// mockDatadogAPI returns a server recording the received events
+func mockDatadogAPI(t *testing.T) (*httptest.Server, chan datadogAPIRequest) {
+	requests := make(chan datadogAPIRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r := datadogAPIRequest{path: req.URL.Path, apiKey: req.Header.Get("DD-API-KEY")}
+		err := json.NewDecoder(req.Body).Decode(&r.event)
+		assert.Nil(t, err)
+		rw.WriteHeader(http.StatusAccepted)
+		requests <- r
+	}))
+	return server, requests
+}
+
+func TestDeletionDeniedDatadogEvent(t *testing.T) {
+	server, requests := mockDatadogAPI(t)
+	defer server.Close()
+
+	*datadogAPIKey = "test-api-key"
+	*datadogAPIURL = server.URL
+	defer func() {
+		*datadogAPIKey = ""
+		*datadogAPIURL = "https://api.datadoghq.com"
+	}()
+
+	testPod := &corev1.Pod{}
+	testPod.Name = "test-pod"
+	testPod.Namespace = "test-namespace"
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has workload resources")
+
+	select {
+	case r := <-requests:
+		assert.Equal(t, "/api/v1/events", r.path)
+		assert.Equal(t, "test-api-key", r.apiKey)
+		assert.Equal(t, "Deletion of namespace test-namespace denied", r.event.Title)
+		assert.Equal(t, admReview.Status.Result.Message, r.event.Text)
+		assert.Equal(t, "warning", r.event.AlertType)
+		assert.Equal(t, []string{"namespace:test-namespace", "user:alice"}, r.event.Tags)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was posted to the Datadog API")
+	}
+}
+
+func TestPostDatadogEventError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := postDatadogEvent(server.URL, "bad-key", newDeletionDeniedEvent("test-namespace", "alice", "denied"))
+	assert.EqualError(t, err, "unexpected response status 403 Forbidden")
+}
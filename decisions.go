@@ -0,0 +1,147 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/api/admission/v1alpha1"
+)
+
+const (
+	// maxRecentDecisions is the number of admission decisions kept for /debug/recent-decisions
+	maxRecentDecisions = 100
+	// defaultRecentDecisionsLimit is the number of decisions served without a limit parameter
+	defaultRecentDecisionsLimit = 10
+)
+
+var (
+	// recentDecisions keeps the last admission decisions in memory, as the simplest audit trail
+	recentDecisions = newDecisionLog(maxRecentDecisions)
+)
+
+// recentDecision is an admission decision served by /debug/recent-decisions
+type recentDecision struct {
+	Timestamp         time.Time        `json:"timestamp"`
+	Namespace         string           `json:"namespace"`
+	User              string           `json:"user"`
+	Operation         string           `json:"operation"`
+	Allowed           bool             `json:"allowed"`
+	BlockingResources []string         `json:"blocking_resources,omitempty"`
+	LatencyMs         int64            `json:"latency_ms"`
+	PhasesMs          map[string]int64 `json:"phases_ms,omitempty"`
+}
+
+// decisionLog is a ring buffer of the last admission decisions
+type decisionLog struct {
+	sync.RWMutex
+	entries []recentDecision
+	// next is the index of the entry overwritten by the next decision once the buffer is full
+	next int
+}
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{entries: make([]recentDecision, 0, size)}
+}
+
+// add records the decision, overwriting the oldest one once the buffer is full
+func (l *decisionLog) add(d recentDecision) {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, d)
+		return
+	}
+	l.entries[l.next] = d
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// recent returns up to limit decisions, the most recent first
+func (l *decisionLog) recent(limit int) []recentDecision {
+	l.RLock()
+	defer l.RUnlock()
+	if limit > len(l.entries) {
+		limit = len(l.entries)
+	}
+	decisions := make([]recentDecision, 0, limit)
+	// the most recent entry precedes next, or is the last one until the buffer is full
+	last := l.next - 1
+	if len(l.entries) < cap(l.entries) {
+		last = len(l.entries) - 1
+	}
+	for i := 0; i < limit; i++ {
+		decisions = append(decisions, l.entries[(last-i+len(l.entries))%len(l.entries)])
+	}
+	return decisions
+}
+
+// blockingResources returns the resource kinds blocking the deletion among the findings, with their count
+func blockingResources(findings []resourceFinding) []string {
+	var blocking []string
+	for _, f := range findings {
+		if f.Count > 0 && (f.External || !tolerated(f)) {
+			blocking = append(blocking, fmt.Sprintf("%s(%d)", f.Kind, f.Count))
+		}
+	}
+	return blocking
+}
+
+// timedWriter records when the handling of the admission request started and the time spent in its phases
+type timedWriter struct {
+	http.ResponseWriter
+	start  time.Time
+	phases *phaseTimer
+}
+
+// requestStart returns when the handling of the request written to started, now if unknown
+func requestStart(rw http.ResponseWriter) time.Time {
+	switch w := rw.(type) {
+	case *timedWriter:
+		return w.start
+	case *deadlineWriter:
+		if !w.start.IsZero() {
+			return w.start
+		}
+	}
+	return time.Now()
+}
+
+// recordDecision adds the admission decision to the recent decisions
+func recordDecision(admReview *v1alpha1.AdmissionReview, decision admissionDecision, start time.Time, phases *phaseTimer) {
+	now := time.Now()
+	recentDecisions.add(recentDecision{
+		Timestamp:         now.UTC(),
+		Namespace:         admReview.Spec.Name,
+		User:              admReview.Spec.UserInfo.Username,
+		Operation:         string(admReview.Spec.Operation),
+		Allowed:           decision.allowed,
+		BlockingResources: decision.blocking,
+		LatencyMs:         int64(now.Sub(start) / time.Millisecond),
+		PhasesMs:          phases.breakdown(),
+	})
+}
+
+// recentDecisionsHandler serves the /debug/recent-decisions endpoint reporting the last admission
+// decisions, the most recent first
+func recentDecisionsHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+
+	if req.Method != http.MethodGet {
+		writeJSON(rw, http.StatusMethodNotAllowed, explainError{fmt.Sprintf("Incoming request method %s is not supported, only GET is supported", req.Method)})
+		return
+	}
+	limit := defaultRecentDecisionsLimit
+	if value := req.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxRecentDecisions {
+			writeJSON(rw, http.StatusBadRequest, explainError{fmt.Sprintf("The limit %q is not a number between 1 and %d", value, maxRecentDecisions)})
+			return
+		}
+	}
+	writeJSON(rw, http.StatusOK, recentDecisions.recent(limit))
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDecisionLog(t *testing.T) {
+	l := newDecisionLog(3)
+	assert.Empty(t, l.recent(10))
+
+	for i := 0; i < 2; i++ {
+		l.add(recentDecision{Namespace: fmt.Sprintf("namespace-%d", i)})
+	}
+	assert.Equal(t, []recentDecision{{Namespace: "namespace-1"}, {Namespace: "namespace-0"}}, l.recent(10))
+
+	for i := 2; i < 5; i++ {
+		l.add(recentDecision{Namespace: fmt.Sprintf("namespace-%d", i)})
+	}
+	assert.Equal(t, []recentDecision{{Namespace: "namespace-4"}, {Namespace: "namespace-3"}, {Namespace: "namespace-2"}}, l.recent(10), "should only keep the last decisions")
+	assert.Equal(t, []recentDecision{{Namespace: "namespace-4"}}, l.recent(1))
+}
+
+func TestRecentDecisionsHandler(t *testing.T) {
+	recentDecisions = newDecisionLog(maxRecentDecisions)
+	getRecent := func(url string) (int, []map[string]interface{}) {
+		rw := httptest.NewRecorder()
+		recentDecisionsHandler(rw, httptest.NewRequest("GET", url, nil))
+		var decisions []map[string]interface{}
+		if rw.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(rw.Body.Bytes(), &decisions))
+		}
+		return rw.Code, decisions
+	}
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(2)...)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	webhookHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec)))
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	webhookHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec)))
+
+	code, decisions := getRecent("http://localhost:8080/debug/recent-decisions")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, decisions, 2) {
+		assert.Equal(t, true, decisions[0]["allowed"], "should serve the most recent decision first")
+		assert.NotContains(t, decisions[0], "blocking_resources")
+		denied := decisions[1]
+		assert.Equal(t, "test-namespace", denied["namespace"])
+		assert.Equal(t, "alice", denied["user"])
+		assert.Equal(t, "DELETE", denied["operation"])
+		assert.Equal(t, false, denied["allowed"])
+		assert.Equal(t, []interface{}{"pods(2)"}, denied["blocking_resources"])
+		assert.Contains(t, denied, "timestamp")
+		assert.Contains(t, denied, "latency_ms")
+	}
+
+	_, decisions = getRecent("http://localhost:8080/debug/recent-decisions?limit=1")
+	assert.Len(t, decisions, 1)
+	code, _ = getRecent("http://localhost:8080/debug/recent-decisions?limit=1000")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = getRecent("http://localhost:8080/debug/recent-decisions?limit=ten")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	rw := httptest.NewRecorder()
+	recentDecisionsHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/debug/recent-decisions", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbacv1beta1 "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+var (
+	// namespaceDeleters caches the deleters of the namespaces when --deleterClusterRole is set, nil otherwise
+	namespaceDeleters *deleterCache
+)
+
+// deleters are the users and groups bound to the --deleterClusterRole in a namespace
+type deleters struct {
+	users  map[string]bool
+	groups map[string]bool
+}
+
+// allows returns true if the user or one of its groups is among the deleters
+func (d deleters) allows(userInfo authenticationv1.UserInfo) bool {
+	if d.users[userInfo.Username] {
+		return true
+	}
+	for _, group := range userInfo.Groups {
+		if d.groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingDeleters returns the deleters of the namespace among the subjects of the RoleBindings referencing
+// the ClusterRole. The bindings without a roleRef are skipped.
+func bindingDeleters(namespace, clusterRole string, bindings []rbacv1beta1.RoleBinding) deleters {
+	d := deleters{users: map[string]bool{}, groups: map[string]bool{}}
+	for _, binding := range bindings {
+		if binding.RoleRef.Kind == "" || binding.RoleRef.Name == "" {
+			log.Warnf("Skipping the RoleBinding %s/%s without a roleRef.", namespace, binding.Name)
+			continue
+		}
+		if binding.RoleRef.Kind != "ClusterRole" || binding.RoleRef.Name != clusterRole {
+			continue
+		}
+		for _, subject := range binding.Subjects {
+			switch subject.Kind {
+			case rbacv1beta1.UserKind:
+				d.users[subject.Name] = true
+			case rbacv1beta1.GroupKind:
+				d.groups[subject.Name] = true
+			case rbacv1beta1.ServiceAccountKind:
+				// the service accounts default to the namespace of the binding
+				saNamespace := subject.Namespace
+				if saNamespace == "" {
+					saNamespace = namespace
+				}
+				d.users[fmt.Sprintf("system:serviceaccount:%s:%s", saNamespace, subject.Name)] = true
+			}
+		}
+	}
+	return d
+}
+
+// cachedDeleters are the deleters of a namespace along with when they were listed
+type cachedDeleters struct {
+	deleters deleters
+	listedAt time.Time
+}
+
+// deleterCache lists the deleters of the namespaces from their RoleBindings, caching them for the ttl
+type deleterCache struct {
+	sync.Mutex
+	clusterRole string
+	ttl         time.Duration
+	now         func() time.Time
+	entries     map[string]cachedDeleters
+}
+
+func newDeleterCache(clusterRole string, ttl time.Duration) *deleterCache {
+	return &deleterCache{clusterRole: clusterRole, ttl: ttl, now: time.Now, entries: map[string]cachedDeleters{}}
+}
+
+// get returns the deleters of the namespace, listing its RoleBindings unless cached within the ttl
+func (c *deleterCache) get(namespace string) (deleters, error) {
+	c.Lock()
+	entry, ok := c.entries[namespace]
+	c.Unlock()
+	now := c.now()
+	if ok && now.Sub(entry.listedAt) < c.ttl {
+		return entry.deleters, nil
+	}
+	list, err := clientset.RbacV1beta1().RoleBindings(namespace).List(v1.ListOptions{})
+	if err != nil {
+		return deleters{}, err
+	}
+	d := bindingDeleters(namespace, c.clusterRole, list.Items)
+	c.Lock()
+	defer c.Unlock()
+	// the expired entries of the other namespaces are dropped along the way
+	for name, e := range c.entries {
+		if now.Sub(e.listedAt) >= c.ttl {
+			delete(c.entries, name)
+		}
+	}
+	c.entries[namespace] = cachedDeleters{deleters: d, listedAt: now}
+	return d, nil
+}
+
+// breakGlassGroup returns the first of the --breakGlassGroups the user is in, empty if none
+func breakGlassGroup(userInfo authenticationv1.UserInfo) string {
+	for _, group := range strings.Split(*breakGlassGroups, ",") {
+		group = strings.TrimSpace(group)
+		for _, g := range userInfo.Groups {
+			if group != "" && g == group {
+				return group
+			}
+		}
+	}
+	return ""
+}
+
+// allowed returns true if the user is bound to the --deleterClusterRole in the namespace
+func (c *deleterCache) allowed(namespace string, userInfo authenticationv1.UserInfo) (bool, error) {
+	d, err := c.get(namespace)
+	if err != nil {
+		return false, err
+	}
+	return d.allows(userInfo), nil
+}
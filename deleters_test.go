@@ -0,0 +1,124 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	rbacv1beta1 "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// newRoleBinding returns a RoleBinding of the test namespace binding the subjects to the ClusterRole
+func newRoleBinding(name, clusterRole string, subjects ...rbacv1beta1.Subject) *rbacv1beta1.RoleBinding {
+	return &rbacv1beta1.RoleBinding{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "test-namespace"},
+		RoleRef:    rbacv1beta1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: clusterRole},
+		Subjects:   subjects,
+	}
+}
+
+// deleterBindings returns the RoleBindings of the test namespace with user, group and service account subjects
+func deleterBindings() []runtime.Object {
+	misconfigured := newRoleBinding("misconfigured", "", rbacv1beta1.Subject{Kind: rbacv1beta1.UserKind, Name: "mallory"})
+	misconfigured.RoleRef = rbacv1beta1.RoleRef{}
+	return []runtime.Object{
+		newRoleBinding("owners", "namespace-owner",
+			rbacv1beta1.Subject{Kind: rbacv1beta1.UserKind, Name: "alice"},
+			rbacv1beta1.Subject{Kind: rbacv1beta1.GroupKind, Name: "team-a"},
+			rbacv1beta1.Subject{Kind: rbacv1beta1.ServiceAccountKind, Name: "deployer"},
+			rbacv1beta1.Subject{Kind: rbacv1beta1.ServiceAccountKind, Name: "ci", Namespace: "ci-system"}),
+		newRoleBinding("viewers", "view", rbacv1beta1.Subject{Kind: rbacv1beta1.UserKind, Name: "bob"}),
+		misconfigured,
+	}
+}
+
+func TestBindingDeleters(t *testing.T) {
+	var bindings []rbacv1beta1.RoleBinding
+	for _, obj := range deleterBindings() {
+		bindings = append(bindings, *obj.(*rbacv1beta1.RoleBinding))
+	}
+	d := bindingDeleters("test-namespace", "namespace-owner", bindings)
+
+	assert.True(t, d.allows(authenticationv1.UserInfo{Username: "alice"}), "should allow the user subjects")
+	assert.True(t, d.allows(authenticationv1.UserInfo{Username: "carol", Groups: []string{"system:authenticated", "team-a"}}), "should allow the members of the group subjects")
+	assert.True(t, d.allows(authenticationv1.UserInfo{Username: "system:serviceaccount:test-namespace:deployer"}), "should default the service accounts to the namespace of the binding")
+	assert.True(t, d.allows(authenticationv1.UserInfo{Username: "system:serviceaccount:ci-system:ci"}))
+	assert.False(t, d.allows(authenticationv1.UserInfo{Username: "system:serviceaccount:test-namespace:ci"}))
+	assert.False(t, d.allows(authenticationv1.UserInfo{Username: "bob"}), "should ignore the bindings to other roles")
+	assert.False(t, d.allows(authenticationv1.UserInfo{Username: "mallory"}), "should skip the bindings without a roleRef")
+}
+
+func TestDeleterCache(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset(deleterBindings()...)
+	lists := 0
+	fakeClientset.PrependReactor("list", "rolebindings", func(action ktesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+	clientset = fakeClientset
+	now := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+	c := newDeleterCache("namespace-owner", 30*time.Second)
+	c.now = func() time.Time { return now }
+
+	allowed, err := c.allowed("test-namespace", authenticationv1.UserInfo{Username: "alice"})
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+	allowed, err = c.allowed("test-namespace", authenticationv1.UserInfo{Username: "bob"})
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 1, lists, "should cache the deleters of the namespace")
+
+	now = now.Add(30 * time.Second)
+	c.allowed("test-namespace", authenticationv1.UserInfo{Username: "alice"})
+	assert.Equal(t, 2, lists, "should list the RoleBindings again once the cache expired")
+}
+
+func TestBreakGlassGroup(t *testing.T) {
+	defer func(groups string) { *breakGlassGroups = groups }(*breakGlassGroups)
+	*breakGlassGroups = "sre, incident-commanders"
+	assert.Equal(t, "incident-commanders", breakGlassGroup(authenticationv1.UserInfo{Username: "bob", Groups: []string{"team-b", "incident-commanders"}}))
+	assert.Equal(t, "", breakGlassGroup(authenticationv1.UserInfo{Username: "bob", Groups: []string{"team-b"}}))
+}
+
+func TestDeleterClusterRoleWebhookHandler(t *testing.T) {
+	review := func(userInfo authenticationv1.UserInfo) (bool, string) {
+		clientset = fake.NewSimpleClientset(append(deleterBindings(), cloneNamespace(templateNamespace))...)
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.UserInfo = userInfo
+		rw := httptest.NewRecorder()
+		webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec)))
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+	defer func(role string) { *deleterClusterRole = role }(*deleterClusterRole)
+	*deleterClusterRole = "namespace-owner"
+	defer func() { namespaceDeleters = nil }()
+
+	for _, userInfo := range []authenticationv1.UserInfo{
+		{Username: "alice"},
+		{Username: "carol", Groups: []string{"team-a"}},
+		{Username: "system:serviceaccount:test-namespace:deployer"},
+	} {
+		namespaceDeleters = newDeleterCache(*deleterClusterRole, time.Minute)
+		allowed, _ := review(userInfo)
+		assert.True(t, allowed, "should allow the deletion by %s", userInfo.Username)
+	}
+
+	namespaceDeleters = newDeleterCache(*deleterClusterRole, time.Minute)
+	allowed, message := review(authenticationv1.UserInfo{Username: "bob"})
+	assert.False(t, allowed)
+	assert.Contains(t, message, "Only the users and groups bound to the ClusterRole namespace-owner by the RoleBindings of the namespace test-namespace may delete it, bob is not one of them.")
+
+	defer func(groups string) { *breakGlassGroups = groups }(*breakGlassGroups)
+	*breakGlassGroups = "sre"
+	allowed, _ = review(authenticationv1.UserInfo{Username: "bob", Groups: []string{"sre"}})
+	assert.True(t, allowed, "should allow the break-glass groups")
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// deleteTokenAnnotationKey is the annotation carrying the delete token required by --deleteTokenKeyFile
+const deleteTokenAnnotationKey = "namespace-guard.io/delete-token"
+
+// deleteTokenKey signs the delete tokens, nil unless --deleteTokenKeyFile is set
+var deleteTokenKey []byte
+
+// deleteTokenResponse is the delete token issued by the /deletetoken endpoint
+type deleteTokenResponse struct {
+	Namespace string    `json:"namespace"`
+	Token     string    `json:"token"`
+	Expires   time.Time `json:"expires"`
+	Command   string    `json:"command"`
+}
+
+// loadDeleteTokenKey reads the key signing the delete tokens, ignoring the surrounding whitespace
+func loadDeleteTokenKey(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, errors.New("the key is empty")
+	}
+	return key, nil
+}
+
+// deleteTokenSignature returns the signature of the namespace and expiry. The namespace UID is signed,
+// so that a token is only valid for the namespace it was issued for and not for a namespace recreated
+// with the same name.
+func deleteTokenSignature(key []byte, namespace *corev1.Namespace, expires string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(namespace.Name + "/" + string(namespace.UID) + "/" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDeleteToken returns the <expiry unix time>.<signature> token allowing the deletion of the
+// namespace until it expires
+func newDeleteToken(key []byte, namespace *corev1.Namespace, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + deleteTokenSignature(key, namespace, expiry)
+}
+
+// validateDeleteToken returns an error unless the namespace carries a delete token issued for it that
+// has not expired
+func validateDeleteToken(key []byte, namespace *corev1.Namespace, now time.Time) error {
+	token := namespace.Annotations[deleteTokenAnnotationKey]
+	if token == "" {
+		return fmt.Errorf("the namespace does not carry the %s annotation", deleteTokenAnnotationKey)
+	}
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return errors.New("the delete token is malformed")
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errors.New("the delete token is malformed")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(deleteTokenSignature(key, namespace, parts[0]))) {
+		return errors.New("the delete token was not issued for this namespace")
+	}
+	if expires := time.Unix(expiry, 0); now.After(expires) {
+		return fmt.Errorf("the delete token expired on %s", expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// deleteTokenApproverAllowed returns true if the user is among the --deleteTokenApprovers
+func deleteTokenApproverAllowed(user string) bool {
+	for _, allowed := range strings.Split(*deleteTokenApprovers, ",") {
+		if strings.TrimSpace(allowed) == user {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteTokenHandler serves the /deletetoken endpoint issuing a delete token for the namespace=
+// parameter on POST, valid for --deleteTokenTTL, for the --deleteTokenApprovers only. It is only
+// enabled with --deleteTokenKeyFile.
+func deleteTokenHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+
+	if deleteTokenKey == nil {
+		writeJSON(rw, http.StatusNotFound, explainError{"The delete token endpoint is not enabled"})
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeJSON(rw, http.StatusMethodNotAllowed, explainError{fmt.Sprintf("Incoming request method %s is not supported, only POST is supported", req.Method)})
+		return
+	}
+	user, err := authenticateRequest(req)
+	if err != nil {
+		log.Warnf("Rejected the delete token request from %s: %s", req.RemoteAddr, err.Error())
+		writeJSON(rw, http.StatusUnauthorized, explainError{err.Error()})
+		return
+	}
+	if !deleteTokenApproverAllowed(user) {
+		log.Warnf("Rejected the delete token request by %s from %s: not among the deleteTokenApprovers", user, req.RemoteAddr)
+		writeJSON(rw, http.StatusForbidden, explainError{fmt.Sprintf("User %s is not allowed to issue delete tokens", user)})
+		return
+	}
+
+	name := req.FormValue("namespace")
+	if name == "" {
+		writeJSON(rw, http.StatusBadRequest, explainError{"The namespace parameter is required"})
+		return
+	}
+	namespace, err := clientset.CoreV1().Namespaces().Get(name, v1.GetOptions{})
+	switch {
+	case apiErrors.IsNotFound(err):
+		writeJSON(rw, http.StatusNotFound, explainError{fmt.Sprintf("Namespace %s not found", name)})
+		return
+	case err != nil:
+		writeJSON(rw, http.StatusInternalServerError, explainError{fmt.Sprintf("Error occurred while retrieving the namespace %s: %s", name, err.Error())})
+		return
+	}
+
+	expires := time.Now().Add(*deleteTokenTTL).UTC().Truncate(time.Second)
+	token := newDeleteToken(deleteTokenKey, namespace, expires)
+	log.Warnf("Delete token issued by %s for namespace %s, valid until %s", user, name, expires.Format(time.RFC3339))
+	writeJSON(rw, http.StatusCreated, deleteTokenResponse{
+		Namespace: name,
+		Token:     token,
+		Expires:   expires,
+		Command:   fmt.Sprintf("kubectl annotate --overwrite namespace %s %s=%s", name, deleteTokenAnnotationKey, token),
+	})
+}
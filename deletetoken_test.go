@@ -0,0 +1,131 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+var testDeleteTokenKey = []byte("test-delete-token-key")
+
+// tokenNamespace returns the test namespace with the UID and delete token annotation
+func tokenNamespace(uid, token string) *corev1.Namespace {
+	namespace := cloneNamespace(templateNamespace)
+	namespace.UID = types.UID(uid)
+	if token != "" {
+		namespace.Annotations = map[string]string{deleteTokenAnnotationKey: token}
+	}
+	return namespace
+}
+
+func TestValidateDeleteToken(t *testing.T) {
+	now := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	token := newDeleteToken(testDeleteTokenKey, tokenNamespace("uid-1", ""), now.Add(time.Hour))
+
+	assert.Nil(t, validateDeleteToken(testDeleteTokenKey, tokenNamespace("uid-1", token), now), "should accept a valid token")
+	assert.Nil(t, validateDeleteToken(testDeleteTokenKey, tokenNamespace("uid-1", token), now.Add(time.Hour)), "should accept a token until it expires")
+
+	for _, c := range []struct {
+		namespace *corev1.Namespace
+		key       []byte
+		now       time.Time
+		err       string
+	}{
+		{tokenNamespace("uid-1", ""), testDeleteTokenKey, now, "the namespace does not carry the namespace-guard.io/delete-token annotation"},
+		{tokenNamespace("uid-1", token), testDeleteTokenKey, now.Add(time.Hour + time.Second), "the delete token expired on 2017-09-01T13:00:00Z"},
+		{tokenNamespace("uid-2", token), testDeleteTokenKey, now, "the delete token was not issued for this namespace"},
+		{tokenNamespace("uid-1", token), []byte("other-key"), now, "the delete token was not issued for this namespace"},
+		{tokenNamespace("uid-1", "9999999999"+token[10:]), testDeleteTokenKey, now, "the delete token was not issued for this namespace"},
+		{tokenNamespace("uid-1", "not-a-token"), testDeleteTokenKey, now, "the delete token is malformed"},
+	} {
+		err := validateDeleteToken(c.key, c.namespace, c.now)
+		if assert.NotNil(t, err, "should reject the token %q", c.namespace.Annotations[deleteTokenAnnotationKey]) {
+			assert.Equal(t, c.err, err.Error())
+		}
+	}
+}
+
+func TestDeleteTokenWebhookHandler(t *testing.T) {
+	deleteTokenKey = testDeleteTokenKey
+	defer func() { deleteTokenKey = nil }()
+
+	review := func(namespace *corev1.Namespace) (bool, string) {
+		clientset = fake.NewSimpleClientset(namespace)
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+
+	allowed, message := review(tokenNamespace("uid-1", ""))
+	assert.False(t, allowed, "should reject the deletion of an empty namespace without a token")
+	assert.Contains(t, message, "The deletion of namespace test-namespace requires a valid delete token: the namespace does not carry the namespace-guard.io/delete-token annotation.")
+
+	expired := newDeleteToken(testDeleteTokenKey, tokenNamespace("uid-1", ""), time.Now().Add(-time.Minute))
+	allowed, message = review(tokenNamespace("uid-1", expired))
+	assert.False(t, allowed, "should reject an expired token")
+	assert.Contains(t, message, "the delete token expired on")
+
+	valid := newDeleteToken(testDeleteTokenKey, tokenNamespace("uid-1", ""), time.Now().Add(time.Hour))
+	allowed, _ = review(tokenNamespace("uid-1", valid))
+	assert.True(t, allowed, "should allow the deletion of an empty namespace with a valid token")
+}
+
+// deleteTokenRequest sends a request to /deletetoken with the query and bearer token
+func deleteTokenRequest(method, query, token string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "http://localhost:8080/deletetoken?"+query, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	deleteTokenHandler(rw, req)
+	return rw
+}
+
+func TestDeleteTokenHandler(t *testing.T) {
+	rw := deleteTokenRequest("POST", "namespace=test-namespace", "alice-token")
+	assert.Equal(t, http.StatusNotFound, rw.Code, "should be disabled without a key")
+
+	deleteTokenKey = testDeleteTokenKey
+	*deleteTokenApprovers = "alice"
+	defer func() {
+		deleteTokenKey = nil
+		*deleteTokenApprovers = ""
+	}()
+	clientset = tokenReviewClientset(map[string]string{"alice-token": "alice", "bob-token": "bob"})
+	_, err := clientset.CoreV1().Namespaces().Create(tokenNamespace("uid-1", ""))
+	assert.Nil(t, err)
+
+	rw = deleteTokenRequest("GET", "namespace=test-namespace", "alice-token")
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+	rw = deleteTokenRequest("POST", "namespace=test-namespace", "")
+	assert.Equal(t, http.StatusUnauthorized, rw.Code, "should require a bearer token")
+	rw = deleteTokenRequest("POST", "namespace=test-namespace", "bob-token")
+	assert.Equal(t, http.StatusForbidden, rw.Code, "should only issue tokens to the approvers")
+	rw = deleteTokenRequest("POST", "namespace=other-namespace", "alice-token")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+
+	rw = deleteTokenRequest("POST", "namespace=test-namespace", "alice-token")
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	issued := deleteTokenResponse{}
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&issued))
+	assert.Equal(t, "test-namespace", issued.Namespace)
+	assert.Equal(t, "kubectl annotate --overwrite namespace test-namespace namespace-guard.io/delete-token="+issued.Token, issued.Command)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), issued.Expires, time.Minute)
+
+	namespace, err := clientset.CoreV1().Namespaces().Get("test-namespace", v1.GetOptions{})
+	assert.Nil(t, err)
+	namespace.Annotations = map[string]string{deleteTokenAnnotationKey: issued.Token}
+	assert.Nil(t, validateDeleteToken(deleteTokenKey, namespace, time.Now()), "should issue a valid token")
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/api/admission/v1alpha1"
+)
+
+const (
+	admissionAllowedMetric = "namespace_guard.admission.allowed"
+	admissionDeniedMetric  = "namespace_guard.admission.denied"
+)
+
+var (
+	// dogstatsd sends the admission metrics to the Datadog agent if --datadogStatsdHost is set
+	dogstatsd *dogstatsdClient
+
+	// dogstatsdTagReplacer replaces the characters of the DogStatsD datagram format in tag values
+	dogstatsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+)
+
+// dogstatsdClient writes metrics in the DogStatsD datagram format to the UDP port of a Datadog agent,
+// e.g. namespace_guard.admission.denied:1|c|#namespace:team-a,user:alice,operation:DELETE
+type dogstatsdClient struct {
+	conn net.Conn
+}
+
+// newDogstatsdClient returns a client of the agent at the address. As UDP is connectionless, an
+// unavailable agent only makes the writes fail.
+func newDogstatsdClient(address string) (*dogstatsdClient, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &dogstatsdClient{conn: conn}, nil
+}
+
+// count increments the counter with the tags, it is a no-op on a nil client and errors are only logged
+func (c *dogstatsdClient) count(name string, value int64, tags ...string) {
+	if c == nil {
+		return
+	}
+	var datagram bytes.Buffer
+	fmt.Fprintf(&datagram, "%s:%d|c", name, value)
+	for i, tag := range tags {
+		if i == 0 {
+			datagram.WriteString("|#")
+		} else {
+			datagram.WriteByte(',')
+		}
+		datagram.WriteString(dogstatsdTagReplacer.Replace(tag))
+	}
+	if _, err := c.conn.Write(datagram.Bytes()); err != nil {
+		log.Debugf("Error occurred while sending the %s metric to the Datadog agent: %s", name, err.Error())
+	}
+}
+
+// recordAdmission counts the admission decision with the namespace, user and operation tags
+func recordAdmission(admReview *v1alpha1.AdmissionReview, allowed bool) {
+	name := admissionAllowedMetric
+	if !allowed {
+		name = admissionDeniedMetric
+	}
+	dogstatsd.count(name, 1,
+		"namespace:"+admReview.Spec.Name,
+		"user:"+admReview.Spec.UserInfo.Username,
+		"operation:"+string(admReview.Spec.Operation))
+}
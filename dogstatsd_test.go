@@ -0,0 +1,86 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// listenDogstatsd returns a UDP listener standing in for the Datadog agent
+func listenDogstatsd(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.Nil(t, err)
+	return conn
+}
+
+// readDatagram returns the next datagram received by the agent
+func readDatagram(t *testing.T, conn *net.UDPConn) string {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	return string(buf[:n])
+}
+
+func TestDogstatsdCount(t *testing.T) {
+	agent := listenDogstatsd(t)
+	defer agent.Close()
+	client, err := newDogstatsdClient(agent.LocalAddr().String())
+	assert.Nil(t, err)
+
+	client.count("test.metric", 2)
+	assert.Equal(t, "test.metric:2|c", readDatagram(t, agent))
+	client.count("test.metric", 1, "namespace:team-a", "user:system:serviceaccount:ci,deployer|#1")
+	assert.Equal(t, "test.metric:1|c|#namespace:team-a,user:system:serviceaccount:ci_deployer__1", readDatagram(t, agent), "should sanitize the tag values")
+
+	// no-op without a client
+	var disabled *dogstatsdClient
+	disabled.count("test.metric", 1)
+}
+
+func TestDogstatsdUnavailableAgent(t *testing.T) {
+	agent := listenDogstatsd(t)
+	address := agent.LocalAddr().String()
+	agent.Close()
+
+	client, err := newDogstatsdClient(address)
+	assert.Nil(t, err, "should not need the agent to be up")
+	client.count("test.metric", 1)
+	client.count("test.metric", 1)
+}
+
+func TestDogstatsdWebhookHandler(t *testing.T) {
+	agent := listenDogstatsd(t)
+	defer agent.Close()
+	client, err := newDogstatsdClient(agent.LocalAddr().String())
+	assert.Nil(t, err)
+	dogstatsd = client
+	defer func() { dogstatsd = nil }()
+
+	testPod := &corev1.Pod{}
+	testPod.Name = "test-pod"
+	testPod.Namespace = "test-namespace"
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.False(t, getAdmissionReview(rw).Status.Allowed)
+	assert.Equal(t, "namespace_guard.admission.denied:1|c|#namespace:test-namespace,user:alice,operation:DELETE", readDatagram(t, agent))
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed)
+	assert.Equal(t, "namespace_guard.admission.allowed:1|c|#namespace:test-namespace,user:alice,operation:DELETE", readDatagram(t, agent))
+	waitForBackgroundTasks(t)
+}
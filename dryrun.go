@@ -0,0 +1,66 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+const (
+	// the exit codes of --dryRun
+	dryRunAllowed   = 0
+	dryRunRejected  = 1
+	dryRunUndecided = 2
+)
+
+// runDryRun writes the decision the webhook would make with the profile for the deletion of the
+// namespace by the user, along with the resources found, and returns the exit code of the dry run
+func runDryRun(w io.Writer, namespace, profileName string, userInfo authenticationv1.UserInfo) int {
+	profile, err := lookupProfile(profileName)
+	if err != nil {
+		fmt.Fprintf(w, "Invalid dryRunProfile: %s\n", err.Error())
+		return dryRunUndecided
+	}
+	resp, err := explainNamespace(namespace, profile, userInfo)
+	if err != nil {
+		fmt.Fprintf(w, "Error occurred while retrieving the namespace %s: %s\n", namespace, err.Error())
+		return dryRunUndecided
+	}
+
+	user := resp.User
+	if user == "" {
+		user = "an anonymous user"
+	}
+	fmt.Fprintf(w, "Deletion of the namespace %s by %s with the profile %s\n", namespace, user, resp.Profile)
+	fmt.Fprintf(w, "Resources in namespace %s:\n", namespace)
+	for _, f := range resp.Resources {
+		fmt.Fprintf(w, "  %-26s %d %v\n", f.Kind, f.Count, f.Names)
+	}
+	for _, e := range resp.Errors {
+		fmt.Fprintf(w, "  error: %s\n", e)
+	}
+	if resp.Bypass != "" {
+		fmt.Fprintf(w, "Bypass: %s\n", resp.Bypass)
+	}
+	if len(resp.Exceptions) > 0 {
+		fmt.Fprintf(w, "GuardExceptions: %s\n", strings.Join(resp.Exceptions, ", "))
+	}
+	if resp.EnforcementBucket != "" {
+		fmt.Fprintf(w, "Enforcement bucket: %s\n", resp.EnforcementBucket)
+	}
+
+	code, verdict := dryRunRejected, "rejected"
+	if resp.Allowed {
+		code, verdict = dryRunAllowed, "allowed"
+	}
+	fmt.Fprintf(w, "The deletion of the namespace %s would be %s.", namespace, verdict)
+	if resp.Reason != "" {
+		fmt.Fprintf(w, " %s", resp.Reason)
+	}
+	fmt.Fprintln(w)
+	return code
+}
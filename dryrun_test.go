@@ -0,0 +1,53 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDryRun(t *testing.T) {
+	dryRun := func(profile, user string) (int, string) {
+		out := &bytes.Buffer{}
+		code := runDryRun(out, "test-namespace", profile, authenticationv1.UserInfo{Username: user})
+		return code, out.String()
+	}
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	code, out := dryRun("", "alice")
+	assert.Equal(t, dryRunAllowed, code, "should allow the deletion of an empty namespace")
+	assert.Contains(t, out, "Deletion of the namespace test-namespace by alice with the profile default\n")
+	assert.Contains(t, out, "The deletion of the namespace test-namespace would be allowed. Namespace test-namespace does not contain any workload resources.\n")
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(2)...)
+	code, out = dryRun("", "")
+	assert.Equal(t, dryRunRejected, code, "should reject the deletion of a namespace holding pods")
+	assert.Contains(t, out, "by an anonymous user")
+	assert.Contains(t, out, "  pods                       2 [test-pod-0 test-pod-1]\n")
+	assert.Contains(t, out, "would be rejected. The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(2)].")
+
+	profiles["lenient"] = policyProfile{Name: "lenient", MaxResourceCount: 2}
+	defer delete(profiles, "lenient")
+	code, _ = dryRun("lenient", "")
+	assert.Equal(t, dryRunAllowed, code, "should decide the deletion with the profile")
+	code, out = dryRun("unknown", "")
+	assert.Equal(t, dryRunUndecided, code)
+	assert.Equal(t, "Invalid dryRunProfile: unknown profile unknown\n", out)
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	deleteTokenKey = testDeleteTokenKey
+	code, out = dryRun("", "alice")
+	deleteTokenKey = nil
+	assert.Equal(t, dryRunRejected, code, "should apply the checks of the webhook")
+	assert.Contains(t, out, "requires a valid delete token")
+
+	clientset = fake.NewSimpleClientset()
+	code, out = dryRun("", "alice")
+	assert.Equal(t, dryRunUndecided, code, "should not decide the deletion of a missing namespace")
+	assert.Contains(t, out, "Error occurred while retrieving the namespace test-namespace")
+}
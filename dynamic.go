@@ -0,0 +1,90 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	// optionalKinds are the resource kinds counted with the dynamic client once enabled by --resources,
+	// their types are not known to the clientset
+	optionalKinds = map[string]schema.GroupVersionResource{
+		"gateways":        {Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"},
+		"httproutes":      {Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"},
+		"servicemonitors": {Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors"},
+		"podmonitors":     {Group: "monitoring.coreos.com", Version: "v1", Resource: "podmonitors"},
+		"certificates":    {Group: "cert-manager.io", Version: "v1", Resource: "certificates"},
+		"issuers":         {Group: "cert-manager.io", Version: "v1", Resource: "issuers"},
+	}
+
+	// enabledOptionalKinds are the optionalKinds enabled by --resources, in order
+	enabledOptionalKinds []string
+
+	// dynamicClients lists the optional kinds, nil unless --resources enables one
+	dynamicClients dynamic.ClientPool
+)
+
+// parseOptionalKinds parses the comma separated --resources, returning the optional kinds enabled in
+// order
+func parseOptionalKinds(value string) ([]string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		if _, ok := optionalKinds[kind]; !ok {
+			known := make([]string, 0, len(optionalKinds))
+			for k := range optionalKinds {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown resource %q, it must be one of %v", kind, known)
+		}
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds, nil
+}
+
+// optionalCounters returns the counters of the enabledOptionalKinds listing with the dynamic clients
+func optionalCounters(clients dynamic.ClientPool) []resourceCounter {
+	counters := make([]resourceCounter, 0, len(enabledOptionalKinds))
+	for _, kind := range enabledOptionalKinds {
+		counters = append(counters, resourceCounter{kind, dynamicCounter(clients, optionalKinds[kind])})
+	}
+	return counters
+}
+
+// dynamicCounter returns the counter listing the objects of the resource with the dynamic client
+func dynamicCounter(clients dynamic.ClientPool, resource schema.GroupVersionResource) func(namespace string) ([]string, error) {
+	return func(namespace string) ([]string, error) {
+		client, err := clients.ClientForGroupVersionResource(resource)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := client.Resource(&v1.APIResource{Name: resource.Resource, Namespaced: true}, namespace).List(counterListOptions())
+		if err != nil {
+			return nil, err
+		}
+		list, ok := obj.(*unstructured.UnstructuredList)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %T listing %s", obj, resource.Resource)
+		}
+		names := make([]string, 0, len(list.Items))
+		for _, item := range list.Items {
+			if countedAge(item.GetCreationTimestamp()) {
+				names = append(names, item.GetName())
+			}
+		}
+		return names, nil
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// newDynamicAPIServer serves the given list responses by path
+func newDynamicAPIServer(lists map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		list, ok := lists[req.URL.Path]
+		if !ok {
+			http.NotFound(rw, req)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		io.WriteString(rw, list)
+	}))
+}
+
+func TestParseOptionalKinds(t *testing.T) {
+	kinds, err := parseOptionalKinds("httproutes, gateways,")
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, []string{"gateways", "httproutes"}, kinds)
+
+	_, err = parseOptionalKinds("gateways,grpcroutes")
+	if assert.NotNil(t, err, "should reject unknown kinds") {
+		assert.Contains(t, err.Error(), `unknown resource "grpcroutes"`)
+	}
+}
+
+func TestGatewayAPIWebhookHandler(t *testing.T) {
+	server := newDynamicAPIServer(map[string]string{
+		"/apis/gateway.networking.k8s.io/v1/namespaces/test-namespace/gateways":   `{"apiVersion":"gateway.networking.k8s.io/v1","kind":"GatewayList","metadata":{},"items":[{"apiVersion":"gateway.networking.k8s.io/v1","kind":"Gateway","metadata":{"name":"web-gateway","namespace":"test-namespace"}}]}`,
+		"/apis/gateway.networking.k8s.io/v1/namespaces/test-namespace/httproutes": `{"apiVersion":"gateway.networking.k8s.io/v1","kind":"HTTPRouteList","metadata":{},"items":[{"apiVersion":"gateway.networking.k8s.io/v1","kind":"HTTPRoute","metadata":{"name":"web-route","namespace":"test-namespace"}}]}`,
+	})
+	defer server.Close()
+	dynamicClients = dynamic.NewDynamicClientPool(&rest.Config{Host: server.URL})
+	enabledOptionalKinds = []string{"gateways", "httproutes"}
+	defer func() {
+		dynamicClients = nil
+		enabledOptionalKinds = nil
+	}()
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace holds Gateway API resources")
+	assert.Contains(t, admReview.Status.Result.Message, "[gateways(1) httproutes(1)]")
+
+	findings, errList := findNamespaceResources("test-namespace")
+	assert.Empty(t, errList)
+	for _, f := range findings {
+		switch f.Kind {
+		case "gateways":
+			assert.Equal(t, []string{"web-gateway"}, f.Names)
+		case "httproutes":
+			assert.Equal(t, []string{"web-route"}, f.Names)
+		}
+	}
+}
+
+func TestPrometheusMonitorsWebhookHandler(t *testing.T) {
+	server := newDynamicAPIServer(map[string]string{
+		"/apis/monitoring.coreos.com/v1/namespaces/test-namespace/servicemonitors": `{"apiVersion":"monitoring.coreos.com/v1","kind":"ServiceMonitorList","metadata":{},"items":[{"apiVersion":"monitoring.coreos.com/v1","kind":"ServiceMonitor","metadata":{"name":"web","namespace":"test-namespace"}}]}`,
+		"/apis/monitoring.coreos.com/v1/namespaces/test-namespace/podmonitors":     `{"apiVersion":"monitoring.coreos.com/v1","kind":"PodMonitorList","metadata":{},"items":[]}`,
+	})
+	defer server.Close()
+	dynamicClients = dynamic.NewDynamicClientPool(&rest.Config{Host: server.URL})
+	enabledOptionalKinds, _ = parseOptionalKinds("servicemonitors,podmonitors")
+	defer func() {
+		dynamicClients = nil
+		enabledOptionalKinds = nil
+	}()
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace holds a ServiceMonitor")
+	assert.Contains(t, admReview.Status.Result.Message, "these resources: [servicemonitors(1)]")
+}
+
+func TestCertManagerWebhookHandler(t *testing.T) {
+	server := newDynamicAPIServer(map[string]string{
+		"/apis/cert-manager.io/v1/namespaces/test-namespace/certificates": `{"apiVersion":"cert-manager.io/v1","kind":"CertificateList","metadata":{},"items":[{"apiVersion":"cert-manager.io/v1","kind":"Certificate","metadata":{"name":"web-tls","namespace":"test-namespace"}},{"apiVersion":"cert-manager.io/v1","kind":"Certificate","metadata":{"name":"api-tls","namespace":"test-namespace"}}]}`,
+		"/apis/cert-manager.io/v1/namespaces/test-namespace/issuers":      `{"apiVersion":"cert-manager.io/v1","kind":"IssuerList","metadata":{},"items":[]}`,
+	})
+	defer server.Close()
+	dynamicClients = dynamic.NewDynamicClientPool(&rest.Config{Host: server.URL})
+	enabledOptionalKinds, _ = parseOptionalKinds("certificates,issuers")
+	defer func() {
+		dynamicClients = nil
+		enabledOptionalKinds = nil
+	}()
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	findings, errList := findNamespaceResources("test-namespace")
+	assert.Empty(t, errList)
+	for _, f := range findings {
+		if f.Kind == "certificates" {
+			assert.Equal(t, []string{"web-tls", "api-tls"}, f.Names)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace holds Certificates")
+	assert.Contains(t, admReview.Status.Result.Message, "these resources: [certificates(2)]")
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// the policies of --onError
+	onErrorAllow = "allow"
+	onErrorDeny  = "deny"
+)
+
+var (
+	// errorPolicies are the --onErrorNamespaces overriding --onError, in their order of precedence
+	errorPolicies []errorPolicy
+)
+
+// errorPolicy is the policy applied to the deletions of the namespaces matching the pattern that could
+// not be validated
+type errorPolicy struct {
+	pattern string
+	policy  string
+}
+
+// parseErrorPolicies parses the comma separated <pattern>=<allow|deny> list, the patterns being shell
+// file name patterns such as prod-*
+func parseErrorPolicies(value string) ([]errorPolicy, error) {
+	var policies []errorPolicy
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid error policy %q, it must be <pattern>=<%s|%s>", entry, onErrorAllow, onErrorDeny)
+		}
+		pattern, policy := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid namespace pattern %q", pattern)
+		}
+		if policy != onErrorAllow && policy != onErrorDeny {
+			return nil, fmt.Errorf("invalid policy %q for pattern %s, it must be either %s or %s", policy, pattern, onErrorAllow, onErrorDeny)
+		}
+		policies = append(policies, errorPolicy{pattern: pattern, policy: policy})
+	}
+	return policies, nil
+}
+
+// namespaceErrorPolicy returns the policy of the first --onErrorNamespaces pattern matching the
+// namespace, --onError if none matches
+func namespaceErrorPolicy(namespace string) string {
+	for _, p := range errorPolicies {
+		if matched, _ := path.Match(p.pattern, namespace); matched {
+			return p.policy
+		}
+	}
+	return *onError
+}
+
+// errorDecision returns the decision for a deletion of the namespace that could not be validated: a
+// rejection as an InternalError, or with the allow policy, an admission with the error as a warning
+func errorDecision(namespace, message string) admissionDecision {
+	if namespaceErrorPolicy(namespace) == onErrorAllow {
+		log.Warnf("The deletion of namespace %s could not be validated, allowing it per its error policy: %s", namespace, message)
+		return allow("The deletion could not be validated and is allowed by the error policy of the namespace: " + message)
+	}
+	return internalError(message)
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseErrorPolicies(t *testing.T) {
+	policies, err := parseErrorPolicies("prod-*=deny, ci-*=allow,")
+	assert.Nil(t, err)
+	assert.Equal(t, []errorPolicy{{"prod-*", onErrorDeny}, {"ci-*", onErrorAllow}}, policies)
+
+	policies, err = parseErrorPolicies("")
+	assert.Nil(t, err)
+	assert.Empty(t, policies)
+
+	_, err = parseErrorPolicies("prod-*")
+	assert.Contains(t, err.Error(), `invalid error policy "prod-*"`)
+	_, err = parseErrorPolicies("prod-[=deny")
+	assert.Contains(t, err.Error(), `invalid namespace pattern "prod-["`)
+	_, err = parseErrorPolicies("prod-*=warn")
+	assert.Contains(t, err.Error(), `invalid policy "warn" for pattern prod-*`)
+}
+
+func TestNamespaceErrorPolicy(t *testing.T) {
+	errorPolicies = []errorPolicy{{"ci-prod-*", onErrorDeny}, {"ci-*", onErrorAllow}}
+	defer func() { errorPolicies = nil }()
+
+	assert.Equal(t, onErrorDeny, namespaceErrorPolicy("ci-prod-1"), "should apply the first matching pattern")
+	assert.Equal(t, onErrorAllow, namespaceErrorPolicy("ci-1"))
+	assert.Equal(t, onErrorDeny, namespaceErrorPolicy("team-a"), "should default to onError")
+
+	*onError = onErrorAllow
+	defer func() { *onError = onErrorDeny }()
+	assert.Equal(t, onErrorAllow, namespaceErrorPolicy("team-a"))
+}
+
+func TestErrorPolicyWebhookHandler(t *testing.T) {
+	errorPolicies, _ = parseErrorPolicies("prod-*=deny,ci-*=allow")
+	defer func() { errorPolicies = nil }()
+
+	review := func(name string) *v1.Status {
+		namespace := cloneNamespace(templateNamespace)
+		namespace.Name = name
+		clientset = failingServicesClientset(apiErrors.NewForbidden(schema.GroupResource{Resource: "services"}, "", fmt.Errorf("RBAC denied")), namespace)
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.Name = name
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+		webhookHandler(rw, req)
+		admReview := getAdmissionReview(rw)
+		assert.Equal(t, admReview.Status.Allowed, namespaceErrorPolicy(name) == onErrorAllow, "namespace %s", name)
+		return admReview.Status.Result
+	}
+
+	result := review("prod-payments")
+	assert.Equal(t, v1.StatusReasonInternalError, result.Reason, "should fail closed on the prod namespaces")
+	assert.Contains(t, result.Message, "error listing services")
+
+	result = review("ci-build-42")
+	assert.Contains(t, result.Message, "The deletion could not be validated and is allowed by the error policy of the namespace:", "should fail open on the ci namespaces")
+	assert.Contains(t, result.Message, "error listing services")
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	eventSourceComponent  = "k8s-namespace-guard"
+	deletionAtRiskReason  = "DeletionAtRisk"
+	namespaceEventKind    = "Namespace"
+	namespaceEventVersion = "v1"
+)
+
+// newNamespaceEvent returns an event of the given type and reason on the namespace, created in the
+// event namespace
+func newNamespaceEvent(namespace *corev1.Namespace, eventNamespace, eventType, reason, message string) *corev1.Event {
+	now := v1.Now()
+	return &corev1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", namespace.Name, now.UnixNano()),
+			Namespace: eventNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:            namespaceEventKind,
+			APIVersion:      namespaceEventVersion,
+			Name:            namespace.Name,
+			UID:             namespace.UID,
+			ResourceVersion: namespace.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+}
+
+// recordNamespaceEvent records an event of the given type and reason on the namespace with the client.
+// The event is named after the namespace and reason, so that a recurring event bumps the count and
+// last timestamp of the existing one rather than adding an event every time.
+func recordNamespaceEvent(client kubernetes.Interface, namespace *corev1.Namespace, eventType, reason, message string) {
+	events := client.CoreV1().Events(namespace.Name)
+	name := fmt.Sprintf("%s.%s", namespace.Name, strings.ToLower(reason))
+	event, err := events.Get(name, v1.GetOptions{})
+	if apiErrors.IsNotFound(err) {
+		event = newNamespaceEvent(namespace, namespace.Name, eventType, reason, message)
+		event.Name = name
+		_, err = events.Create(event)
+	} else if err == nil {
+		event.Count++
+		event.LastTimestamp = v1.Now()
+		event.Message = message
+		_, err = events.Update(event)
+	}
+	if err != nil {
+		log.Errorf("Error occurred while recording the %s event on the namespace %s: %s", reason, namespace.Name, err.Error())
+	}
+}
+
+// checkSoftThreshold emits a DeletionAtRisk warning event on the namespace when the risk score of its
+// resources is more than softThresholdPercentage of the limit allowed before deletion is blocked. It is
+// called by the background scan, so that the event is recorded while the namespace is still there.
+func checkSoftThreshold(client kubernetes.Interface, namespace *corev1.Namespace, findings []resourceFinding, limit int) {
+	if !*softThresholdEnabled || limit <= 0 {
+		return
+	}
+
+	score, percentage := riskScore(findings), *softThresholdPercentage
+	if score*100 <= limit*percentage {
+		return
+	}
+
+	message := fmt.Sprintf("The namespace %s contains %d workload resources, more than %d%% of the %d allowed before its deletion is blocked.",
+		namespace.Name, score, percentage, limit)
+	if len(kindWeights) > 0 {
+		message = fmt.Sprintf("The namespace %s has a risk score of %d, more than %d%% of the %d allowed before its deletion is blocked.",
+			namespace.Name, score, percentage, limit)
+	}
+	log.Warn(message)
+	recordNamespaceEvent(client, namespace, corev1.EventTypeWarning, deletionAtRiskReason, message)
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func namespaceWithPods(count int) []runtime.Object {
+	objects := []runtime.Object{cloneNamespace(templateNamespace)}
+	for i := 0; i < count; i++ {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      fmt.Sprintf("test-pod-%d", i),
+				Namespace: "test-namespace",
+			},
+		})
+	}
+	return objects
+}
+
+func TestSoftThresholdScanner(t *testing.T) {
+	*maxResourceCount = 10
+	*softThresholdEnabled = true
+	defer func() {
+		*maxResourceCount = 0
+		*softThresholdEnabled = false
+	}()
+	scan := func(pods int) []corev1.Event {
+		client := fake.NewSimpleClientset(namespaceWithPods(pods)...)
+		_, err := (&namespaceScanner{client: client}).scan()
+		assert.Nil(t, err)
+		events, err := client.CoreV1().Events("test-namespace").List(v1.ListOptions{})
+		assert.Nil(t, err, "Error should be nil")
+		return events.Items
+	}
+
+	events := scan(9)
+	assert.Len(t, events, 1, "should emit a single DeletionAtRisk event")
+	assert.Equal(t, corev1.EventTypeWarning, events[0].Type)
+	assert.Equal(t, deletionAtRiskReason, events[0].Reason)
+	assert.Equal(t, "test-namespace", events[0].InvolvedObject.Name)
+	assert.Contains(t, events[0].Message, "contains 9 workload resources, more than 80% of the 10 allowed")
+
+	assert.Empty(t, scan(8), "should not emit an event at or below the soft threshold")
+	assert.Empty(t, scan(11), "should not emit a DeletionAtRisk event once deletion is blocked")
+}
+
+func TestSoftThresholdRepeatedScans(t *testing.T) {
+	*maxResourceCount = 10
+	*softThresholdEnabled = true
+	defer func() {
+		*maxResourceCount = 0
+		*softThresholdEnabled = false
+	}()
+
+	client := fake.NewSimpleClientset(namespaceWithPods(9)...)
+	scanner := &namespaceScanner{client: client}
+	for i := 0; i < 2; i++ {
+		_, err := scanner.scan()
+		assert.Nil(t, err)
+	}
+	events, err := client.CoreV1().Events("test-namespace").List(v1.ListOptions{})
+	assert.Nil(t, err, "Error should be nil")
+	if assert.Len(t, events.Items, 1, "should not add an event on every scan") {
+		assert.Equal(t, "test-namespace.deletionatrisk", events.Items[0].Name)
+		assert.Equal(t, int32(2), events.Items[0].Count, "should count the scans finding the namespace at risk")
+	}
+}
+
+func TestSoftThresholdWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*maxResourceCount = 10
+	*softThresholdEnabled = true
+	defer func() {
+		*maxResourceCount = 0
+		*softThresholdEnabled = false
+	}()
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(9)...)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should approve if the namespace holds no more than maxResourceCount resources")
+
+	events, err := clientset.CoreV1().Events("test-namespace").List(v1.ListOptions{})
+	assert.Nil(t, err, "Error should be nil")
+	assert.Empty(t, events.Items, "should not emit the DeletionAtRisk event in the admission path")
+}
+
+func TestMaxResourceCountExceededWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*maxResourceCount = 10
+	defer func() { *maxResourceCount = 0 }()
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(11)...)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace holds more than maxResourceCount resources")
+	assert.Contains(t, admReview.Status.Result.Message, "It holds 11 resources while at most 10 are allowed.")
+}
@@ -0,0 +1,21 @@
+# Denies the deletion of the namespaces labeled environment=production unless their retirement was
+# approved with the namespace-guard.io/retire-approved annotation, and of any namespace by the users
+# outside of the platform-admins group while it still holds resources below the thresholds.
+package namespace_guard
+
+deny[msg] {
+    input.namespace.metadata.labels.environment = "production"
+    not input.namespace.metadata.annotations["namespace-guard.io/retire-approved"]
+    msg = sprintf("the production namespace %s has no namespace-guard.io/retire-approved annotation", [input.namespace.metadata.name])
+}
+
+deny[msg] {
+    finding = input.findings[_]
+    finding.count > 0
+    not platform_admin
+    msg = sprintf("only the platform-admins may delete the namespace %s while it holds %s", [input.namespace.metadata.name, finding.kind])
+}
+
+platform_admin {
+    input.userInfo.groups[_] = "platform-admins"
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+const (
+	// the exemptions letting a user skip some of the checks
+	exemptionSystemController = "systemController"
+	exemptionOwnerController  = "ownerController"
+	exemptionBreakGlass       = "breakGlass"
+	exemptionQuota            = "quotaExempt"
+
+	// what matched the exemption, the user name or one of its groups
+	exemptionMatchUser  = "user"
+	exemptionMatchGroup = "group"
+)
+
+// exemptionRecord is the audit record of a request let through by an exemption
+type exemptionRecord struct {
+	Namespace string   `json:"namespace"`
+	Operation string   `json:"operation"`
+	User      string   `json:"user"`
+	Groups    []string `json:"groups,omitempty"`
+	Exemption string   `json:"exemption"`
+	// Match is user or group, Matched being the user name or group that matched
+	Match     string    `json:"match"`
+	Matched   string    `json:"matched"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// recordExemption logs the audit record of the exemption used by the requester and counts it, so that
+// the requests of the exempt users are accounted for
+func recordExemption(namespace, operation string, userInfo authenticationv1.UserInfo, exemption, match, matched string) {
+	exemptionUsedTotal.WithLabelValues(exemption, match).Inc()
+	record := exemptionRecord{
+		Namespace: namespace,
+		Operation: operation,
+		User:      userInfo.Username,
+		Groups:    userInfo.Groups,
+		Exemption: exemption,
+		Match:     match,
+		Matched:   matched,
+		Timestamp: time.Now().UTC(),
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Error occurred while encoding the exemption audit record of namespace %s: %s", namespace, err.Error())
+		return
+	}
+	log.Warnf("Exemption audit record of namespace %s: %s", namespace, data)
+}
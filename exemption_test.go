@@ -0,0 +1,53 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func exemptionUsedValue(exemption, match string) float64 {
+	m := &dto.Metric{}
+	exemptionUsedTotal.WithLabelValues(exemption, match).Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestExemptionAuditWebhookHandler(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Out
+	log.Out = &buf
+	defer func() { log.Out = out }()
+
+	review := func(username string) bool {
+		clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.UserInfo.Username = username
+		rw := httptest.NewRecorder()
+		webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec)))
+		return getAdmissionReview(rw).Status.Allowed
+	}
+
+	systemControllers := exemptionUsedValue(exemptionSystemController, exemptionMatchUser)
+	assert.True(t, review("system:serviceaccount:kube-system:namespace-controller"))
+	assert.Equal(t, systemControllers+1, exemptionUsedValue(exemptionSystemController, exemptionMatchUser))
+	assert.Contains(t, buf.String(), `Exemption audit record of namespace test-namespace: {"namespace":"test-namespace","operation":"DELETE","user":"system:serviceaccount:kube-system:namespace-controller","exemption":"systemController","match":"user","matched":"system:serviceaccount:kube-system:namespace-controller"`)
+
+	buf.Reset()
+	userQuota = newDeletionQuota(1, []string{"system:serviceaccount:ci:deployer"}, "", time.Now)
+	defer func() { userQuota = nil }()
+	quotaExempt := exemptionUsedValue(exemptionQuota, exemptionMatchUser)
+	assert.False(t, review("system:serviceaccount:ci:deployer"), "should still validate the requests of the quota exempt users")
+	assert.Equal(t, quotaExempt+1, exemptionUsedValue(exemptionQuota, exemptionMatchUser))
+	assert.Contains(t, buf.String(), `"exemption":"quotaExempt","match":"user","matched":"system:serviceaccount:ci:deployer"`)
+
+	buf.Reset()
+	assert.False(t, review("alice"))
+	assert.NotContains(t, buf.String(), "Exemption audit record", "should only audit the exempt users")
+}
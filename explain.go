@@ -0,0 +1,205 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// clientLimiterIdleTimeout is the time after which the rate limiter of a client without requests is
+// evicted, long past the refill of its bucket with the usual explainQPS and explainBurst
+const clientLimiterIdleTimeout = 10 * time.Minute
+
+var (
+	explainLimiter = newClientRateLimiter(time.Now)
+)
+
+// explainResponse describes the decision the webhook would make for a namespace deletion, along with
+// how the policy was scoped to it: the profile applied, the bypass honored, the GuardExceptions waiving
+// checks and the enforcement bucket of a deletion violating the resource policy
+type explainResponse struct {
+	Namespace         string            `json:"namespace"`
+	User              string            `json:"user,omitempty"`
+	Allowed           bool              `json:"allowed"`
+	Reason            string            `json:"reason,omitempty"`
+	Profile           string            `json:"profile"`
+	AdmitAll          bool              `json:"admitAll"`
+	Bypass            string            `json:"bypass,omitempty"`
+	BypassAnnotation  bool              `json:"bypassAnnotation"`
+	ForceDelete       bool              `json:"forceDelete"`
+	Exceptions        []string          `json:"exceptions,omitempty"`
+	EnforcementBucket string            `json:"enforcementBucket,omitempty"`
+	Resources         []resourceFinding `json:"resources"`
+	Errors            []string          `json:"errors,omitempty"`
+}
+
+// explainError is the JSON body returned when the /explain request cannot be served
+type explainError struct {
+	Error string `json:"error"`
+}
+
+// clientRateLimiter keeps a token bucket rate limiter per client host, evicting the limiters of the
+// clients idle for more than the clientLimiterIdleTimeout
+type clientRateLimiter struct {
+	sync.Mutex
+	limiters  map[string]*clientLimiter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// clientLimiter is the rate limiter of a client and the time of its last request
+type clientLimiter struct {
+	flowcontrol.RateLimiter
+	lastSeen time.Time
+}
+
+func newClientRateLimiter(now func() time.Time) *clientRateLimiter {
+	return &clientRateLimiter{limiters: map[string]*clientLimiter{}, lastSweep: now(), now: now}
+}
+
+// allow returns true if the client still has tokens left in its bucket
+func (c *clientRateLimiter) allow(client string) bool {
+	c.Lock()
+	now := c.now()
+	if now.Sub(c.lastSweep) > clientLimiterIdleTimeout {
+		c.sweep(now)
+	}
+	limiter, ok := c.limiters[client]
+	if !ok {
+		limiter = &clientLimiter{RateLimiter: flowcontrol.NewTokenBucketRateLimiter(float32(*explainQPS), *explainBurst)}
+		c.limiters[client] = limiter
+	}
+	limiter.lastSeen = now
+	c.Unlock()
+	return limiter.TryAccept()
+}
+
+// sweep evicts the limiters of the clients idle for more than the clientLimiterIdleTimeout, the lock
+// must be held
+func (c *clientRateLimiter) sweep(now time.Time) {
+	for client, limiter := range c.limiters {
+		if now.Sub(limiter.lastSeen) > clientLimiterIdleTimeout {
+			delete(c.limiters, client)
+		}
+	}
+	c.lastSweep = now
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	err := json.NewEncoder(rw).Encode(v)
+	if err != nil {
+		log.Errorf("Error occurred while encoding the response into json: %s", err.Error())
+	}
+}
+
+// explainHandler serves the /explain endpoint which reports, without deleting anything, whether the
+// given namespace could currently be deleted by the given user
+func explainHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+
+	if req.Method != http.MethodGet {
+		writeJSON(rw, http.StatusMethodNotAllowed, explainError{fmt.Sprintf("Incoming request method %s is not supported, only GET is supported", req.Method)})
+		return
+	}
+
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+	if !explainLimiter.allow(client) {
+		writeJSON(rw, http.StatusTooManyRequests, explainError{fmt.Sprintf("Rate limit exceeded for client %s, please try again later", client)})
+		return
+	}
+
+	name := req.URL.Query().Get("namespace")
+	if name == "" {
+		writeJSON(rw, http.StatusBadRequest, explainError{"The namespace query parameter is required"})
+		return
+	}
+
+	profile, err := lookupProfile(req.URL.Query().Get("profile"))
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, explainError{err.Error()})
+		return
+	}
+	// the deletion is explained for the user and groups given, as the deleters, quota, requester scoping
+	// and rules depend on who deletes the namespace
+	userInfo := authenticationv1.UserInfo{Username: req.URL.Query().Get("user"), Groups: req.URL.Query()["group"]}
+	resp, err := explainNamespace(name, profile, userInfo)
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			writeJSON(rw, http.StatusNotFound, explainError{fmt.Sprintf("Namespace %s not found", name)})
+		} else {
+			writeJSON(rw, http.StatusInternalServerError, explainError{fmt.Sprintf("Error occurred while retrieving the namespace %s: %s", name, err.Error())})
+		}
+		return
+	}
+	writeJSON(rw, http.StatusOK, resp)
+}
+
+// explainNamespace returns the decision the webhook would make with the profile for the deletion of the
+// namespace by the user, or the error retrieving it. It is shared by /explain, the gRPC Check RPC and
+// --dryRun.
+func explainNamespace(name string, profile policyProfile, userInfo authenticationv1.UserInfo) (explainResponse, error) {
+	namespace, err := clientset.CoreV1().Namespaces().Get(name, v1.GetOptions{})
+	if err != nil {
+		return explainResponse{}, err
+	}
+	v := newValidator("/", profile)
+	v.dryRun = true
+	return v.explain(namespace, userInfo), nil
+}
+
+// explain returns the decision of the validator for the deletion of the namespace by the user, along
+// with the resources found even if the deletion was decided before counting them
+func (v *validator) explain(namespace *corev1.Namespace, userInfo authenticationv1.UserInfo) explainResponse {
+	resp := explainResponse{
+		Namespace: namespace.Name,
+		User:      userInfo.Username,
+		Profile:   v.profile.Name,
+		AdmitAll:  v.profile.Mode == admitAllMode,
+	}
+	var o deletionOutcome
+	switch {
+	case *admitSystemControllers && systemControllerUsers[userInfo.Username]:
+		o.decision, o.reason = allow(""), fmt.Sprintf("Request by system controller %s, the deletion is allowed without validation.", userInfo.Username)
+	case resp.AdmitAll:
+		o.decision, o.reason = allow(""), fmt.Sprintf("Profile %s is in %s mode, all namespace deletions are allowed without validation.", v.profile.Name, admitAllMode)
+	default:
+		o = v.decide(namespace, userInfo, nil)
+	}
+	if !o.counted {
+		// the resources are still reported when the deletion is decided before counting them
+		o.findings, o.errList = findResources(namespace.Name, v.profile.counters())
+	}
+
+	resp.Allowed = o.decision.allowed
+	resp.Reason = o.reason
+	if resp.Reason == "" || o.decision.message != "" {
+		resp.Reason = o.decision.message
+	}
+	resp.Bypass = o.bypass
+	resp.BypassAnnotation = o.bypass == bypassAnnotation
+	resp.ForceDelete = o.bypass == bypassForceDelete
+	resp.Exceptions = o.exceptions
+	resp.EnforcementBucket = o.bucket
+	resp.Resources = o.findings
+	for _, e := range o.errList {
+		resp.Errors = append(resp.Errors, e.Error())
+	}
+	return resp
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getExplainResponse(rw *httptest.ResponseRecorder) *explainResponse {
+	resp := &explainResponse{}
+	err := json.NewDecoder(rw.Result().Body).Decode(resp)
+	if err != nil {
+		panic(err.Error())
+	}
+	return resp
+}
+
+func TestDeletableNamespaceExplainHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	testNamespace := cloneNamespace(templateNamespace)
+	clientset = fake.NewSimpleClientset(testNamespace)
+	req := httptest.NewRequest("GET", "http://localhost:8080/explain?namespace=test-namespace", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	explainHandler(rw, req)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	resp := getExplainResponse(rw)
+	assert.True(t, resp.Allowed, "should report an empty namespace as deletable")
+	assert.False(t, resp.BypassAnnotation)
+	assert.Equal(t, len(resourceCounters()), len(resp.Resources), "should report every counted kind")
+	for _, f := range resp.Resources {
+		assert.Equal(t, 0, f.Count, "should report zero %s", f.Kind)
+	}
+}
+
+func TestBlockedNamespaceExplainHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	testNamespace := cloneNamespace(templateNamespace)
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+	req := httptest.NewRequest("GET", "http://localhost:8080/explain?namespace=test-namespace", nil)
+	req.RemoteAddr = "192.0.2.11:1234"
+	explainHandler(rw, req)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	resp := getExplainResponse(rw)
+	assert.False(t, resp.Allowed, "should report a namespace with pods as not deletable")
+	assert.Contains(t, resp.Reason, "contains one or more of these resources: [pods(1)]")
+	assert.Equal(t, resourceFinding{Kind: "pods", Count: 1, Names: []string{"test-pod"}}, resp.Resources[0])
+}
+
+func TestBypassedNamespaceExplainHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true"}
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+	req := httptest.NewRequest("GET", "http://localhost:8080/explain?namespace=test-namespace", nil)
+	req.RemoteAddr = "192.0.2.12:1234"
+	explainHandler(rw, req)
+
+	resp := getExplainResponse(rw)
+	assert.True(t, resp.Allowed, "should report a bypass-annotated namespace as deletable")
+	assert.True(t, resp.BypassAnnotation)
+	assert.Equal(t, 1, resp.Resources[0].Count, "should still report the resources found")
+}
+
+func TestFingerprintedBypassExplainHandler(t *testing.T) {
+	explain := func(value, addr string) *explainResponse {
+		objects := namespaceWithPods(2)
+		objects[0].(*corev1.Namespace).Annotations = map[string]string{bypassAnnotationKey: value}
+		clientset = fake.NewSimpleClientset(objects...)
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:8080/explain?namespace=test-namespace", nil)
+		req.RemoteAddr = addr
+		explainHandler(rw, req)
+		return getExplainResponse(rw)
+	}
+	twoPods := contentFingerprint([]resourceFinding{{Kind: "pods", Count: 2}})
+
+	resp := explain(twoPods, "192.0.2.20:1234")
+	assert.True(t, resp.Allowed, "should report a matching fingerprint as deletable")
+	assert.True(t, resp.BypassAnnotation)
+
+	resp = explain(contentFingerprint([]resourceFinding{{Kind: "pods", Count: 1}}), "192.0.2.21:1234")
+	assert.False(t, resp.Allowed, "should report a stale fingerprint as not deletable")
+	assert.False(t, resp.BypassAnnotation)
+	assert.Contains(t, resp.Reason, "The bypass annotation of the namespace test-namespace does not match its current contents, whose fingerprint is "+twoPods+".")
+
+	*requireBypassFingerprint = true
+	defer func() { *requireBypassFingerprint = false }()
+	resp = explain("true", "192.0.2.22:1234")
+	assert.False(t, resp.Allowed, "should require a fingerprint with requireBypassFingerprint")
+	assert.False(t, resp.BypassAnnotation)
+	assert.Contains(t, resp.Reason, "="+twoPods+"`")
+}
+
+// explain requests /explain with the query from the client address
+func explain(query, addr string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:8080/explain?"+query, nil)
+	req.RemoteAddr = addr
+	explainHandler(rw, req)
+	return rw
+}
+
+func TestWebhookChecksExplainHandler(t *testing.T) {
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	deleteTokenKey = testDeleteTokenKey
+	resp := getExplainResponse(explain("namespace=test-namespace", "192.0.2.30:1234"))
+	deleteTokenKey = nil
+	assert.False(t, resp.Allowed, "should report the deletion of an empty namespace without a token as rejected")
+	assert.Contains(t, resp.Reason, "The deletion of namespace test-namespace requires a valid delete token")
+	assert.Equal(t, len(resourceCounters()), len(resp.Resources), "should still report every counted kind")
+
+	config, err := parseConfig([]byte(testRulesConfig))
+	assert.Nil(t, err, "Error should be nil")
+	celRules = config.Rules[:1]
+	resp = getExplainResponse(explain("namespace=test-namespace", "192.0.2.31:1234"))
+	celRules = nil
+	assert.False(t, resp.Allowed, "should report the deletions the rules deny as rejected")
+	assert.Contains(t, resp.Reason, "The deletion of the namespace test-namespace is denied by the rules: owner-required")
+
+	*enforcementPercent = 0
+	defer func() { *enforcementPercent = 100 }()
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+	resp = getExplainResponse(explain("namespace=test-namespace", "192.0.2.32:1234"))
+	assert.True(t, resp.Allowed, "should report the deletions of the warn bucket as allowed")
+	assert.Equal(t, warnBucket, resp.EnforcementBucket)
+	assert.Contains(t, resp.Reason, "Warn-only, this deletion will be rejected once enforced")
+}
+
+func TestUserExplainHandler(t *testing.T) {
+	userQuota = newDeletionQuota(1, nil, "", time.Now)
+	defer func() { userQuota = nil }()
+	reserve(t, userQuota, "alice")
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	resp := getExplainResponse(explain("namespace=test-namespace&user=alice&group=team-a", "192.0.2.33:1234"))
+	assert.False(t, resp.Allowed, "should explain the deletion for the user given")
+	assert.Equal(t, "alice", resp.User)
+	assert.Contains(t, resp.Reason, "Deletion quota exceeded")
+
+	resp = getExplainResponse(explain("namespace=test-namespace&user=bob", "192.0.2.34:1234"))
+	assert.True(t, resp.Allowed, "should allow the users within their quota")
+	assert.Equal(t, []userUsage{{User: "alice", Deletions: 1}}, userQuota.usage(), "should not count the explained deletions in the quota")
+}
+
+func TestProfileExplainHandler(t *testing.T) {
+	profiles["lenient"] = policyProfile{Name: "lenient", MaxResourceCount: 1}
+	defer delete(profiles, "lenient")
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+
+	resp := getExplainResponse(explain("namespace=test-namespace", "192.0.2.35:1234"))
+	assert.False(t, resp.Allowed, "should apply the default profile without the profile parameter")
+	assert.Equal(t, defaultProfileName, resp.Profile)
+
+	resp = getExplainResponse(explain("namespace=test-namespace&profile=lenient", "192.0.2.36:1234"))
+	assert.True(t, resp.Allowed, "should apply the maxResourceCount of the profile")
+	assert.Equal(t, "lenient", resp.Profile)
+
+	rw := explain("namespace=test-namespace&profile=unknown", "192.0.2.37:1234")
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestMissingNamespaceExplainHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	clientset = fake.NewSimpleClientset()
+	req := httptest.NewRequest("GET", "http://localhost:8080/explain?namespace=test-namespace", nil)
+	req.RemoteAddr = "192.0.2.13:1234"
+	explainHandler(rw, req)
+
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	errResp := &explainError{}
+	assert.Nil(t, json.NewDecoder(rw.Result().Body).Decode(errResp))
+	assert.Equal(t, "Namespace test-namespace not found", errResp.Error)
+}
+
+func TestRateLimitedExplainHandler(t *testing.T) {
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	codes := []int{}
+	for i := 0; i < *explainBurst+1; i++ {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:8080/explain?namespace=test-namespace", nil)
+		req.RemoteAddr = "192.0.2.14:1234"
+		explainHandler(rw, req)
+		codes = append(codes, rw.Code)
+	}
+
+	assert.Equal(t, http.StatusOK, codes[0])
+	assert.Equal(t, http.StatusTooManyRequests, codes[len(codes)-1], "should rate limit a client exceeding its burst")
+}
+
+func TestWrongMethodExplainHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/explain?namespace=test-namespace", nil)
+	explainHandler(rw, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
+
+func TestClientRateLimiterEviction(t *testing.T) {
+	now := time.Now()
+	limiter := newClientRateLimiter(func() time.Time { return now })
+	assert.True(t, limiter.allow("192.0.2.14"))
+	assert.True(t, limiter.allow("192.0.2.15"))
+
+	now = now.Add(clientLimiterIdleTimeout / 2)
+	assert.True(t, limiter.allow("192.0.2.15"))
+	assert.Len(t, limiter.limiters, 2)
+
+	now = now.Add(clientLimiterIdleTimeout/2 + time.Second)
+	assert.True(t, limiter.allow("192.0.2.16"))
+	assert.NotContains(t, limiter.limiters, "192.0.2.14", "should evict the idle clients")
+	assert.Contains(t, limiter.limiters, "192.0.2.15")
+	assert.Contains(t, limiter.limiters, "192.0.2.16")
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// failsafe admits deletions while the apiserver is unreachable, nil when --failsafeAfter is not set
+	failsafe *apiserverFailsafe
+)
+
+// apiserverFailsafe tracks the consecutive apiserver failures and engages once they last longer than
+// the configured window, so that the webhook never wedges the cluster during an apiserver outage
+type apiserverFailsafe struct {
+	sync.Mutex
+	after       time.Duration
+	minFailures int
+	now         func() time.Time
+	failures    int
+	// failingSince is the time of the first of the consecutive failures
+	failingSince time.Time
+	engaged      bool
+}
+
+// newAPIServerFailsafe returns a failsafe engaging after at least minFailures consecutive failures
+// spanning the after duration
+func newAPIServerFailsafe(after time.Duration, minFailures int, now func() time.Time) *apiserverFailsafe {
+	return &apiserverFailsafe{after: after, minFailures: minFailures, now: now}
+}
+
+// observe records the outcome of an apiserver request. Only transient errors count as failures, any
+// other response shows the apiserver is reachable and disengages the failsafe.
+func (f *apiserverFailsafe) observe(err error) {
+	f.Lock()
+	defer f.Unlock()
+	if err == nil || !isTransientError(err) {
+		if f.engaged {
+			log.Warnf("The apiserver is reachable again after %d failures, disengaging the failsafe.", f.failures)
+			failsafeEngaged.Set(0)
+		}
+		f.failures, f.failingSince, f.engaged = 0, time.Time{}, false
+		return
+	}
+
+	now := f.now()
+	if f.failures == 0 {
+		f.failingSince = now
+	}
+	f.failures++
+	if !f.engaged && f.failures >= f.minFailures && now.Sub(f.failingSince) >= f.after {
+		log.Errorf("The apiserver has been unreachable since %s (%d failures), engaging the failsafe: namespace deletions are admitted without validation until it is reachable again.",
+			f.failingSince.UTC().Format(time.RFC3339), f.failures)
+		failsafeEngaged.Set(1)
+		f.engaged = true
+	}
+}
+
+// active returns true while the failsafe is engaged
+func (f *apiserverFailsafe) active() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.engaged
+}
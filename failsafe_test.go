@@ -0,0 +1,79 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func failsafeEngagedValue() float64 {
+	m := &dto.Metric{}
+	failsafeEngaged.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestAPIServerFailsafe(t *testing.T) {
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	f := newAPIServerFailsafe(time.Minute, 3, func() time.Time { return now })
+	unreachable := errors.New("dial tcp 10.0.0.1:443: connection refused")
+
+	f.observe(unreachable)
+	now = now.Add(2 * time.Minute)
+	f.observe(unreachable)
+	assert.False(t, f.active(), "should not engage below the minimum number of failures")
+
+	f.observe(unreachable)
+	assert.True(t, f.active(), "should engage after the failures span the window")
+	assert.Equal(t, float64(1), failsafeEngagedValue())
+
+	f.observe(apiErrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "test-namespace"))
+	assert.False(t, f.active(), "should disengage once the apiserver responds")
+	assert.Equal(t, float64(0), failsafeEngagedValue())
+
+	for i := 0; i < 5; i++ {
+		f.observe(unreachable)
+	}
+	assert.False(t, f.active(), "should not engage before the failures span the window")
+}
+
+func TestFailsafeWebhookHandler(t *testing.T) {
+	failsafe = newAPIServerFailsafe(0, 2, time.Now)
+	defer func() { failsafe = nil }()
+
+	var getErr error = apiErrors.NewServiceUnavailable("apiserver is shutting down")
+	testPod := &corev1.Pod{}
+	testPod.Name = "test-pod"
+	testPod.Namespace = "test-namespace"
+	fakeClientset := fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+	fakeClientset.PrependReactor("get", "namespaces", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return getErr != nil, nil, getErr
+	})
+	clientset = fakeClientset
+
+	review := func() bool {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		return getAdmissionReview(rw).Status.Allowed
+	}
+
+	assert.False(t, review(), "should reject on the first apiserver failure")
+	assert.True(t, review(), "should admit once the failsafe is engaged")
+	assert.True(t, failsafe.active())
+
+	getErr = nil
+	assert.False(t, review(), "should validate again once the apiserver is reachable")
+	assert.False(t, failsafe.active())
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// fingerprintPrefix prefixes the content fingerprints, e.g. sha256:ab12cd34ef56
+	fingerprintPrefix = "sha256:"
+	// fingerprintLength is the number of hex digits of the hash kept in the fingerprints
+	fingerprintLength = 12
+)
+
+// contentFingerprint returns the short fingerprint of the contents of a namespace, the hash of its
+// sorted per-kind counts. The kinds outside of the namespace and those not found are left out.
+func contentFingerprint(findings []resourceFinding) string {
+	var counts []string
+	for _, f := range findings {
+		if f.Count > 0 && !f.External {
+			counts = append(counts, fmt.Sprintf("%s=%d", f.Kind, f.Count))
+		}
+	}
+	sort.Strings(counts)
+	sum := sha256.Sum256([]byte(strings.Join(counts, ",")))
+	return fingerprintPrefix + hex.EncodeToString(sum[:])[:fingerprintLength]
+}
+
+// isFingerprint returns true if the bypass annotation value is a content fingerprint
+func isFingerprint(value string) bool {
+	return strings.HasPrefix(value, fingerprintPrefix)
+}
+
+// bypassFingerprint returns the content fingerprint carried by the bypass annotation, empty if none
+func bypassFingerprint(annotations map[string]string) string {
+	if value := annotations[*bypassKey]; isFingerprint(value) {
+		return value
+	}
+	return ""
+}
+
+// fingerprintedBypass returns true if the bypass annotation is only honored once its fingerprint is
+// checked against the contents of the namespace, i.e. it carries one or --requireBypassFingerprint is set
+func fingerprintedBypass(annotations map[string]string) bool {
+	return *requireBypassFingerprint || bypassFingerprint(annotations) != ""
+}
+
+// fingerprintMatches returns true if the bypass annotation carries the fingerprint of the findings, the
+// contents being unknown if any counter failed
+func fingerprintMatches(annotations map[string]string, findings []resourceFinding, errList []error) bool {
+	return len(errList) == 0 && bypassFingerprint(annotations) == contentFingerprint(findings)
+}
+
+// staleBypassMessage returns the reason a fingerprinted bypass annotation is not honored
+func staleBypassMessage(namespace string, findings []resourceFinding) string {
+	return fmt.Sprintf("The bypass annotation of the namespace %s does not match its current contents, whose fingerprint is %s. ", namespace, contentFingerprint(findings))
+}
+
+// bypassValue returns the bypass annotation value to set to delete the namespace holding the findings,
+// their fingerprint with --requireBypassFingerprint
+func bypassValue(findings []resourceFinding) string {
+	if *requireBypassFingerprint {
+		return contentFingerprint(findings)
+	}
+	return "true"
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestContentFingerprint(t *testing.T) {
+	fingerprint := contentFingerprint([]resourceFinding{{Kind: "pods", Count: 2}, {Kind: "services", Count: 1}})
+	assert.Regexp(t, "^sha256:[0-9a-f]{12}$", fingerprint)
+	assert.Equal(t, fingerprint, contentFingerprint([]resourceFinding{{Kind: "deployments"}, {Kind: "services", Count: 1, Names: []string{"web"}}, {Kind: "pods", Count: 2},
+		{Kind: "clusterrolebindings", Count: 1, External: true}}), "should only depend on the counts of the kinds found inside the namespace")
+	assert.NotEqual(t, fingerprint, contentFingerprint([]resourceFinding{{Kind: "pods", Count: 3}, {Kind: "services", Count: 1}}))
+}
+
+func TestBypassFingerprintDeletionError(t *testing.T) {
+	*requireBypassFingerprint = true
+	defer func() { *requireBypassFingerprint = false }()
+	clientset = fake.NewSimpleClientset(namespaceWithPods(2)...)
+
+	err := validateNamespaceDeletion("test-namespace")
+	fingerprint := contentFingerprint([]resourceFinding{{Kind: "pods", Count: 2}})
+	assert.Contains(t, err.Error(), "run `kubectl annotate --overwrite namespace test-namespace "+bypassAnnotationKey+"="+fingerprint+"` to bypass this policy check.")
+}
+
+func TestBypassFingerprintWebhookHandler(t *testing.T) {
+	review := func(value string, pods int) (bool, string) {
+		objects := namespaceWithPods(pods)
+		objects[0].(*corev1.Namespace).Annotations = map[string]string{bypassAnnotationKey: value}
+		clientset = fake.NewSimpleClientset(objects...)
+		rw := httptest.NewRecorder()
+		webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview))))
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+	twoPods := contentFingerprint([]resourceFinding{{Kind: "pods", Count: 2}})
+	threePods := contentFingerprint([]resourceFinding{{Kind: "pods", Count: 3}})
+
+	allowed, _ := review("true", 2)
+	assert.True(t, allowed, "should honor a plain bypass without requireBypassFingerprint")
+	allowed, _ = review(twoPods, 2)
+	assert.True(t, allowed, "should honor a matching fingerprint without requireBypassFingerprint")
+
+	*requireBypassFingerprint = true
+	defer func() { *requireBypassFingerprint = false }()
+
+	allowed, message := review("true", 2)
+	assert.False(t, allowed, "should require a fingerprint")
+	assert.Contains(t, message, "="+twoPods+"`")
+
+	allowed, _ = review(twoPods, 2)
+	assert.True(t, allowed, "should honor a matching fingerprint")
+
+	allowed, message = review(twoPods, 3)
+	assert.False(t, allowed, "should deny the deletion once the contents changed")
+	assert.Contains(t, message, "The bypass annotation of the namespace test-namespace does not match its current contents, whose fingerprint is "+threePods+".")
+	assert.Contains(t, message, "run `kubectl annotate --overwrite namespace test-namespace "+bypassAnnotationKey+"="+threePods+"` to bypass this policy check.")
+
+	allowed, _ = review(twoPods, 0)
+	assert.True(t, allowed, "should allow the deletion of an empty namespace whatever the fingerprint")
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	forceDeleteRequestKey   = "namespace-guard.io/force-delete-request"
+	forceDeleteRequesterKey = "namespace-guard.io/force-delete-requester"
+	forceDeleteApproveKey   = "namespace-guard.io/force-delete-approve"
+	forceDeleteApproverKey  = "namespace-guard.io/force-delete-approver"
+)
+
+// namespaceSerializer decodes the namespaces of the admission reviews, which may omit the apiVersion
+// and kind
+var namespaceSerializer = json.NewSerializer(json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme, false)
+
+// decodeNamespace returns the namespace serialized in the raw object, an empty namespace if the object
+// is empty
+func decodeNamespace(raw runtime.RawExtension) (*corev1.Namespace, error) {
+	namespace := &corev1.Namespace{}
+	if len(raw.Raw) == 0 {
+		return namespace, nil
+	}
+	_, _, err := namespaceSerializer.Decode(raw.Raw, nil, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return namespace, nil
+}
+
+// validateForceDeleteAnnotations checks a change of the force delete annotations by the user. The
+// requester and approver annotations must name the user setting the request or approval, the
+// approval must match the request timestamp and be set by a different user than the requester.
+func validateForceDeleteAnnotations(oldAnnotations, newAnnotations map[string]string, username string) error {
+	changed := func(keys ...string) bool {
+		for _, key := range keys {
+			if oldAnnotations[key] != newAnnotations[key] {
+				return true
+			}
+		}
+		return false
+	}
+
+	request := newAnnotations[forceDeleteRequestKey]
+	if changed(forceDeleteRequestKey, forceDeleteRequesterKey) && request != "" {
+		if _, err := time.Parse(time.RFC3339, request); err != nil {
+			return fmt.Errorf("the %s annotation must be an RFC3339 timestamp: %v", forceDeleteRequestKey, err)
+		}
+		if newAnnotations[forceDeleteRequesterKey] != username {
+			return fmt.Errorf("the %s annotation must be set to the requesting user %s", forceDeleteRequesterKey, username)
+		}
+	}
+
+	approve := newAnnotations[forceDeleteApproveKey]
+	if changed(forceDeleteApproveKey, forceDeleteApproverKey) && approve != "" {
+		if approve != request {
+			return fmt.Errorf("the %s annotation must match the %s timestamp %q", forceDeleteApproveKey, forceDeleteRequestKey, request)
+		}
+		if newAnnotations[forceDeleteApproverKey] != username {
+			return fmt.Errorf("the %s annotation must be set to the approving user %s", forceDeleteApproverKey, username)
+		}
+		if username == newAnnotations[forceDeleteRequesterKey] {
+			return fmt.Errorf("the force delete of the namespace must be approved by a different user than the requester %s", username)
+		}
+	}
+	return nil
+}
+
+// hasForceDeleteApproval returns true if a force delete was requested and approved by two users
+func hasForceDeleteApproval(annotations map[string]string) bool {
+	request := annotations[forceDeleteRequestKey]
+	requester := annotations[forceDeleteRequesterKey]
+	approver := annotations[forceDeleteApproverKey]
+	return request != "" && annotations[forceDeleteApproveKey] == request &&
+		requester != "" && approver != "" && requester != approver
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const forceDeleteTimestamp = "2017-09-01T10:00:00Z"
+
+// rawNamespace serializes a copy of the template namespace with the given annotations
+func rawNamespace(annotations map[string]string) runtime.RawExtension {
+	namespace := cloneNamespace(templateNamespace)
+	namespace.Annotations = annotations
+	raw, err := json.Marshal(namespace)
+	if err != nil {
+		panic(err)
+	}
+	return runtime.RawExtension{Raw: raw}
+}
+
+func TestValidateForceDeleteAnnotations(t *testing.T) {
+	requested := map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+	}
+	approved := map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+		forceDeleteApproveKey:   forceDeleteTimestamp,
+		forceDeleteApproverKey:  "bob",
+	}
+
+	assert.Nil(t, validateForceDeleteAnnotations(nil, requested, "alice"))
+	assert.Nil(t, validateForceDeleteAnnotations(requested, approved, "bob"))
+	assert.Nil(t, validateForceDeleteAnnotations(approved, approved, "carol"), "unrelated updates are allowed")
+	assert.Nil(t, validateForceDeleteAnnotations(approved, nil, "carol"), "the annotations may be removed")
+
+	err := validateForceDeleteAnnotations(nil, requested, "bob")
+	assert.EqualError(t, err, "the namespace-guard.io/force-delete-requester annotation must be set to the requesting user bob")
+
+	err = validateForceDeleteAnnotations(nil, map[string]string{forceDeleteRequestKey: "now", forceDeleteRequesterKey: "alice"}, "alice")
+	assert.Contains(t, err.Error(), "the namespace-guard.io/force-delete-request annotation must be an RFC3339 timestamp")
+
+	err = validateForceDeleteAnnotations(requested, approved, "alice")
+	assert.EqualError(t, err, "the namespace-guard.io/force-delete-approver annotation must be set to the approving user alice")
+
+	selfApproved := map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+		forceDeleteApproveKey:   forceDeleteTimestamp,
+		forceDeleteApproverKey:  "alice",
+	}
+	err = validateForceDeleteAnnotations(requested, selfApproved, "alice")
+	assert.EqualError(t, err, "the force delete of the namespace must be approved by a different user than the requester alice")
+
+	mismatched := map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+		forceDeleteApproveKey:   "2017-09-02T10:00:00Z",
+		forceDeleteApproverKey:  "bob",
+	}
+	err = validateForceDeleteAnnotations(requested, mismatched, "bob")
+	assert.EqualError(t, err, "the namespace-guard.io/force-delete-approve annotation must match the namespace-guard.io/force-delete-request timestamp \"2017-09-01T10:00:00Z\"")
+
+	err = validateForceDeleteAnnotations(approved, map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "carol",
+		forceDeleteApproveKey:   forceDeleteTimestamp,
+		forceDeleteApproverKey:  "bob",
+	}, "bob")
+	assert.NotNil(t, err, "the requester may not be replaced by another user")
+}
+
+func TestHasForceDeleteApproval(t *testing.T) {
+	assert.False(t, hasForceDeleteApproval(nil))
+	assert.False(t, hasForceDeleteApproval(map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+	}))
+	assert.False(t, hasForceDeleteApproval(map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+		forceDeleteApproveKey:   forceDeleteTimestamp,
+		forceDeleteApproverKey:  "alice",
+	}))
+	assert.True(t, hasForceDeleteApproval(map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+		forceDeleteApproveKey:   forceDeleteTimestamp,
+		forceDeleteApproverKey:  "bob",
+	}))
+}
+
+func TestForceDeleteUpdateWebhookHandler(t *testing.T) {
+	*forceDeleteEnabled = true
+	defer func() { *forceDeleteEnabled = false }()
+
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.Operation = v1alpha1.Update
+	testSpec.Spec.UserInfo.Username = "alice"
+	testSpec.Spec.OldObject = rawNamespace(nil)
+	testSpec.Spec.Object = rawNamespace(map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+	})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should allow the requester to request a force delete")
+
+	testSpec.Spec.OldObject = testSpec.Spec.Object
+	testSpec.Spec.Object = rawNamespace(map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+		forceDeleteApproveKey:   forceDeleteTimestamp,
+		forceDeleteApproverKey:  "alice",
+	})
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject the requester approving its own force delete")
+	assert.Equal(t, "Invalid force delete annotations on namespace test-namespace: the force delete of the namespace must be approved by a different user than the requester alice", admReview.Status.Result.Message)
+}
+
+func TestForceDeleteWebhookHandler(t *testing.T) {
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{
+		forceDeleteRequestKey:   forceDeleteTimestamp,
+		forceDeleteRequesterKey: "alice",
+		forceDeleteApproveKey:   forceDeleteTimestamp,
+		forceDeleteApproverKey:  "bob",
+	}
+	testPod := &corev1.Pod{}
+	testPod.Name = "test-pod"
+	testPod.Namespace = "test-namespace"
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.False(t, getAdmissionReview(rw).Status.Allowed, "should reject if force delete is disabled")
+
+	*forceDeleteEnabled = true
+	defer func() { *forceDeleteEnabled = false }()
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve a force delete approved by two users")
+	waitForBackgroundTasks(t)
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespaceInventory is the decision of the webhook on the deletion of a namespace, as reported by
+// --gitReport
+type namespaceInventory struct {
+	explainResponse
+}
+
+// status returns whether the deletion of the namespace would be rejected
+func (n namespaceInventory) status() string {
+	switch {
+	case n.Bypass != "":
+		return fmt.Sprintf("bypassed (%s)", n.Bypass)
+	case len(n.Errors) > 0:
+		return "unknown"
+	case n.Allowed && n.EnforcementBucket == warnBucket:
+		return "warn-only"
+	case n.Allowed:
+		return "unprotected"
+	}
+	return "protected"
+}
+
+// resources returns the <kind>(<count>) of the kinds found in the namespace, or the errors counting them
+func (n namespaceInventory) resources() string {
+	if len(n.Errors) > 0 {
+		return strings.Join(n.Errors, "; ")
+	}
+	var kinds []string
+	for _, f := range n.Resources {
+		if f.Count > 0 {
+			kinds = append(kinds, fmt.Sprintf("%s(%d)", f.Kind, f.Count))
+		}
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// takeInventory decides the deletion of every namespace of the cluster with the profile, as the webhook
+// would for a user without any exemption. The --confirmIdle window and the --preDeleteHook are skipped.
+func takeInventory(profile policyProfile) ([]namespaceInventory, error) {
+	namespaces, err := clientset.CoreV1().Namespaces().List(v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	v := newValidator("/", profile)
+	v.dryRun, v.offline = true, true
+	inventory := make([]namespaceInventory, 0, len(namespaces.Items))
+	for i := range namespaces.Items {
+		inventory = append(inventory, namespaceInventory{v.explain(&namespaces.Items[i], authenticationv1.UserInfo{})})
+	}
+	sort.Slice(inventory, func(i, j int) bool { return inventory[i].Namespace < inventory[j].Namespace })
+	return inventory, nil
+}
+
+// markdownReport returns the inventory as a Markdown table. The report only changes along with the
+// inventory, so that an unchanged cluster adds no commit.
+func markdownReport(inventory []namespaceInventory) []byte {
+	var buf bytes.Buffer
+	title := "Namespace Guard Report"
+	if *clusterName != "" {
+		title += " of " + *clusterName
+	}
+	fmt.Fprintf(&buf, "# %s\n\n", title)
+	buf.WriteString("The deletion of the protected namespaces would be rejected by the webhook, that of the unprotected ones allowed. The bypassed namespaces are allowed by the bypass annotation, a force delete approval or a GuardException, and the warn-only ones are not enforced yet.\n\n")
+	buf.WriteString("| Namespace | Status | Resources |\n")
+	buf.WriteString("|-----------|--------|-----------|\n")
+	for _, n := range inventory {
+		fmt.Fprintf(&buf, "| %s | %s | %s |\n", n.Namespace, n.status(), strings.Replace(n.resources(), "|", "\\|", -1))
+	}
+	return buf.Bytes()
+}
+
+// commitReport clones the branch of the repository, writes the report and pushes it in a new commit,
+// unless the report did not change
+func commitReport(report []byte, auth *githttp.BasicAuth) error {
+	dir, err := ioutil.TempDir("", "gitreport")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	cloneOptions := &git.CloneOptions{
+		URL:           *gitRepoURL,
+		ReferenceName: plumbing.ReferenceName("refs/heads/" + *gitBranch),
+		SingleBranch:  true,
+		Depth:         1,
+	}
+	pushOptions := &git.PushOptions{}
+	if auth != nil {
+		cloneOptions.Auth = auth
+		pushOptions.Auth = auth
+	}
+	repo, err := git.PlainClone(dir, false, cloneOptions)
+	if err != nil {
+		return fmt.Errorf("error cloning %s: %s", *gitRepoURL, err.Error())
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, *gitReportPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, report, 0644); err != nil {
+		return err
+	}
+	if _, err := worktree.Add(*gitReportPath); err != nil {
+		return err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return err
+	}
+	if status.IsClean() {
+		log.Infof("The report %s did not change, nothing to commit.", *gitReportPath)
+		return nil
+	}
+	now := time.Now().UTC()
+	hash, err := worktree.Commit(fmt.Sprintf("Namespace guard report of %s", now.Format(time.RFC3339)), &git.CommitOptions{
+		Author: &object.Signature{Name: *gitCommitName, Email: *gitCommitEmail, When: now},
+	})
+	if err != nil {
+		return err
+	}
+	if err := repo.Push(pushOptions); err != nil {
+		return fmt.Errorf("error pushing to %s: %s", *gitRepoURL, err.Error())
+	}
+	log.Infof("Committed the report %s to the branch %s of %s: %s", *gitReportPath, *gitBranch, *gitRepoURL, hash)
+	return nil
+}
+
+// runGitReport commits the inventory of the namespaces decided with the default profile to the
+// --gitRepoURL
+func runGitReport() error {
+	var auth *githttp.BasicAuth
+	if *gitTokenFile != "" {
+		token, err := ioutil.ReadFile(*gitTokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading the gitTokenFile: %s", err.Error())
+		}
+		// the Git hosts take the token as the password of any user name
+		auth = &githttp.BasicAuth{Username: "k8s-namespace-guard", Password: strings.TrimSpace(string(token))}
+	}
+	inventory, err := takeInventory(defaultProfile())
+	if err != nil {
+		return fmt.Errorf("error listing the namespaces: %s", err.Error())
+	}
+	return commitReport(markdownReport(inventory), auth)
+}
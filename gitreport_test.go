@@ -0,0 +1,104 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestTakeInventory(t *testing.T) {
+	namespace := func(name string, annotations map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: name, Annotations: annotations}}
+	}
+	pod := func(namespace string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "web-0", Namespace: namespace}}
+	}
+	onePod := contentFingerprint([]resourceFinding{{Kind: "pods", Count: 1}})
+	now := time.Now()
+	guardExceptions = &guardExceptionWatcher{
+		store: guardExceptionStore(t, newGuardException("migration", guardExceptionWaive{All: true}, now.Add(time.Hour), "excepted")),
+		now:   func() time.Time { return now },
+	}
+	defer func() { guardExceptions = nil }()
+	*forceDeleteEnabled = true
+	*guardPersistentVolumeClaims = true
+	defer func() { *forceDeleteEnabled, *guardPersistentVolumeClaims = false, false }()
+	clientset = fake.NewSimpleClientset(
+		namespace("web", nil), pod("web"),
+		namespace("empty", nil),
+		namespace("claims", nil),
+		&corev1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Name: "data", Namespace: "claims"}},
+		namespace("bypassed", map[string]string{bypassAnnotationKey: "true"}), pod("bypassed"),
+		namespace("fingerprinted", map[string]string{bypassAnnotationKey: onePod}), pod("fingerprinted"),
+		namespace("stale", map[string]string{bypassAnnotationKey: onePod}), pod("stale"),
+		&corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "web-1", Namespace: "stale"}},
+		namespace("forced", map[string]string{
+			forceDeleteRequestKey:   forceDeleteTimestamp,
+			forceDeleteRequesterKey: "alice",
+			forceDeleteApproveKey:   forceDeleteTimestamp,
+			forceDeleteApproverKey:  "bob",
+		}), pod("forced"),
+		namespace("excepted", nil), pod("excepted"),
+	)
+
+	inventory, err := takeInventory(defaultProfile())
+	assert.Nil(t, err, "Error should be nil")
+	statuses := map[string]string{}
+	var names []string
+	for _, n := range inventory {
+		names = append(names, n.Namespace)
+		statuses[n.Namespace] = n.status()
+	}
+	assert.Equal(t, []string{"bypassed", "claims", "empty", "excepted", "fingerprinted", "forced", "stale", "web"}, names, "should sort the namespaces by name")
+	assert.Equal(t, map[string]string{
+		"bypassed":      "bypassed (annotation)",
+		"claims":        "protected",
+		"empty":         "unprotected",
+		"excepted":      "bypassed (exception)",
+		"fingerprinted": "bypassed (annotation)",
+		"forced":        "bypassed (forceDelete)",
+		"stale":         "protected",
+		"web":           "protected",
+	}, statuses, "should report the decisions of the webhook")
+	assert.Equal(t, "pods(1)", inventory[0].resources(), "should report the resources of the bypassed namespaces")
+	assert.Equal(t, "persistentvolumeclaims(1)", inventory[1].resources())
+}
+
+func TestNamespaceInventoryStatus(t *testing.T) {
+	for expected, n := range map[string]explainResponse{
+		"protected":              {Allowed: false, EnforcementBucket: enforceBucket},
+		"unprotected":            {Allowed: true},
+		"warn-only":              {Allowed: true, EnforcementBucket: warnBucket},
+		"bypassed (forceDelete)": {Allowed: true, Bypass: bypassForceDelete},
+		"unknown":                {Allowed: false, Errors: []string{"error listing pods, timeout"}},
+	} {
+		assert.Equal(t, expected, namespaceInventory{n}.status())
+	}
+}
+
+func TestMarkdownReport(t *testing.T) {
+	*clusterName = "production"
+	defer func() { *clusterName = "" }()
+
+	report := markdownReport([]namespaceInventory{
+		{explainResponse{Namespace: "team-a", Resources: []resourceFinding{{Kind: "pods", Count: 2}, {Kind: "services", Count: 0}, {Kind: "deployments", Count: 1}}}},
+		{explainResponse{Namespace: "team-b", Allowed: true}},
+		{explainResponse{Namespace: "team-c", Errors: []string{"error listing pods, a|b"}}},
+	})
+	assert.Equal(t, `# Namespace Guard Report of production
+
+The deletion of the protected namespaces would be rejected by the webhook, that of the unprotected ones allowed. The bypassed namespaces are allowed by the bypass annotation, a force delete approval or a GuardException, and the warn-only ones are not enforced yet.
+
+| Namespace | Status | Resources |
+|-----------|--------|-----------|
+| team-a | protected | pods(2), deployments(1) |
+| team-b | unprotected |  |
+| team-c | unknown | error listing pods, a\|b |
+`, string(report))
+}
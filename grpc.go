@@ -0,0 +1,168 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// checkRequest, checkResponse and checkResource mirror the messages of proto/namespaceguard.proto,
+// the struct tags being those protoc-gen-go would generate
+type checkRequest struct {
+	Namespace string   `protobuf:"bytes,1,opt,name=namespace" json:"namespace,omitempty"`
+	User      string   `protobuf:"bytes,2,opt,name=user" json:"user,omitempty"`
+	Groups    []string `protobuf:"bytes,3,rep,name=groups" json:"groups,omitempty"`
+	Profile   string   `protobuf:"bytes,4,opt,name=profile" json:"profile,omitempty"`
+}
+
+func (m *checkRequest) Reset()         { *m = checkRequest{} }
+func (m *checkRequest) String() string { return proto.CompactTextString(m) }
+func (*checkRequest) ProtoMessage()    {}
+
+type checkResponse struct {
+	Namespace         string           `protobuf:"bytes,1,opt,name=namespace" json:"namespace,omitempty"`
+	Allowed           bool             `protobuf:"varint,2,opt,name=allowed" json:"allowed,omitempty"`
+	Reason            string           `protobuf:"bytes,3,opt,name=reason" json:"reason,omitempty"`
+	AdmitAll          bool             `protobuf:"varint,4,opt,name=admit_all,json=admitAll" json:"admit_all,omitempty"`
+	BypassAnnotation  bool             `protobuf:"varint,5,opt,name=bypass_annotation,json=bypassAnnotation" json:"bypass_annotation,omitempty"`
+	ForceDelete       bool             `protobuf:"varint,6,opt,name=force_delete,json=forceDelete" json:"force_delete,omitempty"`
+	Resources         []*checkResource `protobuf:"bytes,7,rep,name=resources" json:"resources,omitempty"`
+	Errors            []string         `protobuf:"bytes,8,rep,name=errors" json:"errors,omitempty"`
+	Profile           string           `protobuf:"bytes,9,opt,name=profile" json:"profile,omitempty"`
+	Bypass            string           `protobuf:"bytes,10,opt,name=bypass" json:"bypass,omitempty"`
+	Exceptions        []string         `protobuf:"bytes,11,rep,name=exceptions" json:"exceptions,omitempty"`
+	EnforcementBucket string           `protobuf:"bytes,12,opt,name=enforcement_bucket,json=enforcementBucket" json:"enforcement_bucket,omitempty"`
+}
+
+func (m *checkResponse) Reset()         { *m = checkResponse{} }
+func (m *checkResponse) String() string { return proto.CompactTextString(m) }
+func (*checkResponse) ProtoMessage()    {}
+
+type checkResource struct {
+	Kind     string   `protobuf:"bytes,1,opt,name=kind" json:"kind,omitempty"`
+	Count    int32    `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
+	Names    []string `protobuf:"bytes,3,rep,name=names" json:"names,omitempty"`
+	External bool     `protobuf:"varint,4,opt,name=external" json:"external,omitempty"`
+}
+
+func (m *checkResource) Reset()         { *m = checkResource{} }
+func (m *checkResource) String() string { return proto.CompactTextString(m) }
+func (*checkResource) ProtoMessage()    {}
+
+// namespaceGuardServer is the NamespaceGuard service of proto/namespaceguard.proto
+type namespaceGuardServer interface {
+	Check(context.Context, *checkRequest) (*checkResponse, error)
+}
+
+// namespaceGuardServiceDesc describes the NamespaceGuard service as protoc-gen-go would
+var namespaceGuardServiceDesc = grpc.ServiceDesc{
+	ServiceName: "namespaceguard.v1.NamespaceGuard",
+	HandlerType: (*namespaceGuardServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Check",
+			Handler:    checkHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/namespaceguard.proto",
+}
+
+func checkHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(checkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(namespaceGuardServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/namespaceguard.v1.NamespaceGuard/Check",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(namespaceGuardServer).Check(ctx, req.(*checkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// guardServer serves the Check RPC with the decision of /explain
+type guardServer struct{}
+
+// Check returns the decision the webhook would make with the profile for the deletion of the namespace
+// by the user in the groups of the request, as /explain does. Every peer is rate limited with the
+// /explain limits.
+func (s *guardServer) Check(ctx context.Context, req *checkRequest) (*checkResponse, error) {
+	client := peerHost(ctx)
+	log.Infof("Serving gRPC Check request for namespace: %s, client: %s", req.Namespace, client)
+	if !explainLimiter.allow(client) {
+		return nil, status.Errorf(codes.ResourceExhausted, "Rate limit exceeded for client %s, please try again later", client)
+	}
+	if req.Namespace == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "The namespace is required")
+	}
+	profile, err := lookupProfile(req.Profile)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "The profile is invalid: %s", err.Error())
+	}
+	explained, err := explainNamespace(req.Namespace, profile, authenticationv1.UserInfo{Username: req.User, Groups: req.Groups})
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "Namespace %s not found", req.Namespace)
+		}
+		return nil, status.Errorf(codes.Internal, "Error occurred while retrieving the namespace %s: %s", req.Namespace, err.Error())
+	}
+	resp := &checkResponse{
+		Namespace:         explained.Namespace,
+		Allowed:           explained.Allowed,
+		Reason:            explained.Reason,
+		AdmitAll:          explained.AdmitAll,
+		BypassAnnotation:  explained.BypassAnnotation,
+		ForceDelete:       explained.ForceDelete,
+		Errors:            explained.Errors,
+		Profile:           explained.Profile,
+		Bypass:            explained.Bypass,
+		Exceptions:        explained.Exceptions,
+		EnforcementBucket: explained.EnforcementBucket,
+	}
+	for _, f := range explained.Resources {
+		resp.Resources = append(resp.Resources, &checkResource{Kind: f.Kind, Count: int32(f.Count), Names: f.Names, External: f.External})
+	}
+	return resp, nil
+}
+
+// newGRPCServer returns the gRPC server of the NamespaceGuard service, serving TLS with the config of
+// the webhook server unless it is nil
+func newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&namespaceGuardServiceDesc, &guardServer{})
+	return server
+}
+
+// peerHost returns the host of the peer of the RPC, its whole address if it has no port
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := newGRPCServer(nil)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	check := func(namespace string) (*checkResponse, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp := &checkResponse{}
+		err := conn.Invoke(ctx, "/namespaceguard.v1.NamespaceGuard/Check", &checkRequest{Namespace: namespace}, resp)
+		return resp, err
+	}
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+	resp, err := check("test-namespace")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "test-namespace", resp.Namespace)
+		assert.False(t, resp.Allowed, "should report the namespace holding a pod as blocked")
+		assert.Contains(t, resp.Reason, "contains one or more of these resources: [pods(1)]")
+		assert.Contains(t, resp.Resources, &checkResource{Kind: "pods", Count: 1, Names: []string{"test-pod-0"}})
+	}
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	resp, err = check("test-namespace")
+	if assert.Nil(t, err) {
+		assert.True(t, resp.Allowed, "should report an empty namespace as deletable")
+	}
+
+	_, err = check("missing-namespace")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = check("")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestRateLimitedCheckRPC(t *testing.T) {
+	defer func(limiter *clientRateLimiter) { explainLimiter = limiter }(explainLimiter)
+	explainLimiter = newClientRateLimiter(time.Now)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := newGRPCServer(nil)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	var errs []error
+	for i := 0; i < *explainBurst+1; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		errs = append(errs, conn.Invoke(ctx, "/namespaceguard.v1.NamespaceGuard/Check", &checkRequest{Namespace: "test-namespace"}, &checkResponse{}))
+		cancel()
+	}
+
+	assert.Nil(t, errs[0])
+	assert.Equal(t, codes.ResourceExhausted, status.Code(errs[len(errs)-1]), "should rate limit a peer exceeding its burst")
+}
+
+func TestWebhookChecksCheckRPC(t *testing.T) {
+	defer func(limiter *clientRateLimiter) { explainLimiter = limiter }(explainLimiter)
+	explainLimiter = newClientRateLimiter(time.Now)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := newGRPCServer(nil)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	check := func(req *checkRequest) (*checkResponse, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp := &checkResponse{}
+		err := conn.Invoke(ctx, "/namespaceguard.v1.NamespaceGuard/Check", req, resp)
+		return resp, err
+	}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	deleteTokenKey = testDeleteTokenKey
+	resp, err := check(&checkRequest{Namespace: "test-namespace"})
+	deleteTokenKey = nil
+	if assert.Nil(t, err) {
+		assert.False(t, resp.Allowed, "should report the deletion of an empty namespace without a delete token as rejected")
+		assert.Contains(t, resp.Reason, "requires a valid delete token")
+	}
+
+	config, err := parseConfig([]byte(`
+rules:
+- name: admins-only
+  expression: "!has(request.userInfo.groups) || !('platform-admins' in request.userInfo.groups)"
+  message: "only the platform-admins may delete {{.namespace.metadata.name}}"
+`))
+	assert.Nil(t, err, "Error should be nil")
+	celRules = config.Rules
+	defer func() { celRules = nil }()
+	resp, err = check(&checkRequest{Namespace: "test-namespace", User: "alice"})
+	if assert.Nil(t, err) {
+		assert.False(t, resp.Allowed, "should report the deletions the rules deny as rejected")
+		assert.Contains(t, resp.Reason, "denied by the rules: admins-only: only the platform-admins may delete test-namespace")
+		assert.Equal(t, defaultProfileName, resp.Profile)
+	}
+	resp, err = check(&checkRequest{Namespace: "test-namespace", User: "alice", Groups: []string{"platform-admins"}})
+	if assert.Nil(t, err) {
+		assert.True(t, resp.Allowed, "should decide the deletion for the groups of the request")
+	}
+
+	_, err = check(&checkRequest{Namespace: "test-namespace", Profile: "unknown"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,313 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// bypassException is the bypass recorded for the deletions allowed by a GuardException waiving all
+	// the checks
+	bypassException = "exception"
+
+	// the policies a GuardException can waive besides the resource kinds
+	waivedRules         = "rules"
+	waivedRegoPolicy    = "regoPolicy"
+	waivedConfirmIdle   = "confirmIdle"
+	waivedPreDeleteHook = "preDeleteHook"
+
+	// exceptionExpiredCondition is the status condition set on the expired GuardExceptions
+	exceptionExpiredCondition = "Expired"
+)
+
+var (
+	// guardExceptionResource is the cluster-scoped resource of the GuardException objects, defined by
+	// the CRD of example/guardexceptions-crd.yaml
+	guardExceptionResource = schema.GroupVersionResource{Group: "namespace-guard.io", Version: "v1alpha1", Resource: "guardexceptions"}
+
+	// waivablePolicies are the policies a GuardException can waive
+	waivablePolicies = map[string]bool{waivedRules: true, waivedRegoPolicy: true, waivedConfirmIdle: true, waivedPreDeleteHook: true}
+
+	// guardExceptions are the GuardExceptions cached by an informer, nil unless --guardExceptions is set
+	guardExceptions *guardExceptionWatcher
+)
+
+// guardException is a GuardException object waiving checks for a set of namespaces until it expires.
+// Unlike the bypass annotation, it is an API object of its own that can be reviewed before it is
+// applied.
+type guardException struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+	Spec          guardExceptionSpec   `json:"spec"`
+	Status        guardExceptionStatus `json:"status,omitempty"`
+}
+
+// guardExceptionSpec is which checks are waived for which namespaces, until when and why
+type guardExceptionSpec struct {
+	// Namespaces are the names of the namespaces the exception applies to
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces the exception applies to by label
+	NamespaceSelector *v1.LabelSelector   `json:"namespaceSelector,omitempty"`
+	Waive             guardExceptionWaive `json:"waive"`
+	// Expiry is the time from which the exception is ignored
+	Expiry        v1.Time `json:"expiry"`
+	Justification string  `json:"justification"`
+}
+
+// guardExceptionWaive is either all the checks, or the resource kinds and policies waived
+type guardExceptionWaive struct {
+	All      bool     `json:"all,omitempty"`
+	Kinds    []string `json:"kinds,omitempty"`
+	Policies []string `json:"policies,omitempty"`
+}
+
+// guardExceptionStatus holds the conditions set by the guard, Expired once the exception expired
+type guardExceptionStatus struct {
+	Conditions []guardExceptionCondition `json:"conditions,omitempty"`
+}
+
+type guardExceptionCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime v1.Time                `json:"lastTransitionTime,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// validate returns an error if the exception is incomplete, such exceptions are ignored
+func (e *guardException) validate() error {
+	if len(e.Spec.Namespaces) == 0 && e.Spec.NamespaceSelector == nil {
+		return fmt.Errorf("neither namespaces nor a namespaceSelector are set")
+	}
+	if !e.Spec.Waive.All && len(e.Spec.Waive.Kinds) == 0 && len(e.Spec.Waive.Policies) == 0 {
+		return fmt.Errorf("no check is waived")
+	}
+	for _, policy := range e.Spec.Waive.Policies {
+		if !waivablePolicies[policy] {
+			return fmt.Errorf("unknown policy %q", policy)
+		}
+	}
+	if e.Spec.Expiry.IsZero() {
+		return fmt.Errorf("no expiry is set")
+	}
+	if e.Spec.Justification == "" {
+		return fmt.Errorf("no justification is given")
+	}
+	return nil
+}
+
+// expired returns true once the expiry of the exception is reached
+func (e *guardException) expired(now time.Time) bool {
+	return !now.Before(e.Spec.Expiry.Time)
+}
+
+// matches returns true if the exception applies to the namespace, by name or label
+func (e *guardException) matches(namespace *corev1.Namespace) (bool, error) {
+	for _, name := range e.Spec.Namespaces {
+		if name == namespace.Name {
+			return true, nil
+		}
+	}
+	if e.Spec.NamespaceSelector == nil {
+		return false, nil
+	}
+	selector, err := v1.LabelSelectorAsSelector(e.Spec.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// hasCondition returns true if the exception has the condition set to true
+func (e *guardException) hasCondition(conditionType string) bool {
+	for _, c := range e.Status.Conditions {
+		if c.Type == conditionType && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// exceptionWaiver is the union of the checks waived by the exceptions matching a namespace
+type exceptionWaiver struct {
+	// exceptions are the names of the matching exceptions
+	exceptions []string
+	all        bool
+	kinds      map[string]bool
+	policies   map[string]bool
+}
+
+// waives returns true if the policy is waived
+func (w exceptionWaiver) waives(policy string) bool {
+	return w.all || w.policies[policy]
+}
+
+// counters returns the counters of the kinds not waived
+func (w exceptionWaiver) counters(counters []resourceCounter) []resourceCounter {
+	if len(w.kinds) == 0 {
+		return counters
+	}
+	var kept []resourceCounter
+	for _, c := range counters {
+		if !w.kinds[c.kind] {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// guardExceptionWatcher looks the exceptions up in the store of an informer and marks the expired
+// ones with the Expired condition
+type guardExceptionWatcher struct {
+	store   cache.Store
+	clients dynamic.ClientPool
+	now     func() time.Time
+}
+
+// guardExceptionClient returns the client of the GuardException objects
+func guardExceptionClient(clients dynamic.ClientPool) (*dynamic.ResourceClient, error) {
+	client, err := clients.ClientForGroupVersionResource(guardExceptionResource)
+	if err != nil {
+		return nil, err
+	}
+	return client.Resource(&v1.APIResource{Name: guardExceptionResource.Resource, Namespaced: false}, ""), nil
+}
+
+// newGuardExceptionInformer returns the informer caching the GuardException objects, which has to be
+// run by the caller
+func newGuardExceptionInformer(clients dynamic.ClientPool, resync time.Duration) (cache.SharedIndexInformer, error) {
+	client, err := guardExceptionClient(clients)
+	if err != nil {
+		return nil, err
+	}
+	lw := &cache.ListWatch{
+		ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+			return client.List(options)
+		},
+		WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+			return client.Watch(options)
+		},
+	}
+	return cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, resync, cache.Indexers{}), nil
+}
+
+// exceptions returns the valid exceptions of the store sorted by name, the invalid ones being logged
+func (w *guardExceptionWatcher) exceptions() []guardException {
+	var exceptions []guardException
+	for _, obj := range w.store.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(u.Object)
+		if err != nil {
+			continue
+		}
+		var e guardException
+		if err := json.Unmarshal(data, &e); err != nil {
+			log.Warnf("Ignoring the malformed GuardException %s: %s", u.GetName(), err.Error())
+			continue
+		}
+		if err := e.validate(); err != nil {
+			log.Warnf("Ignoring the invalid GuardException %s: %s", e.Name, err.Error())
+			continue
+		}
+		exceptions = append(exceptions, e)
+	}
+	sort.Slice(exceptions, func(i, j int) bool { return exceptions[i].Name < exceptions[j].Name })
+	return exceptions
+}
+
+// waiver returns the checks waived for the namespace by the unexpired exceptions matching it
+func (w *guardExceptionWatcher) waiver(namespace *corev1.Namespace) exceptionWaiver {
+	waiver := exceptionWaiver{kinds: map[string]bool{}, policies: map[string]bool{}}
+	now := w.now()
+	for _, e := range w.exceptions() {
+		if e.expired(now) {
+			continue
+		}
+		matched, err := e.matches(namespace)
+		if err != nil {
+			log.Warnf("Ignoring the GuardException %s, its namespaceSelector is invalid: %s", e.Name, err.Error())
+			continue
+		}
+		if !matched {
+			continue
+		}
+		waiver.exceptions = append(waiver.exceptions, e.Name)
+		waiver.all = waiver.all || e.Spec.Waive.All
+		for _, kind := range e.Spec.Waive.Kinds {
+			waiver.kinds[kind] = true
+		}
+		for _, policy := range e.Spec.Waive.Policies {
+			waiver.policies[policy] = true
+		}
+	}
+	return waiver
+}
+
+// reconcile sets the Expired condition on the expired exceptions lacking it, returning the number of
+// exceptions updated
+func (w *guardExceptionWatcher) reconcile() (int, error) {
+	client, err := guardExceptionClient(w.clients)
+	if err != nil {
+		return 0, err
+	}
+	now := w.now()
+	updated := 0
+	for _, e := range w.exceptions() {
+		if !e.expired(now) || e.hasCondition(exceptionExpiredCondition) {
+			continue
+		}
+		e.Status.Conditions = append(e.Status.Conditions, guardExceptionCondition{
+			Type:               exceptionExpiredCondition,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: v1.NewTime(now.UTC()),
+			Message:            fmt.Sprintf("The exception expired on %s and is ignored.", e.Spec.Expiry.UTC().Format(time.RFC3339)),
+		})
+		data, err := json.Marshal(e)
+		if err != nil {
+			return updated, err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(data, &obj.Object); err != nil {
+			return updated, err
+		}
+		if _, err := client.Update(obj); err != nil {
+			log.Errorf("Error occurred while marking the GuardException %s as expired: %s", e.Name, err.Error())
+			continue
+		}
+		log.Infof("The GuardException %s expired on %s.", e.Name, e.Spec.Expiry.UTC().Format(time.RFC3339))
+		updated++
+	}
+	return updated, nil
+}
+
+// run marks the expired exceptions every interval until the stop channel is closed
+func (w *guardExceptionWatcher) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := w.reconcile(); err != nil {
+				log.Errorf("Error occurred while marking the expired GuardExceptions: %s", err.Error())
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const guardExceptionsPath = "/apis/namespace-guard.io/v1alpha1/guardexceptions"
+
+// newGuardException returns a valid exception waiving the checks for the namespaces until the expiry
+func newGuardException(name string, waive guardExceptionWaive, expiry time.Time, namespaces ...string) guardException {
+	return guardException{
+		TypeMeta:   v1.TypeMeta{Kind: "GuardException", APIVersion: guardExceptionResource.GroupVersion().String()},
+		ObjectMeta: v1.ObjectMeta{Name: name, ResourceVersion: "1"},
+		Spec: guardExceptionSpec{
+			Namespaces:    namespaces,
+			Waive:         waive,
+			Expiry:        v1.NewTime(expiry),
+			Justification: "migration to the new cluster, see CHANGE-1234",
+		},
+	}
+}
+
+// guardExceptionStore returns a store holding the exceptions as the informer caches them
+func guardExceptionStore(t *testing.T, exceptions ...guardException) cache.Store {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, e := range exceptions {
+		data, err := json.Marshal(e)
+		assert.Nil(t, err)
+		obj := &unstructured.Unstructured{}
+		assert.Nil(t, json.Unmarshal(data, &obj.Object))
+		assert.Nil(t, store.Add(obj))
+	}
+	return store
+}
+
+func TestValidateGuardException(t *testing.T) {
+	expiry := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+	valid := newGuardException("valid", guardExceptionWaive{All: true}, expiry, "test-namespace")
+	assert.Nil(t, valid.validate())
+
+	for expected, mutate := range map[string]func(e *guardException){
+		"neither namespaces nor a namespaceSelector are set": func(e *guardException) { e.Spec.Namespaces = nil },
+		"no check is waived":        func(e *guardException) { e.Spec.Waive = guardExceptionWaive{} },
+		`unknown policy "minAge"`:   func(e *guardException) { e.Spec.Waive.Policies = []string{"minAge"} },
+		"no expiry is set":          func(e *guardException) { e.Spec.Expiry = v1.Time{} },
+		"no justification is given": func(e *guardException) { e.Spec.Justification = "" },
+	} {
+		e := newGuardException("invalid", guardExceptionWaive{All: true}, expiry, "test-namespace")
+		mutate(&e)
+		if err := e.validate(); assert.NotNil(t, err, expected) {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+}
+
+func TestGuardExceptionWaiver(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	selected := newGuardException("by-label", guardExceptionWaive{Kinds: []string{"statefulsets"}}, now.Add(time.Hour))
+	selected.Spec.NamespaceSelector = &v1.LabelSelector{MatchLabels: map[string]string{"team": "data"}}
+	invalid := newGuardException("no-justification", guardExceptionWaive{All: true}, now.Add(time.Hour), "test-namespace")
+	invalid.Spec.Justification = ""
+	watcher := &guardExceptionWatcher{
+		store: guardExceptionStore(t,
+			newGuardException("by-name", guardExceptionWaive{Kinds: []string{"pods"}, Policies: []string{waivedPreDeleteHook}}, now.Add(time.Hour), "test-namespace"),
+			selected,
+			newGuardException("expired", guardExceptionWaive{All: true}, now, "test-namespace"),
+			invalid,
+		),
+		now: func() time.Time { return now },
+	}
+
+	namespace := cloneNamespace(templateNamespace)
+	waiver := watcher.waiver(namespace)
+	assert.Equal(t, []string{"by-name"}, waiver.exceptions, "should ignore the expired and invalid exceptions")
+	assert.False(t, waiver.all)
+	assert.True(t, waiver.waives(waivedPreDeleteHook))
+	assert.False(t, waiver.waives(waivedConfirmIdle))
+
+	namespace.Labels = map[string]string{"team": "data"}
+	waiver = watcher.waiver(namespace)
+	assert.Equal(t, []string{"by-label", "by-name"}, waiver.exceptions)
+	kinds := counterKinds(waiver.counters(resourceCounters()))
+	assert.NotContains(t, kinds, "pods")
+	assert.NotContains(t, kinds, "statefulsets")
+	assert.Contains(t, kinds, "deployments")
+
+	other := cloneNamespace(templateNamespace)
+	other.Name = "other-namespace"
+	assert.Empty(t, watcher.waiver(other).exceptions)
+}
+
+func TestGuardExceptionsWebhookHandler(t *testing.T) {
+	now := time.Now()
+	review := func(v http.Handler, exceptions ...guardException) (bool, string) {
+		guardExceptions = &guardExceptionWatcher{store: guardExceptionStore(t, exceptions...), now: func() time.Time { return now }}
+		clientset = fake.NewSimpleClientset(namespaceWithResources(1, 1)...)
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		v.ServeHTTP(rw, req)
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+	defer func() { guardExceptions = nil }()
+	handler := http.HandlerFunc(webhookHandler)
+
+	allowed, message := review(handler, newGuardException("pods", guardExceptionWaive{Kinds: []string{"pods"}}, now.Add(time.Hour), "test-namespace"))
+	assert.False(t, allowed, "should check the kinds not waived")
+	assert.Contains(t, message, "contains one or more of these resources: [statefulsets(1)].")
+
+	allowed, _ = review(handler,
+		newGuardException("pods", guardExceptionWaive{Kinds: []string{"pods"}}, now.Add(time.Hour), "test-namespace"),
+		newGuardException("statefulsets", guardExceptionWaive{Kinds: []string{"statefulsets"}}, now.Add(time.Hour), "test-namespace"))
+	assert.True(t, allowed, "should combine the kinds waived by the matching exceptions")
+
+	allowed, _ = review(handler, newGuardException("all", guardExceptionWaive{All: true}, now.Add(time.Hour), "test-namespace"))
+	assert.True(t, allowed, "should allow the deletion when all the checks are waived")
+
+	allowed, _ = review(handler, newGuardException("all", guardExceptionWaive{All: true}, now.Add(-time.Second), "test-namespace"))
+	assert.False(t, allowed, "should ignore the expired exceptions")
+
+	noBypass := false
+	strict := newValidator("/validate/strict", policyProfile{Name: "strict", AllowBypass: &noBypass, Mode: enforceMode})
+	allowed, _ = review(strict, newGuardException("all", guardExceptionWaive{All: true}, now.Add(time.Hour), "test-namespace"))
+	assert.False(t, allowed, "should ignore the exceptions like the bypass annotation in a profile disallowing bypasses")
+}
+
+// fakeExceptionServer records the GuardExceptions updated through it
+type fakeExceptionServer struct {
+	sync.Mutex
+	updated map[string]guardException
+}
+
+func (s *fakeExceptionServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if req.Method != http.MethodPut {
+		http.NotFound(rw, req)
+		return
+	}
+	var e guardException
+	json.NewDecoder(req.Body).Decode(&e)
+	s.updated[req.URL.Path] = e
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(e)
+}
+
+func TestReconcileGuardExceptions(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	marked := newGuardException("marked", guardExceptionWaive{All: true}, now.Add(-time.Hour), "test-namespace")
+	marked.Status.Conditions = []guardExceptionCondition{{Type: exceptionExpiredCondition, Status: corev1.ConditionTrue}}
+
+	fakeServer := &fakeExceptionServer{updated: map[string]guardException{}}
+	server := httptest.NewServer(fakeServer)
+	defer server.Close()
+	watcher := &guardExceptionWatcher{
+		store: guardExceptionStore(t,
+			newGuardException("expired", guardExceptionWaive{All: true}, now.Add(-time.Hour), "test-namespace"),
+			newGuardException("active", guardExceptionWaive{All: true}, now.Add(time.Hour), "test-namespace"),
+			marked,
+		),
+		clients: dynamic.NewDynamicClientPool(&rest.Config{Host: server.URL}),
+		now:     func() time.Time { return now },
+	}
+
+	updated, err := watcher.reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, updated, "should only mark the expired exception lacking the condition")
+	e, ok := fakeServer.updated[guardExceptionsPath+"/expired"]
+	if assert.True(t, ok) && assert.Len(t, e.Status.Conditions, 1) {
+		condition := e.Status.Conditions[0]
+		assert.Equal(t, exceptionExpiredCondition, condition.Type)
+		assert.Equal(t, corev1.ConditionTrue, condition.Status)
+		assert.True(t, now.Equal(condition.LastTransitionTime.Time))
+		assert.Equal(t, "The exception expired on 2017-10-01T11:00:00Z and is ignored.", condition.Message)
+		assert.Equal(t, "1", e.ResourceVersion, "should update the cached version")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	guardProfileStrict   = "strict"
+	guardProfileStandard = "standard"
+	guardProfileMinimal  = "minimal"
+	guardProfileCustom   = "custom"
+)
+
+// guardProfileKinds are the kinds counted by the bundles of --guardProfile. The custom profile counts
+// the default kinds and those enabled by the --guard<Type> flags.
+var guardProfileKinds = map[string][]string{
+	guardProfileStrict: {"pods", "services", "replicasets", "deployments", "statefulsets", "daemonsets", "ingresses",
+		"horizontalpodautoscalers", "endpoints", loadBalancerServicesKind, "persistentvolumeclaims"},
+	guardProfileStandard: {"pods", "deployments", "statefulsets", "persistentvolumeclaims"},
+	guardProfileMinimal:  {"pods"},
+}
+
+// validateGuardProfile returns an error if the profile is not one of the bundles or custom
+func validateGuardProfile(profile string) error {
+	if _, ok := guardProfileKinds[profile]; ok || profile == guardProfileCustom {
+		return nil
+	}
+	profiles := []string{guardProfileCustom}
+	for p := range guardProfileKinds {
+		profiles = append(profiles, p)
+	}
+	sort.Strings(profiles)
+	return fmt.Errorf("unknown guard profile %q, it must be one of %v", profile, profiles)
+}
+
+// guardFlagsIgnored returns the --guard<Type> flags set along with a bundle, which overrides them
+func guardFlagsIgnored(profile string) []string {
+	if profile == guardProfileCustom {
+		return nil
+	}
+	var ignored []string
+	for name, set := range map[string]bool{
+		"guardEndpoints":              *guardEndpoints,
+		"guardLoadBalancerServices":   *guardLoadBalancerServices,
+		"guardPersistentVolumeClaims": *guardPersistentVolumeClaims,
+	} {
+		if set {
+			ignored = append(ignored, name)
+		}
+	}
+	sort.Strings(ignored)
+	return ignored
+}
+
+// guardProfileCounts returns true if the profile counts the kind
+func guardProfileCounts(profile, kind string) bool {
+	if kinds, ok := guardProfileKinds[profile]; ok {
+		for _, k := range kinds {
+			if k == kind {
+				return true
+			}
+		}
+		return false
+	}
+	switch kind {
+	case "endpoints":
+		return *guardEndpoints
+	case loadBalancerServicesKind:
+		return *guardLoadBalancerServices
+	case "persistentvolumeclaims":
+		return *guardPersistentVolumeClaims
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	extensionsv1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+func TestValidateGuardProfile(t *testing.T) {
+	for _, profile := range []string{guardProfileStrict, guardProfileStandard, guardProfileMinimal, guardProfileCustom} {
+		assert.Nil(t, validateGuardProfile(profile), "should accept the %s profile", profile)
+	}
+	err := validateGuardProfile("paranoid")
+	if assert.NotNil(t, err, "should reject unknown profiles") {
+		assert.Equal(t, `unknown guard profile "paranoid", it must be one of [custom minimal standard strict]`, err.Error())
+	}
+}
+
+func TestGuardProfileCounters(t *testing.T) {
+	defer func() {
+		*guardProfile = guardProfileCustom
+		*guardEndpoints = false
+	}()
+
+	assert.Equal(t, []string{"pods", "services", "replicasets", "deployments", "statefulsets", "daemonsets", "ingresses", "horizontalpodautoscalers"},
+		counterKinds(resourceCounters()), "should count the default kinds")
+	*guardEndpoints = true
+	assert.Contains(t, counterKinds(resourceCounters()), "endpoints", "should count the kinds enabled by the guard flags")
+	assert.Empty(t, guardFlagsIgnored(*guardProfile))
+
+	*guardProfile = guardProfileMinimal
+	assert.Equal(t, []string{"pods"}, counterKinds(resourceCounters()))
+	assert.Equal(t, []string{"guardEndpoints"}, guardFlagsIgnored(*guardProfile))
+
+	*guardProfile = guardProfileStandard
+	assert.Equal(t, []string{"pods", "deployments", "statefulsets", "persistentvolumeclaims"}, counterKinds(resourceCounters()))
+
+	*guardProfile = guardProfileStrict
+	assert.Equal(t, []string{"pods", "services", "replicasets", "deployments", "statefulsets", "daemonsets", "ingresses", "horizontalpodautoscalers",
+		"endpoints", "loadbalancerservices", "persistentvolumeclaims"}, counterKinds(resourceCounters()))
+}
+
+func TestGuardProfileValidateNamespaceDeletion(t *testing.T) {
+	defer func() { *guardProfile = guardProfileCustom }()
+	daemonSet := &extensionsv1beta1.DaemonSet{ObjectMeta: v1.ObjectMeta{Name: "test-daemonset", Namespace: "test-namespace"}}
+	statefulSet := &appsv1beta1.StatefulSet{ObjectMeta: v1.ObjectMeta{Name: "test-statefulset", Namespace: "test-namespace"}}
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Name: "test-claim", Namespace: "test-namespace"}}
+
+	*guardProfile = guardProfileMinimal
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), daemonSet, statefulSet, claim)
+	assert.Nil(t, validateNamespaceDeletion("test-namespace"), "should only guard pods")
+
+	*guardProfile = guardProfileStandard
+	err := validateNamespaceDeletion("test-namespace")
+	if assert.NotNil(t, err, "should guard statefulsets and persistentvolumeclaims") {
+		assert.Contains(t, err.Error(), "these resources: [statefulsets(1) persistentvolumeclaims(1)]")
+	}
+
+	*guardProfile = guardProfileStrict
+	err = validateNamespaceDeletion("test-namespace")
+	if assert.NotNil(t, err, "should guard every kind") {
+		assert.Contains(t, err.Error(), "these resources: [statefulsets(1) daemonsets(1) persistentvolumeclaims(1)]")
+	}
+}
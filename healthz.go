@@ -0,0 +1,81 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// listenerHealth probes the listeners of the webhook server, nil until they are bound or when serving
+// on a unix socket
+var listenerHealth *listenerProbe
+
+// listenerProbe checks that the listeners of the webhook server accept connections
+type listenerProbe struct {
+	addresses []string
+	// tls is true to complete a TLS handshake on the connections
+	tls     bool
+	timeout time.Duration
+}
+
+// probeAddresses returns the loopback addresses dialed to reach the listeners, those bound on all
+// interfaces being dialed on the loopback of their address family
+func probeAddresses(listeners []net.Listener) []string {
+	addresses := make([]string, 0, len(listeners))
+	for _, listener := range listeners {
+		address := listener.Addr().String()
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			addresses = append(addresses, address)
+			continue
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+			if ip != nil && ip.To4() == nil {
+				host = "::1"
+			}
+		}
+		addresses = append(addresses, net.JoinHostPort(host, port))
+	}
+	return addresses
+}
+
+// check dials every listener, returning an error if one refuses the connection or doesn't respond to
+// the TLS handshake within the timeout. A handshake failing otherwise, e.g. for the lack of a client
+// certificate, is a response and the listener is healthy.
+func (p *listenerProbe) check() error {
+	for _, address := range p.addresses {
+		conn, err := net.DialTimeout("tcp", address, p.timeout)
+		if err != nil {
+			return fmt.Errorf("the listener %s is not accepting connections: %v", address, err)
+		}
+		if p.tls {
+			conn.SetDeadline(time.Now().Add(p.timeout))
+			err = tls.Client(conn, &tls.Config{InsecureSkipVerify: true}).Handshake()
+		}
+		conn.Close()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return fmt.Errorf("the listener %s did not complete the TLS handshake within %v", address, p.timeout)
+		}
+	}
+	return nil
+}
+
+// healthzHandler serves the /healthz liveness response, which is 503 once a listener of the webhook
+// server stops accepting connections
+func healthzHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Debugf("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+	if listenerHealth != nil {
+		if err := listenerHealth.check(); err != nil {
+			log.Errorf("Liveness check failed: %s", err.Error())
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	io.WriteString(rw, "OK")
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// addrListener is a listener bound on the address
+type addrListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l addrListener) Addr() net.Addr {
+	return l.addr
+}
+
+func TestProbeAddresses(t *testing.T) {
+	assert.Equal(t, []string{"127.0.0.1:8443", "[::1]:8443", "10.0.0.1:8443"}, probeAddresses([]net.Listener{
+		addrListener{addr: &net.TCPAddr{IP: net.IPv4zero, Port: 8443}},
+		addrListener{addr: &net.TCPAddr{IP: net.IPv6unspecified, Port: 8443}},
+		addrListener{addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8443}},
+	}))
+}
+
+func TestListenerProbe(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	serving := strings.TrimPrefix(server.URL, "https://")
+	assert.Nil(t, (&listenerProbe{addresses: []string{serving}, tls: true, timeout: time.Second}).check(), "should accept a responsive TLS listener")
+
+	// the connections to a listener whose server stopped are queued without ever being served
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer stalled.Close()
+	err = (&listenerProbe{addresses: []string{serving, stalled.Addr().String()}, tls: true, timeout: 100 * time.Millisecond}).check()
+	if assert.NotNil(t, err, "should fail if a listener doesn't complete the handshake") {
+		assert.Equal(t, "the listener "+stalled.Addr().String()+" did not complete the TLS handshake within 100ms", err.Error())
+	}
+	assert.Nil(t, (&listenerProbe{addresses: []string{stalled.Addr().String()}, timeout: 100 * time.Millisecond}).check(),
+		"should only dial the plain HTTP listeners")
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	closed.Close()
+	err = (&listenerProbe{addresses: []string{closed.Addr().String()}, tls: true, timeout: 100 * time.Millisecond}).check()
+	if assert.NotNil(t, err, "should fail if a listener refuses the connection") {
+		assert.Contains(t, err.Error(), "the listener "+closed.Addr().String()+" is not accepting connections")
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+	healthzHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/healthz", nil))
+	assert.Equal(t, http.StatusOK, rw.Code, "should be healthy without listeners to probe")
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	closed.Close()
+	listenerHealth = &listenerProbe{addresses: []string{closed.Addr().String()}, tls: true, timeout: 100 * time.Millisecond}
+	defer func() { listenerHealth = nil }()
+
+	rw = httptest.NewRecorder()
+	healthzHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	assert.Contains(t, rw.Body.String(), "is not accepting connections")
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	helmReleasesKind = "helmreleases"
+
+	// helmReleaseSelector selects the Secrets Helm stores its releases in
+	helmReleaseSelector = "owner=helm"
+	helmReleaseDataKey  = "release"
+	helmDeployedStatus  = "deployed"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b, 0x08}
+)
+
+// helmRelease holds the fields of a Helm release record relevant to the guard
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Info      struct {
+		Status string `json:"status"`
+	} `json:"info"`
+}
+
+// decodeHelmRelease decodes the base64 encoded, optionally gzipped, JSON release record
+func decodeHelmRelease(data []byte) (*helmRelease, error) {
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		b, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	release := &helmRelease{}
+	err = json.Unmarshal(b, release)
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// helmReleaseCounter returns the deployed Helm releases targeting the namespace which are stored in
+// another namespace, e.g. by helmfile or fleet, as <storage namespace>/<release>. The releases stored
+// in the namespace itself go away with it.
+func helmReleaseCounter(namespace string) ([]string, error) {
+	options := counterListOptions()
+	options.LabelSelector = strings.Trim(helmReleaseSelector+","+options.LabelSelector, ",")
+	list, err := clientset.CoreV1().Secrets(v1.NamespaceAll).List(options)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, secret := range list.Items {
+		if secret.Namespace == namespace {
+			continue
+		}
+		data, ok := secret.Data[helmReleaseDataKey]
+		if !ok {
+			continue
+		}
+		release, err := decodeHelmRelease(data)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the Helm release Secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+		if release.Namespace == namespace && release.Info.Status == helmDeployedStatus {
+			names = append(names, fmt.Sprintf("%s/%s", secret.Namespace, release.Name))
+		}
+	}
+	return names, nil
+}
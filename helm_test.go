@@ -0,0 +1,73 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// helmReleaseSecret returns a Helm release Secret stored in storageNamespace for a release targeting
+// releaseNamespace, encoded the way Helm does
+func helmReleaseSecret(storageNamespace, name, releaseNamespace, status string) *corev1.Secret {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	fmt.Fprintf(w, `{"name":%q,"namespace":%q,"info":{"status":%q},"version":1}`, name, releaseNamespace, status)
+	w.Close()
+	return &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("sh.helm.release.v1.%s.v1", name),
+			Namespace: storageNamespace,
+			Labels:    map[string]string{"owner": "helm", "name": name},
+		},
+		Type: "helm.sh/release.v1",
+		Data: map[string][]byte{
+			helmReleaseDataKey: []byte(base64.StdEncoding.EncodeToString(buf.Bytes())),
+		},
+	}
+}
+
+func TestHelmReleaseCounter(t *testing.T) {
+	clientset = fake.NewSimpleClientset(
+		helmReleaseSecret("helmfile", "frontend", "test-namespace", helmDeployedStatus),
+		helmReleaseSecret("helmfile", "old-frontend", "test-namespace", "superseded"),
+		helmReleaseSecret("helmfile", "backend", "other-namespace", helmDeployedStatus),
+		helmReleaseSecret("test-namespace", "local", "test-namespace", helmDeployedStatus),
+	)
+
+	names, err := helmReleaseCounter("test-namespace")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"helmfile/frontend"}, names)
+}
+
+func TestHelmDependenciesWebhookHandler(t *testing.T) {
+	clientset = fake.NewSimpleClientset(
+		cloneNamespace(templateNamespace),
+		helmReleaseSecret("fleet", "frontend", "test-namespace", helmDeployedStatus),
+	)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if guardHelmDependencies is not set")
+
+	*guardHelmDependencies = true
+	defer func() { *guardHelmDependencies = false }()
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if a Helm release targets the namespace")
+	assert.Contains(t, admReview.Status.Result.Message, "is referenced by these resources outside of it (external dependencies): [helmreleases[fleet/frontend]].")
+}
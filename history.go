@@ -0,0 +1,148 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// policyVersionKeyPrefix prefixes the history Secret keys holding a policy version
+	policyVersionKeyPrefix = "version-"
+)
+
+var (
+	// currentPolicy is the loaded config and its version in the history, served on /debug/config
+	currentPolicy = policyVersion{}
+)
+
+// policyVersion is a config file content recorded in the policy history Secret
+type policyVersion struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Config    string    `json:"config"`
+}
+
+// debugConfigResponse is the JSON body served on /debug/config
+type debugConfigResponse struct {
+	Version   int             `json:"version"`
+	Timestamp time.Time       `json:"timestamp"`
+	Profiles  []policyProfile `json:"profiles"`
+	Default   policyProfile   `json:"default"`
+}
+
+// splitNamespacedName splits a <namespace>/<name> reference
+func splitNamespacedName(ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid reference %q, it must be <namespace>/<name>", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// policyVersions returns the versions recorded in the history Secret, oldest first
+func policyVersions(secret *corev1.Secret) ([]policyVersion, error) {
+	var versions []policyVersion
+	for key, data := range secret.Data {
+		if !strings.HasPrefix(key, policyVersionKeyPrefix) {
+			continue
+		}
+		version := policyVersion{}
+		err := json.Unmarshal(data, &version)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the policy history entry %s: %v", key, err)
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// recordPolicyVersion stores the config in the history Secret as a new version, unless it is the
+// latest recorded version, and prunes the history to the last limit versions. It returns the version
+// of the config.
+func recordPolicyVersion(secretRef string, config []byte, limit int, now time.Time) (policyVersion, error) {
+	namespace, name, err := splitNamespacedName(secretRef)
+	if err != nil {
+		return policyVersion{}, err
+	}
+	secrets := clientset.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(name, v1.GetOptions{})
+	create := apiErrors.IsNotFound(err)
+	if create {
+		secret = &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: namespace}}
+	} else if err != nil {
+		return policyVersion{}, err
+	}
+
+	versions, err := policyVersions(secret)
+	if err != nil {
+		return policyVersion{}, err
+	}
+	if len(versions) > 0 && versions[len(versions)-1].Config == string(config) {
+		return versions[len(versions)-1], nil
+	}
+
+	current := policyVersion{Version: 1, Timestamp: now.UTC(), Config: string(config)}
+	if len(versions) > 0 {
+		current.Version = versions[len(versions)-1].Version + 1
+	}
+	versions = append(versions, current)
+	if limit > 0 && len(versions) > limit {
+		versions = versions[len(versions)-limit:]
+	}
+
+	secret.Data = map[string][]byte{}
+	for _, version := range versions {
+		data, err := json.Marshal(version)
+		if err != nil {
+			return policyVersion{}, err
+		}
+		secret.Data[policyVersionKeyPrefix+strconv.Itoa(version.Version)] = data
+	}
+	if create {
+		_, err = secrets.Create(secret)
+	} else {
+		_, err = secrets.Update(secret)
+	}
+	if err != nil {
+		return policyVersion{}, err
+	}
+	return current, nil
+}
+
+// debugConfigHandler serves the /debug/config endpoint reporting the loaded policy and its version
+func debugConfigHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+
+	if req.Method != http.MethodGet {
+		writeJSON(rw, http.StatusMethodNotAllowed, explainError{fmt.Sprintf("Incoming request method %s is not supported, only GET is supported", req.Method)})
+		return
+	}
+
+	resp := debugConfigResponse{
+		Version:   currentPolicy.Version,
+		Timestamp: currentPolicy.Timestamp,
+		Profiles:  []policyProfile{},
+		Default:   defaultProfile(),
+	}
+	if currentPolicy.Config != "" {
+		config, err := parseConfig([]byte(currentPolicy.Config))
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, explainError{err.Error()})
+			return
+		}
+		resp.Profiles = config.Profiles
+	}
+	writeJSON(rw, http.StatusOK, resp)
+}
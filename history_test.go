@@ -0,0 +1,64 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordPolicyVersion(t *testing.T) {
+	clientset = fake.NewSimpleClientset()
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	version, err := recordPolicyVersion("kube-system/guard-policy-history", []byte("profiles: []"), 2, now)
+	assert.Nil(t, err)
+	assert.Equal(t, policyVersion{Version: 1, Timestamp: now, Config: "profiles: []"}, version)
+
+	version, err = recordPolicyVersion("kube-system/guard-policy-history", []byte("profiles: []"), 2, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version.Version, "an unchanged config keeps its version")
+
+	_, err = recordPolicyVersion("kube-system/guard-policy-history", []byte("profiles: [{name: a}]"), 2, now.Add(2*time.Hour))
+	assert.Nil(t, err)
+	version, err = recordPolicyVersion("kube-system/guard-policy-history", []byte("profiles: [{name: b}]"), 2, now.Add(3*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version.Version)
+
+	secret, err := clientset.CoreV1().Secrets("kube-system").Get("guard-policy-history", v1.GetOptions{})
+	assert.Nil(t, err)
+	versions, err := policyVersions(secret)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions), "the history is pruned to the limit")
+	assert.Equal(t, 2, versions[0].Version)
+	assert.Equal(t, "profiles: [{name: a}]", versions[0].Config)
+	assert.Equal(t, 3, versions[1].Version)
+
+	_, err = recordPolicyVersion("guard-policy-history", []byte("profiles: []"), 2, now)
+	assert.EqualError(t, err, "invalid reference \"guard-policy-history\", it must be <namespace>/<name>")
+}
+
+func TestDebugConfigHandler(t *testing.T) {
+	currentPolicy = policyVersion{Version: 4, Config: "profiles: [{name: strict, maxResourceCount: 2}]"}
+	defer func() { currentPolicy = policyVersion{} }()
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:8080/debug/config", nil)
+	debugConfigHandler(rw, req)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	resp := debugConfigResponse{}
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Equal(t, 4, resp.Version)
+	assert.Equal(t, 1, len(resp.Profiles))
+	assert.Equal(t, "strict", resp.Profiles[0].Name)
+	assert.Equal(t, 2, resp.Profiles[0].MaxResourceCount)
+	assert.Equal(t, defaultProfileName, resp.Default.Name)
+}
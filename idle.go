@@ -0,0 +1,50 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// confirmNamespaceIdle watches the pods of the namespace for the window and returns an error naming
+// the pods created meanwhile, as a point-in-time count misses namespaces churned by controllers
+func confirmNamespaceIdle(namespace string, window time.Duration) error {
+	pods := clientset.CoreV1().Pods(namespace)
+	list, err := pods.List(counterListOptions())
+	if err != nil {
+		return fmt.Errorf("error listing pods, %v", err)
+	}
+	options := counterListOptions()
+	options.ResourceVersion = list.ResourceVersion
+	w, err := pods.Watch(options)
+	if err != nil {
+		return fmt.Errorf("error watching pods, %v", err)
+	}
+	defer w.Stop()
+
+	var created []string
+	timeout := time.After(window)
+	for {
+		select {
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return fmt.Errorf("the pods watch of namespace %s closed before the %v idle window elapsed", namespace, window)
+			}
+			if event.Type != watch.Added {
+				continue
+			}
+			if accessor, err := meta.Accessor(event.Object); err == nil {
+				created = append(created, accessor.GetName())
+			}
+		case <-timeout:
+			if len(created) > 0 {
+				return fmt.Errorf("the namespace %s is not idle, these pods were created within the last %v: %v", namespace, window, created)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// watchingClientset returns a clientset whose pods watch is served by the fake watcher
+func watchingClientset(watcher *watch.FakeWatcher) *fake.Clientset {
+	fakeClientset := fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	fakeClientset.PrependWatchReactor("pods", ktesting.DefaultWatchReactor(watcher, nil))
+	return fakeClientset
+}
+
+func TestConfirmIdleWebhookHandler(t *testing.T) {
+	*confirmIdle = 200 * time.Millisecond
+	defer func() { *confirmIdle = 0 }()
+
+	watcher := watch.NewFake()
+	clientset = watchingClientset(watcher)
+	go func() {
+		watcher.Add(&corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "churned-pod", Namespace: "test-namespace"}})
+		watcher.Delete(&corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "churned-pod", Namespace: "test-namespace"}})
+	}()
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if pods were created during the idle window")
+	assert.Contains(t, admReview.Status.Result.Message, "the namespace test-namespace is not idle, these pods were created within the last 200ms: [churned-pod]")
+}
+
+func TestConfirmIdleQuietWebhookHandler(t *testing.T) {
+	*confirmIdle = 100 * time.Millisecond
+	defer func() { *confirmIdle = 0 }()
+
+	clientset = watchingClientset(watch.NewFake())
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the namespace stayed idle")
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// impersonator returns the identity impersonating the requester, as recorded in the first of the
+// --impersonatorExtraKeys found in the extra user info, empty if the request is not impersonated
+func impersonator(userInfo authenticationv1.UserInfo) string {
+	for _, key := range strings.Split(*impersonatorExtraKeys, ",") {
+		key = strings.TrimSpace(key)
+		for extraKey, values := range userInfo.Extra {
+			if key != "" && strings.EqualFold(extraKey, key) && len(values) > 0 {
+				return values[0]
+			}
+		}
+	}
+	return ""
+}
+
+// impersonationAllowed returns true unless --denyImpersonatedDeletes is set and the impersonator is
+// not among the --impersonatorAllowlist
+func impersonationAllowed(impersonator string) bool {
+	if !*denyImpersonatedDeletes {
+		return true
+	}
+	for _, allowed := range strings.Split(*impersonatorAllowlist, ",") {
+		if strings.TrimSpace(allowed) == impersonator {
+			return true
+		}
+	}
+	return false
+}
+
+// requesterIdentity describes the requester for the logs, along with its impersonator if any
+func requesterIdentity(userInfo authenticationv1.UserInfo) string {
+	if by := impersonator(userInfo); by != "" {
+		return userInfo.Username + " impersonated by " + by
+	}
+	return userInfo.Username
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	// directUser deletes under its own identity
+	directUser = authenticationv1.UserInfo{
+		Username: "alice",
+		Groups:   []string{"system:authenticated"},
+		Extra:    map[string]authenticationv1.ExtraValue{"scopes": {"ci"}},
+	}
+	// impersonatedUser is impersonated by bob, e.g. with kubectl --as alice
+	impersonatedUser = authenticationv1.UserInfo{
+		Username: "alice",
+		Groups:   []string{"system:authenticated"},
+		Extra:    map[string]authenticationv1.ExtraValue{"Impersonated-By": {"bob"}},
+	}
+)
+
+func TestImpersonator(t *testing.T) {
+	assert.Equal(t, "", impersonator(directUser))
+	assert.Equal(t, "bob", impersonator(impersonatedUser), "should match the extra keys case-insensitively")
+	assert.Equal(t, "carol", impersonator(authenticationv1.UserInfo{
+		Username: "alice",
+		Extra:    map[string]authenticationv1.ExtraValue{"original-user": {"carol"}},
+	}))
+	assert.Equal(t, "", impersonator(authenticationv1.UserInfo{
+		Username: "alice",
+		Extra:    map[string]authenticationv1.ExtraValue{"impersonated-by": {}},
+	}))
+	assert.Equal(t, "alice impersonated by bob", requesterIdentity(impersonatedUser))
+	assert.Equal(t, "alice", requesterIdentity(directUser))
+}
+
+func TestImpersonatedDeleteWebhookHandler(t *testing.T) {
+	var buf bytes.Buffer
+	waitForBackgroundTasks(t)
+	out := log.Out
+	log.Out = &buf
+	defer func() { log.Out = out }()
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	review := func(userInfo authenticationv1.UserInfo) *v1alpha1.AdmissionReview {
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.UserInfo = userInfo
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+		webhookHandler(rw, req)
+		waitForBackgroundTasks(t)
+		return getAdmissionReview(rw)
+	}
+
+	assert.True(t, review(impersonatedUser).Status.Allowed, "should allow impersonated deletions by default")
+	assert.Contains(t, buf.String(), "on Namespace: test-namespace by user: alice impersonated by bob")
+
+	*denyImpersonatedDeletes = true
+	defer func() {
+		*denyImpersonatedDeletes = false
+		*impersonatorAllowlist = ""
+	}()
+	assert.True(t, review(directUser).Status.Allowed, "should allow the deletions without impersonation")
+
+	admReview := review(impersonatedUser)
+	assert.False(t, admReview.Status.Allowed, "should reject the impersonated deletions")
+	assert.Equal(t, "Impersonated namespace deletions are not allowed: bob may not delete the namespace test-namespace as alice. Please delete it under your own identity.", admReview.Status.Result.Message)
+
+	*impersonatorAllowlist = "system:serviceaccount:ci:deployer, bob"
+	assert.True(t, review(impersonatedUser).Status.Allowed, "should allow the impersonators in the allowlist")
+}
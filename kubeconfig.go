@@ -0,0 +1,110 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// inClusterConfig returns the config of the service account, like rest.InClusterConfig but with the
+// token and root CA read from the given files, e.g. mounted elsewhere on customized clusters. An
+// invalid CA file is only logged, the host's root CAs then verifying the apiserver.
+func inClusterConfig(tokenFile, caFile string) (*rest.Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("unable to load in-cluster configuration, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be defined")
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &rest.Config{
+		Host:        "https://" + net.JoinHostPort(host, port),
+		BearerToken: string(token),
+	}
+	if ca, err := ioutil.ReadFile(caFile); err != nil {
+		log.Errorf("Expected to load the root CA from %s: %s", caFile, err.Error())
+	} else if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+		log.Errorf("Expected to load the root CA from %s: no PEM encoded certificate found", caFile)
+	} else {
+		config.TLSClientConfig.CAFile = caFile
+	}
+	return config, nil
+}
+
+// getKubernetesConfig returns the config of the kubeconfig file if given, else the in-cluster config
+// of the --serviceAccountTokenFile and --serviceAccountCAFile. Outside of a cluster, the kubeconfig is
+// loaded like kubectl does, from $KUBECONFIG or ~/.kube/config.
+func getKubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	config, err := inClusterConfig(*serviceAccountTokenFile, *serviceAccountCAFile)
+	if err == nil {
+		return config, nil
+	}
+	log.Infof("In-cluster config unavailable, loading the kubeconfig: %s", err.Error())
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+	return loader.ClientConfig()
+}
+
+// newKubernetesClientset creates the clientset of the kubeconfig and checks the apiserver is reachable
+// with a discovery request, failing if it does not complete within the timeout. There is no deadline
+// when the timeout is 0.
+func newKubernetesClientset(kubeconfig string, timeout time.Duration) (*kubernetes.Clientset, error) {
+	type result struct {
+		clientset *kubernetes.Clientset
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		clientset, err := connectKubernetes(kubeconfig, timeout)
+		done <- result{clientset, err}
+	}()
+	if timeout == 0 {
+		r := <-done
+		return r.clientset, r.err
+	}
+	select {
+	case r := <-done:
+		return r.clientset, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("the apiserver could not be reached within the startup timeout of %v", timeout)
+	}
+}
+
+// connectKubernetes builds the config and clientset, then requests the apiserver version
+func connectKubernetes(kubeconfig string, timeout time.Duration) (*kubernetes.Clientset, error) {
+	config, err := getKubernetesConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while building the kube-config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while initializing the client set: %v", err)
+	}
+
+	// bound the discovery request only, the clientset requests keep the default timeout
+	discoveryConfig := *config
+	discoveryConfig.Timeout = timeout
+	client, err := discovery.NewDiscoveryClientForConfig(&discoveryConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while initializing the discovery client: %v", err)
+	}
+	version, err := client.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("the apiserver %s is unreachable: %v", config.Host, err)
+	}
+	log.Infof("Connected to the apiserver %s running %s", config.Host, version.GitVersion)
+	return clientset, nil
+}
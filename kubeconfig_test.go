@@ -0,0 +1,156 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKubeconfig writes a kubeconfig file of a single cluster served at the URL
+func writeKubeconfig(t *testing.T, dir, name, server string) string {
+	path := filepath.Join(dir, name)
+	content := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+users:
+- name: test
+  user:
+    token: test-token
+current-context: test
+`, server)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestGetKubernetesConfigFromEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// make sure the in-cluster config is unavailable
+	for _, env := range []string{"KUBERNETES_SERVICE_HOST", "KUBERNETES_SERVICE_PORT", "KUBECONFIG"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	os.Unsetenv("KUBERNETES_SERVICE_PORT")
+	os.Setenv("KUBECONFIG", writeKubeconfig(t, dir, "env.yaml", "https://env.example.com:6443"))
+
+	config, err := getKubernetesConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://env.example.com:6443", config.Host, "should use $KUBECONFIG outside of a cluster")
+	assert.Equal(t, "test-token", config.BearerToken)
+
+	config, err = getKubernetesConfig(writeKubeconfig(t, dir, "flag.yaml", "https://flag.example.com:6443"))
+	assert.Nil(t, err)
+	assert.Equal(t, "https://flag.example.com:6443", config.Host, "should prefer --kubeconfig over $KUBECONFIG")
+}
+
+func TestGetKubernetesConfigInCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile, caFile := filepath.Join(dir, "token"), filepath.Join(dir, "ca.crt")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("custom-token"), 0600))
+	assert.Nil(t, ioutil.WriteFile(caFile, generateCert(t, time.Now().Add(time.Hour)), 0600))
+
+	for _, env := range []string{"KUBERNETES_SERVICE_HOST", "KUBERNETES_SERVICE_PORT"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	defer func(token, ca string) { *serviceAccountTokenFile, *serviceAccountCAFile = token, ca }(*serviceAccountTokenFile, *serviceAccountCAFile)
+	*serviceAccountTokenFile, *serviceAccountCAFile = tokenFile, caFile
+
+	config, err := getKubernetesConfig("")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "https://10.0.0.1:443", config.Host)
+		assert.Equal(t, "custom-token", config.BearerToken, "should read the serviceAccountTokenFile")
+		assert.Equal(t, caFile, config.TLSClientConfig.CAFile, "should verify the apiserver with the serviceAccountCAFile")
+	}
+
+	*serviceAccountCAFile = filepath.Join(dir, "missing.crt")
+	config, err = inClusterConfig(tokenFile, *serviceAccountCAFile)
+	if assert.Nil(t, err, "should not fail without the CA file") {
+		assert.Equal(t, "", config.TLSClientConfig.CAFile)
+	}
+
+	_, err = inClusterConfig(filepath.Join(dir, "missing-token"), caFile)
+	assert.NotNil(t, err, "should fail without the token file")
+}
+
+func TestNewKubernetesClientset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	apiserver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/version", req.URL.Path)
+		io.WriteString(rw, `{"major":"1","minor":"7","gitVersion":"v1.7.4"}`)
+	}))
+	defer apiserver.Close()
+
+	clientset, err := newKubernetesClientset(writeKubeconfig(t, dir, "kubeconfig.yaml", apiserver.URL), 5*time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, clientset)
+}
+
+func TestNewKubernetesClientsetUnreachable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// accepts the connections but never responds, like an overloaded apiserver
+	hanging, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer hanging.Close()
+	go func() {
+		for {
+			conn, err := hanging.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	start := time.Now()
+	_, err = newKubernetesClientset(writeKubeconfig(t, dir, "hanging.yaml", "http://"+hanging.Addr().String()), 200*time.Millisecond)
+	if assert.NotNil(t, err, "should fail if the apiserver does not respond") {
+		// either the discovery request or the startup deadline expires first
+		assert.Regexp(t, "the apiserver (http://"+hanging.Addr().String()+" is unreachable|could not be reached within the startup timeout of 200ms)", err.Error())
+	}
+	assert.True(t, time.Since(start) < 5*time.Second, "should fail fast on startup")
+
+	// nothing listens on a closed port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := closed.Addr().String()
+	closed.Close()
+	_, err = newKubernetesClientset(writeKubeconfig(t, dir, "closed.yaml", "http://"+address), 5*time.Second)
+	if assert.NotNil(t, err, "should fail if the apiserver refuses the connections") {
+		assert.Contains(t, err.Error(), "the apiserver http://"+address+" is unreachable")
+	}
+
+	_, err = newKubernetesClientset(filepath.Join(dir, "missing.yaml"), 5*time.Second)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "error occurred while building the kube-config")
+	}
+}
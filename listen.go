@@ -0,0 +1,59 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// addressList is a flag value collecting the listen addresses, repeated and/or comma separated
+type addressList []string
+
+func (l *addressList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *addressList) Set(value string) error {
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			*l = append(*l, address)
+		}
+	}
+	return nil
+}
+
+// listenTCP binds a TCP listener on every address, wrapping them if wrap is not nil. It fails if any
+// address can't be bound, closing the listeners already bound.
+func listenTCP(addresses []string, wrap func(net.Listener) net.Listener) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("unable to listen on %s: %v", address, err)
+		}
+		if wrap != nil {
+			listener = wrap(listener)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenTLS binds a TLS listener sharing the config on every address, wrapping the TCP listeners if
+// wrap is not nil
+func listenTLS(addresses []string, config *tls.Config, wrap func(net.Listener) net.Listener) ([]net.Listener, error) {
+	listeners, err := listenTCP(addresses, wrap)
+	if err != nil {
+		return nil, err
+	}
+	for i, listener := range listeners {
+		listeners[i] = tls.NewListener(listener, config)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestAddressListFlag(t *testing.T) {
+	var addresses addressList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&addresses, "listenAddress", "")
+	err := fs.Parse([]string{"--listenAddress=[::]:8443", "--listenAddress", "0.0.0.0:8443, 10.0.0.1:8443,"})
+	assert.Nil(t, err)
+	assert.Equal(t, addressList{"[::]:8443", "0.0.0.0:8443", "10.0.0.1:8443"}, addresses)
+	assert.Equal(t, "[::]:8443,0.0.0.0:8443,10.0.0.1:8443", addresses.String())
+}
+
+func TestListenTLSDualStack(t *testing.T) {
+	// borrow the self-signed certificate of httptest
+	ts := httptest.NewTLSServer(nil)
+	tlsConfig := &tls.Config{Certificates: ts.TLS.Certificates}
+	ts.Close()
+
+	listeners, err := listenTLS([]string{"127.0.0.1:0", "[::1]:0"}, tlsConfig, nil)
+	if err != nil {
+		t.Skipf("IPv4 and IPv6 loopback listeners unavailable: %s", err.Error())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status.html", statusHandler)
+	srv := &http.Server{Handler: mux}
+	for _, listener := range listeners {
+		go srv.Serve(listener)
+	}
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	for _, listener := range listeners {
+		resp, err := client.Get("https://" + listener.Addr().String() + "/status.html")
+		if !assert.Nil(t, err, "should serve on %s", listener.Addr()) {
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "OK", string(body))
+	}
+}
+
+func TestListenTLSBindFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer taken.Close()
+
+	listeners, err := listenTLS([]string{"127.0.0.1:0", taken.Addr().String()}, &tls.Config{}, nil)
+	assert.Nil(t, listeners)
+	assert.Contains(t, err.Error(), "unable to listen on "+taken.Addr().String())
+}
+
+func TestListenTCPWebhookCycle(t *testing.T) {
+	listeners, err := listenTCP([]string{"127.0.0.1:0"}, nil)
+	assert.Nil(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", webhookHandler)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listeners[0])
+	defer srv.Close()
+
+	emptyNamespace := cloneNamespace(templateNamespace)
+	emptyNamespace.Name = "empty-namespace"
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), emptyNamespace, testPod)
+
+	post := func(namespace string) *v1alpha1.AdmissionReview {
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.Name = namespace
+		resp, err := http.Post("http://"+listeners[0].Addr().String()+"/", "application/json", constructPostBody(testSpec))
+		if !assert.Nil(t, err) {
+			return &v1alpha1.AdmissionReview{}
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		admReview := &v1alpha1.AdmissionReview{}
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(admReview))
+		return admReview
+	}
+
+	admReview := post("test-namespace")
+	assert.False(t, admReview.Status.Allowed, "should reject over plain HTTP if the namespace has pod resources")
+	assert.Contains(t, admReview.Status.Result.Message, "[pods(1)]")
+	assert.True(t, post("empty-namespace").Status.Allowed, "should approve over plain HTTP if the namespace is empty")
+	waitForBackgroundTasks(t)
+}
@@ -4,41 +4,140 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"k8s.io/api/admission/v1alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	autoscalingv1 "k8s.io/client-go/pkg/apis/autoscaling/v1"
 )
 
 const (
+	// bypassAnnotationKey is the default of the --bypassAnnotationKey
 	bypassAnnotationKey = "k8s-namespace-guard.admission.yahoo.com/allow-cascade-delete"
+	// bypassAnnotationPattern is the default of the --bypassAnnotationPattern
+	bypassAnnotationPattern = "^(true|yes|1)$"
+
+	// loadBalancerServicesKind is the kind of the provisioned Services of type LoadBalancer counted
+	// with --guardLoadBalancerServices
+	loadBalancerServicesKind = "loadbalancerservices"
+
+	// kubernetesEndpointsName is the Endpoints of the apiserver, which is never user managed
+	kubernetesEndpointsName = "kubernetes"
+
+	// ageCutoffNewer and ageCutoffOlder are the --resourceAgeCutoffCounts values
+	ageCutoffNewer = "newer"
+	ageCutoffOlder = "older"
+
+	nonDeleteAllow = "allow"
+	nonDeleteDeny  = "deny"
+
+	// bypassHint starts the sentence of the rejection messages giving the bypass command, which is kept
+	// when the message is truncated to --maxMessageBytes
+	bypassHint = " WARNING: If you know what you are doing"
+	// messageEllipsis replaces the truncated part of the messages
+	messageEllipsis = "..."
+)
+
+var (
+	// bypassValueRegex matches the values of the bypass annotation allowing a cascading delete, set by
+	// --bypassAnnotationPattern
+	bypassValueRegex = regexp.MustCompile(bypassAnnotationPattern)
 )
 
 var (
 	namespaceResourceType = v1.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+
+	// systemControllerUsers are the kube-controller-manager identities whose deletes are issued while
+	// tearing down an already terminating namespace, denying them wedges the namespace in Terminating
+	systemControllerUsers = map[string]bool{
+		"system:serviceaccount:kube-system:namespace-controller":      true,
+		"system:serviceaccount:kube-system:generic-garbage-collector": true,
+	}
 )
 
-// writeResponse writes the admissionReviewStatus object to the response body
-func writeResponse(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview, allowed bool, errorMsg string) {
-	log.Infof("Responding Allowed: %t for %s on Namespace: %s by user: %s", allowed,
+// admissionDecision is the outcome of an admission review. Rejections are classified by their status
+// reason so that clients can tell a policy denial from a failed validation.
+type admissionDecision struct {
+	allowed bool
+	// reason is Forbidden for policy denials, InternalError when the validation could not complete and
+	// BadRequest for requests the webhook should not have received. It is empty for allowed requests.
+	reason v1.StatusReason
+	// message is the rejection message, or an optional warning for allowed requests
+	message string
+	// blocking are the resource kinds blocking a rejected deletion, with their count
+	blocking []string
+}
+
+// statusCodes are the HTTP codes reported with the rejection reasons
+var statusCodes = map[v1.StatusReason]int32{
+	v1.StatusReasonForbidden:     http.StatusForbidden,
+	v1.StatusReasonInternalError: http.StatusInternalServerError,
+	v1.StatusReasonBadRequest:    http.StatusBadRequest,
+}
+
+// allow admits the request, with an optional warning
+func allow(warning string) admissionDecision {
+	return admissionDecision{allowed: true, message: warning}
+}
+
+// deny rejects the request as forbidden by the policy
+func deny(message string) admissionDecision {
+	return admissionDecision{reason: v1.StatusReasonForbidden, message: message}
+}
+
+// internalError rejects the request because it could not be validated
+func internalError(message string) admissionDecision {
+	return admissionDecision{reason: v1.StatusReasonInternalError, message: message}
+}
+
+// badRequest rejects a request the webhook should not have received
+func badRequest(message string) admissionDecision {
+	return admissionDecision{reason: v1.StatusReasonBadRequest, message: message}
+}
+
+// writeResponse writes the admissionReviewStatus object of the decision to the response body
+func writeResponse(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview, decision admissionDecision) {
+	log.Infof("Responding Allowed: %t for %s on Namespace: %s by user: %s", decision.allowed,
 		admReview.Spec.Operation,
 		admReview.Spec.Name,
-		admReview.Spec.UserInfo.Username)
-
-	if !allowed {
-		log.Errorf("Rejection reason: %s", errorMsg)
-	}
+		requesterIdentity(admReview.Spec.UserInfo))
 
+	// the apiserver may fail to parse a response without its TypeMeta, e.g. the empty review answering
+	// an undecodable request
+	admReview.TypeMeta = v1.TypeMeta{Kind: "AdmissionReview", APIVersion: v1alpha1.SchemeGroupVersion.String()}
 	admReview.Status = v1alpha1.AdmissionReviewStatus{
-		Allowed: allowed,
-		Result: &v1.Status{
-			Reason: v1.StatusReason(errorMsg),
-		},
+		Allowed: decision.allowed,
+		Result:  &v1.Status{Message: decision.message},
+	}
+	if decision.allowed {
+		// an allowed response only carries a message to warn about
+		if decision.message != "" {
+			log.Warnf("Allowed with warning: %s", decision.message)
+		}
+	} else {
+		decision.message = capMessage(decision.message, *maxMessageBytes)
+		log.Errorf("Rejection reason: %s: %s", decision.reason, decision.message)
+		admReview.Status.Result.Message = decision.message
+		admReview.Status.Result.Status = v1.StatusFailure
+		admReview.Status.Result.Reason = decision.reason
+		admReview.Status.Result.Code = statusCodes[decision.reason]
 	}
 
 	body := new(bytes.Buffer)
@@ -47,187 +146,1089 @@ func writeResponse(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview,
 		io.WriteString(rw, "Error occurred while encoding the admission review status into json: "+err.Error())
 		return
 	}
+	rw.Header().Set("Content-Type", *responseContentType)
 	rw.Write(body.Bytes())
 }
 
-func podCounter(namespace string) (int, error) {
-	list, err := clientset.CoreV1().Pods(namespace).List(v1.ListOptions{})
+// objectNames returns the names of the items in a list object
+func objectNames(list runtime.Object) ([]string, error) {
+	items, err := meta.ExtractList(list)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		accessor, err := meta.Accessor(item)
+		if err != nil {
+			return nil, err
+		}
+		if countedAge(accessor.GetCreationTimestamp()) {
+			names = append(names, accessor.GetName())
+		}
 	}
-	return len(list.Items), nil
+	return names, nil
 }
 
-func serviceCounter(namespace string) (int, error) {
-	list, err := clientset.CoreV1().Services(namespace).List(v1.ListOptions{})
+// countedAge returns true if an object created at the timestamp is counted. With --resourceAgeCutoff,
+// only the objects created within the cutoff are counted, or only the older ones with
+// --resourceAgeCutoffCounts=older.
+func countedAge(created v1.Time) bool {
+	if *resourceAgeCutoff <= 0 {
+		return true
+	}
+	newer := time.Since(created.Time) < *resourceAgeCutoff
+	return newer == (*resourceAgeCutoffCounts == ageCutoffNewer)
+}
+
+// counterListOptions returns the options of the counters' list calls, excluding the objects matched
+// by --systemManagedSelector
+func counterListOptions() v1.ListOptions {
+	return v1.ListOptions{LabelSelector: *systemManagedSelector}
+}
+
+func podCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.CoreV1().Pods(namespace).List(counterListOptions())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return len(list.Items), nil
+	return objectNames(list)
 }
 
-func replicasetCounter(namespace string) (int, error) {
-	list, err := clientset.ExtensionsV1beta1().ReplicaSets(namespace).List(v1.ListOptions{})
+func serviceCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.CoreV1().Services(namespace).List(counterListOptions())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return len(list.Items), nil
+	return objectNames(list)
 }
 
-func deploymentCounter(namespace string) (int, error) {
-	list, err := clientset.AppsV1beta1().Deployments(namespace).List(v1.ListOptions{})
+func replicasetCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.ExtensionsV1beta1().ReplicaSets(namespace).List(counterListOptions())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return len(list.Items), nil
+	return objectNames(list)
 }
 
-func statefulsetCounter(namespace string) (int, error) {
-	list, err := clientset.AppsV1beta1().StatefulSets(namespace).List(v1.ListOptions{})
+func deploymentCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.AppsV1beta1().Deployments(namespace).List(counterListOptions())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return len(list.Items), nil
+	return objectNames(list)
 }
 
-func daemonsetCounter(namespace string) (int, error) {
-	list, err := clientset.ExtensionsV1beta1().DaemonSets(namespace).List(v1.ListOptions{})
+func statefulsetCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.AppsV1beta1().StatefulSets(namespace).List(counterListOptions())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return len(list.Items), nil
+	return objectNames(list)
 }
 
-func ingressCounter(namespace string) (int, error) {
-	list, err := clientset.ExtensionsV1beta1().Ingresses(namespace).List(v1.ListOptions{})
+func daemonsetCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.ExtensionsV1beta1().DaemonSets(namespace).List(counterListOptions())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return len(list.Items), nil
+	return objectNames(list)
 }
 
-func autoScaleCounter(namespace string) (int, error) {
-	list, err := clientset.AutoscalingV1().HorizontalPodAutoscalers(namespace).List(v1.ListOptions{})
+func configMapCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.CoreV1().ConfigMaps(namespace).List(counterListOptions())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return len(list.Items), nil
+	return objectNames(list)
 }
 
-// validateNamespaceDeletion returns an error if the namespace contains any workload resources
-func validateNamespaceDeletion(namespace string) (err error) {
+func ingressCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.ExtensionsV1beta1().Ingresses(namespace).List(counterListOptions())
+	if err != nil {
+		return nil, err
+	}
+	return objectNames(list)
+}
+
+// loadBalancerServiceCounter returns the Services of type LoadBalancer whose load balancer was
+// provisioned, as their cloud resources may outlive the namespace. The apiserver does not support
+// field selectors on the service type, so the services are filtered here.
+func loadBalancerServiceCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.CoreV1().Services(namespace).List(counterListOptions())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, service := range list.Items {
+		if service.Spec.Type == corev1.ServiceTypeLoadBalancer && len(service.Status.LoadBalancer.Ingress) > 0 && countedAge(service.CreationTimestamp) {
+			names = append(names, service.Name)
+		}
+	}
+	return names, nil
+}
+
+func persistentVolumeClaimCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.CoreV1().PersistentVolumeClaims(namespace).List(counterListOptions())
+	if err != nil {
+		return nil, err
+	}
+	return objectNames(list)
+}
+
+// endpointCounter returns the Endpoints objects, except the kubernetes Endpoints of the apiserver
+func endpointCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.CoreV1().Endpoints(namespace).List(counterListOptions())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, endpoints := range list.Items {
+		if endpoints.Name != kubernetesEndpointsName && countedAge(endpoints.CreationTimestamp) {
+			names = append(names, endpoints.Name)
+		}
+	}
+	return names, nil
+}
+
+// autoScaleCounter returns the HPAs actively managing replicas, dormant HPAs do not block the
+// namespace deletion
+func autoScaleCounter(client kubernetes.Interface, namespace string) ([]string, error) {
+	list, err := client.AutoscalingV1().HorizontalPodAutoscalers(namespace).List(counterListOptions())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := range list.Items {
+		if isActiveHPA(&list.Items[i]) && countedAge(list.Items[i].CreationTimestamp) {
+			names = append(names, list.Items[i].Name)
+		}
+	}
+	return names, nil
+}
+
+// isActiveHPA returns true if the HPA is scaled above its minReplicas or has desired replicas
+func isActiveHPA(hpa *autoscalingv1.HorizontalPodAutoscaler) bool {
+	minReplicas := int32(1)
+	if hpa.Spec.MinReplicas != nil {
+		minReplicas = *hpa.Spec.MinReplicas
+	}
+	return hpa.Status.CurrentReplicas > minReplicas || hpa.Status.DesiredReplicas > 0
+}
+
+// servingServices returns the services whose endpoints have ready addresses, i.e. the services
+// actively serving traffic
+func servingServices(namespace string, services []string) []string {
+	list, err := clientset.CoreV1().Endpoints(namespace).List(v1.ListOptions{})
+	if err != nil {
+		log.Errorf("Error occurred while listing the endpoints in namespace %s: %s", namespace, err.Error())
+		return nil
+	}
+	ready := map[string]bool{}
+	for _, endpoints := range list.Items {
+		for _, subset := range endpoints.Subsets {
+			if len(subset.Addresses) > 0 {
+				ready[endpoints.Name] = true
+			}
+		}
+	}
+	var serving []string
+	for _, name := range services {
+		if ready[name] {
+			serving = append(serving, name)
+		}
+	}
+	return serving
+}
+
+// resourceCounter lists the objects of a single resource kind within a namespace
+type resourceCounter struct {
+	kind    string
+	counter func(namespace string) ([]string, error)
+}
+
+// clientCounter binds the counter of a built-in kind to the client
+func clientCounter(client kubernetes.Interface, counter func(kubernetes.Interface, string) ([]string, error)) func(namespace string) ([]string, error) {
+	return func(namespace string) ([]string, error) {
+		return counter(client, namespace)
+	}
+}
+
+// resourceCounters returns the resource kinds that block a namespace deletion
+func resourceCounters() []resourceCounter {
+	return clientCounters(clientset, dynamicClients)
+}
+
+// clientCounters returns the counters of the resource kinds that block a namespace deletion, listing
+// the built-in kinds with the client and the optional kinds with the dynamic clients
+func clientCounters(client kubernetes.Interface, clients dynamic.ClientPool) []resourceCounter {
+	var counters []resourceCounter
+	for _, c := range []resourceCounter{
+		{"pods", clientCounter(client, podCounter)},
+		{"services", clientCounter(client, serviceCounter)},
+		{"replicasets", clientCounter(client, replicasetCounter)},
+		{"deployments", clientCounter(client, deploymentCounter)},
+		{"statefulsets", clientCounter(client, statefulsetCounter)},
+		{"daemonsets", clientCounter(client, daemonsetCounter)},
+		{"ingresses", clientCounter(client, ingressCounter)},
+		{"horizontalpodautoscalers", clientCounter(client, autoScaleCounter)},
+		{"endpoints", clientCounter(client, endpointCounter)},
+		{loadBalancerServicesKind, clientCounter(client, loadBalancerServiceCounter)},
+		{"persistentvolumeclaims", clientCounter(client, persistentVolumeClaimCounter)},
+	} {
+		if guardProfileCounts(*guardProfile, c.kind) {
+			counters = append(counters, c)
+		}
+	}
+	if _, ok := kindThresholds["configmaps"]; ok {
+		counters = append(counters, resourceCounter{"configmaps", clientCounter(client, configMapCounter)})
+	}
+	counters = append(counters, optionalCounters(clients)...)
+	if *countWithTable || *maxCountedObjects > 0 {
+		replacements := streamedCounters(client)
+		if *countWithTable {
+			replacements = tableCounters(client)
+		}
+		for i, c := range counters {
+			if counter, ok := replacements[c.kind]; ok {
+				counters[i].counter = counter
+			}
+		}
+	}
+	return counters
+}
+
+// resourceFinding holds the objects of a single resource kind found within a namespace
+type resourceFinding struct {
+	Kind  string   `json:"kind"`
+	Count int      `json:"count"`
+	Names []string `json:"names,omitempty"`
+	// External is true for resources outside of the namespace referencing it
+	External bool `json:"external,omitempty"`
+	// Serving are the names of the services with ready endpoints
+	Serving []string `json:"serving,omitempty"`
+	// Exceeded is true if the kind held more than the --maxCountedObjects counted
+	Exceeded bool `json:"exceeded,omitempty"`
+}
+
+// counterError is the error returned by the counter of a resource kind
+type counterError struct {
+	kind string
+	err  error
+}
 
-	counters := []struct {
-		kind    string
-		counter func(namespace string) (int, error)
-	}{
-		{"pods", podCounter},
-		{"services", serviceCounter},
-		{"replicasets", replicasetCounter},
-		{"deployments", deploymentCounter},
-		{"statefulsets", statefulsetCounter},
-		{"daemonsets", daemonsetCounter},
-		{"ingresses", ingressCounter},
-		{"horizontalpodautoscalers", autoScaleCounter},
+func (e counterError) Error() string {
+	return fmt.Sprintf("error listing %s, %v", e.kind, e.err)
+}
+
+// isTransientError returns true if the error is likely to go away on retry, i.e. it is not caused
+// by the request itself or by missing permissions
+func isTransientError(err error) bool {
+	if e, ok := err.(counterError); ok {
+		err = e.err
+	}
+	status, ok := err.(apiErrors.APIStatus)
+	if !ok {
+		// connection errors and the like never reached the apiserver
+		return true
 	}
+	return apiErrors.IsTimeout(err) ||
+		apiErrors.IsServerTimeout(err) ||
+		apiErrors.IsTooManyRequests(err) ||
+		apiErrors.IsInternalError(err) ||
+		status.Status().Reason == v1.StatusReasonServiceUnavailable
+}
 
+// uncertainKinds returns the kinds whose counters failed with transient errors only, or nil if any
+// of the errors is not transient
+func uncertainKinds(errList []error) []string {
+	var kinds []string
+	for _, err := range errList {
+		e, ok := err.(counterError)
+		if !ok || !isTransientError(e) {
+			return nil
+		}
+		kinds = append(kinds, e.kind)
+	}
+	return kinds
+}
+
+// findNamespaceResources runs every resource counter against the namespace and returns the findings
+// for each kind along with the errors of the counters that failed
+func findNamespaceResources(namespace string) ([]resourceFinding, []error) {
+	return findResources(namespace, resourceCounters())
+}
+
+// countKind runs the counter of a single kind against the namespace
+func countKind(namespace string, c resourceCounter) (resourceFinding, error) {
+	names, err := c.counter(namespace)
+	if err != nil {
+		return resourceFinding{}, counterError{kind: c.kind, err: err}
+	}
+	finding := applyCountBudget(resourceFinding{Kind: c.kind, Count: len(names), Names: names})
+	if c.kind == "services" && len(finding.Names) > 0 {
+		finding.Serving = servingServices(namespace, finding.Names)
+	}
+	return finding, nil
+}
+
+// findResources runs the given resource counters against the namespace
+func findResources(namespace string, counters []resourceCounter) ([]resourceFinding, []error) {
+	var findings []resourceFinding
 	var errList []error
-	var nonEmptyList []string
 
-	for _, c := range counters {
-		num, err := c.counter(namespace)
+	if *countParallelism > 1 {
+		findings, errList = countInParallel(namespace, counters, *countParallelism)
+	} else {
+		for _, c := range counters {
+			if countBudgetExceeded(findings) {
+				log.Warnf("Namespace %s exceeds the budget of counted objects, not listing %s.", namespace, c.kind)
+				continue
+			}
+			finding, err := countKind(namespace, c)
+			if err != nil {
+				errList = append(errList, err)
+				continue
+			}
+			findings = append(findings, finding)
+		}
+	}
+	var externalCounters []resourceCounter
+	if *checkClusterScopedResources {
+		externalCounters = append(externalCounters, clusterScopedCounters()...)
+	}
+	if *guardHelmDependencies {
+		externalCounters = append(externalCounters, resourceCounter{helmReleasesKind, helmReleaseCounter})
+	}
+	if *guardConsulServices {
+		externalCounters = append(externalCounters, resourceCounter{consulServicesKind, consulServiceCounter})
+	}
+	for _, c := range externalCounters {
+		names, err := c.counter(namespace)
 		if err != nil {
-			errList = append(errList, fmt.Errorf("error listing %s, %v", c.kind, err))
+			errList = append(errList, counterError{kind: c.kind, err: err})
 			continue
 		}
-		if num > 0 {
-			nonEmptyList = append(nonEmptyList, fmt.Sprintf("%s(%d)", c.kind, num))
+		findings = append(findings, resourceFinding{Kind: c.kind, Count: len(names), Names: names, External: true})
+	}
+	if *shadowCompare {
+		compareShadowCounts(namespace, findings)
+	}
+	return findings, errList
+}
+
+// validateNamespaceDeletion returns an error if the namespace contains any workload resources
+func validateNamespaceDeletion(namespace string) (err error) {
+	findings, errList := findNamespaceResources(namespace)
+	return deletionError(namespace, findings, errList, *maxResourceCount)
+}
+
+// totalResourceCount returns the number of namespace-scoped resources found across all kinds
+func totalResourceCount(findings []resourceFinding) int {
+	total := 0
+	for _, f := range findings {
+		if !f.External {
+			total += f.Count
+		}
+	}
+	return total
+}
+
+// policyViolated returns true if the findings alone forbid the deletion, i.e. the risk score of the
+// namespace resources exceeds maxCount, or --maxImpactScore when set, or the namespace is referenced by cluster-scoped resources
+func policyViolated(findings []resourceFinding, maxCount int) bool {
+	for _, f := range findings {
+		if f.External && f.Count > 0 {
+			return true
 		}
 	}
+	return countBudgetExceeded(findings) || len(thresholdsExceeded(findings)) > 0 || riskScore(findings) > scoreLimit(maxCount)
+}
+
+// deletionError builds the rejection error for the findings of a namespace, or returns nil if the risk
+// score of its workload resources is no more than maxCount, it is not referenced by any cluster-scoped
+// resource and every counter succeeded
+func deletionError(namespace string, findings []resourceFinding, errList []error, maxCount int) error {
+	limit := scoreLimit(maxCount)
+	var nonEmptyList, externalList, servingList []string
+	for _, f := range findings {
+		servingList = append(servingList, f.Serving...)
+		switch {
+		case f.Count > 0 && f.External:
+			externalList = append(externalList, fmt.Sprintf("%s%v", f.Kind, f.Names))
+		case f.Exceeded:
+			nonEmptyList = append(nonEmptyList, fmt.Sprintf("%s(exceeds %d)", f.Kind, f.Count))
+		case tolerated(f):
+		case f.Count > 0:
+			nonEmptyList = append(nonEmptyList, fmt.Sprintf("%s(%d)", f.Kind, f.Count))
+		}
+	}
+
+	nonEmptyList = capReportedKinds(nonEmptyList, *maxReportedKinds)
+	externalList = capReportedKinds(externalList, *maxReportedKinds)
 
 	errStr := ""
-	if len(nonEmptyList) > 0 {
+	if countBudgetExceeded(findings) {
+		errStr += fmt.Sprintf("The namespace %s you are trying to remove is far too large to delete without cleanup, it holds %v", namespace, nonEmptyList)
+		if *maxTotalCountedObjects > 0 && totalResourceCount(findings) > *maxTotalCountedObjects {
+			errStr += fmt.Sprintf(", more than the %d objects counted at most", *maxTotalCountedObjects)
+		}
+		errStr += ". Please delete its resources and try again."
+	} else if score, exceeded := riskScore(findings), thresholdsExceeded(findings); score > limit || len(exceeded) > 0 {
+		if len(servingList) > 0 {
+			errStr += fmt.Sprintf("DANGER: The services %v in the namespace %s have ready endpoints and are actively serving traffic. ", servingList, namespace)
+		}
 		errStr += fmt.Sprintf("The namespace %s you are trying to remove contains one or more of these resources: %v. Please delete them and try again.", namespace, nonEmptyList)
+		if len(exceeded) > 0 {
+			errStr += fmt.Sprintf(" At most %v are tolerated.", exceeded)
+		}
+		if score > limit && *maxImpactScore > 0 {
+			errStr += fmt.Sprintf(" Their impact score is %d while at most %d is allowed: %v.", score, limit, impactContributions(findings))
+		} else if score > maxCount && len(kindWeights) > 0 {
+			errStr += fmt.Sprintf(" Their risk score is %d while at most %d is allowed.", score, maxCount)
+		} else if score > maxCount && maxCount > 0 {
+			errStr += fmt.Sprintf(" It holds %d resources while at most %d are allowed.", score, maxCount)
+		}
+	}
+	if len(externalList) > 0 {
+		errStr += fmt.Sprintf("The namespace %s you are trying to remove is referenced by these resources outside of it (external dependencies): %v. Please remove the references and try again.", namespace, externalList)
 	}
 	if len(errList) > 0 {
 		errStr += fmt.Sprintf("The following error(s) occurred while validating the DELETE operation on the namespace %s: %v.", namespace, errList)
 	}
 	if errStr != "" {
-		errStr += fmt.Sprintf(" WARNING: If you know what you are doing, run `kubectl annotate namespace %s %s=true` to bypass this policy check.", namespace, bypassAnnotationKey)
+		errStr += fmt.Sprintf(bypassHint+", run `%s` to bypass this policy check.", bypassCommand(namespace, bypassValue(findings)))
 		return errors.New(errStr)
 	}
 	return nil
 }
 
-// webhookHandler handles the namespace deletion guard admission webhook
+// capReportedKinds truncates the reported kinds to at most max entries, summarizing the rest as
+// "and N more". A max of 0 reports all kinds.
+func capReportedKinds(kinds []string, max int) []string {
+	if max <= 0 || len(kinds) <= max {
+		return kinds
+	}
+	return append(kinds[:max:max], fmt.Sprintf("and %d more", len(kinds)-max))
+}
+
+// capMessage truncates the message to at most max bytes, replacing the end of the reasons with an
+// ellipsis but keeping the bypass hint and what follows it. A max of 0 keeps the whole message.
+func capMessage(message string, max int) string {
+	if max <= 0 || len(message) <= max {
+		return message
+	}
+	head, tail := message, ""
+	if i := strings.LastIndex(message, bypassHint); i >= 0 && len(message[i:])+len(messageEllipsis) < max {
+		head, tail = message[:i], message[i:]
+	}
+	n := max - len(tail) - len(messageEllipsis)
+	if n < 0 {
+		n = 0
+	}
+	// cut at the start of a rune, not to leave an invalid UTF-8 sequence
+	for n > 0 && !utf8.RuneStart(head[n]) {
+		n--
+	}
+	return head[:n] + messageEllipsis + tail
+}
+
+// bypassCommand returns the kubectl command setting the bypass annotation on the namespace, passing
+// the --clusterName as kubectl context if set
+func bypassCommand(namespace, value string) string {
+	context := ""
+	if *clusterName != "" {
+		context = fmt.Sprintf(" --context %s", *clusterName)
+	}
+	// a stale fingerprint is replaced
+	overwrite := ""
+	if isFingerprint(value) {
+		overwrite = " --overwrite"
+	}
+	return fmt.Sprintf("kubectl%s annotate%s namespace %s %s=%s", context, overwrite, namespace, *bypassKey, value)
+}
+
+// hasBypassAnnotation returns true if the namespace annotations allow a cascading delete, the content
+// fingerprints being checked along with the contents
+func hasBypassAnnotation(annotations map[string]string) bool {
+	value, ok := annotations[*bypassKey]
+	return ok && (bypassValueRegex.MatchString(value) || isFingerprint(value))
+}
+
+// parseBypassPattern compiles the --bypassAnnotationPattern
+func parseBypassPattern(pattern string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid regular expression: %v", pattern, err)
+	}
+	return regex, nil
+}
+
+// decodedBody returns the request body decompressed according to its Content-Encoding, e.g. gzip
+// compressed by a reverse proxy. The returned bool is false if the encoding is not supported.
+func decodedBody(req *http.Request) (io.Reader, bool, error) {
+	switch strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return req.Body, true, nil
+	case "gzip":
+		body, err := gzip.NewReader(req.Body)
+		return body, true, err
+	}
+	return nil, false, nil
+}
+
+// webhookHandler handles the namespace deletion guard admission webhook on the "/" path with the
+// default profile built from the command line flags
 func webhookHandler(rw http.ResponseWriter, req *http.Request) {
-	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+	newValidator("/", defaultProfile()).ServeHTTP(rw, req)
+}
+
+// validator handles the namespace deletion guard admission webhook for a single policy profile
+type validator struct {
+	path    string
+	profile policyProfile
+	// dryRun is true to decide the deletions without reserving them in the deletion quota
+	dryRun bool
+	// offline is true to decide the deletions without waiting for the --confirmIdle window nor calling
+	// the --preDeleteHook, e.g. for the inventory of every namespace
+	offline bool
+}
+
+// newValidator returns a validator serving the profile on the given path
+func newValidator(path string, profile policyProfile) *validator {
+	return &validator{path: path, profile: profile}
+}
+
+// respond writes the admission response and records it against the validator profile
+func (v *validator) respond(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview, decision admissionDecision) {
+	if w, ok := rw.(*deadlineWriter); ok && w.expired() {
+		log.Warnf("Discarding the late decision Allowed: %t for %s on Namespace: %s, the timeout fallback was already sent.", decision.allowed, admReview.Spec.Operation, admReview.Spec.Name)
+		return
+	}
+	admissionResponsesTotal.WithLabelValues(v.profile.Name, strconv.FormatBool(decision.allowed)).Inc()
+	admissionRequests.Add(1)
+	if !decision.allowed {
+		admissionRejections.Add(1)
+	}
+	recordAdmission(admReview, decision.allowed)
+	publishDecision(admReview, v.profile.Name, decision)
+	start := time.Now()
+	writeResponse(rw, admReview, decision)
+	phases := requestPhases(rw)
+	phases.observe(phaseWrite, start)
+	recordDecision(admReview, decision, requestStart(rw), phases)
+}
+
+// observeRequestDuration records the time taken to handle the request since the start in the latency
+// histogram, counting and logging an SLO violation beyond --sloThresholdMs
+func observeRequestDuration(req *http.Request, start time.Time) {
+	elapsed := time.Since(start)
+	exceeded := *sloThresholdMs > 0 && elapsed > time.Duration(*sloThresholdMs)*time.Millisecond
+	webhookRequestDurationSeconds.WithLabelValues(strconv.FormatBool(exceeded)).Observe(elapsed.Seconds())
+	if exceeded {
+		sloViolationsTotal.Inc()
+		log.Warnf("SLO violation: the %s %s request for client: %s took %v, more than the %dms threshold.", req.Method, req.URL.Path, req.RemoteAddr, elapsed, *sloThresholdMs)
+	}
+}
+
+func (v *validator) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	defer observeRequestDuration(req, start)
+	phases := newPhaseTimer()
+	rw = &timedWriter{ResponseWriter: rw, start: start, phases: phases}
+	log.Infof("Serving %s %s request for client: %s with profile: %s", req.Method, req.URL.Path, req.RemoteAddr, v.profile.Name)
 
 	if req.Method != http.MethodPost {
 		http.Error(rw, fmt.Sprintf("Incoming request method %s is not supported, only POST is supported", req.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
-	if req.URL.Path != "/" {
+	if req.URL.Path != v.path {
 		http.Error(rw, fmt.Sprintf("%s 404 Not Found", req.URL.Path), http.StatusNotFound)
 		return
 	}
 
+	decodeStart := time.Now()
+	body, supported, err := decodedBody(req)
+	if !supported {
+		http.Error(rw, fmt.Sprintf("Unsupported Content-Encoding %s, only gzip is supported", req.Header.Get("Content-Encoding")), http.StatusUnsupportedMediaType)
+		return
+	}
+
 	admReview := v1alpha1.AdmissionReview{}
-	err := json.NewDecoder(req.Body).Decode(&admReview)
+	if err == nil {
+		err = json.NewDecoder(body).Decode(&admReview)
+	}
+	phases.observe(phaseDecode, decodeStart)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to decode the request body json into an AdmissionReview resource: %s", err.Error())
-		writeResponse(rw, &v1alpha1.AdmissionReview{}, false, errorMsg)
+		v.respond(rw, &v1alpha1.AdmissionReview{}, badRequest(errorMsg))
 		return
 	}
 	log.Debugf("Incoming AdmissionReview for %s on resource: %v, kind: %v", admReview.Spec.Operation, admReview.Spec.Resource, admReview.Spec.Kind)
 
-	if *admitAll == true {
-		log.Warnf("admitAll flag is set to true. Allowing Namespace admission review request to pass without validation.")
-		writeResponse(rw, &admReview, true, "")
+	if *validationTimeout > 0 {
+		v.reviewWithDeadline(rw, req, admReview)
+		return
+	}
+	v.review(rw, admReview)
+}
+
+// review validates the admission review and writes the decision
+func (v *validator) review(rw http.ResponseWriter, admReview v1alpha1.AdmissionReview) {
+
+	if *admitSystemControllers && systemControllerUsers[admReview.Spec.UserInfo.Username] {
+		log.Infof("Request by system controller %s. Allowing %s on %s %s without validation.", admReview.Spec.UserInfo.Username, admReview.Spec.Operation, admReview.Spec.Resource.Resource, admReview.Spec.Name)
+		recordExemption(admReview.Spec.Name, string(admReview.Spec.Operation), admReview.Spec.UserInfo, exemptionSystemController, exemptionMatchUser, admReview.Spec.UserInfo.Username)
+		v.respond(rw, &admReview, allow(""))
+		return
+	}
+
+	if v.profile.Mode == admitAllMode {
+		log.Warnf("Profile %s is in %s mode. Allowing Namespace admission review request to pass without validation.", v.profile.Name, admitAllMode)
+		v.respond(rw, &admReview, allow(""))
 		return
 	}
 
 	if admReview.Spec.Resource != namespaceResourceType {
 		errorMsg := fmt.Sprintf("Incoming resource is not a Namespace: %v", admReview.Spec.Resource)
-		writeResponse(rw, &admReview, false, errorMsg)
+		v.respond(rw, &admReview, badRequest(errorMsg))
+		return
+	}
+
+	if (*forceDeleteEnabled || *authorizeBypass) && (admReview.Spec.Operation == v1alpha1.Create || admReview.Spec.Operation == v1alpha1.Update) {
+		v.validateAnnotationChanges(rw, &admReview)
 		return
 	}
 
 	if admReview.Spec.Operation != v1alpha1.Delete {
+		// CONNECT and other operations on namespaces are not the webhook's concern, even with
+		// --nonDeleteAction=deny
+		if admReview.Spec.Operation != v1alpha1.Create && admReview.Spec.Operation != v1alpha1.Update {
+			log.Infof("Incoming operation is %v on namespace %s. Allowing it, only DELETE is validated.", admReview.Spec.Operation, admReview.Spec.Name)
+			v.respond(rw, &admReview, allow(""))
+			return
+		}
+		if *nonDeleteAction == nonDeleteAllow {
+			log.Warnf("Incoming operation is %v on namespace %s. Allowing it, the webhook should only be registered for DELETE.", admReview.Spec.Operation, admReview.Spec.Name)
+			v.respond(rw, &admReview, allow(""))
+			return
+		}
 		errorMsg := fmt.Sprintf("Incoming operation is %v on namespace %s. Only DELETE is currently supported.", admReview.Spec.Operation, admReview.Spec.Name)
-		writeResponse(rw, &admReview, false, errorMsg)
+		v.respond(rw, &admReview, badRequest(errorMsg))
 		return
 	}
 
+	if by := impersonator(admReview.Spec.UserInfo); by != "" {
+		log.Infof("The DELETE of namespace %s by %s is impersonated by %s.", admReview.Spec.Name, admReview.Spec.UserInfo.Username, by)
+		if !impersonationAllowed(by) {
+			errorMsg := fmt.Sprintf("Impersonated namespace deletions are not allowed: %s may not delete the namespace %s as %s. Please delete it under your own identity.", by, admReview.Spec.Name, admReview.Spec.UserInfo.Username)
+			v.rejectDeletion(rw, &admReview, deny(errorMsg), nil)
+			return
+		}
+	}
+
+	if breaker != nil && !breaker.allow() {
+		log.Errorf("The circuit breaker is open, responding to the DELETE of namespace %s without calling the apiserver.", admReview.Spec.Name)
+		circuitBreakerShortCircuitsTotal.Inc()
+		v.respond(rw, &admReview, degradedDecision())
+		return
+	}
+	getStart := time.Now()
 	namespace, err := clientset.CoreV1().Namespaces().Get(admReview.Spec.Name, v1.GetOptions{})
+	requestPhases(rw).observe(phaseGet, getStart)
+	if breaker != nil {
+		breaker.record(err)
+	}
+	if failsafe != nil {
+		failsafe.observe(err)
+		if err != nil && failsafe.active() {
+			log.Errorf("Failsafe engaged, the apiserver is unreachable: %s. Allowing the DELETE of namespace %s without validation.", err.Error(), admReview.Spec.Name)
+			v.respond(rw, &admReview, allow(""))
+			return
+		}
+	}
 	if err != nil {
 		// If the namespace is not found, approve the request and let apiserver handle the case
 		// For any other error, reject the request
 		if apiErrors.IsNotFound(err) {
 			log.Debugf("Namespace %s not found, let apiserver handle the error: %s", admReview.Spec.Name, err.Error())
-			writeResponse(rw, &admReview, true, "")
+			v.respond(rw, &admReview, allow(""))
 		} else {
 			errorMsg := fmt.Sprintf("Error occurred while retrieving the namespace %s: %s", admReview.Spec.Name, err.Error())
-			writeResponse(rw, &admReview, false, errorMsg)
+			v.respond(rw, &admReview, errorDecision(admReview.Spec.Name, errorMsg))
 		}
 		return
 	}
 
-	if annotations := namespace.GetAnnotations(); annotations != nil {
-		if annotations[bypassAnnotationKey] == "true" {
-			log.Infof("Namespace %s has the bypass annotation set[%s:true]. OK to DELETE.", admReview.Spec.Name, bypassAnnotationKey)
-			writeResponse(rw, &admReview, true, "")
-			return
+	v.apply(rw, &admReview, namespace, v.decide(namespace, admReview.Spec.UserInfo, requestPhases(rw)))
+}
+
+// deletionOutcome is the decision on the DELETE of a namespace along with how it was reached. It is
+// decided without writing any record, so that the webhook applies it while /explain and the Check RPC
+// only report it.
+type deletionOutcome struct {
+	decision admissionDecision
+	// attempt is false for the deletions let through before they are validated, e.g. by the controller
+	// owning the namespace, which are not recorded as deletion attempts
+	attempt bool
+	// reason describes why the deletion is allowed
+	reason string
+	// bypass is the bypass allowing the deletion, if any
+	bypass string
+	// snapshot is true if the deletion snapshot is recorded, the resources being listed if not counted
+	snapshot bool
+	// counted is true once the resources of the namespace are counted
+	counted  bool
+	findings []resourceFinding
+	errList  []error
+	// exceptions are the GuardExceptions waiving checks of the namespace
+	exceptions []string
+	// bucket is the enforcement bucket of a deletion violating the resource policy
+	bucket string
+	// quotaReservation is the time of the deletion reserved in the user's deletion quota, if any
+	quotaReservation time.Time
+	// records are the audit records, metrics and notifications written once the decision is applied
+	records []func()
+}
+
+// record adds a record written once the decision is applied by the webhook
+func (o *deletionOutcome) record(f func()) {
+	o.records = append(o.records, f)
+}
+
+// allowed returns the outcome allowing the validated deletion for the reason, with an optional warning
+func (o deletionOutcome) allowed(reason, warning string) deletionOutcome {
+	o.decision, o.reason, o.attempt = allow(warning), reason, true
+	return o
+}
+
+// rejected returns the outcome rejecting the validated deletion with the decision
+func (o deletionOutcome) rejected(decision admissionDecision) deletionOutcome {
+	o.decision, o.attempt = decision, true
+	return o
+}
+
+// failed returns the outcome of a deletion that could not be validated, as per the error policy of
+// the namespace
+func (o deletionOutcome) failed(namespace, message string) deletionOutcome {
+	o.decision, o.attempt = errorDecision(namespace, message), true
+	return o
+}
+
+// decide validates the DELETE of the namespace by the user with the profile of the validator
+func (v *validator) decide(namespace *corev1.Namespace, userInfo authenticationv1.UserInfo, phases *phaseTimer) (o deletionOutcome) {
+	name, user := namespace.Name, userInfo.Username
+
+	// the namespaces owned by a controller are only deleted by it, neither the bypass nor the
+	// resource checks apply to them
+	if ownerMarkerConfigured() {
+		if owner := namespaceOwner(namespace); owner != "" && isOwnerController(user) {
+			o.decision, o.reason = allow(""), fmt.Sprintf("Namespace %s is managed by the %s and deleted by its controller %s.", name, owner, user)
+			o.record(func() {
+				recordExemption(name, string(v1alpha1.Delete), userInfo, exemptionOwnerController, exemptionMatchUser, user)
+			})
+			return o
+		}
+		if err := ownerDeletionError(namespace, user); err != nil {
+			return o.rejected(deny(err.Error()))
+		}
+	}
+
+	// only the deleters bound in the namespace may delete it, whatever the bypass
+	if namespaceDeleters != nil {
+		if group := breakGlassGroup(userInfo); group != "" {
+			o.record(func() {
+				log.Warnf("The DELETE of namespace %s by %s is let through by the break-glass group %s, whatever the RoleBindings of the namespace.", name, user, group)
+				recordExemption(name, string(v1alpha1.Delete), userInfo, exemptionBreakGlass, exemptionMatchGroup, group)
+			})
+		} else {
+			allowed, err := namespaceDeleters.allowed(name, userInfo)
+			if err != nil {
+				return o.failed(name, fmt.Sprintf("Error occurred while listing the RoleBindings of the namespace %s: %s", name, err.Error()))
+			}
+			if !allowed {
+				errorMsg := fmt.Sprintf("Only the users and groups bound to the ClusterRole %s by the RoleBindings of the namespace %s may delete it, %s is not one of them. Please ask one of its owners to delete it.", *deleterClusterRole, name, user)
+				return o.rejected(deny(errorMsg))
+			}
+		}
+	}
+
+	if userQuota != nil && userQuota.exempted(user) {
+		o.record(func() {
+			recordExemption(name, string(v1alpha1.Delete), userInfo, exemptionQuota, exemptionMatchUser, user)
+		})
+	} else if userQuota != nil {
+		// the deletion is reserved before the resources are listed so that parallel deletions may not
+		// exceed the quota, the reservation being released if the deletion is denied
+		var err error
+		if v.dryRun {
+			err = userQuota.exceeded(user)
+		} else {
+			o.quotaReservation, err = userQuota.reserve(user)
 		}
+		if err != nil {
+			errorMsg := fmt.Sprintf("Deletion quota exceeded: %s. Please contact the cluster administrators to delete the namespace %s.", err.Error(), name)
+			return o.rejected(deny(errorMsg))
+		}
+	}
+
+	if deleteTokenKey != nil {
+		if err := validateDeleteToken(deleteTokenKey, namespace, time.Now()); err != nil {
+			errorMsg := fmt.Sprintf("The deletion of namespace %s requires a valid delete token: %s. Please ask an approver to issue a token with POST /deletetoken?namespace=%s and set it with `kubectl annotate --overwrite namespace %s %s=<token>`.",
+				name, err.Error(), name, name, deleteTokenAnnotationKey)
+			return o.rejected(deny(errorMsg))
+		}
+	}
+
+	// a fingerprinted bypass is only honored once the contents are counted
+	bypassed := v.profile.bypassAllowed() && hasBypassAnnotation(namespace.GetAnnotations())
+	fingerprinted := bypassed && fingerprintedBypass(namespace.GetAnnotations())
+	if bypassed && !fingerprinted {
+		o.bypass, o.snapshot = bypassAnnotation, true
+		return o.allowed(fmt.Sprintf("Namespace %s has the bypass annotation set[%s:true].", name, *bypassKey), "")
+	}
+
+	if *forceDeleteEnabled && v.profile.bypassAllowed() && hasForceDeleteApproval(namespace.GetAnnotations()) {
+		o.bypass, o.snapshot = bypassForceDelete, true
+		return o.allowed(fmt.Sprintf("Namespace %s force delete requested by %s and approved by %s.", name, namespace.Annotations[forceDeleteRequesterKey], namespace.Annotations[forceDeleteApproverKey]), "")
 	}
 
-	err = validateNamespaceDeletion(admReview.Spec.Name)
+	// the GuardExceptions are honored along with the bypass annotation, which takes precedence
+	var waiver exceptionWaiver
+	if guardExceptions != nil && v.profile.bypassAllowed() {
+		waiver = guardExceptions.waiver(namespace)
+		o.exceptions = waiver.exceptions
+		if waiver.all {
+			o.bypass, o.snapshot = bypassException, true
+			return o.allowed(fmt.Sprintf("Namespace %s is exempted from the checks by the GuardExceptions %v.", name, waiver.exceptions), "")
+		}
+		if exceptions := waiver.exceptions; len(exceptions) > 0 {
+			o.record(func() { log.Infof("Namespace %s has checks waived by the GuardExceptions %v.", name, exceptions) })
+		}
+	}
+
+	counters := waiver.counters(v.profile.counters())
+	if *scopeToRequester {
+		counters = requesterCounters(counters, name, userInfo)
+	}
+	if impersonationConfig != nil {
+		var err error
+		counters, err = impersonatedCounters(impersonationConfig, counters, userInfo)
+		if err != nil {
+			return o.failed(name, fmt.Sprintf("Error occurred while impersonating the user %s: %s", user, err.Error()))
+		}
+	}
+	countStart := time.Now()
+	o.findings, o.errList = findResources(name, counters)
+	o.counted = true
+	if phases != nil {
+		phases.observe(phaseCount, countStart)
+	}
+	staleBypass := ""
+	if fingerprinted {
+		fingerprint := contentFingerprint(o.findings)
+		if fingerprintMatches(namespace.GetAnnotations(), o.findings, o.errList) {
+			o.bypass, o.snapshot = bypassAnnotation, true
+			return o.allowed(fmt.Sprintf("Namespace %s has the bypass annotation set with the fingerprint %s of its contents.", name, fingerprint), "")
+		}
+		o.record(func() {
+			log.Infof("The bypass annotation %s=%s of namespace %s does not match the fingerprint %s of its contents.", *bypassKey, namespace.Annotations[*bypassKey], name, fingerprint)
+		})
+		staleBypass = staleBypassMessage(name, o.findings)
+	}
+	if *allowOnTransientErrors && len(o.errList) > 0 && totalResourceCount(o.findings) == 0 {
+		if kinds := uncertainKinds(o.errList); kinds != nil {
+			warning := fmt.Sprintf("No resources were found in the namespace %s, but the following kinds could not be verified due to transient errors: %v.", name, kinds)
+			o.snapshot = true
+			return o.allowed("", warning)
+		}
+	}
+	err := deletionError(name, o.findings, o.errList, v.profile.MaxResourceCount)
+	if err != nil && staleBypass != "" {
+		err = errors.New(staleBypass + err.Error())
+	}
 	if err != nil {
-		writeResponse(rw, &admReview, false, err.Error())
+		o.bucket = enforcementBucket(namespace, *enforcementPercent)
+		if len(o.errList) > 0 {
+			// a deletion that could not be validated is never let through as warn-only
+			o.bucket = enforceBucket
+		}
+		bucket := o.bucket
+		o.record(func() {
+			enforcementDecisionsTotal.WithLabelValues(bucket).Inc()
+			if bucket == warnBucket {
+				log.Warnf("Namespace %s is in the %s enforcement bucket. Allowing the DELETE that would have been rejected.", name, bucket)
+				return
+			}
+			log.Infof("Namespace %s is in the %s enforcement bucket.", name, bucket)
+		})
+		if bucket == warnBucket {
+			return o.allowed("", "Warn-only, this deletion will be rejected once enforced: "+err.Error())
+		}
+		errorMsg := err.Error()
+		violated := policyViolated(o.findings, v.profile.MaxResourceCount)
+		if *requireContentAuthz && violated {
+			errorMsg = contentAuthzMessage(name, o.findings, userInfo, errorMsg)
+		}
+		o.record(func() { notifyDeletionDenied(name, user, errorMsg) })
+		if !violated {
+			// only the counters failed, the namespace could not be validated
+			return o.failed(name, errorMsg)
+		}
+		return o.rejected(deny(errorMsg))
+	}
+	if len(celRules) > 0 && !waiver.waives(waivedRules) {
+		variables, err := celVariables(namespace, userInfo, v.profile.Name, o.findings)
+		if err == nil {
+			var denials []string
+			denials, err = evaluateCELRules(celRules, variables)
+			if len(denials) > 0 {
+				errorMsg := fmt.Sprintf("The deletion of the namespace %s is denied by the rules: %s.", name, strings.Join(denials, "; "))
+				o.record(func() { notifyDeletionDenied(name, user, errorMsg) })
+				return o.rejected(deny(errorMsg))
+			}
+		}
+		if err != nil {
+			return o.failed(name, fmt.Sprintf("Error occurred while evaluating the rules for the namespace %s: %s", name, err.Error()))
+		}
+	}
+
+	if policies != nil && !waiver.waives(waivedRegoPolicy) {
+		messages, err := policies.evaluate(regoInput{Namespace: namespace, Findings: o.findings, UserInfo: userInfo, Profile: v.profile.Name})
+		if err != nil {
+			return o.failed(name, fmt.Sprintf("Error occurred while evaluating the Rego policies for the namespace %s: %s", name, err.Error()))
+		}
+		if len(messages) > 0 {
+			errorMsg := fmt.Sprintf("The deletion of the namespace %s is denied by the policies: %s.", name, strings.Join(messages, "; "))
+			o.record(func() { notifyDeletionDenied(name, user, errorMsg) })
+			return o.rejected(deny(errorMsg))
+		}
+	}
+
+	if *confirmIdle > 0 && !v.offline && !waiver.waives(waivedConfirmIdle) {
+		if err := confirmNamespaceIdle(name, *confirmIdle); err != nil {
+			return o.rejected(deny(fmt.Sprintf("Unable to confirm the namespace %s is idle: %s. Please stop the controllers creating resources in it and try again.", name, err.Error())))
+		}
+	}
+
+	if *preDeleteHook != "" && !v.offline && !waiver.waives(waivedPreDeleteHook) {
+		if err := callPreDeleteHook(*preDeleteHook, name, userInfo); err != nil {
+			return o.rejected(deny(fmt.Sprintf("The deletion of the namespace %s was not approved by the pre-delete hook: %s", name, err.Error())))
+		}
+	}
+
+	o.snapshot = true
+	return o.allowed(fmt.Sprintf("Namespace %s does not contain any workload resources.", name), "")
+}
+
+// apply writes the records of the outcome of the DELETE of the namespace and responds with its decision
+func (v *validator) apply(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview, namespace *corev1.Namespace, o deletionOutcome) {
+	for _, record := range o.records {
+		record()
+	}
+	if userQuota != nil && !o.quotaReservation.IsZero() {
+		if o.decision.allowed {
+			userQuota.commit()
+		} else {
+			userQuota.release(admReview.Spec.UserInfo.Username, o.quotaReservation)
+		}
+	}
+	if o.decision.allowed && o.bypass == bypassForceDelete {
+		log.Warnf("%s OK to DELETE.", o.reason)
+	} else if o.decision.allowed && o.reason != "" {
+		log.Infof("%s OK to DELETE.", o.reason)
+	}
+	if !o.attempt {
+		v.respond(rw, admReview, o.decision)
+		return
+	}
+	if !o.decision.allowed {
+		v.rejectDeletion(rw, admReview, o.decision, o.findings)
 		return
 	}
+	user := admReview.Spec.UserInfo.Username
+	if o.bypass == bypassAnnotation {
+		recordBypass(namespace, user)
+	}
+	if o.snapshot {
+		// the resources are listed for the snapshot if the deletion was allowed before counting them
+		var counters []resourceCounter
+		if !o.counted {
+			counters = resourceCounters()
+		}
+		recordDeletionSnapshot(namespace, user, o.bypass, o.findings, o.errList, counters)
+	}
+	v.allowDeletion(rw, admReview, o.bypass, o.decision.message, o.findings)
+}
 
-	log.Infof("Namespace %s does not contain any workload resources. OK to DELETE.", admReview.Spec.Name)
-	writeResponse(rw, &admReview, true, "")
+// allowDeletion admits a validated namespace deletion, recording it with the bypass used and the
+// resources found, if any. The warning reports the PersistentVolumes reclaimed with the namespace with
+// --reportVolumeReclaim.
+func (v *validator) allowDeletion(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview, bypass, warning string, findings []resourceFinding) {
+	recordDeletionAttempt(admReview.Spec.Name, admReview.Spec.UserInfo.Username, v.profile.Name, true, findings)
+	warning = joinWarnings(warning, volumeReclaimWarning(admReview.Spec.Name))
+	if attempts != nil {
+		attempts.record(admReview.Spec.Name, admReview.Spec.UserInfo.Username, true, warning, bypass)
+	}
+	v.respond(rw, admReview, allow(warning))
+}
+
+// rejectDeletion rejects a namespace deletion, recording it with the resources found, if any
+func (v *validator) rejectDeletion(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview, decision admissionDecision, findings []resourceFinding) {
+	recordDeletionAttempt(admReview.Spec.Name, admReview.Spec.UserInfo.Username, v.profile.Name, false, findings)
+	if attempts != nil {
+		attempts.record(admReview.Spec.Name, admReview.Spec.UserInfo.Username, false, decision.message, "")
+	}
+	if decision.reason == v1.StatusReasonForbidden {
+		decision.message += appealHint(admReview.Spec.Name)
+	}
+	decision.blocking = blockingResources(findings)
+	v.respond(rw, admReview, decision)
+}
+
+// validateAnnotationChanges admits a namespace CREATE or UPDATE unless it changes the force delete
+// annotations in a way not allowed for the requesting user, or with --authorizeBypass, sets the bypass
+// annotation or the warn enforcement label on UPDATE without the requesting user being authorized to
+func (v *validator) validateAnnotationChanges(rw http.ResponseWriter, admReview *v1alpha1.AdmissionReview) {
+	oldNamespace, err := decodeNamespace(admReview.Spec.OldObject)
+	if err != nil {
+		v.respond(rw, admReview, badRequest(fmt.Sprintf("Failed to decode the old namespace %s: %s", admReview.Spec.Name, err.Error())))
+		return
+	}
+	newNamespace, err := decodeNamespace(admReview.Spec.Object)
+	if err != nil {
+		v.respond(rw, admReview, badRequest(fmt.Sprintf("Failed to decode the namespace %s: %s", admReview.Spec.Name, err.Error())))
+		return
+	}
+	oldAnnotations, newAnnotations := oldNamespace.GetAnnotations(), newNamespace.GetAnnotations()
+	if *forceDeleteEnabled {
+		err = validateForceDeleteAnnotations(oldAnnotations, newAnnotations, admReview.Spec.UserInfo.Username)
+		if err != nil {
+			v.respond(rw, admReview, deny(fmt.Sprintf("Invalid force delete annotations on namespace %s: %s", admReview.Spec.Name, err.Error())))
+			return
+		}
+	}
+	if *authorizeBypass && admReview.Spec.Operation == v1alpha1.Update {
+		allowed, err := validateBypassChange(oldAnnotations, newAnnotations, admReview.Spec.Name, admReview.Spec.UserInfo)
+		if err != nil {
+			v.respond(rw, admReview, internalError(fmt.Sprintf("Error occurred while authorizing the bypass annotation on namespace %s: %s", admReview.Spec.Name, err.Error())))
+			return
+		}
+		if !allowed {
+			v.respond(rw, admReview, deny(fmt.Sprintf("User %s is not authorized to set the bypass annotation %s on namespace %s, it requires the %s verb on namespaces in the %s API group.",
+				admReview.Spec.UserInfo.Username, *bypassKey, admReview.Spec.Name, bypassVerb, bypassGroup)))
+			return
+		}
+		allowed, err = validateEnforcementChange(oldNamespace.GetLabels(), newNamespace.GetLabels(), admReview.Spec.Name, admReview.Spec.UserInfo)
+		if err != nil {
+			v.respond(rw, admReview, internalError(fmt.Sprintf("Error occurred while authorizing the enforcement label on namespace %s: %s", admReview.Spec.Name, err.Error())))
+			return
+		}
+		if !allowed {
+			v.respond(rw, admReview, deny(fmt.Sprintf("User %s is not authorized to set the enforcement label %s=%s on namespace %s, it requires the %s verb on namespaces in the %s API group.",
+				admReview.Spec.UserInfo.Username, enforcementLabelKey, warnBucket, admReview.Spec.Name, bypassVerb, bypassGroup)))
+			return
+		}
+	}
+	v.respond(rw, admReview, allow(""))
 }
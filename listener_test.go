@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,18 +12,25 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os/user"
+	"regexp"
+	"sync"
 	"testing"
+	"time"
 
 	"k8s.io/api/admission/v1alpha1"
 	authenticationv1 "k8s.io/api/authentication/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/pkg/api"
 	corev1 "k8s.io/client-go/pkg/api/v1"
 	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
 	autoscalingv1 "k8s.io/client-go/pkg/apis/autoscaling/v1"
 	extensionsv1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	rbacv1beta1 "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+	ktesting "k8s.io/client-go/testing"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -105,16 +113,14 @@ func constructPostBody(admReview *v1alpha1.AdmissionReview) io.Reader {
 func TestAllowedWriteResponse(t *testing.T) {
 	rw := httptest.NewRecorder()
 	review := &v1alpha1.AdmissionReview{}
-	writeResponse(rw, review, true, "")
+	writeResponse(rw, review, allow(""))
 
 	admReview := getAdmissionReview(rw)
 
 	expectedAdmReview := &v1alpha1.AdmissionReview{
 		Status: v1alpha1.AdmissionReviewStatus{
 			Allowed: true,
-			Result: &v1.Status{
-				Reason: v1.StatusReason(""),
-			},
+			Result:  &v1.Status{},
 		},
 	}
 	assert.Equal(t,
@@ -123,10 +129,32 @@ func TestAllowedWriteResponse(t *testing.T) {
 		"writeResponse should write Allowed: true for AdmissionReviewStatus")
 }
 
+func TestWriteResponseContentType(t *testing.T) {
+	rw := httptest.NewRecorder()
+	writeResponse(rw, &v1alpha1.AdmissionReview{}, allow(""))
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+
+	*responseContentType = "application/json; charset=utf-8"
+	defer func() { *responseContentType = "application/json" }()
+
+	rw = httptest.NewRecorder()
+	writeResponse(rw, &v1alpha1.AdmissionReview{}, allow(""))
+	assert.Equal(t, "application/json; charset=utf-8", rw.Header().Get("Content-Type"))
+}
+
+func TestWriteResponseTypeMeta(t *testing.T) {
+	rw := httptest.NewRecorder()
+	writeResponse(rw, &v1alpha1.AdmissionReview{}, deny("denied"))
+
+	admReview := getAdmissionReview(rw)
+	assert.Equal(t, "AdmissionReview", admReview.Kind)
+	assert.Equal(t, "admission.k8s.io/v1alpha1", admReview.APIVersion)
+}
+
 func TestNotAllowedWriteResponse(t *testing.T) {
 	rw := httptest.NewRecorder()
 	review := &v1alpha1.AdmissionReview{}
-	writeResponse(rw, review, false, "Namespace test-namespace contains one or more resources")
+	writeResponse(rw, review, deny("Namespace test-namespace contains one or more resources"))
 
 	admReview := getAdmissionReview(rw)
 
@@ -134,7 +162,10 @@ func TestNotAllowedWriteResponse(t *testing.T) {
 		Status: v1alpha1.AdmissionReviewStatus{
 			Allowed: false,
 			Result: &v1.Status{
-				Reason: v1.StatusReason("Namespace test-namespace contains one or more resources"),
+				Status:  v1.StatusFailure,
+				Message: "Namespace test-namespace contains one or more resources",
+				Reason:  v1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
 			},
 		},
 	}
@@ -174,7 +205,34 @@ func TestWrongReqBodyWebhookHandler(t *testing.T) {
 	admReview := getAdmissionReview(rw)
 
 	assert.False(t, admReview.Status.Allowed, "should fail if request doesn't have a body")
-	assert.Contains(t, admReview.Status.Result.Reason, "Failed to decode the request body json into an AdmissionReview resource: ")
+	assert.Contains(t, admReview.Status.Result.Message, "Failed to decode the request body json into an AdmissionReview resource: ")
+	assert.Equal(t, v1.StatusReasonBadRequest, admReview.Status.Result.Reason)
+	assert.Equal(t, int32(http.StatusBadRequest), admReview.Status.Result.Code)
+}
+
+func TestGzipReqBodyWebhookHandler(t *testing.T) {
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+
+	body := new(bytes.Buffer)
+	zw := gzip.NewWriter(body)
+	io.Copy(zw, constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	zw.Close()
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", body)
+	req.Header.Set("Content-Encoding", "gzip")
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should decode the gzip compressed admission review")
+	assert.Equal(t, "test-namespace", admReview.Spec.Name)
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	req.Header.Set("Content-Encoding", "br")
+	webhookHandler(rw, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, rw.Code)
 }
 
 func TestAdmitAllWebhookHandler(t *testing.T) {
@@ -212,7 +270,8 @@ func TestNamespaceResourceTypeWebhookHandler(t *testing.T) {
 	admReview := getAdmissionReview(rw)
 
 	assert.False(t, admReview.Status.Allowed, "should reject if the resource is not Namespace type")
-	assert.Contains(t, admReview.Status.Result.Reason, "Incoming resource is not a Namespace: { v1 pods}")
+	assert.Contains(t, admReview.Status.Result.Message, "Incoming resource is not a Namespace: { v1 pods}")
+	assert.Equal(t, v1.StatusReasonBadRequest, admReview.Status.Result.Reason)
 }
 
 func TestWrongOperationWebhookHandler(t *testing.T) {
@@ -227,8 +286,34 @@ func TestWrongOperationWebhookHandler(t *testing.T) {
 
 	admReview := getAdmissionReview(rw)
 
-	assert.False(t, admReview.Status.Allowed, "should reject if the operation is NOT DELETE")
-	assert.Contains(t, admReview.Status.Result.Reason, "Incoming operation is CREATE on namespace test-namespace. Only DELETE is currently supported.")
+	assert.True(t, admReview.Status.Allowed, "should approve if the operation is NOT DELETE by default")
+
+	*nonDeleteAction = nonDeleteDeny
+	defer func() { *nonDeleteAction = nonDeleteAllow }()
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview = getAdmissionReview(rw)
+
+	assert.False(t, admReview.Status.Allowed, "should reject if the operation is NOT DELETE with nonDeleteAction=deny")
+	assert.Contains(t, admReview.Status.Result.Message, "Incoming operation is CREATE on namespace test-namespace. Only DELETE is currently supported.")
+	assert.Equal(t, v1.StatusReasonBadRequest, admReview.Status.Result.Reason)
+}
+
+func TestConnectOperationWebhookHandler(t *testing.T) {
+	*nonDeleteAction = nonDeleteDeny
+	defer func() { *nonDeleteAction = nonDeleteAllow }()
+
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.Operation = v1alpha1.Connect
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should approve CONNECT even with nonDeleteAction=deny")
 }
 
 func TestNonExistingNamespaceWebhookHandler(t *testing.T) {
@@ -268,6 +353,8 @@ func TestBypassAnnotationTrueWebhookHandler(t *testing.T) {
 	admReview := getAdmissionReview(rw)
 
 	assert.True(t, admReview.Status.Allowed, "should approve if the bypass annotation is set to true")
+	// the snapshot lists the resources in the background
+	waitForBackgroundTasks(t)
 }
 
 func TestBypassAnnotationFalseWebhookHandler(t *testing.T) {
@@ -294,7 +381,32 @@ func TestBypassAnnotationFalseWebhookHandler(t *testing.T) {
 	admReview := getAdmissionReview(rw)
 
 	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has pod resources and bypass annotation is set to false")
-	assert.Contains(t, admReview.Status.Result.Reason, "The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1)]. Please delete them and try again.")
+	assert.Contains(t, admReview.Status.Result.Message, "The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1)]. Please delete them and try again.")
+}
+
+func TestHasBypassAnnotation(t *testing.T) {
+	for value, bypassed := range map[string]bool{"true": true, "yes": true, "1": true, "True": false, "false": false, "": false, "true ": false} {
+		assert.Equal(t, bypassed, hasBypassAnnotation(map[string]string{bypassAnnotationKey: value}), "value %q", value)
+	}
+	assert.False(t, hasBypassAnnotation(nil))
+
+	regex, err := parseBypassPattern("(?i)^(true|yes|1)$")
+	assert.Nil(t, err)
+	bypassValueRegex = regex
+	defer func() { bypassValueRegex = regexp.MustCompile(bypassAnnotationPattern) }()
+	assert.True(t, hasBypassAnnotation(map[string]string{bypassAnnotationKey: "True"}))
+	assert.True(t, hasBypassAnnotation(map[string]string{bypassAnnotationKey: "YES"}))
+	assert.False(t, hasBypassAnnotation(map[string]string{bypassAnnotationKey: "no"}))
+
+	bypassValueRegex = regexp.MustCompile("^.*$")
+	assert.False(t, hasBypassAnnotation(nil), "a missing annotation should never bypass")
+}
+
+func TestParseBypassPattern(t *testing.T) {
+	_, err := parseBypassPattern("^(true|yes")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), `"^(true|yes" is not a valid regular expression`)
+	}
 }
 
 func TestEmptyNamespaceWebhookHandler(t *testing.T) {
@@ -332,7 +444,9 @@ func TestNonEmptyNamespaceWebhookHandler(t *testing.T) {
 	admReview := getAdmissionReview(rw)
 
 	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has pod resources")
-	assert.Contains(t, admReview.Status.Result.Reason, "The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1)]. Please delete them and try again.")
+	assert.Contains(t, admReview.Status.Result.Message, "The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1)]. Please delete them and try again.")
+	assert.Equal(t, v1.StatusReasonForbidden, admReview.Status.Result.Reason, "policy denials should be forbidden")
+	assert.Equal(t, int32(http.StatusForbidden), admReview.Status.Result.Code)
 }
 
 func TestNonEmptyNamespaceWithMoreResourcesWebhookHandler(t *testing.T) {
@@ -409,6 +523,10 @@ func TestNonEmptyNamespaceWithMoreResourcesWebhookHandler(t *testing.T) {
 		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
 			MinReplicas: new(int32),
 		},
+		Status: autoscalingv1.HorizontalPodAutoscalerStatus{
+			CurrentReplicas: 1,
+			DesiredReplicas: 1,
+		},
 	}
 	testCm := &corev1.ConfigMap{
 		ObjectMeta: v1.ObjectMeta{
@@ -431,7 +549,104 @@ func TestNonEmptyNamespaceWithMoreResourcesWebhookHandler(t *testing.T) {
 	admReview := getAdmissionReview(rw)
 
 	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has workload resources")
-	assert.Contains(t, admReview.Status.Result.Reason, "The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1) services(1) replicasets(1) deployments(1) statefulsets(1) daemonsets(1) ingresses(1) horizontalpodautoscalers(1)]. Please delete them and try again.")
+	assert.Contains(t, admReview.Status.Result.Message, "The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1) services(1) replicasets(1) deployments(1) statefulsets(1) daemonsets(1) ingresses(1) horizontalpodautoscalers(1)]. Please delete them and try again.")
+}
+
+func TestBypassCommandHint(t *testing.T) {
+	findings := []resourceFinding{{Kind: "pods", Count: 1}}
+
+	err := deletionError("test-namespace", findings, nil, 0)
+	assert.Contains(t, err.Error(), "run `kubectl annotate namespace test-namespace k8s-namespace-guard.admission.yahoo.com/allow-cascade-delete=true` to bypass this policy check.")
+
+	*clusterName = "prod-us-east"
+	*bypassKey = "example.com/allow-delete"
+	defer func() {
+		*clusterName = ""
+		*bypassKey = bypassAnnotationKey
+	}()
+
+	err = deletionError("test-namespace", findings, nil, 0)
+	assert.Contains(t, err.Error(), "run `kubectl --context prod-us-east annotate namespace test-namespace example.com/allow-delete=true` to bypass this policy check.")
+}
+
+func TestValidateNamespaceDeletionBypassCommand(t *testing.T) {
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+
+	for _, key := range []string{bypassAnnotationKey, "example.com/allow-delete"} {
+		*bypassKey = key
+		err := validateNamespaceDeletion("test-namespace")
+		if assert.NotNil(t, err, "should reject the deletion of a non-empty namespace") {
+			assert.Contains(t, err.Error(), "kubectl annotate namespace test-namespace "+key+"=true",
+				"the bypass command should set the current bypass annotation key")
+		}
+	}
+	*bypassKey = bypassAnnotationKey
+}
+
+func TestMaxReportedKindsWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*maxReportedKinds = 2
+	defer func() { *maxReportedKinds = 0 }()
+
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	testSvc := &corev1.Service{ObjectMeta: v1.ObjectMeta{Name: "test-svc", Namespace: "test-namespace"}}
+	testReplicaSet := &extensionsv1beta1.ReplicaSet{ObjectMeta: v1.ObjectMeta{Name: "test-rs", Namespace: "test-namespace"}}
+	testDeployment := &appsv1beta1.Deployment{ObjectMeta: v1.ObjectMeta{Name: "test-deployment", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod, testSvc, testReplicaSet, testDeployment)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has workload resources")
+	assert.Contains(t, admReview.Status.Result.Message, "contains one or more of these resources: [pods(1) services(1) and 2 more]. Please delete them and try again.")
+}
+
+func TestCapMessage(t *testing.T) {
+	message := "The namespace test-namespace you are trying to remove contains one or more of these resources: [pods(1)]." +
+		bypassHint + ", run `kubectl annotate namespace test-namespace allow=true` to bypass this policy check."
+	assert.Equal(t, message, capMessage(message, 0), "should keep the whole message without maximum")
+	assert.Equal(t, message, capMessage(message, len(message)))
+
+	capped := capMessage(message, 150)
+	assert.Len(t, capped, 150)
+	assert.Equal(t, "The namespace test..."+bypassHint+", run `kubectl annotate namespace test-namespace allow=true` to bypass this policy check.", capped,
+		"should truncate the reasons and keep the bypass hint")
+
+	assert.Equal(t, "The namespace test-...", capMessage(message, 22), "should truncate the bypass hint when it does not fit")
+	assert.Equal(t, "é...", capMessage("éééé", 6), "should not split a multi-byte character")
+}
+
+func TestMaxMessageBytesWebhookHandler(t *testing.T) {
+	*checkClusterScopedResources = true
+	*maxMessageBytes = 1024
+	defer func() {
+		*checkClusterScopedResources = false
+		*maxMessageBytes = 0
+	}()
+
+	objects := []runtime.Object{cloneNamespace(templateNamespace)}
+	for i := 0; i < 1000; i++ {
+		objects = append(objects, &rbacv1beta1.ClusterRoleBinding{
+			ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("test-namespace-deployer-binding-%d", i)},
+			Subjects:   []rbacv1beta1.Subject{{Kind: "ServiceAccount", Name: "deployer", Namespace: "test-namespace"}},
+		})
+	}
+	clientset = fake.NewSimpleClientset(objects...)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace is referenced by ClusterRoleBindings")
+	message := admReview.Status.Result.Message
+	assert.True(t, len(message) <= 1024, "should cap the message at maxMessageBytes, got %d bytes", len(message))
+	assert.Contains(t, message, "referenced by these resources outside of it (external dependencies): [clusterrolebindings[test-namespace-deployer-binding-0")
+	assert.Contains(t, message, "..."+bypassHint+", run `kubectl annotate namespace test-namespace "+bypassAnnotationKey+"=true` to bypass this policy check.",
+		"should keep the bypass hint after the ellipsis")
 }
 
 func TestNonEmptyNamespaceWithIgnoredResourcesWebhookHandler(t *testing.T) {
@@ -460,9 +675,456 @@ func TestNonEmptyNamespaceWithIgnoredResourcesWebhookHandler(t *testing.T) {
 	assert.True(t, admReview.Status.Allowed, "should approve if the namespace has ignored resources")
 }
 
+func TestSystemManagedSelectorWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*systemManagedSelector = "!kubernetes.io/managed-by"
+	defer func() { *systemManagedSelector = "" }()
+
+	systemPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "system-pod",
+			Namespace: "test-namespace",
+			Labels:    map[string]string{"kubernetes.io/managed-by": "addon-manager"},
+		},
+	}
+	systemSvc := &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "system-svc",
+			Namespace: "test-namespace",
+			Labels:    map[string]string{"kubernetes.io/managed-by": "addon-manager"},
+		},
+	}
+	userPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "user-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), systemPod, systemSvc, userPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has user managed resources")
+	assert.Contains(t, admReview.Status.Result.Message, "contains one or more of these resources: [pods(1)].")
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), systemPod, systemSvc)
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the namespace only has system managed resources")
+}
+
+func TestResourceAgeCutoffWebhookHandler(t *testing.T) {
+	*resourceAgeCutoff = time.Hour
+	defer func() {
+		*resourceAgeCutoff = 0
+		*resourceAgeCutoffCounts = ageCutoffNewer
+	}()
+
+	newPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:              "new-pod",
+			Namespace:         "test-namespace",
+			CreationTimestamp: v1.NewTime(time.Now().Add(-time.Minute)),
+		},
+	}
+	oldPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:              "old-pod",
+			Namespace:         "test-namespace",
+			CreationTimestamp: v1.NewTime(time.Now().Add(-2 * time.Hour)),
+		},
+	}
+	oldDeployment := &appsv1beta1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Name:              "old-deployment",
+			Namespace:         "test-namespace",
+			CreationTimestamp: v1.NewTime(time.Now().Add(-24 * time.Hour)),
+		},
+	}
+	review := func(objects ...runtime.Object) (bool, string) {
+		clientset = fake.NewSimpleClientset(append(objects, cloneNamespace(templateNamespace))...)
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+
+	allowed, _ := review(oldPod, oldDeployment)
+	assert.True(t, allowed, "should approve if the namespace only has resources older than the cutoff")
+	allowed, message := review(newPod, oldPod, oldDeployment)
+	assert.False(t, allowed, "should reject if the namespace has resources newer than the cutoff")
+	assert.Contains(t, message, "contains one or more of these resources: [pods(1)].")
+
+	*resourceAgeCutoffCounts = ageCutoffOlder
+	allowed, _ = review(newPod)
+	assert.True(t, allowed, "should approve if the namespace only has resources newer than the cutoff")
+	allowed, message = review(newPod, oldPod, oldDeployment)
+	assert.False(t, allowed, "should reject if the namespace has resources older than the cutoff")
+	assert.Contains(t, message, "contains one or more of these resources: [pods(1) deployments(1)].")
+}
+
+func TestGuardEndpointsWebhookHandler(t *testing.T) {
+	*guardEndpoints = true
+	defer func() { *guardEndpoints = false }()
+
+	kubernetesEndpoints := &corev1.Endpoints{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "kubernetes",
+			Namespace: "test-namespace",
+		},
+	}
+	legacyEndpoints := &corev1.Endpoints{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "legacy-backend",
+			Namespace: "test-namespace",
+		},
+	}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), kubernetesEndpoints)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the namespace only has the kubernetes endpoints")
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), kubernetesEndpoints, legacyEndpoints)
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has endpoints")
+	assert.Contains(t, admReview.Status.Result.Message, "contains one or more of these resources: [endpoints(1)].")
+}
+
+func TestLoadBalancerServiceCounter(t *testing.T) {
+	service := func(name string, serviceType corev1.ServiceType, ingress ...corev1.LoadBalancerIngress) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "test-namespace"},
+			Spec:       corev1.ServiceSpec{Type: serviceType},
+			Status:     corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: ingress}},
+		}
+	}
+	clientset = fake.NewSimpleClientset(
+		service("backend", corev1.ServiceTypeClusterIP),
+		service("pending-lb", corev1.ServiceTypeLoadBalancer),
+		service("public-lb", corev1.ServiceTypeLoadBalancer, corev1.LoadBalancerIngress{IP: "203.0.113.10"}),
+	)
+
+	names, err := loadBalancerServiceCounter(clientset, "test-namespace")
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, []string{"public-lb"}, names, "should only count the provisioned load balancers")
+
+	assert.NotContains(t, counterKinds(resourceCounters()), loadBalancerServicesKind)
+	*guardLoadBalancerServices = true
+	defer func() { *guardLoadBalancerServices = false }()
+	assert.Contains(t, counterKinds(resourceCounters()), loadBalancerServicesKind)
+}
+
+func TestServingServicesWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	servingSvc := &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "serving-svc",
+			Namespace: "test-namespace",
+		},
+	}
+	servingEndpoints := &corev1.Endpoints{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "serving-svc",
+			Namespace: "test-namespace",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}},
+		},
+	}
+	idleSvc := &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "idle-svc",
+			Namespace: "test-namespace",
+		},
+	}
+	idleEndpoints := &corev1.Endpoints{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "idle-svc",
+			Namespace: "test-namespace",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}}},
+		},
+	}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), servingSvc, servingEndpoints, idleSvc, idleEndpoints)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has services")
+	assert.Regexp(t, "^DANGER: The services \\[serving-svc\\] in the namespace test-namespace have ready endpoints and are actively serving traffic. ", admReview.Status.Result.Message)
+	assert.Contains(t, admReview.Status.Result.Message, "services(2)")
+}
+
+func TestIdleServicesWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	idleSvc := &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "idle-svc",
+			Namespace: "test-namespace",
+		},
+	}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), idleSvc)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the namespace has services")
+	assert.NotContains(t, admReview.Status.Result.Message, "DANGER")
+}
+
+func TestDormantHPAWebhookHandler(t *testing.T) {
+	minReplicas := int32(2)
+	dormantHpa := &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "dormant-hpa",
+			Namespace: "test-namespace",
+		},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			MinReplicas: &minReplicas,
+		},
+		Status: autoscalingv1.HorizontalPodAutoscalerStatus{
+			CurrentReplicas: 2,
+		},
+	}
+	scaledHpa := &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "scaled-hpa",
+			Namespace: "test-namespace",
+		},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			MinReplicas: &minReplicas,
+		},
+		Status: autoscalingv1.HorizontalPodAutoscalerStatus{
+			CurrentReplicas: 3,
+		},
+	}
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), dormantHpa)
+	names, err := autoScaleCounter(clientset, "test-namespace")
+	assert.Nil(t, err, "Error should be nil")
+	assert.Empty(t, names, "should not count an HPA at its minReplicas with no desired replicas")
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), dormantHpa, scaledHpa)
+	names, err = autoScaleCounter(clientset, "test-namespace")
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, []string{"scaled-hpa"}, names, "should count an HPA scaled above its minReplicas")
+}
+
+func failingServicesClientset(err error, objects ...runtime.Object) *fake.Clientset {
+	fakeClientset := fake.NewSimpleClientset(objects...)
+	fakeClientset.PrependReactor("list", "services", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, err
+	})
+	return fakeClientset
+}
+
+func TestTransientCounterErrorWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	testNamespace := cloneNamespace(templateNamespace)
+	clientset = failingServicesClientset(apiErrors.NewServerTimeout(schema.GroupResource{Resource: "services"}, "list", 1), testNamespace)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject on counter errors by default")
+
+	*allowOnTransientErrors = true
+	defer func() { *allowOnTransientErrors = false }()
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview = getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should approve if no resources were found and only transient errors occurred")
+	assert.Equal(t, "No resources were found in the namespace test-namespace, but the following kinds could not be verified due to transient errors: [services].", admReview.Status.Result.Message)
+}
+
+func TestTransientCounterErrorWithResourcesWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*allowOnTransientErrors = true
+	defer func() { *allowOnTransientErrors = false }()
+
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	testNamespace := cloneNamespace(templateNamespace)
+	clientset = failingServicesClientset(apiErrors.NewServerTimeout(schema.GroupResource{Resource: "services"}, "list", 1), testNamespace, testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if resources were found")
+}
+
+func TestPermanentCounterErrorWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*allowOnTransientErrors = true
+	defer func() { *allowOnTransientErrors = false }()
+
+	testNamespace := cloneNamespace(templateNamespace)
+	clientset = failingServicesClientset(apiErrors.NewForbidden(schema.GroupResource{Resource: "services"}, "", fmt.Errorf("RBAC denied")), testNamespace)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject on errors that are not transient")
+	assert.Contains(t, admReview.Status.Result.Message, "error listing services")
+	assert.Equal(t, v1.StatusReasonInternalError, admReview.Status.Result.Reason, "list errors should be internal errors")
+	assert.Equal(t, int32(http.StatusInternalServerError), admReview.Status.Result.Code)
+}
+
+func TestSystemControllerWebhookHandler(t *testing.T) {
+	for _, username := range []string{
+		"system:serviceaccount:kube-system:namespace-controller",
+		"system:serviceaccount:kube-system:generic-garbage-collector",
+	} {
+		rw := httptest.NewRecorder()
+
+		testPod := &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "test-pod",
+				Namespace: "test-namespace",
+			},
+		}
+		fakeClientset := fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+		clientset = fakeClientset
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.UserInfo.Username = username
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+		webhookHandler(rw, req)
+
+		admReview := getAdmissionReview(rw)
+		assert.True(t, admReview.Status.Allowed, "should approve requests by %s", username)
+		assert.Empty(t, fakeClientset.Actions(), "should not call the apiserver for requests by %s", username)
+	}
+}
+
+func TestSystemControllerDisabledWebhookHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	*admitSystemControllers = false
+	defer func() { *admitSystemControllers = true }()
+
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "system:serviceaccount:kube-system:namespace-controller"
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should validate system controller requests if admitSystemControllers is false")
+}
+
+func TestWebhookHandlerConcurrency(t *testing.T) {
+	t.Parallel()
+
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	emptyNamespace := cloneNamespace(templateNamespace)
+	emptyNamespace.Name = "empty-namespace"
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), emptyNamespace, testPod)
+
+	// half of the requests are rejected for the pod, the other half target the empty namespace
+	var wg sync.WaitGroup
+	results := make([]*v1alpha1.AdmissionReview, 100)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			testSpec := cloneAdmissionReview(templateAdmReview)
+			if i%2 == 1 {
+				testSpec.Spec.Name = emptyNamespace.Name
+				testSpec.Spec.Namespace = emptyNamespace.Name
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+			webhookHandler(rw, req)
+			results[i] = getAdmissionReview(rw)
+		}(i)
+	}
+	wg.Wait()
+	waitForBackgroundTasks(t)
+
+	for i, admReview := range results {
+		if i%2 == 1 {
+			assert.True(t, admReview.Status.Allowed, "request %d should approve the empty namespace", i)
+		} else {
+			assert.False(t, admReview.Status.Allowed, "request %d should reject the namespace with a pod", i)
+			assert.Contains(t, admReview.Status.Result.Message, "[pods(1)]", "request %d", i)
+		}
+	}
+}
+
 func TestStatusHandler200(t *testing.T) {
 	rw := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "http://localhost:8080/status.html", nil)
 	statusHandler(rw, req)
 	assert.Equal(t, http.StatusOK, rw.Code, "/status.html should return 200")
 }
+
+func TestStatusHandlerFormats(t *testing.T) {
+	rw := httptest.NewRecorder()
+	statusHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/status.html", nil))
+	assert.Equal(t, "OK", rw.Body.String(), "should serve plain text by default")
+
+	rw = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:8080/status.html", nil)
+	req.Header.Set("Accept", "application/json, text/plain")
+	statusHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"status\":\"ok\",\"version\":\"dev\",\"components\":{\"apiserver\":\"ok\",\"certificates\":\"ok\"}}\n", rw.Body.String())
+
+	*statusFormat = statusFormatJSON
+	defer func() { *statusFormat = statusFormatText }()
+	failsafe = newAPIServerFailsafe(0, 1, time.Now)
+	defer func() {
+		failsafe.observe(nil)
+		failsafe = nil
+	}()
+	failsafe.observe(apiErrors.NewServiceUnavailable("apiserver is shutting down"))
+	rw = httptest.NewRecorder()
+	statusHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/status.html", nil))
+	resp := statusResponse{}
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Equal(t, statusResponse{Status: "ok", Version: "dev", Components: map[string]string{"apiserver": "unreachable", "certificates": "ok"}}, resp,
+		"should serve JSON with --statusFormat=json and report the unreachable apiserver")
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	authenticationv1 "k8s.io/client-go/pkg/apis/authentication/v1"
+)
+
+var (
+	// logLevels tracks the log level changes made through /debug/loglevel
+	logLevels = &logLevelController{}
+)
+
+// logLevelResponse is the JSON response of the /debug/loglevel endpoint
+type logLevelResponse struct {
+	Level    string     `json:"level"`
+	RevertTo string     `json:"revertTo,omitempty"`
+	RevertAt *time.Time `json:"revertAt,omitempty"`
+}
+
+// logLevelController changes the level of the logger at runtime, reverting temporary changes once
+// their TTL expires
+type logLevelController struct {
+	sync.Mutex
+	// base is the level reverted to, set while a temporary change is pending
+	base     logrus.Level
+	revertAt time.Time
+	timer    *time.Timer
+	// changes counts the changes, so that a superseded timer doesn't revert
+	changes int
+}
+
+// set changes the log level, until the TTL expires if it is not 0. A temporary change reverts to the
+// level set before the first of the pending temporary changes. It returns the previous level.
+func (c *logLevelController) set(level logrus.Level, ttl time.Duration) logrus.Level {
+	c.Lock()
+	defer c.Unlock()
+	previous := log.Level
+	if c.timer != nil {
+		c.timer.Stop()
+	} else {
+		c.base = previous
+	}
+	log.Level = level
+	c.changes++
+	c.timer, c.revertAt = nil, time.Time{}
+	if ttl > 0 {
+		change := c.changes
+		c.timer = time.AfterFunc(ttl, func() { c.expire(change) })
+		c.revertAt = time.Now().Add(ttl)
+	}
+	return previous
+}
+
+// expire reverts the temporary change, unless another change superseded it
+func (c *logLevelController) expire(change int) {
+	c.Lock()
+	defer c.Unlock()
+	if c.changes != change {
+		return
+	}
+	log.Warnf("Log level change expired, reverting from %s to %s", log.Level, c.base)
+	log.Level = c.base
+	c.timer, c.revertAt = nil, time.Time{}
+}
+
+// status returns the current log level and the pending revert, if any
+func (c *logLevelController) status() logLevelResponse {
+	c.Lock()
+	defer c.Unlock()
+	resp := logLevelResponse{Level: log.Level.String()}
+	if c.timer != nil {
+		revertAt := c.revertAt.UTC()
+		resp.RevertTo, resp.RevertAt = c.base.String(), &revertAt
+	}
+	return resp
+}
+
+// authenticateRequest returns the user of the bearer token of the request, as validated by the
+// apiserver with a TokenReview
+func authenticateRequest(req *http.Request) (string, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return "", errors.New("a bearer token is required")
+	}
+	review, err := clientset.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error occurred while reviewing the token: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return "", errors.New("the token is not valid")
+	}
+	return review.Status.User.Username, nil
+}
+
+// logLevelUserAllowed returns true if the user is among the --logLevelUsers
+func logLevelUserAllowed(user string) bool {
+	for _, allowed := range strings.Split(*logLevelUsers, ",") {
+		if strings.TrimSpace(allowed) == user {
+			return true
+		}
+	}
+	return false
+}
+
+// logLevelHandler serves the /debug/loglevel endpoint reporting the log level on GET and changing it
+// on PUT with the v=<level> and optional ttl=<duration> parameters, for the --logLevelUsers only
+func logLevelHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+
+	if strings.TrimSpace(*logLevelUsers) == "" {
+		writeJSON(rw, http.StatusNotFound, explainError{"The log level endpoint is not enabled"})
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(rw, http.StatusOK, logLevels.status())
+		return
+	case http.MethodPut:
+	default:
+		writeJSON(rw, http.StatusMethodNotAllowed, explainError{fmt.Sprintf("Incoming request method %s is not supported, only GET and PUT are supported", req.Method)})
+		return
+	}
+
+	user, err := authenticateRequest(req)
+	if err != nil {
+		log.Warnf("Rejected the log level change from %s: %s", req.RemoteAddr, err.Error())
+		writeJSON(rw, http.StatusUnauthorized, explainError{err.Error()})
+		return
+	}
+	if !logLevelUserAllowed(user) {
+		log.Warnf("Rejected the log level change by %s from %s: not among the logLevelUsers", user, req.RemoteAddr)
+		writeJSON(rw, http.StatusForbidden, explainError{fmt.Sprintf("User %s is not allowed to change the log level", user)})
+		return
+	}
+	level, err := logrus.ParseLevel(req.FormValue("v"))
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, explainError{fmt.Sprintf("Invalid log level %q, it must be one of debug, info, warning or error", req.FormValue("v"))})
+		return
+	}
+	var ttl time.Duration
+	if value := req.FormValue("ttl"); value != "" {
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			writeJSON(rw, http.StatusBadRequest, explainError{fmt.Sprintf("Invalid ttl %q, it must be a positive duration", value)})
+			return
+		}
+	}
+
+	previous := logLevels.set(level, ttl)
+	if ttl > 0 {
+		log.Warnf("Log level changed from %s to %s by %s from %s for %v", previous, level, user, req.RemoteAddr, ttl)
+	} else {
+		log.Warnf("Log level changed from %s to %s by %s from %s", previous, level, user, req.RemoteAddr)
+	}
+	writeJSON(rw, http.StatusOK, logLevels.status())
+}
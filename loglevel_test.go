@@ -0,0 +1,127 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	authenticationv1 "k8s.io/client-go/pkg/apis/authentication/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// tokenReviewClientset returns a clientset authenticating the tokens as the users they map to
+func tokenReviewClientset(users map[string]string) *fake.Clientset {
+	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset.PrependReactor("create", "tokenreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if user, ok := users[review.Spec.Token]; ok {
+			review.Status.Authenticated = true
+			review.Status.User.Username = user
+		}
+		return true, review, nil
+	})
+	return fakeClientset
+}
+
+// putLogLevel sends a PUT /debug/loglevel request with the query and bearer token
+func putLogLevel(query, token string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "http://localhost:8080/debug/loglevel?"+query, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	logLevelHandler(rw, req)
+	return rw
+}
+
+// setupLogLevelTest enables the endpoint for alice and captures the logs, returning the reset function
+func setupLogLevelTest(t *testing.T, buf *bytes.Buffer) func() {
+	waitForBackgroundTasks(t)
+	out, level := log.Out, log.Level
+	log.Out = buf
+	*logLevelUsers = "alice, carol"
+	clientset = tokenReviewClientset(map[string]string{"alice-token": "alice", "bob-token": "bob"})
+	return func() {
+		logLevels.set(level, 0)
+		log.Out = out
+		*logLevelUsers = ""
+	}
+}
+
+func TestLogLevelHandlerDisabled(t *testing.T) {
+	rw := putLogLevel("v=debug", "alice-token")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	var buf bytes.Buffer
+	defer setupLogLevelTest(t, &buf)()
+	logLevels.set(logrus.InfoLevel, 0)
+
+	log.Debugf("suppressed line")
+	assert.NotContains(t, buf.String(), "suppressed line")
+
+	rw := putLogLevel("v=debug", "")
+	assert.Equal(t, http.StatusUnauthorized, rw.Code, "should require a bearer token")
+	rw = putLogLevel("v=debug", "unknown-token")
+	assert.Equal(t, http.StatusUnauthorized, rw.Code, "should reject invalid tokens")
+	rw = putLogLevel("v=debug", "bob-token")
+	assert.Equal(t, http.StatusForbidden, rw.Code, "should only allow the logLevelUsers")
+	rw = putLogLevel("v=verbose", "alice-token")
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	log.Debugf("suppressed line")
+	assert.NotContains(t, buf.String(), "suppressed line", "rejected changes should keep the level")
+
+	rw = putLogLevel("v=debug", "alice-token")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	resp := logLevelResponse{}
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Equal(t, logLevelResponse{Level: "debug"}, resp)
+	assert.Contains(t, buf.String(), "Log level changed from info to debug by alice from 192.0.2.1:1234")
+
+	log.Debugf("previously suppressed line")
+	assert.Contains(t, buf.String(), "DEBUG", "should log debug lines once the level is raised")
+	assert.Contains(t, buf.String(), "previously suppressed line")
+
+	rw = httptest.NewRecorder()
+	logLevelHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/debug/loglevel", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "{\"level\":\"debug\"}\n", rw.Body.String())
+}
+
+func TestLogLevelHandlerTTL(t *testing.T) {
+	var buf bytes.Buffer
+	defer setupLogLevelTest(t, &buf)()
+	logLevels.set(logrus.WarnLevel, 0)
+
+	rw := putLogLevel("v=debug&ttl=1h", "alice-token")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	status := logLevels.status()
+	assert.Equal(t, "debug", status.Level)
+	assert.Equal(t, "warning", status.RevertTo)
+	if assert.NotNil(t, status.RevertAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *status.RevertAt, time.Minute)
+	}
+	assert.Contains(t, buf.String(), "Log level changed from warning to debug by alice from 192.0.2.1:1234 for 1h0m0s")
+
+	// a new temporary change supersedes the pending one and reverts to the original level
+	rw = putLogLevel("v=info&ttl=50ms", "alice-token")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "info", logLevels.status().Level)
+	for i := 0; i < 50 && logLevels.status().Level != "warning"; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, logLevelResponse{Level: "warning"}, logLevels.status(), "should revert once the ttl expires")
+	log.Infof("suppressed again")
+	assert.NotContains(t, buf.String(), "suppressed again")
+	assert.Contains(t, buf.String(), "Log level change expired, reverting from info to warning")
+}
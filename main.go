@@ -1,24 +1,44 @@
-// Copyright 2017 Yahoo Holdings Inc. 
+// Copyright 2017 Yahoo Holdings Inc.
 // Licensed under the terms of the 3-Clause BSD License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"expvar"
 	"flag"
 	"io"
 	"net/http"
 
 	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
+const (
+	statusFormatText = "text"
+	statusFormatJSON = "json"
+)
+
+// version is the release of the binary, set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
 var (
 	port          = flag.String("port", "443", "Server port.")
 	logFilename   = flag.String("logFile", "/var/log/nslifecycle.log", "Log file name and full path.")
@@ -26,8 +46,169 @@ var (
 	httpsCertFile = flag.String("certFile", "/var/lib/kubernetes/kubernetes.pem", "The cert file for the https server.")
 	httpsKeyFile  = flag.String("keyFile", "/var/lib/kubernetes/kubernetes-key.pem", "The key file for the https server.")
 	clientCAFile  = flag.String("clientCAFile", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt", "The cluster root CA that signs the apiserver cert")
+
+	spiffe            = flag.Bool("spiffe", false, "True to serve the X509-SVID of the SPIFFE Workload API instead of the certFile and keyFile, rotating it as it renews.")
+	spiffeSocket      = flag.String("spiffeSocket", "", "The address of the SPIFFE Workload API with spiffe, e.g. unix:///run/spire/sockets/agent.sock, $SPIFFE_ENDPOINT_SOCKET when empty.")
+	spiffeTrustBundle = flag.Bool("spiffeTrustBundle", false, "True to verify the client certificates with the trust bundle of the SPIFFE Workload API instead of the clientCAFile, with spiffe.")
+
 	clientAuth    = flag.Bool("clientAuth", false, "True to verify client cert/auth during TLS handshake.")
 	admitAll      = flag.Bool("admitAll", false, "True to admit all namespace deletions without validation.")
+	logLevelUsers = flag.String("logLevelUsers", "", "The comma separated users allowed to change the log level with PUT /debug/loglevel, authenticated by their bearer token. The endpoint is disabled when empty.")
+	kubeconfig    = flag.String("kubeconfig", "", "The kubeconfig file, the in-cluster config is used when empty and $KUBECONFIG outside of a cluster.")
+
+	serviceAccountTokenFile = flag.String("serviceAccountTokenFile", "/var/run/secrets/kubernetes.io/serviceaccount/token", "The service account token file of the in-cluster config.")
+	serviceAccountCAFile    = flag.String("serviceAccountCAFile", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt", "The root CA file verifying the apiserver with the in-cluster config.")
+
+	// listenAddresses is set by the repeated or comma separated --listenAddress
+	listenAddresses addressList
+	unixSocket      = flag.String("unixSocket", "", "The unix socket path serving plain HTTP instead of the HTTPS port, e.g. /var/run/namespace-guard.sock.")
+	unixSocketMode  = flag.String("unixSocketMode", "0660", "The octal file mode of the unixSocket.")
+	insecureHTTP    = flag.Bool("insecureHTTP", false, "True to serve plain HTTP instead of HTTPS on the listen addresses, e.g. behind a service mesh sidecar terminating mTLS. The certFile, keyFile and clientCAFile are not loaded.")
+	noTLS           = flag.Bool("noTLS", false, "True to serve plain HTTP on the httpPort for local development, e.g. posting admission reviews with curl. INSECURE, never use it in production.")
+	httpPort        = flag.String("httpPort", "8080", "The port of the plain HTTP server with noTLS, unless listen addresses are set.")
+	startupTimeout  = flag.Duration("startupTimeout", 30*time.Second, "The time allowed to create the clientset and reach the apiserver with a discovery request on startup, no deadline when 0.")
+	shutdownTimeout = flag.Duration("shutdownTimeout", 30*time.Second, "The time allowed for the in-flight requests to complete on shutdown, before their connections are forcibly closed.")
+
+	proxyProtocol             = flag.Bool("proxyProtocol", false, "True to parse the PROXY protocol v1 or v2 header of the HTTPS connections from the proxyProtocolTrustedCIDRs.")
+	proxyProtocolTrustedCIDRs = flag.String("proxyProtocolTrustedCIDRs", "", "The comma separated CIDRs of the load balancers allowed to send a PROXY protocol header.")
+	proxyProtocolStrict       = flag.Bool("proxyProtocolStrict", true, "True to close the connections from the proxyProtocolTrustedCIDRs without a PROXY protocol header, false to serve them with the peer address.")
+
+	healthzPort    = flag.String("healthzPort", "", "The port of a plain HTTP server serving /healthz apart from the webhook server, /healthz is only served by the webhook server when empty.")
+	healthzTimeout = flag.Duration("healthzTimeout", time.Second, "The time /healthz allows each listener of the webhook server to accept a connection and respond to the TLS handshake.")
+
+	bypassKey                = flag.String("bypassAnnotationKey", bypassAnnotationKey, "The annotation allowing a cascading delete of a namespace when its value matches the bypassAnnotationPattern.")
+	bypassPattern            = flag.String("bypassAnnotationPattern", bypassAnnotationPattern, "The regular expression the value of the bypass annotation must match, e.g. (?i)^(true|yes|1)$ to ignore the case.")
+	requireBypassFingerprint = flag.Bool("requireBypassFingerprint", false, "True to only honor the bypass annotations set to the fingerprint of the current contents of the namespace given in the denial message, rather than true.")
+	bypassEventNamespace     = flag.String("bypassEventNamespace", "", "The namespace of the Events recording the namespace deletions allowed through the bypass annotation, no Events when empty.")
+	bypassMaxAge             = flag.Duration("bypassMaxAge", 0, "The age after which the bypass annotations are removed, as set in their namespace-guard.io/bypass-set-at annotation, never when 0.")
+	bypassExpiryInterval     = flag.Duration("bypassExpiryInterval", 10*time.Minute, "How often the bypass annotations are checked against the bypassMaxAge.")
+	expireBypassDryRun       = flag.Bool("expireBypassDryRun", false, "True to only log the bypass annotations that would be stamped or removed with bypassMaxAge.")
+	clusterName              = flag.String("clusterName", "", "The kubectl context of the cluster, included in the bypass command of rejection messages and the title of the gitReport if set.")
+
+	webhookConfigName   = flag.String("webhookConfigName", "", "The ExternalAdmissionHookConfiguration to check for misconfigurations, no check is done when empty.")
+	webhookService      = flag.String("webhookService", "default/k8s-namespace-guard", "The <namespace>/<name> of the service the webhook configuration must reference.")
+	webhookCAFile       = flag.String("webhookCAFile", "", "The CA bundle written to the webhook configuration on repair.")
+	manageWebhookConfig = flag.Bool("manageWebhookConfig", false, "True to repair the discrepancies found in the webhookConfigName.")
+
+	certExpiryThresholds    = flag.String("certExpiryThresholds", "720h,168h,24h", "The comma separated durations before the certificate expiry from which warnings are logged.")
+	failStatusOnExpiredCert = flag.Bool("failStatusOnExpiredCert", false, "True to fail /status.html with 503 once the serving cert or client CA has expired.")
+	statusFormat            = flag.String("statusFormat", statusFormatText, "The format of the /status.html response, either text or json. The JSON format is also served to the requests accepting application/json.")
+
+	responseContentType    = flag.String("responseContentType", "application/json", "The Content-Type header of the admission review responses.")
+	nonDeleteAction        = flag.String("nonDeleteAction", nonDeleteAllow, "The response to CREATE and UPDATE operations, either allow or deny. Other operations than DELETE are always allowed.")
+	admitSystemControllers = flag.Bool("admitSystemControllers", true, "True to admit all requests by the namespace and garbage collector controllers without validation.")
+
+	explainQPS    = flag.Float64("explainQPS", 1, "The number of /explain requests per second allowed for each client.")
+	explainBurst  = flag.Int("explainBurst", 5, "The number of /explain requests a client may burst above explainQPS.")
+	grpcAddr      = flag.String("grpcAddr", "", "The address of the gRPC server serving the Check RPC of proto/namespaceguard.proto, e.g. :9443, no gRPC server when empty.")
+	dryRun        = flag.String("dryRun", "", "The namespace whose deletion is decided as by the webhook instead of serving, printing the decision and exiting with 1 if it would be rejected.")
+	dryRunUser    = flag.String("dryRunUser", "", "The user deleting the dryRun namespace, an anonymous user when empty.")
+	dryRunGroups  = flag.String("dryRunGroups", "", "The comma separated groups of the dryRunUser.")
+	dryRunProfile = flag.String("dryRunProfile", "", "The profile of the configFile deciding the dryRun deletion, the default profile when empty.")
+
+	gitReport      = flag.Bool("gitReport", false, "True to commit the inventory of the namespaces decided as by the webhook as a Markdown report to the gitRepoURL and exit instead of serving.")
+	gitRepoURL     = flag.String("gitRepoURL", "", "The HTTPS URL of the Git repository the gitReport is committed to.")
+	gitBranch      = flag.String("gitBranch", "master", "The branch of the Git repository the gitReport is committed to.")
+	gitCommitEmail = flag.String("gitCommitEmail", "", "The email of the author of the gitReport commits.")
+	gitCommitName  = flag.String("gitCommitName", "k8s-namespace-guard", "The name of the author of the gitReport commits.")
+	gitTokenFile   = flag.String("gitTokenFile", "", "The file holding the token pushing to the Git repository, anonymous when empty.")
+	gitReportPath  = flag.String("gitReportPath", "namespace-guard-report.md", "The path of the gitReport within the Git repository.")
+
+	enforcementPercent      = flag.Int("enforcementPercent", 100, "The percentage of namespaces, by name hash, whose rejected deletions are enforced rather than allowed with a warning.")
+	systemManagedSelector   = flag.String("systemManagedSelector", "", "The label selector every counted object must match, e.g. !kubernetes.io/managed-by to exclude system-managed objects.")
+	resourceAgeCutoff       = flag.Duration("resourceAgeCutoff", 0, "The age splitting the counted objects by their creationTimestamp, only the objects on the resourceAgeCutoffCounts side of it are counted. All objects are counted when 0.")
+	resourceAgeCutoffCounts = flag.String("resourceAgeCutoffCounts", ageCutoffNewer, "The objects counted with resourceAgeCutoff, either newer or older.")
+	userDeletionQuota       = flag.Int("userDeletionQuota", 0, "The number of namespace deletions allowed per user within 24h, no quota when 0.")
+	quotaExemptUsers        = flag.String("quotaExemptUsers", "", "The comma separated users exempted from the userDeletionQuota, e.g. automation accounts.")
+	quotaConfigMap          = flag.String("quotaConfigMap", "", "The <namespace>/<name> of the ConfigMap persisting the userDeletionQuota across restarts, not persisted when empty.")
+	maxReportedKinds        = flag.Int("maxReportedKinds", 0, "The maximum number of resource kinds listed in a rejection message, all kinds when 0.")
+	maxMessageBytes         = flag.Int("maxMessageBytes", 0, "The maximum length in bytes of a rejection message, truncated with an ellipsis before the bypass command, no maximum when 0.")
+	optionalKindList        = flag.String("resources", "", "The comma separated optional resource kinds also counted, listed with the dynamic client: gateways, httproutes, servicemonitors, podmonitors, certificates, issuers.")
+	kindWeightList          = flag.String("kindWeights", "", "The comma separated <kind>=<weight> contributions of each resource to the risk score compared to maxResourceCount, e.g. pods=1,statefulsets=10. Kinds not listed weigh 1.")
+	kindThresholdList       = flag.String("kindThresholds", "", "The comma separated <kind>=<threshold> numbers of leftover resources of each kind tolerated, e.g. pods=0,configmaps=5. The kinds listed block the deletion once above their threshold and don't count towards maxResourceCount, configmaps are only counted when listed.")
+	countParallelism        = flag.Int("countParallelism", 1, "The number of resource kinds counted at the same time, the kinds are counted one after the other when 1.")
+	maxImpactScore          = flag.Int("maxImpactScore", 0, "The impact score above which the deletion is denied, the weighted sum of the resources using the kindWeights and the weights of the config file. maxResourceCount applies when 0.")
+	maxResourceCount        = flag.Int("maxResourceCount", 0, "The number of workload resources a namespace may hold and still be deleted.")
+	maxCountedObjects       = flag.Int("maxCountedObjects", 0, "The number of objects of a kind counted before listing stops and the namespace is deemed too large to delete, no budget when 0.")
+	countWithTable          = flag.Bool("countWithTable", false, "True to list the objects as Tables, only transferring their name and age rather than the full objects.")
+	maxTotalCountedObjects  = flag.Int("maxTotalCountedObjects", 0, "The number of objects across kinds counted before listing stops and the namespace is deemed too large to delete, no budget when 0.")
+	softThresholdEnabled    = flag.Bool("softThresholdEnabled", false, "True to emit a DeletionAtRisk warning event on the namespaces nearing maxResourceCount found by the background scan.")
+	softThresholdPercentage = flag.Int("softThresholdPercentage", 80, "The percentage of maxResourceCount above which the DeletionAtRisk event is emitted.")
+
+	shadowCompare               = flag.Bool("shadowCompare", false, "True to compare the live resource counts against an informer cache and report divergences.")
+	failsafeAfter               = flag.Duration("failsafeAfter", 0, "The duration of sustained apiserver unreachability after which namespace deletions are admitted without validation until it is reachable again, never when 0.")
+	failsafeMinFailures         = flag.Int("failsafeMinFailures", 3, "The number of consecutive apiserver failures needed, along with failsafeAfter, to admit deletions without validation.")
+	circuitBreakerFailures      = flag.Int("circuitBreakerFailures", 0, "The number of consecutive apiserver failures within circuitBreakerWindow after which admission requests get the circuitBreakerDecision without calling the apiserver, never when 0.")
+	circuitBreakerWindow        = flag.Duration("circuitBreakerWindow", 30*time.Second, "The window within which the circuitBreakerFailures must occur to open the circuit.")
+	circuitBreakerProbeInterval = flag.Duration("circuitBreakerProbeInterval", 10*time.Second, "How often a single request is let through to probe the apiserver while the circuit is open.")
+	circuitBreakerDecision      = flag.String("circuitBreakerDecision", degradedFailClosed, "The decision while the circuit is open, either failOpen to allow the deletions or failClosed to reject them.")
+	validationTimeout           = flag.Duration("validationTimeout", 0, "The time allowed to validate an admission request before responding with the timeoutFallback, e.g. 25s to respond before the apiserver's webhook timeout of 30s, no deadline when 0.")
+	sloThresholdMs              = flag.Int("sloThresholdMs", 1000, "The response time in milliseconds above which an admission webhook request is counted and logged as an SLO violation, never when 0.")
+	onError                     = flag.String("onError", onErrorDeny, "The decision on the deletions that could not be validated, e.g. on list errors, either allow or deny.")
+	onErrorNamespaces           = flag.String("onErrorNamespaces", "", "The comma separated <pattern>=<allow|deny> overriding onError for the namespaces matching the pattern, the first match applying, e.g. prod-*=deny,ci-*=allow.")
+	timeoutFallback             = flag.String("timeoutFallback", timeoutFallbackDeny, "The decision once the validationTimeout elapsed or the apiserver gave up on the request, either allow or deny.")
+	scanInterval                = flag.Duration("scanInterval", 0, "How often every namespace is scanned in the background to export the number of namespaces whose deletion would be blocked, never when 0.")
+	scanQPS                     = flag.Float64("scanQPS", 2, "The queries per second of the background scanner's own apiserver client, not shared with the admission requests.")
+	allowOnTransientErrors      = flag.Bool("allowOnTransientErrors", false, "True to allow the deletion with a warning when no resources were found and only transient list errors occurred.")
+	confirmIdle                 = flag.Duration("confirmIdle", 0, "The window during which no pod may be created in a namespace before its deletion is allowed, no window when 0.")
+	guardEndpoints              = flag.Bool("guardEndpoints", false, "True to also reject deletions of namespaces holding Endpoints objects.")
+	guardPersistentVolumeClaims = flag.Bool("guardPersistentVolumeClaims", false, "True to also reject deletions of namespaces holding PersistentVolumeClaims.")
+	guardProfile                = flag.String("guardProfile", guardProfileCustom, "The bundle of resource kinds guarded, either strict (all kinds), standard (pods, deployments, statefulsets and persistentvolumeclaims), minimal (pods) or custom (the default kinds and those enabled by the guard<Type> flags).")
+	guardLoadBalancerServices   = flag.Bool("guardLoadBalancerServices", false, "True to also count the Services of type LoadBalancer whose load balancer is provisioned as loadbalancerservices, e.g. to set a kindThresholds of their own.")
+	reportVolumeReclaim         = flag.Bool("reportVolumeReclaim", false, "True to warn on the allowed namespace deletions about the PersistentVolumes bound to their claims that would be destroyed or left Released, by reclaim policy.")
+	checkClusterScopedResources = flag.Bool("checkClusterScopedResources", false, "True to also reject deletions of namespaces referenced by ClusterRoleBindings or PersistentVolumes.")
+	guardHelmDependencies       = flag.Bool("guardHelmDependencies", false, "True to also reject deletions of namespaces targeted by deployed Helm releases stored in other namespaces.")
+	scopeToRequester            = flag.Bool("scopeToRequester", false, "True to only count the resource kinds the requesting user may list in the namespace, as reviewed with a SubjectAccessReview.")
+	requireContentAuthz         = flag.Bool("requireContentAuthz", false, "True to deny more strongly the deletions of namespaces holding blocking resources the requesting user may not delete, as reviewed with a SubjectAccessReview per kind.")
+	impersonateUser             = flag.Bool("impersonateUser", false, "True to list the resources impersonating the requesting user, not counting the resource kinds the user may not list.")
+	guardConsulServices         = flag.Bool("guardConsulServices", false, "True to also reject deletions of namespaces referenced by a k8s-namespace=<namespace> tag of a service in the Consul catalog.")
+	consulEndpoint              = flag.String("consulEndpoint", "http://consul:8500", "The Consul HTTP API URL listing the catalog services checked by guardConsulServices.")
+	configFile                  = flag.String("configFile", "", "The YAML file defining the policy profiles served on /validate/<profile>.")
+	policyHistorySecret         = flag.String("policyHistorySecret", "", "The <namespace>/<name> of the Secret recording the versions of the config file, no history is kept when empty.")
+	policyHistoryLimit          = flag.Int("policyHistoryLimit", 10, "The number of config file versions kept in the policyHistorySecret.")
+	denyImpersonatedDeletes     = flag.Bool("denyImpersonatedDeletes", false, "True to reject the namespace deletions impersonated by another identity than the impersonatorAllowlist.")
+	impersonatorAllowlist       = flag.String("impersonatorAllowlist", "", "The comma separated identities allowed to impersonate namespace deletions with denyImpersonatedDeletes.")
+	impersonatorExtraKeys       = flag.String("impersonatorExtraKeys", "impersonated-by,original-user", "The comma separated keys of the extra user info recording the identity impersonating the requester, matched case-insensitively.")
+	ownerKind                   = flag.String("ownerKind", "", "The kind of the ownerReferences marking the namespaces created by a controller, e.g. Tenant, which only the ownerControllers may delete.")
+	ownerAPIVersion             = flag.String("ownerAPIVersion", "", "The apiVersion the ownerReferences of the ownerKind must also match, e.g. tenancy.example.com/v1, any when empty.")
+	ownerAnnotation             = flag.String("ownerAnnotation", "", "The annotation naming the object owning the namespaces created by a controller, which only the ownerControllers may delete.")
+	ownerControllers            = flag.String("ownerControllers", "", "The comma separated users allowed to delete the namespaces marked by the ownerKind or ownerAnnotation, e.g. system:serviceaccount:tenancy:tenant-operator.")
+	deleterClusterRole          = flag.String("deleterClusterRole", "", "The ClusterRole, e.g. admin, whose subjects bound by the RoleBindings of a namespace are the only users allowed to delete it along with the breakGlassGroups. Anyone may delete the namespaces when empty.")
+	deleterCacheTTL             = flag.Duration("deleterCacheTTL", 30*time.Second, "The time the subjects bound to the deleterClusterRole in a namespace are cached for.")
+	breakGlassGroups            = flag.String("breakGlassGroups", "", "The comma separated groups allowed to delete any namespace with deleterClusterRole.")
+	authorizeBypass             = flag.Bool("authorizeBypass", false, "True to only allow the namespace UPDATEs setting the bypass annotation or the warn enforcement label by users authorized the bypass verb on namespaces in the k8s-namespace-guard.admission.yahoo.com API group.")
+	forceDeleteEnabled          = flag.Bool("forceDeleteEnabled", false, "True to allow namespace deletions requested and approved by two different users through the force delete annotations.")
+	preDeleteHook               = flag.String("preDeleteHook", "", "The URL posted the namespace and user of every deletion passing the resource checks, the deletion is rejected unless it responds 200.")
+	datadogAPIKey               = flag.String("datadogApiKey", "", "The Datadog API key used to post an event for each denied namespace deletion, no events are posted when empty.")
+	datadogAPIURL               = flag.String("datadogApiURL", "https://api.datadoghq.com", "The Datadog API URL.")
+	datadogStatsdHost           = flag.String("datadogStatsdHost", "", "The host of the Datadog agent the admission metrics are sent to over DogStatsD, none are sent when empty.")
+	datadogStatsdPort           = flag.Int("datadogStatsdPort", 8125, "The DogStatsD UDP port of the Datadog agent.")
+	timeseriesEndpoint          = flag.String("timeseriesEndpoint", "", "The InfluxDB compatible URL the namespace deletion attempts are written to, none are written when empty.")
+	timeseriesDB                = flag.String("timeseriesDB", "namespace_guard", "The database of the timeseriesEndpoint.")
+	timeseriesToken             = flag.String("timeseriesToken", "", "The token authenticating the writes to the timeseriesEndpoint.")
+	timeseriesBatchInterval     = flag.Duration("timeseriesBatchInterval", 30*time.Second, "How often the deletion attempts are written to the timeseriesEndpoint.")
+	cloudEventsSink             = flag.String("cloudEventsSink", "", "The HTTP URL every admission decision is posted to as a CloudEvent, none are posted when empty.")
+	cloudEventsQueueSize        = flag.Int("cloudEventsQueueSize", 1000, "The number of decision events queued for the cloudEventsSink, the events are dropped once the queue is full.")
+	cloudEventsMaxRetries       = flag.Int("cloudEventsMaxRetries", 5, "The number of times the delivery of a decision event to the cloudEventsSink is retried, with an exponential backoff.")
+	snapshotNamespace           = flag.String("snapshotNamespace", "", "The namespace archiving a ConfigMap snapshot of every namespace whose deletion is allowed, snapshots are only logged when empty.")
+	appealNamespace             = flag.String("appealNamespace", "", "The namespace storing a ConfigMap for every appeal recorded through /appeal, the endpoint is disabled when empty.")
+	deleteTokenKeyFile          = flag.String("deleteTokenKeyFile", "", "The file holding the key signing the delete tokens issued through /deletetoken. Once set, every namespace deletion requires a valid delete token annotation.")
+	deleteTokenTTL              = flag.Duration("deleteTokenTTL", time.Hour, "The time a delete token issued through /deletetoken is valid for.")
+	deleteTokenApprovers        = flag.String("deleteTokenApprovers", "", "The comma separated users allowed to issue delete tokens with POST /deletetoken, authenticated by their bearer token.")
+	recordDeletionAttempts      = flag.Bool("recordDeletionAttempts", false, "True to record every namespace deletion decision as a NamespaceDeletionAttempt object, see example/namespacedeletionattempts-crd.yaml.")
+	attemptMaxAge               = flag.Duration("attemptMaxAge", 30*24*time.Hour, "The age past which the NamespaceDeletionAttempt objects are pruned, none when 0.")
+	attemptMaxCount             = flag.Int("attemptMaxCount", 1000, "The number of NamespaceDeletionAttempt objects kept, the oldest being pruned first, all when 0.")
+	attemptPruneInterval        = flag.Duration("attemptPruneInterval", 10*time.Minute, "How often the NamespaceDeletionAttempt objects are pruned.")
+	watchGuardExceptions        = flag.Bool("guardExceptions", false, "True to waive the checks of the namespaces matched by unexpired GuardException objects, see example/guardexceptions-crd.yaml.")
+	exceptionReconcileInterval  = flag.Duration("exceptionReconcileInterval", time.Minute, "How often the expired GuardException objects are marked with the Expired condition.")
+	appealURL                   = flag.String("appealURL", "", "The external URL of the webhook service surfaced in the denials to appeal them through /appeal, e.g. https://namespace-guard.example.com.")
+	checkRBAC                   = flag.Bool("checkRBAC", true, "True to check at startup the permissions needed by the enabled features with SelfSubjectAccessReviews and log the missing ones.")
+	rbacReadiness               = flag.Bool("rbacReadiness", false, "True to respond 503 on /readyz while the service account is missing any permission needed by the enabled features, checking them again on every probe until granted.")
+	requireRBAC                 = flag.Bool("requireRBAC", false, "True to exit at startup if the service account is missing any permission needed by the enabled features.")
+	celCostLimit                = flag.Uint64("celCostLimit", 10000, "The cost above which the evaluation of a CEL rule of the configFile fails, rejecting the deletion.")
+	regoPolicy                  = flag.String("regoPolicy", "", "The .rego file, or directory of .rego files such as a mounted ConfigMap, whose data.namespace_guard.deny messages deny the deletions passing the resource checks. No policy is evaluated when empty.")
+	regoTimeout                 = flag.Duration("regoTimeout", 100*time.Millisecond, "The time allowed to evaluate the Rego policies, the deletion is rejected when exceeded.")
+	regoReloadInterval          = flag.Duration("regoReloadInterval", 30*time.Second, "How often the regoPolicy files are checked for changes and recompiled, never when 0.")
 
 	clientset kubernetes.Interface
 
@@ -35,76 +216,591 @@ var (
 )
 
 func init() {
+	flag.Var(&listenAddresses, "listenAddress", "The address of an HTTPS listener, e.g. [::]:8443 or a pod IP, repeated or comma separated for several listeners. Defaults to all interfaces on port.")
 	flag.Parse()
 	log = getLogger(*logFilename, *logLevel)
 }
 
-// statusHandler serves the /status.html response which is always 200.
+// statusResponse is the JSON response of /status.html, reporting the health of each component
+type statusResponse struct {
+	Status     string            `json:"status"`
+	Version    string            `json:"version"`
+	Components map[string]string `json:"components"`
+}
+
+// flagPassed returns true if the flag was set on the command line
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// statusWantsJSON returns true if the status is served as JSON, with --statusFormat=json or when the
+// request accepts application/json
+func statusWantsJSON(req *http.Request) bool {
+	return *statusFormat == statusFormatJSON || strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// statusHandler serves the /status.html response which is always 200, unless --failStatusOnExpiredCert
+// is set and a certificate has expired.
 func statusHandler(rw http.ResponseWriter, req *http.Request) {
 	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+	expired := monitoredCerts.anyExpired()
+	failed := *failStatusOnExpiredCert && expired
+	if statusWantsJSON(req) {
+		resp := statusResponse{Status: "ok", Version: version, Components: map[string]string{"certificates": "ok", "apiserver": "ok"}}
+		if expired {
+			resp.Components["certificates"] = "expired"
+		}
+		if (breaker != nil && breaker.current() != circuitClosed) || (failsafe != nil && failsafe.active()) {
+			resp.Components["apiserver"] = "unreachable"
+		}
+		code := http.StatusOK
+		if failed {
+			resp.Status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		writeJSON(rw, code, resp)
+		return
+	}
+	if failed {
+		http.Error(rw, "Certificate expired", http.StatusServiceUnavailable)
+		return
+	}
 	io.WriteString(rw, "OK")
 }
 
 func main() {
 
-	// creates the k8s in-cluster config
-	config, err := rest.InClusterConfig()
+	if *nonDeleteAction != nonDeleteAllow && *nonDeleteAction != nonDeleteDeny {
+		log.Fatalf("Invalid nonDeleteAction %s, it must be either %s or %s", *nonDeleteAction, nonDeleteAllow, nonDeleteDeny)
+	}
+	if *statusFormat != statusFormatText && *statusFormat != statusFormatJSON {
+		log.Fatalf("Invalid statusFormat %s, it must be either %s or %s", *statusFormat, statusFormatText, statusFormatJSON)
+	}
+	if *resourceAgeCutoffCounts != ageCutoffNewer && *resourceAgeCutoffCounts != ageCutoffOlder {
+		log.Fatalf("Invalid resourceAgeCutoffCounts %q, it must be %s or %s", *resourceAgeCutoffCounts, ageCutoffNewer, ageCutoffOlder)
+	}
+	if _, err := labels.Parse(*systemManagedSelector); err != nil {
+		log.Fatalf("Invalid systemManagedSelector %s: %s", *systemManagedSelector, err.Error())
+	}
+	if *gitReport && (*gitRepoURL == "" || *gitCommitEmail == "") {
+		log.Fatalf("gitReport requires gitRepoURL and gitCommitEmail")
+	}
+	if *enforcementPercent < 0 || *enforcementPercent > 100 {
+		log.Fatalf("Invalid enforcementPercent %d, it must be between 0 and 100", *enforcementPercent)
+	}
+	if err := validateGuardProfile(*guardProfile); err != nil {
+		log.Fatalf("Invalid guardProfile: %s", err.Error())
+	}
+	if ignored := guardFlagsIgnored(*guardProfile); len(ignored) > 0 {
+		log.Warnf("guardProfile is %s, the %v flags are ignored. Set guardProfile to %s to enable them.", *guardProfile, ignored, guardProfileCustom)
+	}
+	if *maxImpactScore < 0 {
+		log.Fatalf("Invalid maxImpactScore %d, it must not be negative", *maxImpactScore)
+	}
+	if *countParallelism < 1 {
+		log.Fatalf("Invalid countParallelism %d, it must be at least 1", *countParallelism)
+	}
+	if *softThresholdPercentage < 0 || *softThresholdPercentage > 100 {
+		log.Fatalf("Invalid softThresholdPercentage %d, it must be between 0 and 100", *softThresholdPercentage)
+	}
+	if *softThresholdEnabled && *scanInterval <= 0 {
+		log.Warnf("softThresholdEnabled is set but scanInterval is not, no DeletionAtRisk events will be emitted.")
+	}
+	if *softThresholdEnabled && *maxResourceCount <= 0 {
+		log.Warnf("softThresholdEnabled is set but maxResourceCount is %d, no DeletionAtRisk events will be emitted.", *maxResourceCount)
+	}
+	if conflicts := admitAllConflicts(); len(conflicts) > 0 {
+		log.Warnf("admitAll is set, EVERY namespace deletion is admitted without validation and the %v flags are IGNORED. Unset admitAll to enforce them.", conflicts)
+	}
+	if *insecureHTTP {
+		if *clientAuth {
+			log.Fatalf("insecureHTTP and clientAuth are mutually exclusive, client certificates can't be verified without TLS")
+		}
+		log.Warnf("insecureHTTP is set, the admission webhook is served over PLAIN HTTP WITHOUT TLS. Only use it behind a sidecar terminating mTLS.")
+	}
+	if *noTLS {
+		if *clientAuth {
+			log.Fatalf("noTLS and clientAuth are mutually exclusive, client certificates can't be verified without TLS")
+		}
+		log.Warnf("noTLS is set, the admission webhook is served over PLAIN HTTP WITHOUT TLS and accepts admission reviews from ANYONE. This mode is INSECURE and only meant for local development, NEVER use it in production.")
+	}
+	// plainHTTP is true if the listeners serve HTTP, behind a sidecar or for local development
+	plainHTTP := *insecureHTTP || *noTLS
+	if *spiffe {
+		if plainHTTP {
+			log.Fatalf("spiffe is mutually exclusive with insecureHTTP and noTLS, the SVID is only served over TLS")
+		}
+		if flagPassed("certFile") || flagPassed("keyFile") {
+			log.Fatalf("spiffe is mutually exclusive with certFile and keyFile, the serving certificate is the SVID")
+		}
+		if *spiffeTrustBundle && flagPassed("clientCAFile") {
+			log.Fatalf("spiffeTrustBundle is mutually exclusive with clientCAFile, the client certificates are verified with the trust bundle")
+		}
+	} else if *spiffeTrustBundle || *spiffeSocket != "" {
+		log.Fatalf("spiffeTrustBundle and spiffeSocket require spiffe")
+	}
+	if ownerMarkerConfigured() && strings.TrimSpace(*ownerControllers) == "" {
+		log.Fatalf("ownerKind and ownerAnnotation require ownerControllers, the owned namespaces could never be deleted")
+	}
+	if *ownerAPIVersion != "" && *ownerKind == "" {
+		log.Fatalf("ownerAPIVersion requires ownerKind")
+	}
+	socketMode, err := parseSocketMode(*unixSocketMode)
+	if err != nil {
+		log.Fatalf("Invalid unixSocketMode: %s", err.Error())
+	}
+	bypassValueRegex, err = parseBypassPattern(*bypassPattern)
+	if err != nil {
+		log.Fatalf("Invalid bypassAnnotationPattern: %s", err.Error())
+	}
+	enabledOptionalKinds, err = parseOptionalKinds(*optionalKindList)
+	if err != nil {
+		log.Fatalf("Invalid resources: %s", err.Error())
+	}
+	// configmaps are only counted when given a threshold
+	kindThresholds, err = parseKindThresholds(*kindThresholdList, append(counterKinds(resourceCounters()), "configmaps"))
+	if err != nil {
+		log.Fatalf("Invalid kindThresholds: %s", err.Error())
+	}
+	kindWeights, err = parseKindWeights(*kindWeightList, counterKinds(resourceCounters()))
+	if err != nil {
+		log.Fatalf("Invalid kindWeights: %s", err.Error())
+	}
+	expiryThresholds, err := parseExpiryThresholds(*certExpiryThresholds)
+	if err != nil {
+		log.Fatalf("Invalid certExpiryThresholds: %s", err.Error())
+	}
+	if *deleteTokenKeyFile != "" {
+		deleteTokenKey, err = loadDeleteTokenKey(*deleteTokenKeyFile)
+		if err != nil {
+			log.Fatalf("Unable to read the deleteTokenKeyFile %s: %s", *deleteTokenKeyFile, err.Error())
+		}
+		if strings.TrimSpace(*deleteTokenApprovers) == "" {
+			log.Warnf("deleteTokenKeyFile is set without deleteTokenApprovers, no delete token can be issued and EVERY namespace deletion is rejected.")
+		}
+	}
+	if *regoPolicy != "" {
+		policies = &regoPolicies{path: *regoPolicy, timeout: *regoTimeout}
+		if _, err := policies.load(); err != nil {
+			log.Fatalf("Unable to load the regoPolicy %s: %s", *regoPolicy, err.Error())
+		}
+	}
+
+	// creates the clientset, in-cluster unless --kubeconfig is set, and checks the apiserver is
+	// reachable within --startupTimeout before binding the listeners
+	clientset, err = newKubernetesClientset(*kubeconfig, *startupTimeout)
 	if err != nil {
-		log.Fatalf("Error occurred while building the in-cluster kube-config: %s", err.Error())
+		log.Fatalf("Unable to connect to the cluster: %s", err.Error())
+	}
+
+	// list the resources as the requesting user if --impersonateUser is set
+	if *impersonateUser {
+		impersonationConfig, err = getKubernetesConfig(*kubeconfig)
+		if err != nil {
+			log.Fatalf("Unable to build the impersonation client config: %s", err.Error())
+		}
+	}
+
+	// list the optional kinds enabled by --resources, record the deletion attempts and watch the
+	// GuardExceptions with the dynamic client
+	if len(enabledOptionalKinds) > 0 || *recordDeletionAttempts || *watchGuardExceptions {
+		config, err := getKubernetesConfig(*kubeconfig)
+		if err != nil {
+			log.Fatalf("Unable to build the dynamic client config: %s", err.Error())
+		}
+		dynamicClients = dynamic.NewDynamicClientPool(config)
+	}
+	if *recordDeletionAttempts {
+		attempts = &attemptRecorder{clients: dynamicClients, maxAge: *attemptMaxAge, maxCount: *attemptMaxCount, now: time.Now}
+	}
+
+	// check the permissions needed by the enabled features, exiting if --requireRBAC=true or holding
+	// the readiness back if --rbacReadiness=true
+	if *checkRBAC {
+		if err := verifyRBAC(); err != nil {
+			if *requireRBAC {
+				log.Fatalf("RBAC self-check failed: %s", err.Error())
+			}
+			log.Warnf("RBAC self-check failed: %s", err.Error())
+			if *rbacReadiness {
+				rbacGate = &permissionGate{pending: true}
+			}
+		}
+	} else if *requireRBAC || *rbacReadiness {
+		log.Warnf("checkRBAC is false, the requireRBAC and rbacReadiness flags are ignored.")
+	}
+
+	// track the deletions per user if --userDeletionQuota is set
+	if *userDeletionQuota > 0 {
+		var exempt []string
+		for _, user := range strings.Split(*quotaExemptUsers, ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				exempt = append(exempt, user)
+			}
+		}
+		userQuota = newDeletionQuota(*userDeletionQuota, exempt, *quotaConfigMap, time.Now)
+		if *quotaConfigMap != "" {
+			if err := userQuota.load(); err != nil {
+				log.Fatalf("Unable to load the deletion quota from %s: %s", *quotaConfigMap, err.Error())
+			}
+		}
 	}
 
-	// creates the clientset
-	clientset, err = kubernetes.NewForConfig(config)
+	// only let the subjects bound in the namespace delete it if --deleterClusterRole is set
+	if *deleterClusterRole != "" {
+		namespaceDeleters = newDeleterCache(*deleterClusterRole, *deleterCacheTTL)
+	}
+
+	if *failsafeAfter > 0 {
+		failsafe = newAPIServerFailsafe(*failsafeAfter, *failsafeMinFailures, time.Now)
+	}
+
+	if *circuitBreakerDecision != degradedFailOpen && *circuitBreakerDecision != degradedFailClosed {
+		log.Fatalf("Invalid circuitBreakerDecision %s, it must be either %s or %s", *circuitBreakerDecision, degradedFailOpen, degradedFailClosed)
+	}
+	if *onError != onErrorAllow && *onError != onErrorDeny {
+		log.Fatalf("Invalid onError %s, it must be either %s or %s", *onError, onErrorAllow, onErrorDeny)
+	}
+	errorPolicies, err = parseErrorPolicies(*onErrorNamespaces)
 	if err != nil {
-		log.Fatalf("Error occurred while initializing the client set: %s", err.Error())
+		log.Fatalf("Invalid onErrorNamespaces: %s", err.Error())
+	}
+	if *timeoutFallback != timeoutFallbackAllow && *timeoutFallback != timeoutFallbackDeny {
+		log.Fatalf("Invalid timeoutFallback %s, it must be either %s or %s", *timeoutFallback, timeoutFallbackAllow, timeoutFallbackDeny)
+	}
+	if *circuitBreakerFailures > 0 {
+		breaker = newCircuitBreaker(*circuitBreakerFailures, *circuitBreakerWindow, *circuitBreakerProbeInterval, time.Now)
+	}
+
+	// start the informers backing the shadow counters if --shadowCompare=true
+	stopCh := make(chan struct{})
+	if *shadowCompare {
+		factory := informers.NewSharedInformerFactory(clientset, 0)
+		shadowCounters = newShadowCounters(factory)
+		factory.Start(stopCh)
+		for informerType, synced := range factory.WaitForCacheSync(stopCh) {
+			if !synced {
+				log.Fatalf("Timed out waiting for the %v informer cache to sync", informerType)
+			}
+		}
+		log.Infof("Shadow comparison against the informer cache is enabled")
+	}
+
+	// cache the GuardExceptions with an informer and mark the expired ones in the background if
+	// --guardExceptions=true
+	if *watchGuardExceptions {
+		informer, err := newGuardExceptionInformer(dynamicClients, 0)
+		if err != nil {
+			log.Fatalf("Unable to watch the GuardExceptions: %s", err.Error())
+		}
+		go informer.Run(stopCh)
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			log.Fatalf("Timed out waiting for the GuardException informer cache to sync")
+		}
+		guardExceptions = &guardExceptionWatcher{store: informer.GetStore(), clients: dynamicClients, now: time.Now}
+		go guardExceptions.run(*exceptionReconcileInterval, stopCh)
+	}
+
+	// scan the namespaces in the background with a separate, slower client if --scanInterval is set
+	if *scanInterval > 0 {
+		config, err := getKubernetesConfig(*kubeconfig)
+		if err != nil {
+			log.Fatalf("Unable to build the scanner client config: %s", err.Error())
+		}
+		config.QPS, config.Burst = float32(*scanQPS), 1
+		scanClient, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			log.Fatalf("Unable to initialize the scanner client: %s", err.Error())
+		}
+		go (&namespaceScanner{client: scanClient}).run(*scanInterval, stopCh)
+	}
+
+	// remove the bypass annotations older than --bypassMaxAge in the background
+	if *bypassMaxAge > 0 {
+		expirer := &bypassExpirer{maxAge: *bypassMaxAge, dryRun: *expireBypassDryRun, now: time.Now}
+		go expirer.run(*bypassExpiryInterval, stopCh)
+	}
+
+	// prune the NamespaceDeletionAttempt objects past their retention in the background
+	if attempts != nil && (*attemptMaxAge > 0 || *attemptMaxCount > 0) {
+		go attempts.run(*attemptPruneInterval, stopCh)
+	}
+
+	// recompile the Rego policies when their files change
+	if policies != nil && *regoReloadInterval > 0 {
+		go policies.run(*regoReloadInterval, stopCh)
 	}
 
 	// add the serving path handlers
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status.html", statusHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/explain", explainHandler)
+	mux.HandleFunc("/debug/config", debugConfigHandler)
+	mux.HandleFunc("/debug/quota", debugQuotaHandler)
+	mux.HandleFunc("/debug/recent-decisions", recentDecisionsHandler)
+	mux.HandleFunc("/debug/loglevel", logLevelHandler)
+	mux.HandleFunc("/appeal", appealHandler)
+	mux.HandleFunc("/deletetoken", deleteTokenHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// bind each policy profile of the config file to its own path
+	if *configFile != "" {
+		config, data, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Unable to load the config file %s: %s", *configFile, err.Error())
+		}
+		for kind, weight := range config.Weights {
+			kindWeights[kind] = weight
+		}
+		celRules = config.Rules
+		currentPolicy = policyVersion{Config: string(data)}
+		// record the config in the policy history to allow rolling it back, unless only deciding a dry run
+		// or a report
+		if *policyHistorySecret != "" && *dryRun == "" && !*gitReport {
+			version, err := recordPolicyVersion(*policyHistorySecret, data, *policyHistoryLimit, time.Now())
+			if err != nil {
+				log.Errorf("Error occurred while recording the policy version in %s: %s", *policyHistorySecret, err.Error())
+			} else {
+				currentPolicy = version
+				log.Infof("Loaded policy version %d recorded on %s", version.Version, version.Timestamp.Format(time.RFC3339))
+			}
+		}
+		registerProfiles(mux, config)
+	}
 	mux.HandleFunc("/", webhookHandler)
 
-	// load the https server cert and key
-	xcert, err := tls.LoadX509KeyPair(*httpsCertFile, *httpsKeyFile)
-	if err != nil {
-		log.Fatalf("Unable to read the server cert and/or key file: %s", err.Error())
+	// decide the deletion of the --dryRun namespace as the webhook would and exit instead of serving
+	if *dryRun != "" {
+		var groups []string
+		for _, group := range strings.Split(*dryRunGroups, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+		os.Exit(runDryRun(os.Stdout, *dryRun, *dryRunProfile, authenticationv1.UserInfo{Username: *dryRunUser, Groups: groups}))
 	}
 
-	// load the cluster CA that signs the client(apiserver) cert
-	caCert, err := ioutil.ReadFile(*clientCAFile)
-	if err != nil {
-		log.Fatalf("Couldn't load file: %s", err.Error())
+	// commit the inventory of the namespaces to the --gitRepoURL and exit instead of serving
+	if *gitReport {
+		if err := runGitReport(); err != nil {
+			log.Fatalf("Error occurred while committing the git report: %s", err.Error())
+		}
+		os.Exit(0)
 	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
+	// create the TLS config of the https server, unless --insecureHTTP leaves TLS to a sidecar or
+	// --noTLS serves plain HTTP for local development
+	var tlsConfig *tls.Config
+	var leaf *x509.Certificate
+	if *spiffe {
+		// serve the SVID of the SPIFFE Workload API, rotated as it renews, if --spiffe=true
+		spiffeSource = newSVIDWatcher()
+		spiffeSource.monitorBundle = *spiffeTrustBundle
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-stopCh
+			cancel()
+		}()
+		go spiffeSource.watch(ctx, *spiffeSocket)
+		if err := spiffeSource.waitForSVID(*startupTimeout); err != nil {
+			log.Fatalf("Unable to obtain an SVID from the SPIFFE Workload API: %s", err.Error())
+		}
+		var clientCAs *x509.CertPool
+		if !*spiffeTrustBundle {
+			caCert, err := ioutil.ReadFile(*clientCAFile)
+			if err != nil {
+				log.Fatalf("Couldn't load file: %s", err.Error())
+			}
+			caExpiry, err := earliestExpiry(caCert)
+			if err != nil {
+				log.Fatalf("Unable to parse the client CA file %s: %s", *clientCAFile, err.Error())
+			}
+			monitoredCerts.set(clientCACertificateName, caExpiry)
+			clientCAs = x509.NewCertPool()
+			clientCAs.AppendCertsFromPEM(caCert)
+		}
+		go monitoredCerts.monitor(expiryThresholds, stopCh)
+		cert, _ := spiffeSource.certificate()
+		leaf = cert.Leaf
+		tlsConfig = spiffeSource.tlsConfig(clientCAs, *spiffeTrustBundle)
+	} else if !plainHTTP {
+		// load the https server cert and key
+		xcert, err := tls.LoadX509KeyPair(*httpsCertFile, *httpsKeyFile)
+		if err != nil {
+			log.Fatalf("Unable to read the server cert and/or key file: %s", err.Error())
+		}
 
-	// create the TLS config for the https server
-	tlsConfig := &tls.Config{
-		RootCAs:      caCertPool,
-		Certificates: []tls.Certificate{xcert},
-		ClientCAs:    caCertPool,
+		// load the cluster CA that signs the client(apiserver) cert
+		caCert, err := ioutil.ReadFile(*clientCAFile)
+		if err != nil {
+			log.Fatalf("Couldn't load file: %s", err.Error())
+		}
+
+		// monitor the expiry of the server cert and the cluster CA
+		leaf, err = x509.ParseCertificate(xcert.Certificate[0])
+		if err != nil {
+			log.Fatalf("Unable to parse the server cert: %s", err.Error())
+		}
+		monitoredCerts.set(servingCertificateName, leaf.NotAfter)
+		caExpiry, err := earliestExpiry(caCert)
+		if err != nil {
+			log.Fatalf("Unable to parse the client CA file %s: %s", *clientCAFile, err.Error())
+		}
+		monitoredCerts.set(clientCACertificateName, caExpiry)
+		go monitoredCerts.monitor(expiryThresholds, stopCh)
+
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig = &tls.Config{
+			RootCAs:      caCertPool,
+			Certificates: []tls.Certificate{xcert},
+			ClientCAs:    caCertPool,
+		}
+		// enable client(apiserver) certificate verification if --clientAuth=true
+		if *clientAuth {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
 	}
-	// enable client(apiserver) certificate verification if --clientAuth=true
-	if *clientAuth {
-		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	// send the admission metrics to the Datadog agent if --datadogStatsdHost is set
+	if *datadogStatsdHost != "" {
+		address := net.JoinHostPort(*datadogStatsdHost, strconv.Itoa(*datadogStatsdPort))
+		dogstatsd, err = newDogstatsdClient(address)
+		if err != nil {
+			log.Warnf("Unable to reach the Datadog agent at %s, admission metrics are not sent: %s", address, err.Error())
+		}
+	}
+
+	// publish the admission decisions if --cloudEventsSink is set, the events still queued on shutdown
+	// being sent along with the background records
+	if *cloudEventsSink != "" {
+		cloudEvents = newCloudEventsPublisher(*cloudEventsSink, *cloudEventsQueueSize, *cloudEventsMaxRetries)
+		runInBackground(func() { cloudEvents.run(stopCh) })
+	}
+
+	// write the deletion attempts in batches if --timeseriesEndpoint is set
+	if *timeseriesEndpoint != "" {
+		timeseries = newTimeseriesWriter(*timeseriesEndpoint, *timeseriesDB, *timeseriesToken)
+		go timeseries.run(*timeseriesBatchInterval, stopCh)
+	}
+
+	// check the webhook configuration registering the service if --webhookConfigName is set
+	if *webhookConfigName != "" {
+		checker := &webhookConfigChecker{
+			name:        *webhookConfigName,
+			service:     *webhookService,
+			servingCert: leaf,
+			repair:      *manageWebhookConfig,
+		}
+		if *webhookCAFile != "" {
+			checker.caBundle, err = ioutil.ReadFile(*webhookCAFile)
+			if err != nil {
+				log.Fatalf("Unable to read the webhook CA file %s: %s", *webhookCAFile, err.Error())
+			}
+		}
+		go checker.watch(stopCh)
 	}
 
 	// create the https server object
+	conns := newConnTracker()
 	srv := &http.Server{
-		Addr:      ":" + *port,
 		Handler:   mux,
 		TLSConfig: tlsConfig,
+		ConnState: conns.track,
+	}
+
+	if *unixSocket != "" {
+		// serve plain http on the unix socket if --unixSocket is set, e.g. for an apiserver on the same node
+		listener, err := listenUnixSocket(*unixSocket, socketMode)
+		if err != nil {
+			log.Fatalf("Unable to listen on the unix socket %s: %s", *unixSocket, err.Error())
+		}
+		go func() {
+			err := srv.Serve(listener)
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		log.Infof("HTTP server listening on unix socket: %s with mode: %s", *unixSocket, socketMode)
+	} else {
+		// start the https server, or http with --insecureHTTP or --noTLS, on every --listenAddress or on all
+		// interfaces of --port by default, --httpPort with --noTLS
+		addresses := []string(listenAddresses)
+		if len(addresses) == 0 && *noTLS {
+			addresses = []string{":" + *httpPort}
+		} else if len(addresses) == 0 {
+			addresses = []string{":" + *port}
+		}
+		// parse the PROXY protocol header of the connections from trusted load balancers if --proxyProtocol=true
+		var wrap func(net.Listener) net.Listener
+		if *proxyProtocol {
+			trusted, err := parseCIDRs(*proxyProtocolTrustedCIDRs)
+			if err != nil || len(trusted) == 0 {
+				log.Fatalf("Invalid proxyProtocolTrustedCIDRs %q, at least one valid CIDR is required", *proxyProtocolTrustedCIDRs)
+			}
+			wrap = func(listener net.Listener) net.Listener {
+				return &proxyListener{Listener: listener, trusted: trusted, strict: *proxyProtocolStrict}
+			}
+		}
+		var listeners []net.Listener
+		if plainHTTP {
+			listeners, err = listenTCP(addresses, wrap)
+		} else {
+			listeners, err = listenTLS(addresses, tlsConfig, wrap)
+		}
+		if err != nil {
+			log.Fatalf("Unable to start the server: %s", err.Error())
+		}
+		for _, listener := range listeners {
+			go func(listener net.Listener) {
+				err := srv.Serve(listener)
+				if err != nil && err != http.ErrServerClosed {
+					log.Fatal(err)
+				}
+			}(listener)
+		}
+		listenerHealth = &listenerProbe{addresses: probeAddresses(listeners), tls: !plainHTTP, timeout: *healthzTimeout}
+		// serve /healthz on its own port, so that it still responds once the webhook server stops
+		if *healthzPort != "" {
+			healthMux := http.NewServeMux()
+			healthMux.HandleFunc("/healthz", healthzHandler)
+			go func() {
+				err := http.ListenAndServe(":"+*healthzPort, healthMux)
+				if err != nil {
+					log.Fatalf("Unable to serve /healthz on port %s: %s", *healthzPort, err.Error())
+				}
+			}()
+		}
+		if plainHTTP {
+			log.Warnf("HTTP server listening on: %v without TLS, INSECURE", addresses)
+		} else {
+			log.Infof("HTTPS server listening on: %v with ClientAuthEnabled: %t ", addresses, *clientAuth)
+		}
 	}
 
-	// start the https server
-	go func() {
-		err = srv.ListenAndServeTLS("", "")
+	// serve the Check RPC on --grpcAddr, with the TLS config of the webhook server unless it serves plain HTTP
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("Unable to listen on the gRPC address %s: %s", *grpcAddr, err.Error())
 		}
-	}()
-	log.Infof("HTTPS server listening on port: %s with ClientAuthEnabled: %t ", *port, *clientAuth)
+		grpcServer = newGRPCServer(tlsConfig)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatalf("Unable to serve gRPC on %s: %s", *grpcAddr, err.Error())
+			}
+		}()
+		log.Infof("gRPC server listening on: %s with TLS: %t", *grpcAddr, tlsConfig != nil)
+	}
 
 	// graceful shutdown..
 	signalChan := make(chan os.Signal, 2)
@@ -113,6 +809,24 @@ func main() {
 		select {
 		case <-signalChan:
 			log.Printf("Shutdown signal received, exiting...")
+			close(stopCh)
+			if _, err := shutdownServer(srv, conns, *shutdownTimeout); err != nil {
+				log.Errorf("Error occurred while shutting down the server: %s", err.Error())
+			}
+			if grpcServer != nil {
+				grpcServer.GracefulStop()
+			}
+			if !backgroundTasks.wait(*shutdownTimeout) {
+				log.Warnf("Background records did not complete within %v, exiting anyway", *shutdownTimeout)
+			}
+			if timeseries != nil {
+				if err := timeseries.flush(); err != nil {
+					log.Errorf("Error occurred while writing the deletion attempts to %s: %s", *timeseriesEndpoint, err.Error())
+				}
+			}
+			if *unixSocket != "" {
+				os.Remove(*unixSocket)
+			}
 			os.Exit(0)
 		}
 	}
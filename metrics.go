@@ -0,0 +1,185 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"expvar"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "namespace_guard"
+)
+
+var (
+	shadowDivergenceTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "shadow_count_divergence_total",
+			Help:      "Number of times the informer cache count of a resource kind diverged from the live count.",
+		},
+		[]string{"kind"},
+	)
+	admissionResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "admission_responses_total",
+			Help:      "Number of admission responses written, by policy profile and decision.",
+		},
+		[]string{"profile", "allowed"},
+	)
+	certificateExpirySeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_expiry_seconds",
+			Help:      "Number of seconds until the certificate expires, negative once expired.",
+		},
+		[]string{"certificate"},
+	)
+	enforcementDecisionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "enforcement_decisions_total",
+			Help:      "Number of deletions that would be rejected, by the enforce or warn bucket applied.",
+		},
+		[]string{"bucket"},
+	)
+	userDeletions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "user_deletions",
+			Help:      "Number of namespace deletions allowed within the last 24h for the top users of the deletion quota, by rank.",
+		},
+		[]string{"rank", "user"},
+	)
+	failsafeEngaged = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "failsafe_engaged",
+			Help:      "1 while namespace deletions are admitted without validation because the apiserver is unreachable, 0 otherwise.",
+		},
+	)
+	circuitBreakerState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "circuit_breaker_state",
+			Help:      "The state of the apiserver circuit breaker: 0 when closed, 1 when open, 2 while probing the apiserver.",
+		},
+	)
+	circuitBreakerShortCircuitsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "circuit_breaker_short_circuits_total",
+			Help:      "Number of admission requests answered with the degraded decision without calling the apiserver.",
+		},
+	)
+	validationTimeoutsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "validation_timeouts_total",
+			Help:      "Number of admission requests answered with the timeout fallback decision.",
+		},
+	)
+	webhookRequestDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "webhook_request_duration_seconds",
+			Help:      "Time taken to handle the admission webhook requests, by whether it exceeded the sloThresholdMs.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"request_duration_exceeded_slo"},
+	)
+	sloViolationsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "slo_violations_total",
+			Help:      "Number of admission webhook requests taking longer than the sloThresholdMs.",
+		},
+	)
+	phaseDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "phase_duration_seconds",
+			Help:      "Time spent in each phase of the admission webhook requests: decode, get, count and write.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"phase"},
+	)
+	phaseBudgetExceededTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "phase_budget_exceeded_total",
+			Help:      "Number of admission webhook request phases taking more than half of the timeout budget, by phase.",
+		},
+		[]string{"phase"},
+	)
+	exemptionUsedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "exemption_used_total",
+			Help:      "Number of requests let through by an exemption, by exemption and whether the user or one of its groups matched.",
+		},
+		[]string{"exemption", "match"},
+	)
+	namespacesScanned = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "namespaces_scanned",
+			Help:      "Number of namespaces found by the last background scan.",
+		},
+	)
+	namespacesBlocked = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "namespaces_blocked",
+			Help:      "Number of namespaces whose deletion would be blocked as of the last background scan.",
+		},
+	)
+	namespacesBlockedByKind = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "namespaces_blocked_by_kind",
+			Help:      "Number of namespaces whose deletion would be blocked holding resources of the kind, as of the last background scan.",
+		},
+		[]string{"kind"},
+	)
+	webhookConfigOK = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "webhook_config_ok",
+			Help:      "1 if the webhook configuration registering the service was found without discrepancies, 0 otherwise.",
+		},
+	)
+)
+
+// the expvar counters served on /debug/vars along with the memstats and cmdline published by expvar,
+// for the environments without Prometheus
+var (
+	admissionRequests   = expvar.NewInt("admission_requests")
+	admissionRejections = expvar.NewInt("admission_rejections")
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+
+	prometheus.MustRegister(shadowDivergenceTotal)
+	prometheus.MustRegister(admissionResponsesTotal)
+	prometheus.MustRegister(certificateExpirySeconds)
+	prometheus.MustRegister(webhookConfigOK)
+	prometheus.MustRegister(enforcementDecisionsTotal)
+	prometheus.MustRegister(userDeletions)
+	prometheus.MustRegister(failsafeEngaged)
+	prometheus.MustRegister(circuitBreakerState)
+	prometheus.MustRegister(circuitBreakerShortCircuitsTotal)
+	prometheus.MustRegister(validationTimeoutsTotal)
+	prometheus.MustRegister(webhookRequestDurationSeconds)
+	prometheus.MustRegister(sloViolationsTotal)
+	prometheus.MustRegister(phaseDurationSeconds)
+	prometheus.MustRegister(phaseBudgetExceededTotal)
+	prometheus.MustRegister(exemptionUsedTotal)
+	prometheus.MustRegister(namespacesScanned)
+	prometheus.MustRegister(namespacesBlocked)
+	prometheus.MustRegister(namespacesBlockedByKind)
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExpvarCounters(t *testing.T) {
+	review := func() {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+	}
+	requests, rejections := admissionRequests.Value(), admissionRejections.Value()
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+	review()
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	review()
+	assert.Equal(t, requests+2, admissionRequests.Value())
+	assert.Equal(t, rejections+1, admissionRejections.Value(), "should only count the rejection")
+
+	rw := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(rw, httptest.NewRequest("GET", "http://localhost:8080/debug/vars", nil))
+	var vars map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rw.Body.Bytes(), &vars))
+	for _, name := range []string{"memstats", "goroutines", "admission_requests", "admission_rejections"} {
+		assert.Contains(t, vars, name)
+	}
+}
+
+// requestDurationCount returns the number of requests observed with the exceeded label
+func requestDurationCount(exceeded string) uint64 {
+	m := &dto.Metric{}
+	webhookRequestDurationSeconds.WithLabelValues(exceeded).(prometheus.Metric).Write(m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func sloViolationsValue() float64 {
+	m := &dto.Metric{}
+	sloViolationsTotal.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestSLOViolations(t *testing.T) {
+	withinSLO, exceededSLO, violations := requestDurationCount("false"), requestDurationCount("true"), sloViolationsValue()
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw := httptest.NewRecorder()
+	webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview))))
+	assert.Equal(t, withinSLO+1, requestDurationCount("false"), "should observe the duration of the webhook requests")
+	assert.Equal(t, violations, sloViolationsValue())
+
+	req := httptest.NewRequest("POST", "http://localhost:8080/", nil)
+	observeRequestDuration(req, time.Now().Add(-2*time.Second))
+	assert.Equal(t, exceededSLO+1, requestDurationCount("true"))
+	assert.Equal(t, violations+1, sloViolationsValue(), "should count the requests slower than the sloThresholdMs")
+
+	defer func(threshold int) { *sloThresholdMs = threshold }(*sloThresholdMs)
+	*sloThresholdMs = 0
+	observeRequestDuration(req, time.Now().Add(-2*time.Second))
+	assert.Equal(t, violations+1, sloViolationsValue(), "should not count violations when the threshold is 0")
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// regoDenyQuery is the partial set rule whose messages deny a namespace deletion
+	regoDenyQuery = "data.namespace_guard.deny"
+	// regoFileExtension is the extension of the policy files loaded from the --regoPolicy directory
+	regoFileExtension = ".rego"
+)
+
+var (
+	// policies are the Rego policies loaded from --regoPolicy, nil when unset
+	policies *regoPolicies
+)
+
+// regoInput is the input document the Rego policies are evaluated against
+type regoInput struct {
+	Namespace *corev1.Namespace         `json:"namespace"`
+	Findings  []resourceFinding         `json:"findings"`
+	UserInfo  authenticationv1.UserInfo `json:"userInfo"`
+	Profile   string                    `json:"profile"`
+}
+
+// regoPolicies holds the compiled Rego policies of a file or a directory, such as a mounted ConfigMap.
+// The policies are reloaded when the files change, a bundle failing to compile keeps the previous one.
+type regoPolicies struct {
+	path    string
+	timeout time.Duration
+
+	mu       sync.RWMutex
+	compiler *ast.Compiler
+	digest   string
+}
+
+// readRegoFiles returns the content of the .rego file, or of the .rego files of the directory by name.
+// The files starting with .. are skipped, as they are the timestamped copies of a mounted ConfigMap.
+func readRegoFiles(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = nil
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), "..") || filepath.Ext(entry.Name()) != regoFileExtension {
+				continue
+			}
+			paths = append(paths, filepath.Join(path, entry.Name()))
+		}
+	}
+	files := map[string]string{}
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.Base(p)] = string(data)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no %s file found", regoFileExtension)
+	}
+	return files, nil
+}
+
+// regoDigest returns the digest of the policy files, telling whether they changed since the last load
+func regoDigest(files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, files[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// load compiles the policy files if they changed since the last load. On error the previously loaded
+// policies are kept.
+func (p *regoPolicies) load() (bool, error) {
+	files, err := readRegoFiles(p.path)
+	if err != nil {
+		return false, err
+	}
+	digest := regoDigest(files)
+	p.mu.RLock()
+	unchanged := digest == p.digest
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	modules := map[string]*ast.Module{}
+	for name, src := range files {
+		module, err := ast.ParseModule(name, src)
+		if err != nil {
+			return false, err
+		}
+		modules[name] = module
+	}
+	compiler := ast.NewCompiler()
+	compiler.Compile(modules)
+	if compiler.Failed() {
+		return false, compiler.Errors
+	}
+
+	p.mu.Lock()
+	p.compiler, p.digest = compiler, digest
+	p.mu.Unlock()
+	return true, nil
+}
+
+// run reloads the policies every interval until the stop channel is closed
+func (p *regoPolicies) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if reloaded, err := p.load(); err != nil {
+				log.Errorf("Error occurred while reloading the Rego policies from %s, keeping the previous ones: %s", p.path, err.Error())
+			} else if reloaded {
+				log.Infof("Reloaded the Rego policies from %s", p.path)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// evaluate returns the messages of the deny rule for the input, sorted. The evaluation fails if it
+// takes longer than the timeout.
+func (p *regoPolicies) evaluate(input regoInput) ([]string, error) {
+	p.mu.RLock()
+	compiler := p.compiler
+	p.mu.RUnlock()
+	if compiler == nil {
+		return nil, fmt.Errorf("no policy loaded from %s", p.path)
+	}
+
+	// the input is passed to the policies as plain JSON values
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	rs, err := rego.New(rego.Query(regoDenyQuery), rego.Compiler(compiler), rego.Input(document)).Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("the evaluation exceeded %v", p.timeout)
+	}
+
+	var messages []string
+	for _, result := range rs {
+		for _, expression := range result.Expressions {
+			values, ok := expression.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s must be a set of messages, not %v", regoDenyQuery, expression.Value)
+			}
+			for _, value := range values {
+				messages = append(messages, fmt.Sprint(value))
+			}
+		}
+	}
+	sort.Strings(messages)
+	return messages, nil
+}
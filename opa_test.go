@@ -0,0 +1,133 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	// allowAllPolicy never denies a deletion
+	allowAllPolicy = "package namespace_guard\n\ndeny[msg] {\n    false\n    msg = \"never\"\n}\n"
+	// denyAllPolicy denies every deletion
+	denyAllPolicy = "package namespace_guard\n\ndeny[msg] {\n    msg = sprintf(\"%s may not be deleted\", [input.namespace.metadata.name])\n}\n"
+)
+
+// examplePolicies returns the policies of the example directory
+func examplePolicies(t *testing.T) *regoPolicies {
+	p := &regoPolicies{path: filepath.Join("example", "policies"), timeout: time.Second}
+	_, err := p.load()
+	assert.Nil(t, err)
+	return p
+}
+
+func TestRegoPoliciesEvaluate(t *testing.T) {
+	p := examplePolicies(t)
+	admin := authenticationv1.UserInfo{Username: "admin", Groups: []string{"platform-admins"}}
+	user := authenticationv1.UserInfo{Username: "user"}
+
+	messages, err := p.evaluate(regoInput{Namespace: cloneNamespace(templateNamespace), UserInfo: user})
+	assert.Nil(t, err)
+	assert.Empty(t, messages, "should allow an empty non-production namespace")
+
+	production := cloneNamespace(templateNamespace)
+	production.Labels = map[string]string{"environment": "production"}
+	messages, err = p.evaluate(regoInput{Namespace: production, UserInfo: admin})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"the production namespace test-namespace has no namespace-guard.io/retire-approved annotation"}, messages)
+
+	production.Annotations = map[string]string{"namespace-guard.io/retire-approved": "true"}
+	findings := []resourceFinding{{Kind: "configmaps", Count: 2}, {Kind: "pods"}}
+	messages, err = p.evaluate(regoInput{Namespace: production, Findings: findings, UserInfo: admin})
+	assert.Nil(t, err)
+	assert.Empty(t, messages, "should allow the platform-admins to delete an approved namespace")
+
+	messages, err = p.evaluate(regoInput{Namespace: production, Findings: findings, UserInfo: user})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"only the platform-admins may delete the namespace test-namespace while it holds configmaps"}, messages)
+}
+
+func TestRegoPoliciesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.rego")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(allowAllPolicy), 0600))
+	// the timestamped copies of a mounted ConfigMap are skipped
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "..2017_10_01"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not a policy"), 0600))
+
+	p := &regoPolicies{path: dir, timeout: time.Second}
+	reloaded, err := p.load()
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	input := regoInput{Namespace: cloneNamespace(templateNamespace)}
+	messages, err := p.evaluate(input)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+
+	reloaded, err = p.load()
+	assert.Nil(t, err)
+	assert.False(t, reloaded, "should not recompile unchanged files")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("package namespace_guard\n\ndeny[msg] {\n"), 0600))
+	reloaded, err = p.load()
+	assert.NotNil(t, err)
+	assert.False(t, reloaded)
+	messages, err = p.evaluate(input)
+	assert.Nil(t, err)
+	assert.Empty(t, messages, "should keep the previous policies on a compile error")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(denyAllPolicy), 0600))
+	reloaded, err = p.load()
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	messages, err = p.evaluate(input)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test-namespace may not be deleted"}, messages)
+}
+
+func TestRegoPoliciesTimeout(t *testing.T) {
+	p := examplePolicies(t)
+	p.timeout = 0
+	_, err := p.evaluate(regoInput{Namespace: cloneNamespace(templateNamespace)})
+	assert.NotNil(t, err, "should fail once the time budget is exceeded")
+}
+
+func TestRegoWebhookHandler(t *testing.T) {
+	policies = examplePolicies(t)
+	defer func() { policies = nil }()
+
+	review := func() (bool, string) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	allowed, _ := review()
+	assert.True(t, allowed, "should allow the deletions the policies don't deny")
+
+	production := cloneNamespace(templateNamespace)
+	production.Labels = map[string]string{"environment": "production"}
+	clientset = fake.NewSimpleClientset(production)
+	allowed, message := review()
+	assert.False(t, allowed, "should reject the deletions the policies deny")
+	assert.Contains(t, message, "The deletion of the namespace test-namespace is denied by the policies: the production namespace test-namespace has no namespace-guard.io/retire-approved annotation.")
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+	allowed, message = review()
+	assert.False(t, allowed)
+	assert.NotContains(t, message, "denied by the policies", "should only evaluate the policies once the resource checks pass")
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// ownerMarkerConfigured returns true if the namespaces owned by a controller are told apart by the
+// --ownerKind or the --ownerAnnotation
+func ownerMarkerConfigured() bool {
+	return *ownerKind != "" || *ownerAnnotation != ""
+}
+
+// namespaceOwner returns the object owning the namespace, matched by the --ownerKind and --ownerAPIVersion
+// of its ownerReferences or by its --ownerAnnotation, empty if it has no owner marker
+func namespaceOwner(namespace *corev1.Namespace) string {
+	if *ownerKind != "" {
+		for _, ref := range namespace.OwnerReferences {
+			if ref.Kind == *ownerKind && (*ownerAPIVersion == "" || ref.APIVersion == *ownerAPIVersion) {
+				return fmt.Sprintf("%s %s", ref.Kind, ref.Name)
+			}
+		}
+	}
+	if *ownerAnnotation != "" {
+		if name := namespace.Annotations[*ownerAnnotation]; name != "" {
+			if *ownerKind != "" {
+				return fmt.Sprintf("%s %s", *ownerKind, name)
+			}
+			return fmt.Sprintf("owner %s", name)
+		}
+	}
+	return ""
+}
+
+// isOwnerController returns true if the user is one of the --ownerControllers
+func isOwnerController(username string) bool {
+	for _, controller := range strings.Split(*ownerControllers, ",") {
+		if controller = strings.TrimSpace(controller); controller != "" && controller == username {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerDeletionError returns an error if the namespace is owned by a controller and the user is not
+// one of the --ownerControllers, nil otherwise
+func ownerDeletionError(namespace *corev1.Namespace, username string) error {
+	owner := namespaceOwner(namespace)
+	if owner == "" || isOwnerController(username) {
+		return nil
+	}
+	return fmt.Errorf("The namespace %s is managed by the %s, only its controller may delete it. Please delete the %s instead and let its controller remove the namespace.", namespace.Name, owner, owner)
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const tenantOperator = "system:serviceaccount:tenancy:tenant-operator"
+
+// setOwnerFlags configures the owner marker and controller, returning a func restoring them
+func setOwnerFlags(kind, apiVersion, annotation, controllers string) func() {
+	previous := []string{*ownerKind, *ownerAPIVersion, *ownerAnnotation, *ownerControllers}
+	*ownerKind, *ownerAPIVersion, *ownerAnnotation, *ownerControllers = kind, apiVersion, annotation, controllers
+	return func() {
+		*ownerKind, *ownerAPIVersion, *ownerAnnotation, *ownerControllers = previous[0], previous[1], previous[2], previous[3]
+	}
+}
+
+// tenantNamespace returns the test namespace owned by the Tenant acme
+func tenantNamespace() *corev1.Namespace {
+	namespace := cloneNamespace(templateNamespace)
+	namespace.OwnerReferences = []v1.OwnerReference{{APIVersion: "tenancy.example.com/v1", Kind: "Tenant", Name: "acme", UID: "1234"}}
+	return namespace
+}
+
+func TestNamespaceOwner(t *testing.T) {
+	defer setOwnerFlags("Tenant", "", "", tenantOperator)()
+	assert.Equal(t, "Tenant acme", namespaceOwner(tenantNamespace()))
+	assert.Equal(t, "", namespaceOwner(cloneNamespace(templateNamespace)))
+
+	*ownerAPIVersion = "tenancy.example.com/v2"
+	assert.Equal(t, "", namespaceOwner(tenantNamespace()), "should match the apiVersion when set")
+
+	*ownerKind, *ownerAPIVersion, *ownerAnnotation = "", "", "tenancy.example.com/tenant"
+	assert.Equal(t, "", namespaceOwner(tenantNamespace()), "should ignore the ownerReferences without ownerKind")
+	annotated := cloneNamespace(templateNamespace)
+	annotated.Annotations = map[string]string{"tenancy.example.com/tenant": "acme"}
+	assert.Equal(t, "owner acme", namespaceOwner(annotated))
+	*ownerKind = "Tenant"
+	assert.Equal(t, "Tenant acme", namespaceOwner(annotated))
+
+	assert.True(t, isOwnerController(tenantOperator))
+	assert.False(t, isOwnerController("alice"))
+	assert.False(t, isOwnerController(""))
+}
+
+func TestOwnerControllerWebhookHandler(t *testing.T) {
+	review := func(namespace *corev1.Namespace, username string) (bool, string) {
+		// the pods would block the deletion by the resource checks
+		clientset = fake.NewSimpleClientset(append(namespaceWithPods(1)[1:], namespace)...)
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.UserInfo.Username = username
+		rw := httptest.NewRecorder()
+		webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec)))
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+
+	allowed, _ := review(tenantNamespace(), "alice")
+	assert.False(t, allowed, "should apply the resource checks unless an owner marker is configured")
+
+	defer setOwnerFlags("Tenant", "tenancy.example.com/v1", "", tenantOperator)()
+	allowed, _ = review(tenantNamespace(), tenantOperator)
+	assert.True(t, allowed, "should allow the deletion issued by the operator")
+
+	bypassed := tenantNamespace()
+	bypassed.Annotations = map[string]string{bypassAnnotationKey: "true"}
+	allowed, message := review(bypassed, "alice")
+	assert.False(t, allowed, "should not let the bypass annotation apply to the owned namespaces")
+	assert.Contains(t, message, "The namespace test-namespace is managed by the Tenant acme, only its controller may delete it. Please delete the Tenant acme instead and let its controller remove the namespace.")
+
+	allowed, message = review(cloneNamespace(templateNamespace), tenantOperator)
+	assert.False(t, allowed, "should check the namespaces not owned by a Tenant as usual")
+	assert.Contains(t, message, "contains one or more of these resources: [pods(1)]")
+
+	*ownerKind, *ownerAPIVersion, *ownerAnnotation = "", "", "tenancy.example.com/tenant"
+	annotated := cloneNamespace(templateNamespace)
+	annotated.Annotations = map[string]string{"tenancy.example.com/tenant": "acme"}
+	allowed, message = review(annotated, "bob")
+	assert.False(t, allowed)
+	assert.Contains(t, message, "is managed by the owner acme, only its controller may delete it.")
+	allowed, _ = review(annotated, tenantOperator)
+	assert.True(t, allowed, "should allow the operator to delete the namespaces marked by the annotation")
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"sync"
+)
+
+// countResult is the outcome of the counter of a single kind
+type countResult struct {
+	finding resourceFinding
+	err     error
+}
+
+// countInParallel runs the counters against the namespace with at most parallelism of them listing at
+// the same time. The findings and errors are returned in the order of the counters, whatever the order
+// the counters completed in. Unlike the sequential counting, the kinds are all listed even once the
+// --maxTotalCountedObjects is exceeded.
+func countInParallel(namespace string, counters []resourceCounter, parallelism int) ([]resourceFinding, []error) {
+	results := make([]countResult, len(counters))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, c := range counters {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, c resourceCounter) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			results[i].finding, results[i].err = countKind(namespace, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	var findings []resourceFinding
+	var errList []error
+	for _, r := range results {
+		if r.err != nil {
+			errList = append(errList, r.err)
+			continue
+		}
+		findings = append(findings, r.finding)
+	}
+	return findings, errList
+}
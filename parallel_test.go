@@ -0,0 +1,57 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountInParallel(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	var counters []resourceCounter
+	for i := 0; i < 8; i++ {
+		kind := fmt.Sprintf("kind%d", i)
+		// the first kinds take the longest, completing last
+		delay := time.Duration(8-i) * 5 * time.Millisecond
+		counters = append(counters, resourceCounter{kind, func(namespace string) ([]string, error) {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+			time.Sleep(delay)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			if kind == "kind5" {
+				return nil, errors.New("forbidden")
+			}
+			return []string{kind + "-object"}, nil
+		}})
+	}
+
+	findings, errList := countInParallel("test-namespace", counters, 3)
+	assert.True(t, maxRunning <= 3, "should run at most 3 counters at the same time, ran %d", maxRunning)
+	assert.True(t, maxRunning > 1, "should run the counters in parallel")
+	assert.Equal(t, []string{"kind0", "kind1", "kind2", "kind3", "kind4", "kind6", "kind7"}, counterKindsOf(findings),
+		"should return the findings in the order of the counters")
+	if assert.Len(t, errList, 1) {
+		assert.Equal(t, "kind5", errList[0].(counterError).kind)
+	}
+}
+
+func counterKindsOf(findings []resourceFinding) []string {
+	var kinds []string
+	for _, f := range findings {
+		kinds = append(kinds, f.Kind)
+	}
+	return kinds
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// the phases of the handling of an admission request
+	phaseDecode = "decode"
+	phaseGet    = "get"
+	phaseCount  = "count"
+	phaseWrite  = "write"
+
+	// apiserverWebhookTimeout is the time the apiserver waits for the webhook, the timeout budget
+	// without a --validationTimeout
+	apiserverWebhookTimeout = 30 * time.Second
+)
+
+// phaseTimer holds the time spent in each phase of the handling of an admission request
+type phaseTimer struct {
+	sync.Mutex
+	durations map[string]time.Duration
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{durations: map[string]time.Duration{}}
+}
+
+// timeoutBudget returns the time allowed to handle an admission request, the --validationTimeout if
+// set and the apiserver's webhook timeout otherwise
+func timeoutBudget() time.Duration {
+	if *validationTimeout > 0 {
+		return *validationTimeout
+	}
+	return apiserverWebhookTimeout
+}
+
+// observe records the time spent in the phase since the start in the phase histogram, counting and
+// logging the phases taking more than half of the timeout budget. The timer may be nil.
+func (p *phaseTimer) observe(phase string, start time.Time) {
+	elapsed := time.Since(start)
+	phaseDurationSeconds.WithLabelValues(phase).Observe(elapsed.Seconds())
+	if budget := timeoutBudget(); elapsed > budget/2 {
+		phaseBudgetExceededTotal.WithLabelValues(phase).Inc()
+		log.Warnf("The %s phase of the admission request took %v, more than half of the %v timeout budget.", phase, elapsed, budget)
+	}
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.durations[phase] += elapsed
+}
+
+// breakdown returns the milliseconds spent in each phase so far, nil for a nil timer
+func (p *phaseTimer) breakdown() map[string]int64 {
+	if p == nil {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	breakdown := make(map[string]int64, len(p.durations))
+	for phase, d := range p.durations {
+		breakdown[phase] = int64(d / time.Millisecond)
+	}
+	return breakdown
+}
+
+// requestPhases returns the phase timer of the request written to, nil if unknown
+func requestPhases(rw http.ResponseWriter) *phaseTimer {
+	switch w := rw.(type) {
+	case *timedWriter:
+		return w.phases
+	case *deadlineWriter:
+		return w.phases
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func phaseDurationCount(phase string) uint64 {
+	m := &dto.Metric{}
+	phaseDurationSeconds.WithLabelValues(phase).(prometheus.Metric).Write(m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func phaseBudgetExceededValue(phase string) float64 {
+	m := &dto.Metric{}
+	phaseBudgetExceededTotal.WithLabelValues(phase).Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestPhaseTimer(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Out
+	log.Out = &buf
+	defer func() { log.Out = out }()
+	defer func(timeout time.Duration) { *validationTimeout = timeout }(*validationTimeout)
+	*validationTimeout = time.Second
+
+	count, exceeded := phaseBudgetExceededValue(phaseCount), phaseBudgetExceededValue(phaseGet)
+	p := newPhaseTimer()
+	p.observe(phaseGet, time.Now().Add(-100*time.Millisecond))
+	p.observe(phaseCount, time.Now().Add(-600*time.Millisecond))
+	p.observe(phaseCount, time.Now().Add(-200*time.Millisecond))
+
+	breakdown := p.breakdown()
+	assert.True(t, breakdown[phaseGet] >= 100)
+	assert.True(t, breakdown[phaseCount] >= 800, "should add up the time spent in a phase")
+	assert.Equal(t, count+1, phaseBudgetExceededValue(phaseCount), "should count the phases taking more than half of the budget")
+	assert.Equal(t, exceeded, phaseBudgetExceededValue(phaseGet))
+	assert.Contains(t, buf.String(), "The count phase of the admission request took")
+	assert.Contains(t, buf.String(), "more than half of the 1s timeout budget.")
+
+	var nilTimer *phaseTimer
+	nilTimer.observe(phaseWrite, time.Now())
+	assert.Nil(t, nilTimer.breakdown())
+}
+
+func TestTimeoutBudget(t *testing.T) {
+	defer func(timeout time.Duration) { *validationTimeout = timeout }(*validationTimeout)
+	*validationTimeout = 0
+	assert.Equal(t, apiserverWebhookTimeout, timeoutBudget())
+	*validationTimeout = 10 * time.Second
+	assert.Equal(t, 10*time.Second, timeoutBudget())
+}
+
+func TestPhasesWebhookHandler(t *testing.T) {
+	recentDecisions = newDecisionLog(maxRecentDecisions)
+	counts := map[string]uint64{}
+	for _, phase := range []string{phaseDecode, phaseGet, phaseCount, phaseWrite} {
+		counts[phase] = phaseDurationCount(phase)
+	}
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw := httptest.NewRecorder()
+	webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview))))
+	assert.True(t, getAdmissionReview(rw).Status.Allowed)
+
+	for phase, count := range counts {
+		assert.Equal(t, count+1, phaseDurationCount(phase), "should observe the %s phase", phase)
+	}
+	if decisions := recentDecisions.recent(1); assert.Len(t, decisions, 1) {
+		for _, phase := range []string{phaseDecode, phaseGet, phaseCount, phaseWrite} {
+			assert.Contains(t, decisions[0].PhasesMs, phase, "should record the breakdown of the decision")
+		}
+	}
+}
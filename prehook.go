@@ -0,0 +1,59 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+const (
+	preDeleteHookTimeout = 10 * time.Second
+	// preDeleteHookMaxReason is the number of bytes of the hook's response read as the rejection
+	// reason without a --maxMessageBytes
+	preDeleteHookMaxReason = 4096
+)
+
+var (
+	preDeleteHookClient = &http.Client{Timeout: preDeleteHookTimeout}
+)
+
+// preDeleteHookRequest is the body posted to the --preDeleteHook
+type preDeleteHookRequest struct {
+	Namespace string                    `json:"namespace"`
+	UserInfo  authenticationv1.UserInfo `json:"userInfo"`
+}
+
+// callPreDeleteHook asks the external hook to approve the namespace deletion. It returns an error
+// with the hook's response body, up to the message limit, as the reason if the hook does not respond 200.
+func callPreDeleteHook(url, namespace string, userInfo authenticationv1.UserInfo) error {
+	body, err := json.Marshal(preDeleteHookRequest{Namespace: namespace, UserInfo: userInfo})
+	if err != nil {
+		return err
+	}
+	resp, err := preDeleteHookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error calling the pre-delete hook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	limit := int64(preDeleteHookMaxReason)
+	if *maxMessageBytes > 0 {
+		limit = int64(*maxMessageBytes)
+	}
+	reason, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil || len(bytes.TrimSpace(reason)) == 0 {
+		return fmt.Errorf("the pre-delete hook responded %s", resp.Status)
+	}
+	return fmt.Errorf("%s", strings.TrimSpace(string(reason)))
+}
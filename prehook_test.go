@@ -0,0 +1,73 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPreDeleteHookWebhookHandler(t *testing.T) {
+	var received preDeleteHookRequest
+	approve := true
+	hook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&received))
+		if !approve {
+			rw.WriteHeader(http.StatusForbidden)
+			io.WriteString(rw, "change request CHG-42 is not approved\n")
+		}
+	}))
+	defer hook.Close()
+
+	*preDeleteHook = hook.URL
+	defer func() { *preDeleteHook = "" }()
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	testSpec.Spec.UserInfo.Groups = []string{"sre"}
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the hook responds 200")
+	assert.Equal(t, "test-namespace", received.Namespace)
+	assert.Equal(t, "alice", received.UserInfo.Username)
+	assert.Equal(t, []string{"sre"}, received.UserInfo.Groups)
+
+	approve = false
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject if the hook does not respond 200")
+	assert.Equal(t, "The deletion of the namespace test-namespace was not approved by the pre-delete hook: change request CHG-42 is not approved", admReview.Status.Result.Message)
+}
+
+func TestPreDeleteHookLongReason(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+		io.WriteString(rw, strings.Repeat("x", 10*preDeleteHookMaxReason))
+	}))
+	defer hook.Close()
+
+	err := callPreDeleteHook(hook.URL, "test-namespace", authenticationv1.UserInfo{})
+	if assert.NotNil(t, err) {
+		assert.Len(t, err.Error(), preDeleteHookMaxReason, "should only read the reason up to the limit")
+	}
+
+	*maxMessageBytes = 100
+	defer func() { *maxMessageBytes = 0 }()
+	err = callPreDeleteHook(hook.URL, "test-namespace", authenticationv1.UserInfo{})
+	if assert.NotNil(t, err) {
+		assert.Len(t, err.Error(), 100)
+	}
+}
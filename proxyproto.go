@@ -0,0 +1,212 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyHeaderTimeout bounds the time a trusted source has to send the PROXY protocol header
+	proxyHeaderTimeout = 5 * time.Second
+	// proxyV1MaxLength is the maximum length of a v1 header, including the CRLF
+	proxyV1MaxLength = 107
+)
+
+var (
+	// proxyV2Signature starts every PROXY protocol v2 header
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	proxyV1Prefix    = []byte("PROXY ")
+
+	errMissingProxyHeader = errors.New("missing PROXY protocol header")
+)
+
+// parseCIDRs parses the comma separated CIDRs
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(value, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// readProxyHeader reads the PROXY protocol v1 or v2 header at the start of the reader. It returns
+// false if there is no header, in which case nothing is consumed, and a nil address if the header
+// does not carry a TCP source address, e.g. for the LOCAL command of health checks.
+func readProxyHeader(r *bufio.Reader) (net.Addr, bool, error) {
+	peeked, _ := r.Peek(len(proxyV2Signature))
+	switch {
+	case bytes.Equal(peeked, proxyV2Signature):
+		addr, err := readProxyV2Header(r)
+		return addr, true, err
+	case bytes.HasPrefix(peeked, proxyV1Prefix):
+		addr, err := readProxyV1Header(r)
+		return addr, true, err
+	}
+	return nil, false, nil
+}
+
+// readProxyV1Header reads a human-readable header, e.g. PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading the PROXY v1 header: %v", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("the PROXY v1 header is not terminated by CRLF")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid source address in the PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2Header reads a binary header of the signature, version and command, address family and
+// transport protocol, addresses length and the addresses
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading the PROXY v2 header: %v", err)
+	}
+	versionCommand, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("error reading the PROXY v2 addresses: %v", err)
+	}
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", versionCommand>>4)
+	}
+
+	// only the PROXY command over a stream transport carries the TCP source address
+	if versionCommand&0xF != 1 || family&0xF != 1 {
+		return nil, nil
+	}
+	switch family >> 4 {
+	case 1:
+		if len(payload) < 12 {
+			return nil, errors.New("truncated IPv4 addresses in the PROXY v2 header")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2:
+		if len(payload) < 36 {
+			return nil, errors.New("truncated IPv6 addresses in the PROXY v2 header")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
+
+// proxyListener parses the PROXY protocol header of the connections from trusted sources, so that
+// the connections report the original client address
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	// strict is true to close the connections from trusted sources without a header
+	strict bool
+}
+
+// trusts returns true if the address is within the trusted CIDRs
+func (l *proxyListener) trusts(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, ipNet := range l.trusted {
+		if ip != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept returns the next connection, connections from untrusted sources are returned as is
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || !l.trusts(conn.RemoteAddr()) {
+		return conn, err
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), strict: l.strict}, nil
+}
+
+// proxyConn reads the PROXY protocol header on first use, outside of the accept loop
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	strict bool
+	once   sync.Once
+	source net.Addr
+	err    error
+}
+
+// readHeader reads the header once, closing the connection if it is invalid, or missing when strict
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		source, found, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		switch {
+		case err != nil:
+			c.err = err
+		case !found && c.strict:
+			c.err = errMissingProxyHeader
+		default:
+			c.source = source
+		}
+		if c.err != nil {
+			log.Warnf("Closing the connection from %s: %s", c.Conn.RemoteAddr(), c.err.Error())
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, or the peer address without one
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// proxyV2Header returns a v2 PROXY command header of the TCP source and destination addresses
+func proxyV2Header(src, dst *net.TCPAddr) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(0x21)
+	addresses := new(bytes.Buffer)
+	if ip := src.IP.To4(); ip != nil {
+		buf.WriteByte(0x11)
+		addresses.Write(ip)
+		addresses.Write(dst.IP.To4())
+	} else {
+		buf.WriteByte(0x21)
+		addresses.Write(src.IP.To16())
+		addresses.Write(dst.IP.To16())
+	}
+	binary.Write(addresses, binary.BigEndian, uint16(src.Port))
+	binary.Write(addresses, binary.BigEndian, uint16(dst.Port))
+	binary.Write(&buf, binary.BigEndian, uint16(addresses.Len()))
+	buf.Write(addresses.Bytes())
+	return buf.Bytes()
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs("10.0.0.0/8, fd00::/8,")
+	assert.Nil(t, err)
+	assert.Len(t, nets, 2)
+	assert.True(t, nets[1].Contains(net.ParseIP("fd00::1")))
+
+	_, err = parseCIDRs("10.0.0.1")
+	assert.NotNil(t, err)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 56324}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 443}
+	local := append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0x00, 0x00)
+
+	for _, c := range []struct {
+		name   string
+		header []byte
+		addr   string
+		found  bool
+		err    string
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n"), "203.0.113.7:56324", true, ""},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::7 fd00::1 56324 443\r\n"), "[2001:db8::7]:56324", true, ""},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", true, ""},
+		{"v1 without CRLF", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\n"), "", true, "not terminated by CRLF"},
+		{"v1 invalid address", []byte("PROXY TCP4 example.com 10.0.0.1 56324 443\r\n"), "", true, "invalid source address"},
+		{"v2 IPv4", proxyV2Header(src, dst), "203.0.113.7:56324", true, ""},
+		{"v2 IPv6", proxyV2Header(src6, dst6), "[2001:db8::7]:56324", true, ""},
+		{"v2 LOCAL", local, "", true, ""},
+		{"no header", []byte("GET / HTTP/1.1\r\n\r\n"), "", false, ""},
+	} {
+		payload := "GET / HTTP/1.1\r\n\r\n"
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(c.header), strings.NewReader(payload)))
+		addr, found, err := readProxyHeader(r)
+		assert.Equal(t, c.found, found, c.name)
+		if c.err != "" {
+			if assert.NotNil(t, err, c.name) {
+				assert.Contains(t, err.Error(), c.err, c.name)
+			}
+			continue
+		}
+		assert.Nil(t, err, c.name)
+		if c.addr == "" {
+			assert.Nil(t, addr, c.name)
+		} else if assert.NotNil(t, addr, c.name) {
+			assert.Equal(t, c.addr, addr.String(), c.name)
+		}
+		if found {
+			rest, _ := ioutil.ReadAll(r)
+			assert.Equal(t, payload, string(rest), "%s should only consume the header", c.name)
+		}
+	}
+}
+
+// startProxyServer serves the client address of each request behind a PROXY protocol listener
+func startProxyServer(t *testing.T, trusted string, strict bool) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	nets, err := parseCIDRs(trusted)
+	assert.Nil(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, req.RemoteAddr)
+	})}
+	go srv.Serve(&proxyListener{Listener: listener, trusted: nets, strict: strict})
+	return listener.Addr().String(), func() { srv.Close() }
+}
+
+// proxyRequest sends a GET request preceded by the header and returns the response body
+func proxyRequest(address string, header []byte) (string, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.Write(header)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: guard\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestProxyListenerTrusted(t *testing.T) {
+	address, stop := startProxyServer(t, "127.0.0.0/8", true)
+	defer stop()
+
+	body, err := proxyRequest(address, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.7:56324", body, "should expose the v1 client address")
+
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 40000}
+	dst := &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 443}
+	body, err = proxyRequest(address, proxyV2Header(src, dst))
+	assert.Nil(t, err)
+	assert.Equal(t, "[2001:db8::7]:40000", body, "should expose the v2 client address")
+
+	_, err = proxyRequest(address, nil)
+	assert.NotNil(t, err, "should close trusted connections without a header in strict mode")
+}
+
+func TestProxyListenerLenient(t *testing.T) {
+	address, stop := startProxyServer(t, "127.0.0.0/8", false)
+	defer stop()
+
+	body, err := proxyRequest(address, nil)
+	assert.Nil(t, err)
+	assert.Regexp(t, "^127.0.0.1:[0-9]+$", body, "should serve with the peer address in lenient mode")
+}
+
+func TestProxyListenerUntrusted(t *testing.T) {
+	address, stop := startProxyServer(t, "10.0.0.0/8", true)
+	defer stop()
+
+	body, err := proxyRequest(address, nil)
+	assert.Nil(t, err)
+	assert.Regexp(t, "^127.0.0.1:[0-9]+$", body, "should not expect a header from untrusted sources")
+
+	body, _ = proxyRequest(address, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n"))
+	assert.NotEqual(t, "203.0.113.7:56324", body, "should not accept a header from untrusted sources")
+}
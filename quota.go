@@ -0,0 +1,251 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	quotaWindow = 24 * time.Hour
+	// quotaMetricUsers is the number of top users exported in the namespace_guard_user_deletions metric
+	quotaMetricUsers = 5
+	quotaDataKey     = "deletions.json"
+)
+
+var (
+	// userQuota limits the deletions allowed per user, nil when --userDeletionQuota is not set
+	userQuota *deletionQuota
+)
+
+// userUsage is the number of deletions allowed for a user within the quota window
+type userUsage struct {
+	User      string `json:"user"`
+	Deletions int    `json:"deletions"`
+}
+
+// deletionQuota tracks the namespace deletions allowed per user over a rolling window
+type deletionQuota struct {
+	sync.Mutex
+	limit  int
+	exempt map[string]bool
+	now    func() time.Time
+	// configMap persisting the deletions as <namespace>/<name>, not persisted when empty
+	configMap string
+	deletions map[string][]time.Time
+}
+
+// newDeletionQuota returns a quota of limit deletions per user and window
+func newDeletionQuota(limit int, exempt []string, configMap string, now func() time.Time) *deletionQuota {
+	q := &deletionQuota{
+		limit:     limit,
+		exempt:    map[string]bool{},
+		now:       now,
+		configMap: configMap,
+		deletions: map[string][]time.Time{},
+	}
+	for _, user := range exempt {
+		q.exempt[user] = true
+	}
+	return q
+}
+
+// pruneLocked drops the deletions of the user older than the window
+func (q *deletionQuota) pruneLocked(user string, now time.Time) {
+	var kept []time.Time
+	for _, t := range q.deletions[user] {
+		if now.Sub(t) < quotaWindow {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		delete(q.deletions, user)
+		return
+	}
+	q.deletions[user] = kept
+}
+
+// exempted returns true if the user is exempted from the quota
+func (q *deletionQuota) exempted(user string) bool {
+	return q.exempt[user]
+}
+
+// exceeded returns an error if the user already used up the quota
+func (q *deletionQuota) exceeded(user string) error {
+	if q.exempt[user] {
+		return nil
+	}
+	q.Lock()
+	defer q.Unlock()
+	q.pruneLocked(user, q.now())
+	if count := len(q.deletions[user]); count >= q.limit {
+		return fmt.Errorf("user %s already deleted %d namespaces within the last %v, the quota is %d", user, count, quotaWindow, q.limit)
+	}
+	return nil
+}
+
+// reserve counts a deletion by the user in the quota unless the user already used it up, checking and
+// counting it under the same lock so that parallel deletions may not exceed the quota. It returns the
+// time of the reserved deletion, zero for the exempt users, to release it if the deletion is denied.
+func (q *deletionQuota) reserve(user string) (time.Time, error) {
+	if q.exempt[user] {
+		return time.Time{}, nil
+	}
+	q.Lock()
+	defer q.Unlock()
+	now := q.now()
+	q.pruneLocked(user, now)
+	if count := len(q.deletions[user]); count >= q.limit {
+		return time.Time{}, fmt.Errorf("user %s already deleted %d namespaces within the last %v, the quota is %d", user, count, quotaWindow, q.limit)
+	}
+	q.deletions[user] = append(q.deletions[user], now)
+	return now, nil
+}
+
+// release drops the deletion reserved by the user at the time, once the deletion is denied
+func (q *deletionQuota) release(user string, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	deletions := q.deletions[user]
+	for i, t := range deletions {
+		if t.Equal(at) {
+			q.deletions[user] = append(deletions[:i:i], deletions[i+1:]...)
+			break
+		}
+	}
+	if len(q.deletions[user]) == 0 {
+		delete(q.deletions, user)
+	}
+}
+
+// commit exports the deletions once a reserved deletion is allowed, persisting them in the background
+func (q *deletionQuota) commit() {
+	q.updateMetrics()
+	if q.configMap != "" {
+		runInBackground(func() {
+			if err := q.save(); err != nil {
+				log.Errorf("Error occurred while persisting the deletion quota to %s: %s", q.configMap, err.Error())
+			}
+		})
+	}
+}
+
+// usage returns the deletions per user within the window, highest first
+func (q *deletionQuota) usage() []userUsage {
+	q.Lock()
+	defer q.Unlock()
+	now := q.now()
+	usage := []userUsage{}
+	for user := range q.deletions {
+		q.pruneLocked(user, now)
+		if count := len(q.deletions[user]); count > 0 {
+			usage = append(usage, userUsage{User: user, Deletions: count})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Deletions != usage[j].Deletions {
+			return usage[i].Deletions > usage[j].Deletions
+		}
+		return usage[i].User < usage[j].User
+	})
+	return usage
+}
+
+// updateMetrics exports the usage of the top users only, to keep the metric cardinality bounded
+func (q *deletionQuota) updateMetrics() {
+	usage := q.usage()
+	if len(usage) > quotaMetricUsers {
+		usage = usage[:quotaMetricUsers]
+	}
+	userDeletions.Reset()
+	for i, u := range usage {
+		userDeletions.WithLabelValues(strconv.Itoa(i+1), u.User).Set(float64(u.Deletions))
+	}
+}
+
+// save writes the deletions to the ConfigMap
+func (q *deletionQuota) save() error {
+	namespace, name, err := splitNamespacedName(q.configMap)
+	if err != nil {
+		return err
+	}
+	q.Lock()
+	data, err := json.Marshal(q.deletions)
+	q.Unlock()
+	if err != nil {
+		return err
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Get(name, v1.GetOptions{})
+	if apiErrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       map[string]string{quotaDataKey: string(data)},
+		}
+		_, err = configMaps.Create(configMap)
+		return err
+	} else if err != nil {
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[quotaDataKey] = string(data)
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+// load reads the deletions from the ConfigMap, a missing ConfigMap is an empty quota
+func (q *deletionQuota) load() error {
+	namespace, name, err := splitNamespacedName(q.configMap)
+	if err != nil {
+		return err
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(name, v1.GetOptions{})
+	if apiErrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	deletions := map[string][]time.Time{}
+	if data, ok := configMap.Data[quotaDataKey]; ok {
+		err = json.Unmarshal([]byte(data), &deletions)
+		if err != nil {
+			return fmt.Errorf("error decoding the deletion quota: %v", err)
+		}
+	}
+	q.Lock()
+	q.deletions = deletions
+	q.Unlock()
+	q.updateMetrics()
+	return nil
+}
+
+// debugQuotaHandler serves the /debug/quota endpoint reporting the deletions per user
+func debugQuotaHandler(rw http.ResponseWriter, req *http.Request) {
+	log.Infof("Serving %s %s request for client: %s", req.Method, req.URL.Path, req.RemoteAddr)
+
+	if req.Method != http.MethodGet {
+		writeJSON(rw, http.StatusMethodNotAllowed, explainError{fmt.Sprintf("Incoming request method %s is not supported, only GET is supported", req.Method)})
+		return
+	}
+	if userQuota == nil {
+		writeJSON(rw, http.StatusNotFound, explainError{"The deletion quota is not enabled"})
+		return
+	}
+	writeJSON(rw, http.StatusOK, userQuota.usage())
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeClock returns a clock function and a setter moving it
+func fakeClock(start time.Time) (func() time.Time, func(time.Duration)) {
+	now := start
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+// reserve reserves a deletion by the user in the quota, failing the test if the quota is used up
+func reserve(t *testing.T, q *deletionQuota, user string) time.Time {
+	at, err := q.reserve(user)
+	assert.Nil(t, err, "should reserve a deletion by %s", user)
+	return at
+}
+
+func TestDeletionQuotaWindowRollover(t *testing.T) {
+	now, advance := fakeClock(time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC))
+	q := newDeletionQuota(2, []string{"system:serviceaccount:ci:deployer"}, "", now)
+
+	assert.Nil(t, q.exceeded("alice"))
+	reserve(t, q, "alice")
+	advance(12 * time.Hour)
+	reserve(t, q, "alice")
+	assert.EqualError(t, q.exceeded("alice"), "user alice already deleted 2 namespaces within the last 24h0m0s, the quota is 2")
+	assert.Nil(t, q.exceeded("bob"), "the quota is per user")
+
+	advance(12 * time.Hour)
+	assert.Nil(t, q.exceeded("alice"), "the first deletion left the window")
+	assert.Equal(t, []userUsage{{User: "alice", Deletions: 1}}, q.usage())
+
+	for i := 0; i < 5; i++ {
+		reserve(t, q, "system:serviceaccount:ci:deployer")
+	}
+	assert.Nil(t, q.exceeded("system:serviceaccount:ci:deployer"), "exempt users have no quota")
+
+	advance(24 * time.Hour)
+	assert.Equal(t, []userUsage{}, q.usage())
+}
+
+func TestDeletionQuotaReservation(t *testing.T) {
+	now, advance := fakeClock(time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC))
+	q := newDeletionQuota(2, []string{"system:serviceaccount:ci:deployer"}, "", now)
+
+	first := reserve(t, q, "alice")
+	advance(time.Minute)
+	second := reserve(t, q, "alice")
+	_, err := q.reserve("alice")
+	assert.EqualError(t, err, "user alice already deleted 2 namespaces within the last 24h0m0s, the quota is 2", "should not reserve past the quota")
+
+	q.release("alice", first)
+	assert.Equal(t, []userUsage{{User: "alice", Deletions: 1}}, q.usage(), "should only release the denied deletion")
+	advance(time.Minute)
+	reserve(t, q, "alice")
+	q.release("alice", second)
+	q.release("alice", second)
+	assert.Equal(t, []userUsage{{User: "alice", Deletions: 1}}, q.usage(), "should release a deletion once")
+
+	assert.True(t, reserve(t, q, "system:serviceaccount:ci:deployer").IsZero(), "should not reserve the deletions of exempt users")
+}
+
+func TestParallelDeletionQuota(t *testing.T) {
+	q := newDeletionQuota(3, nil, "", time.Now)
+
+	var wg sync.WaitGroup
+	reserved := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := q.reserve("alice")
+			reserved <- err == nil
+		}()
+	}
+	wg.Wait()
+	close(reserved)
+	count := 0
+	for ok := range reserved {
+		if ok {
+			count++
+		}
+	}
+	assert.Equal(t, 3, count, "should never reserve more deletions than the quota")
+}
+
+func TestDeletionQuotaPersistence(t *testing.T) {
+	clientset = fake.NewSimpleClientset()
+	now, advance := fakeClock(time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC))
+	q := newDeletionQuota(1, nil, "kube-system/guard-quota", now)
+	reserve(t, q, "alice")
+	assert.Nil(t, q.save())
+	reserve(t, q, "bob")
+	assert.Nil(t, q.save(), "an existing ConfigMap is updated")
+	waitForBackgroundTasks(t)
+
+	advance(time.Hour)
+	restarted := newDeletionQuota(1, nil, "kube-system/guard-quota", now)
+	assert.Nil(t, restarted.load())
+	assert.NotNil(t, restarted.exceeded("alice"), "the quota survives restarts")
+	assert.Equal(t, []userUsage{{User: "alice", Deletions: 1}, {User: "bob", Deletions: 1}}, restarted.usage())
+
+	missing := newDeletionQuota(1, nil, "kube-system/missing", now)
+	assert.Nil(t, missing.load())
+	assert.Equal(t, []userUsage{}, missing.usage())
+}
+
+func TestDeletionQuotaWebhookHandler(t *testing.T) {
+	now, _ := fakeClock(time.Now())
+	userQuota = newDeletionQuota(1, nil, "", now)
+	defer func() { userQuota = nil }()
+
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve the first deletion")
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed, "should reject once the quota is used up")
+	assert.Equal(t, "Deletion quota exceeded: user alice already deleted 1 namespaces within the last 24h0m0s, the quota is 1. Please contact the cluster administrators to delete the namespace test-namespace.", admReview.Status.Result.Message)
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "http://localhost:8080/debug/quota", nil)
+	debugQuotaHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var usage []userUsage
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&usage))
+	assert.Equal(t, []userUsage{{User: "alice", Deletions: 1}}, usage)
+}
+
+func TestDeniedDeletionQuotaWebhookHandler(t *testing.T) {
+	userQuota = newDeletionQuota(1, nil, "", time.Now)
+	defer func() { userQuota = nil }()
+
+	review := func() bool {
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.UserInfo.Username = "alice"
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+		webhookHandler(rw, req)
+		return getAdmissionReview(rw).Status.Allowed
+	}
+
+	clientset = fake.NewSimpleClientset(namespaceWithPods(1)...)
+	assert.False(t, review(), "should reject the deletion of a namespace holding a pod")
+	assert.Equal(t, []userUsage{}, userQuota.usage(), "should release the deletion reserved once it is denied")
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	assert.True(t, review(), "should approve the deletion within the quota")
+	assert.Equal(t, []userUsage{{User: "alice", Deletions: 1}}, userQuota.usage())
+}
@@ -0,0 +1,270 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	authorizationv1 "k8s.io/client-go/pkg/apis/authorization/v1"
+)
+
+var (
+	// kindGroups maps the counted kinds to their API group
+	kindGroups = map[string]string{
+		"pods":                     "",
+		"services":                 "",
+		"replicasets":              "extensions",
+		"deployments":              "apps",
+		"statefulsets":             "apps",
+		"daemonsets":               "extensions",
+		"ingresses":                "extensions",
+		"horizontalpodautoscalers": "autoscaling",
+		"clusterrolebindings":      "rbac.authorization.k8s.io",
+		"persistentvolumes":        "",
+		"endpoints":                "",
+		"configmaps":               "",
+		"loadbalancerservices":     "",
+		"persistentvolumeclaims":   "",
+		"gateways":                 "gateway.networking.k8s.io",
+		"httproutes":               "gateway.networking.k8s.io",
+		"servicemonitors":          "monitoring.coreos.com",
+		"podmonitors":              "monitoring.coreos.com",
+		"certificates":             "cert-manager.io",
+		"issuers":                  "cert-manager.io",
+	}
+)
+
+// kindResource returns the resource listed to count the kind, the kinds counting a subset of the
+// objects of a resource are not resources themselves
+func kindResource(kind string) string {
+	if kind == loadBalancerServicesKind {
+		return "services"
+	}
+	return kind
+}
+
+// permission is a verb on a resource the webhook needs to be granted
+type permission struct {
+	verb     string
+	group    string
+	resource string
+}
+
+func (p permission) String() string {
+	if p.group == "" {
+		return fmt.Sprintf("%s %s", p.verb, p.resource)
+	}
+	return fmt.Sprintf("%s %s.%s", p.verb, p.resource, p.group)
+}
+
+// permissionResult is the outcome of the access review of a permission
+type permissionResult struct {
+	permission
+	allowed bool
+	err     error
+}
+
+// requiredPermissions returns the permissions needed by the enabled features
+func requiredPermissions() []permission {
+	perms := []permission{{"get", "", "namespaces"}}
+	var kinds []string
+	for _, c := range resourceCounters() {
+		kinds = append(kinds, c.kind)
+	}
+	if *checkClusterScopedResources {
+		for _, c := range clusterScopedCounters() {
+			kinds = append(kinds, c.kind)
+		}
+	}
+	for _, kind := range kinds {
+		perms = append(perms, permission{"list", kindGroups[kind], kindResource(kind)})
+		if *shadowCompare {
+			perms = append(perms, permission{"watch", kindGroups[kind], kindResource(kind)})
+		}
+	}
+	if *confirmIdle > 0 && !*shadowCompare {
+		perms = append(perms, permission{"watch", "", "pods"})
+	}
+	if *reportVolumeReclaim && !*checkClusterScopedResources {
+		perms = append(perms, permission{"list", "", "persistentvolumes"})
+	}
+	if *deleterClusterRole != "" {
+		perms = append(perms, permission{"list", "rbac.authorization.k8s.io", "rolebindings"})
+	}
+	if *guardHelmDependencies {
+		perms = append(perms, permission{"list", "", "secrets"})
+	}
+	if *scanInterval > 0 || *bypassMaxAge > 0 {
+		perms = append(perms, permission{"list", "", "namespaces"})
+	}
+	if *bypassMaxAge > 0 && !*expireBypassDryRun {
+		perms = append(perms, permission{"patch", "", "namespaces"})
+	}
+	if *webhookConfigName != "" {
+		perms = append(perms, permission{"get", "admissionregistration.k8s.io", "externaladmissionhookconfigurations"})
+		if *manageWebhookConfig {
+			perms = append(perms, permission{"update", "admissionregistration.k8s.io", "externaladmissionhookconfigurations"})
+		}
+	}
+	// the endpoints are read to find the services serving traffic
+	if !*guardEndpoints {
+		perms = append(perms, permission{"list", "", "endpoints"})
+	}
+	if *logLevelUsers != "" || *appealNamespace != "" || *deleteTokenKeyFile != "" {
+		perms = append(perms, permission{"create", "authentication.k8s.io", "tokenreviews"})
+	}
+	if *appealNamespace != "" {
+		perms = append(perms, permission{"create", "", "configmaps"}, permission{"get", "", "configmaps"})
+	}
+	if *snapshotNamespace != "" {
+		perms = append(perms, permission{"create", "", "configmaps"})
+	}
+	if *quotaConfigMap != "" {
+		perms = append(perms, permission{"get", "", "configmaps"},
+			permission{"update", "", "configmaps"},
+			permission{"create", "", "configmaps"})
+	}
+	if *policyHistorySecret != "" {
+		perms = append(perms, permission{"get", "", "secrets"},
+			permission{"update", "", "secrets"},
+			permission{"create", "", "secrets"})
+	}
+	if *scopeToRequester || *requireContentAuthz || *authorizeBypass {
+		perms = append(perms, permission{"create", "authorization.k8s.io", "subjectaccessreviews"})
+	}
+	if *impersonateUser {
+		perms = append(perms, permission{"impersonate", "", "users"},
+			permission{"impersonate", "", "groups"},
+			permission{"impersonate", "authentication.k8s.io", "userextras"})
+	}
+	if *recordDeletionAttempts {
+		perms = append(perms, permission{"create", attemptResource.Group, attemptResource.Resource},
+			permission{"list", attemptResource.Group, attemptResource.Resource},
+			permission{"delete", attemptResource.Group, attemptResource.Resource})
+	}
+	if *watchGuardExceptions {
+		perms = append(perms, permission{"list", guardExceptionResource.Group, guardExceptionResource.Resource},
+			permission{"watch", guardExceptionResource.Group, guardExceptionResource.Resource},
+			permission{"update", guardExceptionResource.Group, guardExceptionResource.Resource})
+	}
+	if *softThresholdEnabled || *bypassEventNamespace != "" || *bypassMaxAge > 0 {
+		perms = append(perms, permission{"create", "", "events"})
+	}
+	// the recurring events on the namespaces are aggregated
+	if *softThresholdEnabled || *bypassMaxAge > 0 {
+		perms = append(perms, permission{"get", "", "events"}, permission{"update", "", "events"})
+	}
+	return uniquePermissions(perms)
+}
+
+// uniquePermissions returns the permissions without the ones needed by several features repeated
+func uniquePermissions(perms []permission) []permission {
+	seen := map[permission]bool{}
+	unique := perms[:0]
+	for _, p := range perms {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
+
+// checkPermissions issues a SelfSubjectAccessReview for every given permission
+func checkPermissions(perms []permission) []permissionResult {
+	results := make([]permissionResult, 0, len(perms))
+	for _, p := range perms {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:     p.verb,
+					Group:    p.group,
+					Resource: p.resource,
+				},
+			},
+		}
+		result := permissionResult{permission: p}
+		review, result.err = clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+		if result.err == nil {
+			result.allowed = review.Status.Allowed
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// missingPermissions returns the permissions which were not granted or could not be verified
+func missingPermissions(results []permissionResult) []string {
+	var missing []string
+	for _, r := range results {
+		if !r.allowed {
+			missing = append(missing, r.permission.String())
+		}
+	}
+	return missing
+}
+
+// formatPermissionTable renders the results as a table of granted and missing permissions
+func formatPermissionTable(results []permissionResult) string {
+	width := 0
+	for _, r := range results {
+		if l := len(r.permission.String()); l > width {
+			width = l
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%-*s  %s\n", width, "PERMISSION", "STATUS")
+	for _, r := range results {
+		status := "granted"
+		switch {
+		case r.err != nil:
+			status = fmt.Sprintf("unknown (%v)", r.err)
+		case !r.allowed:
+			status = "MISSING"
+		}
+		fmt.Fprintf(&b, "%-*s  %s\n", width, r.permission.String(), status)
+	}
+	return b.String()
+}
+
+// verifyRBAC checks the permissions needed by the enabled features and logs them. It returns an
+// error listing the missing permissions, if any.
+func verifyRBAC() error {
+	results := checkPermissions(requiredPermissions())
+	missing := missingPermissions(results)
+	if len(missing) == 0 {
+		log.Infof("RBAC self-check passed:\n%s", formatPermissionTable(results))
+		return nil
+	}
+	log.Warnf("RBAC self-check found missing permissions:\n%s", formatPermissionTable(results))
+	return fmt.Errorf("the service account is missing the permissions: %s", strings.Join(missing, ", "))
+}
+
+// rbacGate holds /readyz back while permissions are missing, nil unless --rbacReadiness is set and the
+// self-check at startup found missing permissions
+var rbacGate *permissionGate
+
+// permissionGate checks the required permissions again until they are all granted
+type permissionGate struct {
+	sync.Mutex
+	pending bool
+}
+
+// ready returns an error listing the missing permissions, checking them again while any is missing
+func (g *permissionGate) ready() error {
+	g.Lock()
+	defer g.Unlock()
+	if !g.pending {
+		return nil
+	}
+	missing := missingPermissions(checkPermissions(requiredPermissions()))
+	if len(missing) > 0 {
+		return fmt.Errorf("the service account is missing the permissions: %s", strings.Join(missing, ", "))
+	}
+	g.pending = false
+	log.Infof("RBAC self-check passed, the missing permissions were granted")
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	authorizationv1 "k8s.io/client-go/pkg/apis/authorization/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// accessReviewClientset returns a clientset granting only the given permissions
+func accessReviewClientset(granted ...permission) *fake.Clientset {
+	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset.PrependReactor("create", "selfsubjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attr := review.Spec.ResourceAttributes
+		for _, p := range granted {
+			if p.verb == attr.Verb && p.group == attr.Group && p.resource == attr.Resource {
+				review.Status.Allowed = true
+			}
+		}
+		return true, review, nil
+	})
+	return fakeClientset
+}
+
+func TestVerifyRBACAllGranted(t *testing.T) {
+	clientset = accessReviewClientset(requiredPermissions()...)
+	assert.Nil(t, verifyRBAC())
+}
+
+func TestVerifyRBACPartialGrant(t *testing.T) {
+	clientset = accessReviewClientset(
+		permission{"get", "", "namespaces"},
+		permission{"list", "", "pods"},
+		permission{"list", "", "services"},
+		permission{"list", "extensions", "replicasets"},
+		permission{"list", "apps", "deployments"},
+		permission{"list", "apps", "statefulsets"},
+		permission{"list", "extensions", "daemonsets"},
+		permission{"list", "", "endpoints"},
+	)
+	err := verifyRBAC()
+	assert.NotNil(t, err)
+	assert.Equal(t, "the service account is missing the permissions: list ingresses.extensions, list horizontalpodautoscalers.autoscaling", err.Error())
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	assert.NotContains(t, requiredPermissions(), permission{"create", "", "events"})
+	assert.NotContains(t, requiredPermissions(), permission{"list", "", "persistentvolumes"})
+
+	*softThresholdEnabled = true
+	*checkClusterScopedResources = true
+	*shadowCompare = true
+	defer func() {
+		*softThresholdEnabled = false
+		*checkClusterScopedResources = false
+		*shadowCompare = false
+	}()
+	perms := requiredPermissions()
+	assert.Contains(t, perms, permission{"create", "", "events"})
+	assert.Contains(t, perms, permission{"update", "", "events"})
+	assert.Contains(t, perms, permission{"list", "", "persistentvolumes"})
+	assert.Contains(t, perms, permission{"list", "rbac.authorization.k8s.io", "clusterrolebindings"})
+	assert.Contains(t, perms, permission{"watch", "apps", "deployments"})
+}
+
+func TestRequiredPermissionsSnapshotNamespace(t *testing.T) {
+	assert.NotContains(t, requiredPermissions(), permission{"create", "", "configmaps"})
+
+	*snapshotNamespace = "guard-archive"
+	*appealNamespace = "guard-appeals"
+	defer func() {
+		*snapshotNamespace = ""
+		*appealNamespace = ""
+	}()
+	count := 0
+	for _, p := range requiredPermissions() {
+		if p == (permission{"create", "", "configmaps"}) {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count, "should only require the permissions needed by several features once")
+}
+
+func TestRequiredPermissionsQuotaConfigMap(t *testing.T) {
+	assert.NotContains(t, requiredPermissions(), permission{"update", "", "configmaps"})
+
+	*quotaConfigMap = "kube-system/namespace-guard-quota"
+	defer func() { *quotaConfigMap = "" }()
+	perms := requiredPermissions()
+	assert.Contains(t, perms, permission{"get", "", "configmaps"})
+	assert.Contains(t, perms, permission{"update", "", "configmaps"})
+	assert.Contains(t, perms, permission{"create", "", "configmaps"})
+}
+
+func TestRequiredPermissionsPolicyHistorySecret(t *testing.T) {
+	assert.NotContains(t, requiredPermissions(), permission{"get", "", "secrets"})
+
+	*policyHistorySecret = "kube-system/namespace-guard-policy-history"
+	defer func() { *policyHistorySecret = "" }()
+	perms := requiredPermissions()
+	assert.Contains(t, perms, permission{"get", "", "secrets"})
+	assert.Contains(t, perms, permission{"update", "", "secrets"})
+	assert.Contains(t, perms, permission{"create", "", "secrets"})
+}
+
+func TestFormatPermissionTable(t *testing.T) {
+	results := []permissionResult{
+		{permission: permission{"get", "", "namespaces"}, allowed: true},
+		{permission: permission{"list", "apps", "deployments"}},
+	}
+	expected := "PERMISSION             STATUS\n" +
+		"get namespaces         granted\n" +
+		"list deployments.apps  MISSING\n"
+	assert.Equal(t, expected, formatPermissionTable(results))
+}
+
+func TestPermissionGateReadyz(t *testing.T) {
+	fakeClientset := accessReviewClientset(
+		permission{"get", "", "namespaces"},
+		permission{"list", "", "pods"},
+		permission{"list", "", "services"},
+		permission{"list", "extensions", "replicasets"},
+		permission{"list", "apps", "deployments"},
+		permission{"list", "apps", "statefulsets"},
+		permission{"list", "extensions", "daemonsets"},
+		permission{"list", "", "endpoints"},
+	)
+	clientset = fakeClientset
+	rbacGate = &permissionGate{pending: true}
+	defer func() { rbacGate = nil }()
+
+	rw := httptest.NewRecorder()
+	readyzHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code, "should not be ready while permissions are missing")
+	assert.Contains(t, rw.Body.String(), "not ready, the service account is missing the permissions: list ingresses.extensions, list horizontalpodautoscalers.autoscaling")
+
+	// the missing permissions are granted
+	fakeClientset = accessReviewClientset(requiredPermissions()...)
+	clientset = fakeClientset
+	rw = httptest.NewRecorder()
+	readyzHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/readyz", nil))
+	assert.Equal(t, http.StatusOK, rw.Code, "should be ready once the permissions are granted")
+
+	reviews := len(fakeClientset.Actions())
+	rw = httptest.NewRecorder()
+	readyzHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/readyz", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, reviews, len(fakeClientset.Actions()), "should not check the permissions again once granted")
+}
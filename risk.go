@@ -0,0 +1,129 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// kindWeights are the risk score contributions per kind set by --kindWeights, kinds not listed
+	// contribute 1 per resource
+	kindWeights = map[string]int{}
+	// kindThresholds are the numbers of leftover resources tolerated per kind set by --kindThresholds,
+	// the kinds listed don't contribute to the risk score
+	kindThresholds = map[string]int{}
+)
+
+// parseKindWeights parses the comma separated kind=weight list, the kinds must be among the given ones
+func parseKindWeights(value string, kinds []string) (map[string]int, error) {
+	return parseKindValues(value, kinds, "weight")
+}
+
+// parseKindThresholds parses the comma separated kind=threshold list, the kinds must be among the
+// given ones
+func parseKindThresholds(value string, kinds []string) (map[string]int, error) {
+	return parseKindValues(value, kinds, "threshold")
+}
+
+// parseKindValues parses a comma separated list of kind=<non-negative integer> entries, the name of
+// the values being used in the errors
+func parseKindValues(value string, kinds []string, name string) (map[string]int, error) {
+	known := map[string]bool{}
+	for _, kind := range kinds {
+		known[kind] = true
+	}
+	values := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s %q, it must be <kind>=<%s>", name, entry, name)
+		}
+		kind := strings.TrimSpace(parts[0])
+		if !known[kind] {
+			return nil, fmt.Errorf("unknown kind %q, it must be one of %v", kind, kinds)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid %s %q for kind %s, it must be a non-negative integer", name, parts[1], kind)
+		}
+		values[kind] = count
+	}
+	return values, nil
+}
+
+// kindWeight returns the risk score contribution of a single resource of the kind
+func kindWeight(kind string) int {
+	if weight, ok := kindWeights[kind]; ok {
+		return weight
+	}
+	return 1
+}
+
+// scoreLimit returns the score above which the deletion is denied, the --maxImpactScore in the scoring
+// mode and the maxCount of the profile otherwise
+func scoreLimit(maxCount int) int {
+	if *maxImpactScore > 0 {
+		return *maxImpactScore
+	}
+	return maxCount
+}
+
+// impactContributions returns the <kind>(<count>x<weight>=<contribution>) of the kinds contributing to
+// the risk score
+func impactContributions(findings []resourceFinding) []string {
+	var contributions []string
+	for _, f := range findings {
+		if _, ok := kindThresholds[f.Kind]; ok || f.External || f.Count == 0 {
+			continue
+		}
+		weight := kindWeight(f.Kind)
+		contributions = append(contributions, fmt.Sprintf("%s(%dx%d=%d)", f.Kind, f.Count, weight, f.Count*weight))
+	}
+	return contributions
+}
+
+// riskScore returns the weighted sum of the namespace-scoped resources found, except the kinds with a
+// threshold. Without --kindWeights it is the number of resources.
+func riskScore(findings []resourceFinding) int {
+	score := 0
+	for _, f := range findings {
+		if _, ok := kindThresholds[f.Kind]; !ok && !f.External {
+			score += f.Count * kindWeight(f.Kind)
+		}
+	}
+	return score
+}
+
+// counterKinds returns the kinds of the counters
+func counterKinds(counters []resourceCounter) []string {
+	kinds := make([]string, 0, len(counters))
+	for _, c := range counters {
+		kinds = append(kinds, c.kind)
+	}
+	return kinds
+}
+
+// tolerated returns true if the finding holds no more resources than the threshold of its kind
+func tolerated(f resourceFinding) bool {
+	threshold, ok := kindThresholds[f.Kind]
+	return ok && !f.External && !f.Exceeded && f.Count <= threshold
+}
+
+// thresholdsExceeded returns the <kind>(<threshold>) of the kinds holding more resources than their
+// threshold
+func thresholdsExceeded(findings []resourceFinding) []string {
+	var exceeded []string
+	for _, f := range findings {
+		if threshold, ok := kindThresholds[f.Kind]; ok && !f.External && !tolerated(f) {
+			exceeded = append(exceeded, fmt.Sprintf("%s(%d)", f.Kind, threshold))
+		}
+	}
+	return exceeded
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
+)
+
+func TestParseKindWeights(t *testing.T) {
+	kinds := counterKinds(resourceCounters())
+	weights, err := parseKindWeights("pods=0, statefulsets=10,", kinds)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"pods": 0, "statefulsets": 10}, weights)
+
+	weights, err = parseKindWeights("", kinds)
+	assert.Nil(t, err)
+	assert.Empty(t, weights)
+
+	_, err = parseKindWeights("pvc=10", kinds)
+	assert.Contains(t, err.Error(), `unknown kind "pvc"`)
+	_, err = parseKindWeights("pods", kinds)
+	assert.Contains(t, err.Error(), `invalid weight "pods"`)
+	_, err = parseKindWeights("pods=-1", kinds)
+	assert.Contains(t, err.Error(), "must be a non-negative integer")
+}
+
+func TestRiskScore(t *testing.T) {
+	findings := []resourceFinding{
+		{Kind: "pods", Count: 3},
+		{Kind: "statefulsets", Count: 1},
+		{Kind: "clusterrolebindings", Count: 2, External: true},
+	}
+	assert.Equal(t, 4, riskScore(findings), "should count the resources without weights")
+
+	kindWeights = map[string]int{"pods": 0, "statefulsets": 10}
+	defer func() { kindWeights = map[string]int{} }()
+	assert.Equal(t, 10, riskScore(findings))
+}
+
+// namespaceWithResources returns the namespace holding the number of pods and statefulsets
+func namespaceWithResources(pods, statefulsets int) []runtime.Object {
+	objects := namespaceWithPods(pods)
+	for i := 0; i < statefulsets; i++ {
+		objects = append(objects, &appsv1beta1.StatefulSet{
+			ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("test-statefulset-%d", i), Namespace: "test-namespace"},
+		})
+	}
+	return objects
+}
+
+func TestRiskScoreThresholdWebhookHandler(t *testing.T) {
+	*maxResourceCount = 10
+	kindWeights = map[string]int{"pods": 1, "statefulsets": 5}
+	defer func() {
+		*maxResourceCount = 0
+		kindWeights = map[string]int{}
+	}()
+
+	review := func(objects []runtime.Object) (bool, string) {
+		clientset = fake.NewSimpleClientset(objects...)
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+
+	allowed, _ := review(namespaceWithResources(5, 1))
+	assert.True(t, allowed, "should approve at the threshold")
+
+	allowed, message := review(namespaceWithResources(1, 2))
+	assert.False(t, allowed, "should reject above the threshold")
+	assert.Contains(t, message, "contains one or more of these resources: [pods(1) statefulsets(2)]. Please delete them and try again. Their risk score is 11 while at most 10 is allowed.")
+
+	allowed, _ = review(namespaceWithResources(12, 0))
+	assert.False(t, allowed, "should reject many trivial leftovers above the threshold")
+}
+
+func TestImpactScore(t *testing.T) {
+	config, err := parseConfig([]byte("weights:\n  statefulsets: 10\n  services: 0\n"))
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, map[string]int{"statefulsets": 10, "services": 0}, config.Weights)
+	kindWeights = config.Weights
+	*maxImpactScore = 20
+	defer func() {
+		*maxImpactScore = 0
+		kindWeights = map[string]int{}
+	}()
+
+	for _, c := range []struct {
+		pods, statefulsets int
+		err                string
+	}{
+		{0, 0, ""},
+		{20, 0, ""},
+		{10, 1, ""},
+		{0, 2, ""},
+		{21, 0, "Their impact score is 21 while at most 20 is allowed: [pods(21x1=21)]."},
+		{1, 2, "Their impact score is 21 while at most 20 is allowed: [pods(1x1=1) statefulsets(2x10=20)]."},
+	} {
+		clientset = fake.NewSimpleClientset(namespaceWithResources(c.pods, c.statefulsets)...)
+		findings := findNamespaceResourcesOrFail(t)
+		err := deletionError("test-namespace", findings, nil, *maxResourceCount)
+		if c.err == "" {
+			assert.Nil(t, err, "%d pods and %d statefulsets should be at most the max impact score", c.pods, c.statefulsets)
+			assert.False(t, policyViolated(findings, *maxResourceCount))
+		} else if assert.NotNil(t, err, "%d pods and %d statefulsets should exceed the max impact score", c.pods, c.statefulsets) {
+			assert.Contains(t, err.Error(), c.err)
+			assert.True(t, policyViolated(findings, *maxResourceCount))
+		}
+	}
+
+	*maxImpactScore = 0
+	clientset = fake.NewSimpleClientset(namespaceWithResources(1, 0)...)
+	assert.NotNil(t, validateNamespaceDeletion("test-namespace"), "should deny any resource without the scoring mode")
+}
+
+func TestParseKindThresholds(t *testing.T) {
+	thresholds, err := parseKindThresholds("pods=0, configmaps=5", []string{"pods", "configmaps"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"pods": 0, "configmaps": 5}, thresholds)
+
+	_, err = parseKindThresholds("configmaps=-1", []string{"pods", "configmaps"})
+	assert.Contains(t, err.Error(), `invalid threshold "-1" for kind configmaps, it must be a non-negative integer`)
+}
+
+// namespaceWithConfigMaps returns the namespace holding the number of pods and configmaps
+func namespaceWithConfigMaps(pods, configMaps int) []runtime.Object {
+	objects := namespaceWithPods(pods)
+	for i := 0; i < configMaps; i++ {
+		objects = append(objects, &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("test-configmap-%d", i), Namespace: "test-namespace"},
+		})
+	}
+	return objects
+}
+
+func TestKindThresholds(t *testing.T) {
+	kindThresholds = map[string]int{"pods": 0, "configmaps": 5}
+	defer func() { kindThresholds = map[string]int{} }()
+
+	for _, c := range []struct {
+		pods, configMaps int
+		maxCount         int
+		err              string
+	}{
+		{0, 0, 0, ""},
+		{0, 5, 0, ""},
+		{0, 6, 0, "contains one or more of these resources: [configmaps(6)]. Please delete them and try again. At most [configmaps(5)] are tolerated."},
+		{1, 0, 0, "contains one or more of these resources: [pods(1)]. Please delete them and try again. At most [pods(0)] are tolerated."},
+		{1, 5, 10, "contains one or more of these resources: [pods(1)]. Please delete them and try again. At most [pods(0)] are tolerated. WARNING"},
+	} {
+		clientset = fake.NewSimpleClientset(namespaceWithConfigMaps(c.pods, c.configMaps)...)
+		err := deletionError("test-namespace", findNamespaceResourcesOrFail(t), nil, c.maxCount)
+		if c.err == "" {
+			assert.Nil(t, err, "%d pods and %d configmaps should be tolerated", c.pods, c.configMaps)
+			assert.Nil(t, validateNamespaceDeletion("test-namespace"))
+		} else if assert.NotNil(t, err, "%d pods and %d configmaps should be rejected", c.pods, c.configMaps) {
+			assert.Contains(t, err.Error(), c.err)
+		}
+	}
+
+	kindThresholds = map[string]int{"configmaps": 1}
+	findings := []resourceFinding{{Kind: "statefulsets", Count: 2}, {Kind: "configmaps", Count: 1}}
+	assert.Equal(t, 2, riskScore(findings), "kinds with a threshold should not contribute to the risk score")
+	assert.False(t, policyViolated(findings, 2))
+	findings[1].Count = 2
+	assert.True(t, policyViolated(findings, 2), "should block above the threshold regardless of maxResourceCount")
+}
+
+// findNamespaceResourcesOrFail returns the findings of the counters, failing on any error
+func findNamespaceResourcesOrFail(t *testing.T) []resourceFinding {
+	findings, errList := findNamespaceResources("test-namespace")
+	assert.Empty(t, errList)
+	return findings
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// scanResult is the outcome of a scan of every namespace
+type scanResult struct {
+	scanned int
+	blocked int
+	// blockedByKind is the number of blocked namespaces holding resources of each kind
+	blockedByKind map[string]int
+}
+
+// namespaceScanner periodically finds the namespaces whose deletion would be blocked, with its own
+// client whose rate limiter is not shared with the admission requests
+type namespaceScanner struct {
+	client kubernetes.Interface
+}
+
+// scan counts the resources of every namespace one at a time with the counters of the webhook. The
+// namespaces already terminating or whose deletion the bypass annotation allows are not blocked, nor are
+// the namespaces that could not be counted. The namespaces nearing the limit without being blocked get
+// a DeletionAtRisk event.
+func (s *namespaceScanner) scan() (scanResult, error) {
+	result := scanResult{blockedByKind: map[string]int{}}
+	namespaces, err := s.client.CoreV1().Namespaces().List(v1.ListOptions{})
+	if err != nil {
+		return result, err
+	}
+	counters := clientCounters(s.client, dynamicClients)
+	for _, namespace := range namespaces.Items {
+		result.scanned++
+		if namespace.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		// a fingerprinted bypass only allows the deletion while it matches the contents, as by the webhook
+		annotations := namespace.GetAnnotations()
+		bypassed := hasBypassAnnotation(annotations)
+		if bypassed && !fingerprintedBypass(annotations) {
+			continue
+		}
+		findings, errList := findResources(namespace.Name, counters)
+		if bypassed && fingerprintMatches(annotations, findings, errList) {
+			continue
+		}
+		if len(errList) > 0 {
+			log.Warnf("Error occurred while scanning the namespace %s: %v", namespace.Name, errList)
+			continue
+		}
+		if deletionError(namespace.Name, findings, nil, *maxResourceCount) == nil {
+			checkSoftThreshold(s.client, &namespace, findings, scoreLimit(*maxResourceCount))
+			continue
+		}
+		result.blocked++
+		for _, f := range findings {
+			if f.Count > 0 && !tolerated(f) {
+				result.blockedByKind[f.Kind]++
+			}
+		}
+	}
+	return result, nil
+}
+
+// run scans the namespaces every interval until the stop channel is closed, updating the gauges
+func (s *namespaceScanner) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.update()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// update scans the namespaces and sets the gauges, which are left unchanged if the scan fails
+func (s *namespaceScanner) update() {
+	start := time.Now()
+	result, err := s.scan()
+	if err != nil {
+		log.Errorf("Error occurred while listing the namespaces to scan: %s", err.Error())
+		return
+	}
+	namespacesScanned.Set(float64(result.scanned))
+	namespacesBlocked.Set(float64(result.blocked))
+	for _, c := range clientCounters(s.client, dynamicClients) {
+		namespacesBlockedByKind.WithLabelValues(c.kind).Set(float64(result.blockedByKind[c.kind]))
+	}
+	log.Infof("Scanned %d namespaces in %v, the deletion of %d would be blocked", result.scanned, time.Since(start), result.blocked)
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
+)
+
+func TestNamespaceScanner(t *testing.T) {
+	namespace := func(name string, annotations map[string]string, phase corev1.NamespacePhase) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: v1.ObjectMeta{Name: name, Annotations: annotations},
+			Status:     corev1.NamespaceStatus{Phase: phase},
+		}
+	}
+	pod := func(namespace, name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: namespace}}
+	}
+	onePod := contentFingerprint([]resourceFinding{{Kind: "pods", Count: 1}})
+	client := fake.NewSimpleClientset(
+		namespace("empty", nil, corev1.NamespaceActive),
+		namespace("web", nil, corev1.NamespaceActive),
+		pod("web", "web-0"),
+		&appsv1beta1.Deployment{ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "web"}},
+		namespace("batch", nil, corev1.NamespaceActive),
+		pod("batch", "job-0"),
+		namespace("bypassed", map[string]string{bypassAnnotationKey: "true"}, corev1.NamespaceActive),
+		pod("bypassed", "web-0"),
+		namespace("terminating", nil, corev1.NamespaceTerminating),
+		pod("terminating", "web-0"),
+	)
+	scanner := &namespaceScanner{client: client}
+
+	result, err := scanner.scan()
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 5, result.scanned)
+	assert.Equal(t, 2, result.blocked, "should only count the active namespaces holding resources without bypass")
+	assert.Equal(t, map[string]int{"pods": 2, "deployments": 1}, result.blockedByKind)
+
+	*maxResourceCount = 1
+	defer func() { *maxResourceCount = 0 }()
+	scanner.update()
+	gauge := func(g interface {
+		Write(*dto.Metric) error
+	}) float64 {
+		m := &dto.Metric{}
+		g.Write(m)
+		return m.GetGauge().GetValue()
+	}
+	assert.Equal(t, float64(5), gauge(namespacesScanned))
+	assert.Equal(t, float64(1), gauge(namespacesBlocked), "should tolerate maxResourceCount resources")
+	assert.Equal(t, float64(1), gauge(namespacesBlockedByKind.WithLabelValues("deployments")))
+	assert.Equal(t, float64(0), gauge(namespacesBlockedByKind.WithLabelValues("services")))
+
+	*maxResourceCount = 0
+	*guardPersistentVolumeClaims = true
+	defer func() { *guardPersistentVolumeClaims = false }()
+	scanner.client = fake.NewSimpleClientset(
+		namespace("fingerprinted", map[string]string{bypassAnnotationKey: onePod}, corev1.NamespaceActive),
+		pod("fingerprinted", "web-0"),
+		namespace("stale", map[string]string{bypassAnnotationKey: onePod}, corev1.NamespaceActive),
+		pod("stale", "web-0"),
+		pod("stale", "web-1"),
+		namespace("claims", nil, corev1.NamespaceActive),
+		&corev1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Name: "data", Namespace: "claims"}},
+	)
+	result, err = scanner.scan()
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 2, result.blocked, "should block the namespaces whose fingerprinted bypass is stale, as the webhook does")
+	assert.Equal(t, map[string]int{"pods": 1, "persistentvolumeclaims": 1}, result.blockedByKind, "should count the kinds counted by the webhook")
+}
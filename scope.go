@@ -0,0 +1,163 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	authorizationv1 "k8s.io/client-go/pkg/apis/authorization/v1"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// impersonationConfig is the config of the clients impersonating the requesting users, nil unless
+	// --impersonateUser is set
+	impersonationConfig *rest.Config
+)
+
+// requesterCanList returns true if the requester may list the kind in the namespace, according to a
+// SubjectAccessReview on behalf of the requester
+func requesterCanList(userInfo authenticationv1.UserInfo, namespace, kind string) (bool, error) {
+	return reviewRequesterAccess(userInfo, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "list",
+		Group:     kindGroups[kind],
+		Resource:  kindResource(kind),
+	})
+}
+
+// requesterCanDelete returns true if the requester may delete the kind in the namespace, according to
+// a SubjectAccessReview on behalf of the requester
+func requesterCanDelete(userInfo authenticationv1.UserInfo, namespace, kind string) (bool, error) {
+	return reviewRequesterAccess(userInfo, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "delete",
+		Group:     kindGroups[kind],
+		Resource:  kindResource(kind),
+	})
+}
+
+// undeletableKinds returns the kinds blocking the deletion of the namespace that the requester may not
+// delete in it, reviewing each kind found inside the namespace
+func undeletableKinds(namespace string, findings []resourceFinding, userInfo authenticationv1.UserInfo) ([]string, error) {
+	var kinds []string
+	for _, f := range findings {
+		if f.Count == 0 || f.External || tolerated(f) {
+			continue
+		}
+		allowed, err := requesterCanDelete(userInfo, namespace, f.Kind)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			kinds = append(kinds, f.Kind)
+		}
+	}
+	return kinds, nil
+}
+
+// contentAuthzMessage returns the denial of the namespace deletion by a requester who may not delete
+// some of the blocking resources individually, the given message if the requester may delete them all.
+// The given message is also returned if the access of the requester could not be reviewed.
+func contentAuthzMessage(namespace string, findings []resourceFinding, userInfo authenticationv1.UserInfo, message string) string {
+	kinds, err := undeletableKinds(namespace, findings, userInfo)
+	if err != nil {
+		log.Errorf("Error occurred while reviewing the access of %s to the resources of namespace %s, falling back to the resource checks: %s", userInfo.Username, namespace, err.Error())
+		return message
+	}
+	if len(kinds) == 0 {
+		return message
+	}
+	log.Warnf("User %s may not delete the %v of namespace %s.", userInfo.Username, kinds, namespace)
+	return fmt.Sprintf("The namespace %s you are trying to remove contains %v that %s is not authorized to delete, deleting the namespace would delete them on your behalf. Please ask their owners to delete them and try again.", namespace, kinds, userInfo.Username)
+}
+
+// reviewRequesterAccess returns true if the requester is allowed the resource attributes, according
+// to a SubjectAccessReview on behalf of the requester
+func reviewRequesterAccess(userInfo authenticationv1.UserInfo, attributes *authorizationv1.ResourceAttributes) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range userInfo.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attributes,
+			User:               userInfo.Username,
+			Groups:             userInfo.Groups,
+			Extra:              extra,
+		},
+	}
+	review, err := clientset.AuthorizationV1().SubjectAccessReviews().Create(review)
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// requesterCounters returns the counters of the kinds the requester may list in the namespace, so
+// that resources the requester can't even see don't block the deletion. The counters of the kinds
+// that could not be reviewed are kept.
+func requesterCounters(counters []resourceCounter, namespace string, userInfo authenticationv1.UserInfo) []resourceCounter {
+	var scoped []resourceCounter
+	for _, c := range counters {
+		allowed, err := requesterCanList(userInfo, namespace, c.kind)
+		if err != nil {
+			log.Errorf("Error occurred while reviewing the access of %s to %s in namespace %s, counting them: %s", userInfo.Username, c.kind, namespace, err.Error())
+			allowed = true
+		}
+		if !allowed {
+			log.Infof("User %s may not list %s in namespace %s, not counting them.", userInfo.Username, c.kind, namespace)
+			continue
+		}
+		scoped = append(scoped, c)
+	}
+	return scoped
+}
+
+// impersonatedCounters returns the counters of the same kinds listing the resources as the requester,
+// with clients of the config impersonating the user, groups and extra of the request. The kinds the
+// requester may not list are not counted, so that their resources are neither counted nor named.
+func impersonatedCounters(config *rest.Config, counters []resourceCounter, userInfo authenticationv1.UserInfo) ([]resourceCounter, error) {
+	impersonated := *config
+	impersonated.Impersonate = rest.ImpersonationConfig{
+		UserName: userInfo.Username,
+		Groups:   userInfo.Groups,
+		Extra:    map[string][]string{},
+	}
+	for key, value := range userInfo.Extra {
+		impersonated.Impersonate.Extra[key] = []string(value)
+	}
+	client, err := kubernetes.NewForConfig(&impersonated)
+	if err != nil {
+		return nil, err
+	}
+	byKind := map[string]func(namespace string) ([]string, error){}
+	for _, c := range clientCounters(client, dynamic.NewDynamicClientPool(&impersonated)) {
+		byKind[c.kind] = c.counter
+	}
+
+	scoped := make([]resourceCounter, 0, len(counters))
+	for _, c := range counters {
+		if counter, ok := byKind[c.kind]; ok {
+			c.counter = visibleCounter(userInfo.Username, c.kind, counter)
+		}
+		scoped = append(scoped, c)
+	}
+	return scoped, nil
+}
+
+// visibleCounter returns the counter finding no objects when the requester is forbidden to list them
+func visibleCounter(user, kind string, counter func(namespace string) ([]string, error)) func(namespace string) ([]string, error) {
+	return func(namespace string) ([]string, error) {
+		names, err := counter(namespace)
+		if apiErrors.IsForbidden(err) {
+			log.Infof("User %s may not list %s in namespace %s, not counting them.", user, kind, namespace)
+			return nil, nil
+		}
+		return names, err
+	}
+}
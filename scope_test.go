@@ -0,0 +1,199 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	authorizationv1 "k8s.io/client-go/pkg/apis/authorization/v1"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// subjectAccessReviewClientset returns a clientset holding the objects, where the requester may list
+// every kind but the denied ones
+func subjectAccessReviewClientset(t *testing.T, denied []string, objects ...runtime.Object) *fake.Clientset {
+	fakeClientset := fake.NewSimpleClientset(objects...)
+	fakeClientset.PrependReactor("create", "subjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		assert.Equal(t, "alice", review.Spec.User)
+		assert.Equal(t, []string{"team-a"}, review.Spec.Groups)
+		assert.Equal(t, authorizationv1.ExtraValue{"ci"}, review.Spec.Extra["scopes"])
+		assert.Equal(t, "list", review.Spec.ResourceAttributes.Verb)
+		assert.Equal(t, "test-namespace", review.Spec.ResourceAttributes.Namespace)
+		review.Status.Allowed = true
+		for _, kind := range denied {
+			if review.Spec.ResourceAttributes.Resource == kind {
+				review.Status.Allowed = false
+			}
+		}
+		return true, review, nil
+	})
+	return fakeClientset
+}
+
+var requester = authenticationv1.UserInfo{
+	Username: "alice",
+	Groups:   []string{"team-a"},
+	Extra:    map[string]authenticationv1.ExtraValue{"scopes": {"ci"}},
+}
+
+func TestRequesterCounters(t *testing.T) {
+	clientset = subjectAccessReviewClientset(t, []string{"pods", "statefulsets"})
+	var kinds []string
+	for _, c := range requesterCounters(resourceCounters(), "test-namespace", requester) {
+		kinds = append(kinds, c.kind)
+	}
+	assert.Equal(t, []string{"services", "replicasets", "deployments", "daemonsets", "ingresses", "horizontalpodautoscalers"}, kinds)
+
+	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset.PrependReactor("create", "subjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, &authorizationv1.SubjectAccessReview{}, errors.New("apiserver unavailable")
+	})
+	clientset = fakeClientset
+	assert.Equal(t, len(resourceCounters()), len(requesterCounters(resourceCounters(), "test-namespace", requester)), "should count the kinds that could not be reviewed")
+}
+
+func TestScopeToRequesterWebhookHandler(t *testing.T) {
+	testPod := &corev1.Pod{}
+	testPod.Name = "system-pod"
+	testPod.Namespace = "test-namespace"
+	testSvc := &corev1.Service{}
+	testSvc.Name = "team-svc"
+	testSvc.Namespace = "test-namespace"
+	clientset = subjectAccessReviewClientset(t, []string{"pods"}, cloneNamespace(templateNamespace), testPod, testSvc)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo = requester
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed)
+	assert.Contains(t, admReview.Status.Result.Message, "[pods(1) services(1)]", "the service account sees every resource")
+
+	*scopeToRequester = true
+	defer func() { *scopeToRequester = false }()
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	admReview = getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed)
+	assert.Contains(t, admReview.Status.Result.Message, "[services(1)]", "should only count the resources the requester may list")
+	assert.NotContains(t, admReview.Status.Result.Message, "pods")
+}
+
+func TestImpersonatedCounters(t *testing.T) {
+	// the apiserver lets alice list the pods but not the services
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "alice", req.Header.Get("Impersonate-User"))
+		assert.Equal(t, []string{"team-a"}, req.Header["Impersonate-Group"])
+		assert.Equal(t, "ci", req.Header.Get("Impersonate-Extra-Scopes"))
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/api/v1/namespaces/test-namespace/pods":
+			fmt.Fprint(rw, `{"kind":"PodList","apiVersion":"v1","metadata":{},"items":[{"metadata":{"name":"web-0","namespace":"test-namespace"}}]}`)
+		case "/api/v1/namespaces/test-namespace/services":
+			rw.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(rw, `{"kind":"Status","apiVersion":"v1","metadata":{},"status":"Failure","message":"services is forbidden","reason":"Forbidden","code":403}`)
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer server.Close()
+
+	var counters []resourceCounter
+	for _, c := range resourceCounters() {
+		if c.kind == "pods" || c.kind == "services" {
+			counters = append(counters, c)
+		}
+	}
+	requester := authenticationv1.UserInfo{Username: "alice", Groups: []string{"team-a"}, Extra: map[string]authenticationv1.ExtraValue{"scopes": {"ci"}}}
+	counters, err := impersonatedCounters(&rest.Config{Host: server.URL}, counters, requester)
+	assert.Nil(t, err)
+	// the service account sees a service the requester may not list
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), &corev1.Service{ObjectMeta: v1.ObjectMeta{Name: "internal", Namespace: "test-namespace"}})
+	findings, errList := findResources("test-namespace", counters)
+	assert.Empty(t, errList)
+	assert.Equal(t, []resourceFinding{{Kind: "pods", Count: 1, Names: []string{"web-0"}}, {Kind: "services"}}, findings,
+		"should list the pods as the requester and not count the services it may not list")
+}
+
+// deleteAccessClientset returns a clientset holding the objects, where the SubjectAccessReviews of the
+// delete verb are answered by the outcomes of the resources, the requester being allowed the others
+func deleteAccessClientset(t *testing.T, outcomes map[string]error, denied []string, objects ...runtime.Object) *fake.Clientset {
+	fakeClientset := fake.NewSimpleClientset(objects...)
+	fakeClientset.PrependReactor("create", "subjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		assert.Equal(t, "alice", review.Spec.User)
+		assert.Equal(t, "delete", review.Spec.ResourceAttributes.Verb)
+		assert.Equal(t, "test-namespace", review.Spec.ResourceAttributes.Namespace)
+		if err := outcomes[review.Spec.ResourceAttributes.Resource]; err != nil {
+			return true, &authorizationv1.SubjectAccessReview{}, err
+		}
+		review.Status.Allowed = true
+		for _, kind := range denied {
+			if review.Spec.ResourceAttributes.Resource == kind {
+				review.Status.Allowed = false
+			}
+		}
+		return true, review, nil
+	})
+	return fakeClientset
+}
+
+func TestUndeletableKinds(t *testing.T) {
+	findings := []resourceFinding{
+		{Kind: "pods", Count: 2},
+		{Kind: "services"},
+		{Kind: "persistentvolumeclaims", Count: 1},
+		{Kind: "deployments", Count: 1},
+		{Kind: "clusterrolebindings", Count: 1, External: true},
+	}
+	clientset = deleteAccessClientset(t, nil, []string{"persistentvolumeclaims", "services", "clusterrolebindings"})
+	kinds, err := undeletableKinds("test-namespace", findings, requester)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"persistentvolumeclaims"}, kinds, "should only review the kinds found inside the namespace")
+
+	clientset = deleteAccessClientset(t, map[string]error{"deployments": errors.New("apiserver unavailable")}, []string{"persistentvolumeclaims"})
+	_, err = undeletableKinds("test-namespace", findings, requester)
+	assert.NotNil(t, err)
+	assert.Equal(t, "denied", contentAuthzMessage("test-namespace", findings, requester, "denied"), "should fall back to the given message")
+}
+
+func TestRequireContentAuthzWebhookHandler(t *testing.T) {
+	*requireContentAuthz = true
+	defer func() { *requireContentAuthz = false }()
+	review := func(denied []string, outcomes map[string]error) string {
+		clientset = deleteAccessClientset(t, outcomes, denied, namespaceWithPods(2)...)
+		testSpec := cloneAdmissionReview(templateAdmReview)
+		testSpec.Spec.UserInfo = requester
+		rw := httptest.NewRecorder()
+		webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec)))
+		admReview := getAdmissionReview(rw)
+		assert.False(t, admReview.Status.Allowed)
+		return admReview.Status.Result.Message
+	}
+
+	message := review([]string{"pods"}, nil)
+	assert.Contains(t, message, "The namespace test-namespace you are trying to remove contains [pods] that alice is not authorized to delete")
+	assert.NotContains(t, message, bypassHint, "should not hint at the bypass")
+
+	message = review(nil, nil)
+	assert.Contains(t, message, "[pods(2)]")
+	assert.Contains(t, message, bypassHint, "should give the normal denial to a requester authorized over the contents")
+
+	message = review([]string{"pods"}, map[string]error{"pods": errors.New("apiserver unavailable")})
+	assert.Contains(t, message, bypassHint, "should fall back to the normal denial when the review fails")
+}
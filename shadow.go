@@ -0,0 +1,88 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	autoscalingv1 "k8s.io/client-go/pkg/apis/autoscaling/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	// shadowCounters are the informer backed counters compared against the live counters when
+	// --shadowCompare is set, keyed by resource kind
+	shadowCounters map[string]func(namespace string) ([]string, error)
+)
+
+// informerCounter returns a counter listing the names of the objects cached by the informer which
+// match --systemManagedSelector and --resourceAgeCutoff. If given, only the objects matching the filter are counted.
+func informerCounter(informer cache.SharedIndexInformer, filter func(obj interface{}) bool) func(namespace string) ([]string, error) {
+	return func(namespace string) ([]string, error) {
+		selector, err := labels.Parse(*systemManagedSelector)
+		if err != nil {
+			return nil, err
+		}
+		items, err := informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(items))
+		for _, item := range items {
+			if filter != nil && !filter(item) {
+				continue
+			}
+			accessor, err := meta.Accessor(item)
+			if err != nil {
+				return nil, err
+			}
+			if !selector.Matches(labels.Set(accessor.GetLabels())) || !countedAge(accessor.GetCreationTimestamp()) {
+				continue
+			}
+			names = append(names, accessor.GetName())
+		}
+		return names, nil
+	}
+}
+
+// isActiveHPAObject applies isActiveHPA to a cached HPA
+func isActiveHPAObject(obj interface{}) bool {
+	hpa, ok := obj.(*autoscalingv1.HorizontalPodAutoscaler)
+	return ok && isActiveHPA(hpa)
+}
+
+// newShadowCounters builds an informer backed counter for every kind counted by resourceCounters.
+// The factory has to be started and synced by the caller.
+func newShadowCounters(factory informers.SharedInformerFactory) map[string]func(namespace string) ([]string, error) {
+	return map[string]func(namespace string) ([]string, error){
+		"pods":                     informerCounter(factory.Core().V1().Pods().Informer(), nil),
+		"services":                 informerCounter(factory.Core().V1().Services().Informer(), nil),
+		"replicasets":              informerCounter(factory.Extensions().V1beta1().ReplicaSets().Informer(), nil),
+		"deployments":              informerCounter(factory.Apps().V1beta1().Deployments().Informer(), nil),
+		"statefulsets":             informerCounter(factory.Apps().V1beta1().StatefulSets().Informer(), nil),
+		"daemonsets":               informerCounter(factory.Extensions().V1beta1().DaemonSets().Informer(), nil),
+		"ingresses":                informerCounter(factory.Extensions().V1beta1().Ingresses().Informer(), nil),
+		"horizontalpodautoscalers": informerCounter(factory.Autoscaling().V1().HorizontalPodAutoscalers().Informer(), isActiveHPAObject),
+	}
+}
+
+// compareShadowCounts compares the live findings of a namespace against the informer cache, logging
+// and counting every kind whose counts diverge. The live findings are always the ones acted upon.
+func compareShadowCounts(namespace string, findings []resourceFinding) {
+	for _, f := range findings {
+		counter, ok := shadowCounters[f.Kind]
+		if !ok {
+			continue
+		}
+		names, err := counter(namespace)
+		if err != nil {
+			log.Errorf("Error occurred while listing %s in namespace %s from the informer cache: %s", f.Kind, namespace, err.Error())
+			continue
+		}
+		if len(names) != f.Count {
+			log.Warnf("Shadow count mismatch for %s in namespace %s: live count %d, informer count %d", f.Kind, namespace, f.Count, len(names))
+			shadowDivergenceTotal.WithLabelValues(f.Kind).Inc()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func shadowDivergence(kind string) float64 {
+	m := &dto.Metric{}
+	err := shadowDivergenceTotal.WithLabelValues(kind).Write(m)
+	if err != nil {
+		panic(err.Error())
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestStaleInformerShadowCompare(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	testPod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+		},
+	}
+	testNamespace := cloneNamespace(templateNamespace)
+	clientset = fake.NewSimpleClientset(testNamespace)
+
+	// the informer is never started, its cache only holds a pod deleted since
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	assert.Nil(t, factory.Core().V1().Pods().Informer().GetIndexer().Add(testPod))
+	shadowCounters = newShadowCounters(factory)
+	*shadowCompare = true
+	defer func() {
+		*shadowCompare = false
+		shadowCounters = nil
+	}()
+
+	podsBefore, servicesBefore := shadowDivergence("pods"), shadowDivergence("services")
+
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+
+	admReview := getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should act on the live counts when the informer cache diverges")
+	assert.Equal(t, podsBefore+1, shadowDivergence("pods"), "should count the pods divergence")
+	assert.Equal(t, servicesBefore, shadowDivergence("services"), "should not count matching kinds")
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// backgroundTasks tracks the records written in the background once a request is answered, e.g. the
+// deletion snapshots and audit events, so that they can complete before exiting
+var backgroundTasks = &taskGroup{}
+
+// taskGroup counts the running tasks, unlike a sync.WaitGroup it can be waited on with a timeout
+type taskGroup struct {
+	sync.Mutex
+	running int
+	// idle is closed once no task is running
+	idle chan struct{}
+}
+
+func (g *taskGroup) start() {
+	g.Lock()
+	defer g.Unlock()
+	if g.running == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.running++
+}
+
+func (g *taskGroup) done() {
+	g.Lock()
+	defer g.Unlock()
+	g.running--
+	if g.running == 0 {
+		close(g.idle)
+	}
+}
+
+// wait waits for the running tasks for up to the timeout, returning false if some are still running
+func (g *taskGroup) wait(timeout time.Duration) bool {
+	g.Lock()
+	if g.running == 0 {
+		g.Unlock()
+		return true
+	}
+	idle := g.idle
+	g.Unlock()
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// runInBackground runs the function in a goroutine tracked by backgroundTasks
+func runInBackground(f func()) {
+	backgroundTasks.start()
+	go func() {
+		defer backgroundTasks.done()
+		f()
+	}()
+}
+
+// connTracker tracks the open connections of a server through its ConnState hook
+type connTracker struct {
+	sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: map[net.Conn]http.ConnState{}}
+}
+
+// track records the state of the connection, forgetting it once closed or hijacked
+func (c *connTracker) track(conn net.Conn, state http.ConnState) {
+	c.Lock()
+	defer c.Unlock()
+	if state == http.StateClosed || state == http.StateHijacked {
+		delete(c.conns, conn)
+		return
+	}
+	c.conns[conn] = state
+}
+
+// count returns the number of open connections
+func (c *connTracker) count() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.conns)
+}
+
+// shutdownServer drains the in-flight requests of the server for up to the timeout, then force closes
+// the remaining connections so that a stuck request can't block the shutdown. It returns the number of
+// connections forcibly closed.
+func shutdownServer(srv *http.Server, conns *connTracker, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		return 0, err
+	}
+	remaining := conns.count()
+	log.Warnf("Graceful shutdown did not complete within %v, forcibly closing %d connections", timeout, remaining)
+	return remaining, srv.Close()
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTrackedServer serves the handler on a loopback port, tracking its connections
+func startTrackedServer(t *testing.T, handler http.Handler) (*http.Server, *connTracker, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	conns := newConnTracker()
+	srv := &http.Server{Handler: handler, ConnState: conns.track}
+	go srv.Serve(listener)
+	return srv, conns, "http://" + listener.Addr().String()
+}
+
+func TestShutdownServerForceClose(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv, conns, url := startTrackedServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(entered)
+		<-release
+	}))
+
+	errCh := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errCh <- err
+	}()
+	<-entered
+
+	start := time.Now()
+	forced, err := shutdownServer(srv, conns, 100*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, forced, "should force close the connection of the stuck request")
+	assert.True(t, time.Since(start) < 5*time.Second, "should not wait for the stuck request")
+
+	select {
+	case err := <-errCh:
+		assert.NotNil(t, err, "the stuck request should fail once its connection is closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stuck request was not closed")
+	}
+}
+
+func TestShutdownServerDrain(t *testing.T) {
+	srv, conns, url := startTrackedServer(t, http.HandlerFunc(statusHandler))
+	resp, err := http.Get(url)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	forced, err := shutdownServer(srv, conns, 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, forced, "should close idle connections gracefully")
+}
+
+// waitForBackgroundTasks waits for the records written in the background by the previous requests,
+// which read the clientset and flags the tests reassign
+func waitForBackgroundTasks(t *testing.T) {
+	if !backgroundTasks.wait(5 * time.Second) {
+		t.Fatal("the background tasks did not complete")
+	}
+}
+
+func TestBackgroundTasksWait(t *testing.T) {
+	release := make(chan struct{})
+	runInBackground(func() { <-release })
+	assert.False(t, backgroundTasks.wait(50*time.Millisecond), "should time out while a task is running")
+
+	close(release)
+	assert.True(t, backgroundTasks.wait(5*time.Second), "should return once the tasks complete")
+	assert.True(t, backgroundTasks.wait(0), "should return right away without running tasks")
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	bypassAnnotation  = "annotation"
+	bypassForceDelete = "forceDelete"
+
+	snapshotDataKey        = "snapshot.json"
+	snapshotNamespaceLabel = "namespace-guard.io/deleted-namespace"
+)
+
+var (
+	// snapshotCountTimeout bounds the listing of the resources of a snapshot before the deletion is
+	// admitted, so that a slow apiserver does not delay the admission response
+	snapshotCountTimeout = 2 * time.Second
+)
+
+// deletionSnapshot records what a namespace held when its deletion was allowed and who deleted it
+type deletionSnapshot struct {
+	Namespace         string            `json:"namespace"`
+	UID               string            `json:"uid"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	User              string            `json:"user"`
+	Bypass            string            `json:"bypass,omitempty"`
+	Resources         []resourceFinding `json:"resources"`
+	Errors            []string          `json:"errors,omitempty"`
+	Timestamp         time.Time         `json:"timestamp"`
+}
+
+// newDeletionSnapshot returns the snapshot of the namespace with the resources found in it
+func newDeletionSnapshot(namespace *corev1.Namespace, user, bypass string, findings []resourceFinding, errList []error, now time.Time) deletionSnapshot {
+	snapshot := deletionSnapshot{
+		Namespace:         namespace.Name,
+		UID:               string(namespace.UID),
+		Labels:            namespace.Labels,
+		Annotations:       namespace.Annotations,
+		CreationTimestamp: namespace.CreationTimestamp.Time,
+		User:              user,
+		Bypass:            bypass,
+		Resources:         findings,
+		Timestamp:         now.UTC(),
+	}
+	for _, e := range errList {
+		snapshot.Errors = append(snapshot.Errors, e.Error())
+	}
+	return snapshot
+}
+
+// recordDeletionSnapshot logs the snapshot of a namespace whose deletion was allowed and archives it
+// to a ConfigMap if --snapshotNamespace is set. The resources are listed with the counters when no
+// findings are given, e.g. on bypass, before the deletion is admitted so that the snapshot holds what
+// was deleted, for at most the snapshotCountTimeout. Only the snapshot is written in the background
+// and errors are only logged.
+func recordDeletionSnapshot(namespace *corev1.Namespace, user, bypass string, findings []resourceFinding, errList []error, counters []resourceCounter) {
+	archiveNamespace := *snapshotNamespace
+	if findings == nil {
+		findings, errList = countSnapshotResources(namespace.Name, counters, snapshotCountTimeout)
+	}
+	runInBackground(func() {
+		snapshot := newDeletionSnapshot(namespace, user, bypass, findings, errList, time.Now())
+		err := writeDeletionSnapshot(snapshot, archiveNamespace)
+		if err != nil {
+			log.Errorf("Error occurred while writing the deletion snapshot of namespace %s: %s", namespace.Name, err.Error())
+		}
+	})
+}
+
+// countSnapshotResources lists the resources of the namespace with the counters, giving up after the
+// timeout. The counts are then unknown and the timeout is returned as the error.
+func countSnapshotResources(namespace string, counters []resourceCounter, timeout time.Duration) ([]resourceFinding, []error) {
+	type counts struct {
+		findings []resourceFinding
+		errList  []error
+	}
+	done := make(chan counts, 1)
+	go func() {
+		findings, errList := findResources(namespace, counters)
+		done <- counts{findings, errList}
+	}()
+	select {
+	case c := <-done:
+		return c.findings, c.errList
+	case <-time.After(timeout):
+		log.Warnf("The resources of namespace %s were not counted within %v, its deletion snapshot holds no counts.", namespace, timeout)
+		return nil, []error{fmt.Errorf("the resources were not counted within %v, their counts are unknown", timeout)}
+	}
+}
+
+// writeDeletionSnapshot logs the snapshot and stores it in a ConfigMap in the archive namespace, if
+// given, named after the deleted namespace and the snapshot time
+func writeDeletionSnapshot(snapshot deletionSnapshot, archiveNamespace string) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	log.Infof("Deletion snapshot of namespace %s: %s", snapshot.Namespace, data)
+	if archiveNamespace == "" {
+		return nil
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", snapshot.Namespace, snapshot.Timestamp.Unix()),
+			Namespace: archiveNamespace,
+			Labels:    map[string]string{snapshotNamespaceLabel: snapshot.Namespace},
+		},
+		Data: map[string]string{snapshotDataKey: string(data)},
+	}
+	_, err = clientset.CoreV1().ConfigMaps(archiveNamespace).Create(configMap)
+	return err
+}
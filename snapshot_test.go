@@ -0,0 +1,106 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// waitForSnapshot returns the snapshot archived in the namespace once the background records are
+// written
+func waitForSnapshot(t *testing.T, archiveNamespace string) deletionSnapshot {
+	snapshot := deletionSnapshot{}
+	waitForBackgroundTasks(t)
+	list, err := clientset.CoreV1().ConfigMaps(archiveNamespace).List(v1.ListOptions{})
+	assert.Nil(t, err)
+	if len(list.Items) == 0 {
+		t.Fatal("no deletion snapshot was archived")
+	}
+	assert.Equal(t, "test-namespace", list.Items[0].Labels[snapshotNamespaceLabel])
+	assert.Regexp(t, "^test-namespace-[0-9]+$", list.Items[0].Name)
+	assert.Nil(t, json.Unmarshal([]byte(list.Items[0].Data[snapshotDataKey]), &snapshot))
+	return snapshot
+}
+
+func TestBypassDeletionSnapshot(t *testing.T) {
+	*snapshotNamespace = "guard-archive"
+	defer func() { *snapshotNamespace = "" }()
+
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true"}
+	testPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+	clientset = fake.NewSimpleClientset(testNamespace, testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the bypass annotation is set")
+	// the namespace contents are gone once the deletion is admitted
+	assert.Nil(t, clientset.CoreV1().Pods("test-namespace").Delete("test-pod", &v1.DeleteOptions{}))
+
+	snapshot := waitForSnapshot(t, "guard-archive")
+	assert.Equal(t, "test-namespace", snapshot.Namespace)
+	assert.Equal(t, "alice", snapshot.User)
+	assert.Equal(t, bypassAnnotation, snapshot.Bypass)
+	assert.Equal(t, "true", snapshot.Annotations[bypassAnnotationKey])
+	assert.Equal(t, 1, totalResourceCount(snapshot.Resources))
+	assert.Equal(t, resourceFinding{Kind: "pods", Count: 1, Names: []string{"test-pod"}}, snapshot.Resources[0])
+}
+
+func TestEmptyDeletionSnapshot(t *testing.T) {
+	*snapshotNamespace = "guard-archive"
+	defer func() { *snapshotNamespace = "" }()
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "bob"
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the namespace is empty")
+
+	snapshot := waitForSnapshot(t, "guard-archive")
+	assert.Equal(t, "bob", snapshot.User)
+	assert.Equal(t, "", snapshot.Bypass)
+	assert.Equal(t, len(resourceCounters()), len(snapshot.Resources))
+	assert.Equal(t, 0, totalResourceCount(snapshot.Resources))
+}
+
+func TestSlowCountDeletionSnapshot(t *testing.T) {
+	*snapshotNamespace = "guard-archive"
+	defer func() { *snapshotNamespace = "" }()
+	defer func(timeout time.Duration) { snapshotCountTimeout = timeout }(snapshotCountTimeout)
+	snapshotCountTimeout = 50 * time.Millisecond
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	testNamespace := cloneNamespace(templateNamespace)
+	testNamespace.Annotations = map[string]string{bypassAnnotationKey: "true"}
+	fakeClientset := fake.NewSimpleClientset(testNamespace)
+	fakeClientset.PrependReactor("list", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		<-unblock
+		return false, nil, nil
+	})
+	clientset = fakeClientset
+
+	start := time.Now()
+	rw := httptest.NewRecorder()
+	webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview))))
+	assert.True(t, getAdmissionReview(rw).Status.Allowed, "should approve if the bypass annotation is set")
+	assert.True(t, time.Since(start) < time.Second, "should not wait for a slow count to admit the deletion")
+
+	snapshot := waitForSnapshot(t, "guard-archive")
+	assert.Empty(t, snapshot.Resources)
+	assert.Equal(t, []string{"the resources were not counted within 50ms, their counts are unknown"}, snapshot.Errors)
+}
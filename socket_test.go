@@ -0,0 +1,76 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSocketMode(t *testing.T) {
+	mode, err := parseSocketMode("0660")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), mode)
+
+	_, err = parseSocketMode("rw-rw----")
+	assert.NotNil(t, err)
+	_, err = parseSocketMode("1777")
+	assert.NotNil(t, err)
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "guard.sock")
+
+	listener, err := listenUnixSocket(path, 0600)
+	assert.Nil(t, err)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status.html", statusHandler)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", path) },
+	}}
+	resp, err := client.Get("http://unix/status.html")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "OK", string(body))
+}
+
+func TestListenUnixSocketStaleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a socket left behind by a previous run is replaced
+	path := filepath.Join(dir, "guard.sock")
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err := listenUnixSocket(path, 0660)
+	assert.Nil(t, err)
+	listener.Close()
+
+	// any other file is kept
+	path = filepath.Join(dir, "guard.conf")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("keep"), 0644))
+	_, err = listenUnixSocket(path, 0660)
+	assert.EqualError(t, err, path+" exists and is not a unix socket")
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+var (
+	// spiffeSource serves the SVIDs of the SPIFFE Workload API, nil unless --spiffe is set
+	spiffeSource *svidWatcher
+)
+
+// svidWatcher keeps the latest X509-SVID and trust bundle delivered by the SPIFFE Workload API, along
+// with the error of the watch since the last update
+type svidWatcher struct {
+	sync.Mutex
+	svid        *x509svid.SVID
+	authorities []*x509.Certificate
+	err         error
+	// monitorBundle monitors the expiry of the trust bundle as the client CA, with --spiffeTrustBundle
+	monitorBundle bool
+	// updated is closed on the first update
+	updated chan struct{}
+}
+
+func newSVIDWatcher() *svidWatcher {
+	return &svidWatcher{updated: make(chan struct{})}
+}
+
+// OnX509ContextUpdate records the default SVID and the bundle of its trust domain
+func (w *svidWatcher) OnX509ContextUpdate(update *workloadapi.X509Context) {
+	svid := update.DefaultSVID()
+	bundle, err := update.Bundles.GetX509BundleForTrustDomain(svid.ID.TrustDomain())
+	if err != nil {
+		w.OnX509ContextWatchError(err)
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	first := w.svid == nil
+	w.svid = svid
+	w.authorities = bundle.X509Authorities()
+	w.err = nil
+	monitoredCerts.set(servingCertificateName, svid.Certificates[0].NotAfter)
+	if w.monitorBundle && len(w.authorities) > 0 {
+		monitoredCerts.set(clientCACertificateName, earliestNotAfter(w.authorities))
+	}
+	log.Infof("Received the SVID %s expiring on %s from the SPIFFE Workload API", svid.ID, svid.Certificates[0].NotAfter.Format(time.RFC3339))
+	if first {
+		close(w.updated)
+	}
+}
+
+// OnX509ContextWatchError records the error, the watch being retried by the Workload API client
+func (w *svidWatcher) OnX509ContextWatchError(err error) {
+	log.Errorf("Error occurred while watching the SPIFFE Workload API: %s", err.Error())
+	w.Lock()
+	defer w.Unlock()
+	w.err = err
+}
+
+// watch watches the Workload API at the address until the context is canceled
+func (w *svidWatcher) watch(ctx context.Context, address string) {
+	var opts []workloadapi.ClientOption
+	if address != "" {
+		opts = append(opts, workloadapi.WithAddr(address))
+	}
+	if err := workloadapi.WatchX509Context(ctx, w, opts...); err != nil && ctx.Err() == nil {
+		w.OnX509ContextWatchError(err)
+	}
+}
+
+// waitForSVID waits for the first SVID for up to the timeout, with no deadline when 0
+func (w *svidWatcher) waitForSVID(timeout time.Duration) error {
+	if timeout == 0 {
+		<-w.updated
+		return nil
+	}
+	select {
+	case <-w.updated:
+		return nil
+	case <-time.After(timeout):
+		w.Lock()
+		defer w.Unlock()
+		if w.err != nil {
+			return fmt.Errorf("no SVID was received within %v: %s", timeout, w.err.Error())
+		}
+		return fmt.Errorf("no SVID was received within %v", timeout)
+	}
+}
+
+// ready returns an error until an SVID is received, or while the Workload API is failing
+func (w *svidWatcher) ready() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return fmt.Errorf("the SPIFFE Workload API is failing: %s", w.err.Error())
+	}
+	if w.svid == nil {
+		return errors.New("no SVID was received from the SPIFFE Workload API")
+	}
+	return nil
+}
+
+// certificate returns the latest SVID as the serving certificate
+func (w *svidWatcher) certificate() (*tls.Certificate, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.svid == nil {
+		return nil, errors.New("no SVID was received from the SPIFFE Workload API")
+	}
+	cert := &tls.Certificate{PrivateKey: w.svid.PrivateKey, Leaf: w.svid.Certificates[0]}
+	for _, c := range w.svid.Certificates {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
+
+// trustPool returns the pool of the latest trust bundle
+func (w *svidWatcher) trustPool() *x509.CertPool {
+	w.Lock()
+	defer w.Unlock()
+	pool := x509.NewCertPool()
+	for _, authority := range w.authorities {
+		pool.AddCert(authority)
+	}
+	return pool
+}
+
+// tlsConfig returns the TLS config serving the latest SVID. With trustBundle, the client certificates
+// are verified against the latest trust bundle rather than the clientCAs.
+func (w *svidWatcher) tlsConfig(clientCAs *x509.CertPool, trustBundle bool) *tls.Config {
+	config := &tls.Config{
+		ClientCAs: clientCAs,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return w.certificate()
+		},
+	}
+	if *clientAuth {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if trustBundle {
+		// the bundle may rotate along with the SVID, so the client CAs are set on every handshake
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig := config.Clone()
+			clientConfig.GetConfigForClient = nil
+			clientConfig.ClientCAs = w.trustPool()
+			return clientConfig, nil
+		}
+	}
+	return config
+}
+
+// earliestNotAfter returns the earliest expiry of the certificates
+func earliestNotAfter(certs []*x509.Certificate) time.Time {
+	earliest := certs[0].NotAfter
+	for _, c := range certs[1:] {
+		if c.NotAfter.Before(earliest) {
+			earliest = c.NotAfter
+		}
+	}
+	return earliest
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testSPIFFEID = "spiffe://example.org/namespace-guard"
+
+// fakeWorkloadAPI streams the X509-SVID responses sent on its channel, failing once it is closed
+type fakeWorkloadAPI struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	responses chan *workload.X509SVIDResponse
+}
+
+func (f *fakeWorkloadAPI) FetchX509SVID(req *workload.X509SVIDRequest, stream workload.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	for {
+		select {
+		case resp, ok := <-f.responses:
+			if !ok {
+				return status.Error(codes.Unavailable, "the agent is shutting down")
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// startFakeWorkloadAPI serves the fake Workload API on a unix socket, returning its address
+func startFakeWorkloadAPI(t *testing.T) (*fakeWorkloadAPI, string, func()) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	assert.Nil(t, err)
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+	fake := &fakeWorkloadAPI{responses: make(chan *workload.X509SVIDResponse, 10)}
+	server := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(server, fake)
+	go server.Serve(listener)
+	return fake, "unix://" + socket, func() {
+		server.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// testTrustDomain is the CA signing the SVIDs of the example.org trust domain
+type testTrustDomain struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestTrustDomain(t *testing.T) *testTrustDomain {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.org"},
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: "example.org"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testTrustDomain{cert: cert, key: key}
+}
+
+// svidResponse returns the response delivering a new SVID with the serial number
+func (d *testTrustDomain) svidResponse(t *testing.T, serial int64, notAfter time.Time) *workload.X509SVIDResponse {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	id, _ := url.Parse(testSPIFFEID)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		URIs:         []*url.URL{id},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, d.cert, &key.PublicKey, d.key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	return &workload.X509SVIDResponse{Svids: []*workload.X509SVID{{
+		SpiffeId:    testSPIFFEID,
+		X509Svid:    der,
+		X509SvidKey: keyDER,
+		Bundle:      d.cert.Raw,
+	}}}
+}
+
+// eventually polls the condition for up to 5 seconds
+func eventually(condition func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSPIFFEServingCertificate(t *testing.T) {
+	fake, address, stop := startFakeWorkloadAPI(t)
+	defer stop()
+	domain := newTestTrustDomain(t)
+	firstExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	fake.responses <- domain.svidResponse(t, 1, firstExpiry)
+
+	spiffeSource = newSVIDWatcher()
+	spiffeSource.monitorBundle = true
+	defer func() { spiffeSource = nil }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go spiffeSource.watch(ctx, address)
+	assert.Nil(t, spiffeSource.waitForSVID(5*time.Second))
+	assert.Nil(t, spiffeSource.ready())
+
+	config := spiffeSource.tlsConfig(nil, true)
+	cert, err := config.GetCertificate(nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(1), cert.Leaf.SerialNumber.Int64())
+		assert.Equal(t, testSPIFFEID, cert.Leaf.URIs[0].String())
+	}
+	assert.True(t, firstExpiry.Equal(monitoredCerts.expiries[servingCertificateName]), "should monitor the expiry of the SVID")
+	assert.True(t, domain.cert.NotAfter.Equal(monitoredCerts.expiries[clientCACertificateName]), "should monitor the expiry of the trust bundle")
+
+	// the client certificates are verified with the trust bundle
+	clientConfig, err := config.GetConfigForClient(nil)
+	if assert.Nil(t, err) {
+		_, err = cert.Leaf.Verify(x509.VerifyOptions{Roots: clientConfig.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		assert.Nil(t, err, "should trust the certificates signed by the bundle")
+	}
+
+	// the SVID is rotated as it renews
+	fake.responses <- domain.svidResponse(t, 2, time.Now().Add(2*time.Hour))
+	assert.True(t, eventually(func() bool {
+		cert, err := config.GetCertificate(nil)
+		return err == nil && cert.Leaf.SerialNumber.Int64() == 2
+	}), "should serve the renewed SVID")
+
+	// the Workload API failures are surfaced in readiness
+	close(fake.responses)
+	assert.True(t, eventually(func() bool { return spiffeSource.ready() != nil }), "should not be ready while the Workload API fails")
+	rw := httptest.NewRecorder()
+	readyzHandler(rw, httptest.NewRequest("GET", "http://localhost:8080/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	assert.Contains(t, rw.Body.String(), "the SPIFFE Workload API is failing")
+	cert, err = config.GetCertificate(nil)
+	if assert.Nil(t, err, "should keep serving the last SVID") {
+		assert.Equal(t, int64(2), cert.Leaf.SerialNumber.Int64())
+	}
+}
+
+func TestSPIFFEWaitForSVID(t *testing.T) {
+	_, address, stop := startFakeWorkloadAPI(t)
+	defer stop()
+	watcher := newSVIDWatcher()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.watch(ctx, address)
+
+	err := watcher.waitForSVID(100 * time.Millisecond)
+	assert.Contains(t, err.Error(), "no SVID was received within 100ms")
+	_, err = watcher.certificate()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// tableAccept asks the apiserver for a Table of the objects, falling back to the list object where
+	// the Table conversion is not supported
+	tableAccept = "application/json;as=Table;v=v1beta1;g=meta.k8s.io, application/json"
+	// tableNameColumn is the column of the object names in the Tables
+	tableNameColumn = "Name"
+)
+
+// table is the part of the meta.k8s.io Table read to count the objects, the rows only carrying the
+// metadata of their object with includeObject=Metadata
+type table struct {
+	Kind              string `json:"kind"`
+	ColumnDefinitions []struct {
+		Name string `json:"name"`
+	} `json:"columnDefinitions"`
+	Rows []struct {
+		Cells  []interface{} `json:"cells"`
+		Object struct {
+			Metadata struct {
+				Name              string  `json:"name"`
+				CreationTimestamp v1.Time `json:"creationTimestamp"`
+			} `json:"metadata"`
+		} `json:"object"`
+	} `json:"rows"`
+}
+
+// tableCounter returns a counter of the resource listing the objects as a Table, so that only their
+// name and age are transferred rather than the full objects. The client is resolved on every call, as
+// the clientset may change.
+func tableCounter(resource string, client func() rest.Interface) func(namespace string) ([]string, error) {
+	return func(namespace string) ([]string, error) {
+		options := counterListOptions()
+		body, err := client().Get().
+			Namespace(namespace).
+			Resource(resource).
+			VersionedParams(&options, scheme.ParameterCodec).
+			Param("includeObject", "Metadata").
+			SetHeader("Accept", tableAccept).
+			DoRaw()
+		if err != nil {
+			return nil, err
+		}
+		return readTableNames(body)
+	}
+}
+
+// readTableNames returns the names of the objects of a Table counted by their age, reading the names
+// of the items of a list object if the apiserver did not convert it
+func readTableNames(body []byte) ([]string, error) {
+	t := table{}
+	if err := json.Unmarshal(body, &t); err != nil {
+		return nil, err
+	}
+	limit := math.MaxInt32
+	if *maxCountedObjects > 0 {
+		limit = *maxCountedObjects + 1
+	}
+	if t.Kind != "Table" {
+		return readListNames(bytes.NewReader(body), limit)
+	}
+	nameColumn := -1
+	for i, c := range t.ColumnDefinitions {
+		if c.Name == tableNameColumn {
+			nameColumn = i
+		}
+	}
+	var names []string
+	for _, row := range t.Rows {
+		if !countedAge(row.Object.Metadata.CreationTimestamp) {
+			continue
+		}
+		name := row.Object.Metadata.Name
+		if nameColumn >= 0 && nameColumn < len(row.Cells) {
+			if cell, ok := row.Cells[nameColumn].(string); ok {
+				name = cell
+			}
+		}
+		names = append(names, name)
+		if len(names) >= limit {
+			break
+		}
+	}
+	return names, nil
+}
+
+// tableCounters returns the counters of the kinds listed as Tables, replacing the regular counters
+// when --countWithTable is set
+func tableCounters(client kubernetes.Interface) map[string]func(namespace string) ([]string, error) {
+	core := func() rest.Interface { return client.CoreV1().RESTClient() }
+	extensions := func() rest.Interface { return client.ExtensionsV1beta1().RESTClient() }
+	apps := func() rest.Interface { return client.AppsV1beta1().RESTClient() }
+	return map[string]func(namespace string) ([]string, error){
+		"pods":                   tableCounter("pods", core),
+		"services":               tableCounter("services", core),
+		"configmaps":             tableCounter("configmaps", core),
+		"persistentvolumeclaims": tableCounter("persistentvolumeclaims", core),
+		"replicasets":            tableCounter("replicasets", extensions),
+		"daemonsets":             tableCounter("daemonsets", extensions),
+		"ingresses":              tableCounter("ingresses", extensions),
+		"deployments":            tableCounter("deployments", apps),
+		"statefulsets":           tableCounter("statefulsets", apps),
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// podTable returns a Table of pods created at the given times
+func podTable(created ...time.Time) string {
+	var rows []string
+	for i, c := range created {
+		rows = append(rows, fmt.Sprintf(`{"cells":["pod-%d","1/1","Running",0,"5d"],"object":{"kind":"PartialObjectMetadata","apiVersion":"meta.k8s.io/v1beta1","metadata":{"name":"pod-%d","namespace":"test-namespace","creationTimestamp":%q}}}`,
+			i, i, c.UTC().Format(time.RFC3339)))
+	}
+	return `{"kind":"Table","apiVersion":"meta.k8s.io/v1beta1","metadata":{"resourceVersion":"1"},"columnDefinitions":[` +
+		`{"name":"Name","type":"string","format":"name"},{"name":"Ready","type":"string"},{"name":"Status","type":"string"},{"name":"Restarts","type":"integer"},{"name":"Age","type":"string"}],` +
+		`"rows":[` + strings.Join(rows, ",") + `]}`
+}
+
+func TestReadTableNames(t *testing.T) {
+	now := time.Now()
+	names, err := readTableNames([]byte(podTable(now.Add(-time.Hour), now.Add(-48*time.Hour))))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pod-0", "pod-1"}, names)
+
+	defer func(cutoff time.Duration) { *resourceAgeCutoff = cutoff }(*resourceAgeCutoff)
+	*resourceAgeCutoff = 24 * time.Hour
+	names, err = readTableNames([]byte(podTable(now.Add(-time.Hour), now.Add(-48*time.Hour))))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pod-0"}, names, "should only count the objects by the age of the rows")
+	*resourceAgeCutoff = 0
+
+	*maxCountedObjects = 1
+	defer func() { *maxCountedObjects = 0 }()
+	names, err = readTableNames([]byte(podTable(now, now, now)))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pod-0", "pod-1"}, names, "should stop reading past the budget")
+	*maxCountedObjects = 0
+
+	names, err = readTableNames([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{},"items":[{"metadata":{"name":"a"}},{"metadata":{"name":"b"}}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, names, "should read the list object if the apiserver did not convert it")
+
+	_, err = readTableNames([]byte(`{"kind":"Table","rows":[`))
+	assert.NotNil(t, err)
+}
+
+func TestTableCounter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/api/v1/namespaces/test-namespace":
+			io.WriteString(rw, `{"kind":"Namespace","apiVersion":"v1","metadata":{"name":"test-namespace"}}`)
+		case "/api/v1/namespaces/test-namespace/pods":
+			assert.Contains(t, req.Header.Get("Accept"), "as=Table")
+			assert.Equal(t, "Metadata", req.URL.Query().Get("includeObject"))
+			io.WriteString(rw, podTable(time.Now(), time.Now()))
+		default:
+			io.WriteString(rw, `{"metadata":{},"items":[]}`)
+		}
+	}))
+	defer srv.Close()
+	*countWithTable = true
+	defer func() { *countWithTable = false }()
+	waitForBackgroundTasks(t)
+	realClientset, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	assert.Nil(t, err)
+	clientset = realClientset
+
+	findings, errList := findNamespaceResources("test-namespace")
+	assert.Empty(t, errList)
+	assert.Equal(t, resourceFinding{Kind: "pods", Count: 2, Names: []string{"pod-0", "pod-1"}}, findings[0])
+
+	rw := httptest.NewRecorder()
+	webhookHandler(rw, httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview))))
+	admReview := getAdmissionReview(rw)
+	assert.False(t, admReview.Status.Allowed)
+	assert.Contains(t, admReview.Status.Result.Message, "[pods(2)]")
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/api/admission/v1alpha1"
+)
+
+const (
+	// the decisions of --timeoutFallback
+	timeoutFallbackAllow = "allow"
+	timeoutFallbackDeny  = "deny"
+)
+
+// deadlineWriter buffers the response of a review running against a deadline, the response is only
+// written out if the review completes in time
+type deadlineWriter struct {
+	sync.Mutex
+	header  http.Header
+	body    bytes.Buffer
+	code    int
+	timeout bool
+	// start is when the handling of the request started
+	start time.Time
+	// phases holds the time spent in the phases of the request
+	phases *phaseTimer
+}
+
+func newDeadlineWriter() *deadlineWriter {
+	return &deadlineWriter{header: http.Header{}}
+}
+
+func (w *deadlineWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *deadlineWriter) WriteHeader(code int) {
+	w.Lock()
+	defer w.Unlock()
+	if !w.timeout && w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.timeout {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.body.Write(b)
+}
+
+// expire discards the writes from now on, it returns false if the response was already written
+func (w *deadlineWriter) expire() bool {
+	w.Lock()
+	defer w.Unlock()
+	if w.body.Len() > 0 {
+		return false
+	}
+	w.timeout = true
+	return true
+}
+
+// expired returns true once the deadline passed without a response
+func (w *deadlineWriter) expired() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.timeout
+}
+
+// flush writes the buffered response out
+func (w *deadlineWriter) flush(rw http.ResponseWriter) {
+	for key, values := range w.header {
+		rw.Header()[key] = values
+	}
+	if w.code != 0 {
+		rw.WriteHeader(w.code)
+	}
+	rw.Write(w.body.Bytes())
+}
+
+// timeoutDecision returns the --timeoutFallback decision for a review that did not complete in time
+func timeoutDecision(namespace string) admissionDecision {
+	message := fmt.Sprintf("The validation of the namespace %s did not complete within %v", namespace, *validationTimeout)
+	if *timeoutFallback == timeoutFallbackAllow {
+		return allow(message + ", allowing it without validation.")
+	}
+	return internalError(message + ". Please try again.")
+}
+
+// reviewWithDeadline validates the admission review, responding with the --timeoutFallback decision
+// if the --validationTimeout elapses or the apiserver gives up on the request first. The counters
+// can't be cancelled, the review keeps running in the background and its late decision is discarded.
+func (v *validator) reviewWithDeadline(rw http.ResponseWriter, req *http.Request, admReview v1alpha1.AdmissionReview) {
+	ctx, cancel := context.WithTimeout(req.Context(), *validationTimeout)
+	defer cancel()
+
+	w := newDeadlineWriter()
+	w.start = requestStart(rw)
+	w.phases = requestPhases(rw)
+	done := make(chan struct{})
+	// the review sets the status of its own copy
+	fallbackReview := admReview
+	go func() {
+		defer close(done)
+		v.review(w, admReview)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if w.expire() {
+			validationTimeoutsTotal.Inc()
+			log.Errorf("The validation of %s on namespace %s was interrupted: %s. Responding with the %s timeout fallback.", admReview.Spec.Operation, admReview.Spec.Name, ctx.Err(), *timeoutFallback)
+			v.respond(rw, &fallbackReview, timeoutDecision(admReview.Spec.Name))
+			return
+		}
+		// the decision was written just in time
+		<-done
+	}
+	w.flush(rw)
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestDeadlineWriter(t *testing.T) {
+	w := newDeadlineWriter()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+	assert.False(t, w.expire(), "should not expire once the response was written")
+
+	rw := httptest.NewRecorder()
+	w.flush(rw)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "{}", rw.Body.String())
+
+	w = newDeadlineWriter()
+	assert.True(t, w.expire())
+	_, err := w.Write([]byte("{}"))
+	assert.Equal(t, http.ErrHandlerTimeout, err, "should discard the writes once expired")
+}
+
+func TestValidationTimeoutWebhookHandler(t *testing.T) {
+	*validationTimeout = 50 * time.Millisecond
+	defer func() {
+		*validationTimeout = 0
+		*timeoutFallback = timeoutFallbackDeny
+	}()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	fakeClientset := fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	fakeClientset.PrependReactor("list", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		<-unblock
+		return false, nil, nil
+	})
+	clientset = fakeClientset
+
+	review := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		return rw
+	}
+
+	admReview := getAdmissionReview(review())
+	assert.False(t, admReview.Status.Allowed, "should deny on timeout by default")
+	assert.Equal(t, v1.StatusReasonInternalError, admReview.Status.Result.Reason)
+	assert.Contains(t, admReview.Status.Result.Message, "The validation of the namespace test-namespace did not complete within 50ms")
+
+	*timeoutFallback = timeoutFallbackAllow
+	admReview = getAdmissionReview(review())
+	assert.True(t, admReview.Status.Allowed, "should allow on timeout with timeoutFallback=allow")
+
+	*validationTimeout = time.Minute
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	rw := review()
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	admReview = getAdmissionReview(rw)
+	assert.True(t, admReview.Status.Allowed, "should respond with the decision of a review completing in time")
+	assert.Empty(t, admReview.Status.Result.Message)
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	timeseriesMeasurement = "namespace_deletion"
+	timeseriesTimeout     = 10 * time.Second
+	// timeseriesMaxPoints bounds the points buffered while the endpoint is unavailable, the oldest
+	// points are dropped first
+	timeseriesMaxPoints = 10000
+)
+
+var (
+	// timeseries batches the deletion attempts written to --timeseriesEndpoint, nil when not set
+	timeseries *timeseriesWriter
+
+	// tagEscaper escapes the InfluxDB line protocol special characters of tag keys and values
+	tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// deletionAttempt is a namespace deletion decision recorded in the time-series database
+type deletionAttempt struct {
+	Namespace string
+	User      string
+	Profile   string
+	Allowed   bool
+	Findings  []resourceFinding
+	Timestamp time.Time
+}
+
+// line returns the attempt in the InfluxDB line protocol, with the outcome as a tag and the resource
+// counts per kind as integer fields
+func (a deletionAttempt) line() string {
+	outcome := "denied"
+	if a.Allowed {
+		outcome = "allowed"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s,namespace=%s,outcome=%s,profile=%s,user=%s ", timeseriesMeasurement,
+		tagEscaper.Replace(a.Namespace), outcome, tagEscaper.Replace(a.Profile), tagEscaper.Replace(a.User))
+
+	fields := []string{fmt.Sprintf("resources=%di", totalResourceCount(a.Findings))}
+	for _, f := range a.Findings {
+		fields = append(fields, fmt.Sprintf("%s=%di", tagEscaper.Replace(f.Kind), f.Count))
+	}
+	sort.Strings(fields[1:])
+	buf.WriteString(strings.Join(fields, ","))
+	fmt.Fprintf(&buf, " %d", a.Timestamp.UnixNano())
+	return buf.String()
+}
+
+// timeseriesWriter buffers the deletion attempts and writes them to an InfluxDB compatible /write
+// endpoint in batches
+type timeseriesWriter struct {
+	sync.Mutex
+	endpoint string
+	db       string
+	token    string
+	client   *http.Client
+	points   []string
+}
+
+// newTimeseriesWriter returns a writer to the database of the endpoint, authenticated with the token if set
+func newTimeseriesWriter(endpoint, db, token string) *timeseriesWriter {
+	return &timeseriesWriter{
+		endpoint: endpoint,
+		db:       db,
+		token:    token,
+		client:   &http.Client{Timeout: timeseriesTimeout},
+	}
+}
+
+// trimLocked drops the oldest points above timeseriesMaxPoints
+func (w *timeseriesWriter) trimLocked() {
+	if dropped := len(w.points) - timeseriesMaxPoints; dropped > 0 {
+		log.Warnf("Dropping %d deletion attempts buffered for the time-series endpoint %s", dropped, w.endpoint)
+		w.points = w.points[dropped:]
+	}
+}
+
+// record buffers the deletion attempt until the next flush
+func (w *timeseriesWriter) record(attempt deletionAttempt) {
+	w.Lock()
+	defer w.Unlock()
+	w.points = append(w.points, attempt.line())
+	w.trimLocked()
+}
+
+// flush writes the buffered points in a single request. The points are kept for the next flush if the
+// endpoint is unavailable.
+func (w *timeseriesWriter) flush() error {
+	w.Lock()
+	points := w.points
+	w.points = nil
+	w.Unlock()
+	if len(points) == 0 {
+		return nil
+	}
+
+	err := w.write(points)
+	if err != nil {
+		// keep the points ahead of those recorded meanwhile
+		w.Lock()
+		w.points = append(points, w.points...)
+		w.trimLocked()
+		w.Unlock()
+	}
+	return err
+}
+
+// write posts the points to the endpoint
+func (w *timeseriesWriter) write(points []string) error {
+	query := url.Values{"db": {w.db}, "precision": {"ns"}}
+	body := strings.NewReader(strings.Join(points, "\n") + "\n")
+	req, err := http.NewRequest(http.MethodPost, w.endpoint+"/write?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// run flushes the points every interval until the stop channel is closed, errors are only logged
+func (w *timeseriesWriter) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.flush(); err != nil {
+				log.Errorf("Error occurred while writing the deletion attempts to %s: %s", w.endpoint, err.Error())
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// recordDeletionAttempt buffers the deletion decision for the time-series database if
+// --timeseriesEndpoint is set
+func recordDeletionAttempt(namespace, user, profile string, allowed bool, findings []resourceFinding) {
+	if timeseries == nil {
+		return
+	}
+	timeseries.record(deletionAttempt{
+		Namespace: namespace,
+		User:      user,
+		Profile:   profile,
+		Allowed:   allowed,
+		Findings:  findings,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// timeseriesRequest is a write received by the mock time-series endpoint
+type timeseriesRequest struct {
+	path  string
+	query string
+	auth  string
+	body  string
+}
+
+// mockTimeseriesEndpoint returns a server recording the writes and responding with the status
+func mockTimeseriesEndpoint(status int) (*httptest.Server, chan timeseriesRequest) {
+	requests := make(chan timeseriesRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests <- timeseriesRequest{req.URL.Path, req.URL.RawQuery, req.Header.Get("Authorization"), string(body)}
+		rw.WriteHeader(status)
+	}))
+	return server, requests
+}
+
+func TestDeletionAttemptLine(t *testing.T) {
+	attempt := deletionAttempt{
+		Namespace: "test-namespace",
+		User:      "system:serviceaccount:ci,deployer",
+		Profile:   "default",
+		Allowed:   false,
+		Findings:  []resourceFinding{{Kind: "services", Count: 1}, {Kind: "pods", Count: 2}},
+		Timestamp: time.Unix(1504224000, 0),
+	}
+	assert.Equal(t, `namespace_deletion,namespace=test-namespace,outcome=denied,profile=default,user=system:serviceaccount:ci\,deployer resources=3i,pods=2i,services=1i 1504224000000000000`, attempt.line())
+
+	attempt.Allowed, attempt.Findings = true, nil
+	assert.Contains(t, attempt.line(), ",outcome=allowed,")
+	assert.Contains(t, attempt.line(), " resources=0i ")
+}
+
+func TestTimeseriesWriterFlush(t *testing.T) {
+	server, requests := mockTimeseriesEndpoint(http.StatusNoContent)
+	defer server.Close()
+
+	w := newTimeseriesWriter(server.URL, "guard", "secret-token")
+	assert.Nil(t, w.flush(), "should not write an empty batch")
+	assert.Len(t, requests, 0)
+
+	w.record(deletionAttempt{Namespace: "ns-a", User: "alice", Profile: "default", Timestamp: time.Unix(1, 0)})
+	w.record(deletionAttempt{Namespace: "ns-b", User: "bob", Profile: "default", Allowed: true, Timestamp: time.Unix(2, 0)})
+	assert.Nil(t, w.flush())
+
+	r := <-requests
+	assert.Equal(t, "/write", r.path)
+	assert.Equal(t, "db=guard&precision=ns", r.query)
+	assert.Equal(t, "Token secret-token", r.auth)
+	assert.Equal(t, []string{
+		"namespace_deletion,namespace=ns-a,outcome=denied,profile=default,user=alice resources=0i 1000000000",
+		"namespace_deletion,namespace=ns-b,outcome=allowed,profile=default,user=bob resources=0i 2000000000",
+	}, strings.Split(strings.TrimSpace(r.body), "\n"))
+	assert.Empty(t, w.points, "should clear the written points")
+}
+
+func TestTimeseriesWriterUnavailable(t *testing.T) {
+	server, requests := mockTimeseriesEndpoint(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	w := newTimeseriesWriter(server.URL, "guard", "")
+	w.record(deletionAttempt{Namespace: "ns-a", Timestamp: time.Unix(1, 0)})
+	assert.NotNil(t, w.flush())
+	assert.Equal(t, "", (<-requests).auth)
+	assert.Len(t, w.points, 1, "should keep the points for the next flush")
+
+	server.Close()
+	w.record(deletionAttempt{Namespace: "ns-b", Timestamp: time.Unix(2, 0)})
+	assert.NotNil(t, w.flush())
+	assert.Len(t, w.points, 2)
+	assert.Contains(t, w.points[0], "namespace=ns-a", "should keep the oldest points first")
+}
+
+func TestDeletionAttemptWebhookHandler(t *testing.T) {
+	timeseries = newTimeseriesWriter("http://localhost", "guard", "")
+	defer func() { timeseries = nil }()
+
+	testPod := &corev1.Pod{}
+	testPod.Name = "test-pod"
+	testPod.Namespace = "test-namespace"
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace), testPod)
+	testSpec := cloneAdmissionReview(templateAdmReview)
+	testSpec.Spec.UserInfo.Username = "alice"
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(testSpec))
+	webhookHandler(rw, req)
+	assert.False(t, getAdmissionReview(rw).Status.Allowed, "should reject if the namespace has workload resources")
+
+	assert.Len(t, timeseries.points, 1)
+	assert.Regexp(t, "^namespace_deletion,namespace=test-namespace,outcome=denied,profile=default,user=alice resources=1i,.*pods=1i", timeseries.points[0])
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// volumeReclaim classifies the PersistentVolumes bound to the claims of a namespace by what becomes of
+// them once the namespace and its claims are deleted
+type volumeReclaim struct {
+	// Destroyed are the volumes whose data is deleted or scrubbed, by the Delete or Recycle reclaim policies
+	Destroyed []string
+	// Orphaned are the volumes left Released with their data by the Retain reclaim policy, until an
+	// administrator reclaims them
+	Orphaned []string
+}
+
+// classifyVolumes returns the PersistentVolumes bound to the claims of the namespace by reclaim policy.
+// The apiserver does not support field selectors on the claimRef, so all the volumes are listed.
+func classifyVolumes(namespace string) (volumeReclaim, error) {
+	var reclaim volumeReclaim
+	list, err := clientset.CoreV1().PersistentVolumes().List(v1.ListOptions{})
+	if err != nil {
+		return reclaim, err
+	}
+	for _, pv := range list.Items {
+		// the volumes already Released or Failed are not affected by the deletion
+		if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != namespace || pv.Status.Phase != corev1.VolumeBound {
+			continue
+		}
+		if pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain {
+			reclaim.Orphaned = append(reclaim.Orphaned, pv.Name)
+		} else {
+			reclaim.Destroyed = append(reclaim.Destroyed, pv.Name)
+		}
+	}
+	sort.Strings(reclaim.Destroyed)
+	sort.Strings(reclaim.Orphaned)
+	return reclaim, nil
+}
+
+// message returns the report of the volumes reclaimed with the namespace, empty if there are none
+func (r volumeReclaim) message(namespace string) string {
+	var parts []string
+	if len(r.Destroyed) > 0 {
+		parts = append(parts, fmt.Sprintf("destroys the data of the PersistentVolumes %v", r.Destroyed))
+	}
+	if len(r.Orphaned) > 0 {
+		parts = append(parts, fmt.Sprintf("leaves the PersistentVolumes %v Released with their data, to be reclaimed manually", r.Orphaned))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Deleting the namespace %s %s.", namespace, strings.Join(parts, " and "))
+}
+
+// volumeReclaimWarning returns the warning reporting the PersistentVolumes destroyed or orphaned by
+// the deletion of the namespace with --reportVolumeReclaim, empty otherwise. The report is informational,
+// a failure to list the volumes is only logged.
+func volumeReclaimWarning(namespace string) string {
+	if !*reportVolumeReclaim {
+		return ""
+	}
+	reclaim, err := classifyVolumes(namespace)
+	if err != nil {
+		log.Errorf("Error occurred while listing the PersistentVolumes of namespace %s: %s", namespace, err.Error())
+		return ""
+	}
+	return reclaim.message(namespace)
+}
+
+// joinWarnings joins the non-empty warnings with a space
+func joinWarnings(warnings ...string) string {
+	var nonEmpty []string
+	for _, w := range warnings {
+		if w != "" {
+			nonEmpty = append(nonEmpty, w)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
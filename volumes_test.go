@@ -0,0 +1,77 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// newClaimedVolume returns a PersistentVolume in the phase claimed from the namespace with the reclaim policy
+func newClaimedVolume(name, namespace string, policy corev1.PersistentVolumeReclaimPolicy, phase corev1.PersistentVolumePhase) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: v1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef:                      &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: name, Namespace: namespace},
+			PersistentVolumeReclaimPolicy: policy,
+		},
+		Status: corev1.PersistentVolumeStatus{Phase: phase},
+	}
+}
+
+// volumesClientset returns a clientset holding a mix of Retain, Delete and Recycle volumes
+func volumesClientset() *fake.Clientset {
+	return fake.NewSimpleClientset(
+		cloneNamespace(templateNamespace),
+		newClaimedVolume("postgres-data", "test-namespace", corev1.PersistentVolumeReclaimRetain, corev1.VolumeBound),
+		newClaimedVolume("cache", "test-namespace", corev1.PersistentVolumeReclaimDelete, corev1.VolumeBound),
+		newClaimedVolume("scratch", "test-namespace", corev1.PersistentVolumeReclaimRecycle, corev1.VolumeBound),
+		newClaimedVolume("archive", "test-namespace", corev1.PersistentVolumeReclaimDelete, corev1.VolumeBound),
+		newClaimedVolume("old-data", "test-namespace", corev1.PersistentVolumeReclaimRetain, corev1.VolumeReleased),
+		newClaimedVolume("other-data", "other-namespace", corev1.PersistentVolumeReclaimDelete, corev1.VolumeBound),
+		&corev1.PersistentVolume{ObjectMeta: v1.ObjectMeta{Name: "unclaimed"}, Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeAvailable}},
+	)
+}
+
+func TestClassifyVolumes(t *testing.T) {
+	clientset = volumesClientset()
+	reclaim, err := classifyVolumes("test-namespace")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"archive", "cache", "scratch"}, reclaim.Destroyed)
+	assert.Equal(t, []string{"postgres-data"}, reclaim.Orphaned, "should ignore the volumes no longer bound")
+	assert.Equal(t, "Deleting the namespace test-namespace destroys the data of the PersistentVolumes [archive cache scratch] and leaves the PersistentVolumes [postgres-data] Released with their data, to be reclaimed manually.", reclaim.message("test-namespace"))
+
+	assert.Equal(t, "Deleting the namespace test-namespace leaves the PersistentVolumes [postgres-data] Released with their data, to be reclaimed manually.", volumeReclaim{Orphaned: []string{"postgres-data"}}.message("test-namespace"))
+	assert.Equal(t, "", volumeReclaim{}.message("test-namespace"))
+}
+
+func TestReportVolumeReclaimWebhookHandler(t *testing.T) {
+	review := func() (bool, string) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost:8080/", constructPostBody(cloneAdmissionReview(templateAdmReview)))
+		webhookHandler(rw, req)
+		admReview := getAdmissionReview(rw)
+		return admReview.Status.Allowed, admReview.Status.Result.Message
+	}
+
+	clientset = volumesClientset()
+	allowed, message := review()
+	assert.True(t, allowed)
+	assert.Equal(t, "", message, "should not report the volumes unless reportVolumeReclaim is set")
+
+	*reportVolumeReclaim = true
+	defer func() { *reportVolumeReclaim = false }()
+	allowed, message = review()
+	assert.True(t, allowed, "should only report the volumes")
+	assert.Equal(t, "Deleting the namespace test-namespace destroys the data of the PersistentVolumes [archive cache scratch] and leaves the PersistentVolumes [postgres-data] Released with their data, to be reclaimed manually.", message)
+
+	clientset = fake.NewSimpleClientset(cloneNamespace(templateNamespace))
+	allowed, message = review()
+	assert.True(t, allowed)
+	assert.Equal(t, "", message, "should not warn without claimed volumes")
+}
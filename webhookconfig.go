@@ -0,0 +1,182 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionregistrationv1alpha1 "k8s.io/client-go/pkg/apis/admissionregistration/v1alpha1"
+)
+
+const (
+	// webhookConfigCheckInterval is how often the webhook configuration is inspected
+	webhookConfigCheckInterval = time.Minute
+)
+
+// webhookConfigChecker inspects the ExternalAdmissionHookConfiguration registering the webhook
+type webhookConfigChecker struct {
+	// name of the ExternalAdmissionHookConfiguration
+	name string
+	// service the admission hooks must reference, as <namespace>/<name>
+	service string
+	// servingCert is the leaf certificate the caBundle must verify, the caBundle is not checked when
+	// nil, e.g. with --insecureHTTP
+	servingCert *x509.Certificate
+	// caBundle replaces a stale caBundle on repair, it is never repaired when empty
+	caBundle []byte
+	// repair is true to update misconfigured hooks
+	repair bool
+}
+
+// referencesService returns true if the hook sends its requests to the checked service
+func (c *webhookConfigChecker) referencesService(hook admissionregistrationv1alpha1.ExternalAdmissionHook) bool {
+	service := hook.ClientConfig.Service
+	return service.Namespace+"/"+service.Name == c.service
+}
+
+// verifiesServingCert returns true if the CA bundle verifies the serving certificate
+func (c *webhookConfigChecker) verifiesServingCert(caBundle []byte) bool {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return false
+	}
+	_, err := c.servingCert.Verify(x509.VerifyOptions{Roots: pool})
+	return err == nil
+}
+
+// matchesAny returns true if the values contain the value or the * wildcard
+func matchesAny(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasNamespaceDeleteRule returns true if the hook intercepts namespace deletions
+func hasNamespaceDeleteRule(hook admissionregistrationv1alpha1.ExternalAdmissionHook) bool {
+	for _, rule := range hook.Rules {
+		var operations []string
+		for _, op := range rule.Operations {
+			operations = append(operations, string(op))
+		}
+		if matchesAny(operations, string(admissionregistrationv1alpha1.Delete)) &&
+			matchesAny(rule.APIGroups, "") &&
+			matchesAny(rule.APIVersions, "v1") &&
+			matchesAny(rule.Resources, "namespaces") {
+			return true
+		}
+	}
+	return false
+}
+
+// problems returns the discrepancies of the hooks referencing the service
+func (c *webhookConfigChecker) problems(config *admissionregistrationv1alpha1.ExternalAdmissionHookConfiguration) []string {
+	var problems []string
+	found := false
+	for _, hook := range config.ExternalAdmissionHooks {
+		if !c.referencesService(hook) {
+			continue
+		}
+		found = true
+		if hook.FailurePolicy == nil || *hook.FailurePolicy != admissionregistrationv1alpha1.Fail {
+			problems = append(problems, fmt.Sprintf("hook %s does not have failurePolicy %s, namespace deletions are allowed whenever the webhook is unreachable", hook.Name, admissionregistrationv1alpha1.Fail))
+		}
+		if c.servingCert != nil && !c.verifiesServingCert(hook.ClientConfig.CABundle) {
+			problems = append(problems, fmt.Sprintf("hook %s caBundle does not verify the serving certificate", hook.Name))
+		}
+		if !hasNamespaceDeleteRule(hook) {
+			problems = append(problems, fmt.Sprintf("hook %s rules do not include DELETE on v1 namespaces", hook.Name))
+		}
+	}
+	if !found {
+		problems = append(problems, fmt.Sprintf("no admission hook references the service %s", c.service))
+	}
+	return problems
+}
+
+// repairHooks fixes the failurePolicy, caBundle and rules of the hooks referencing the service. It
+// returns true if a hook was changed.
+func (c *webhookConfigChecker) repairHooks(config *admissionregistrationv1alpha1.ExternalAdmissionHookConfiguration) bool {
+	changed := false
+	for i := range config.ExternalAdmissionHooks {
+		hook := &config.ExternalAdmissionHooks[i]
+		if !c.referencesService(*hook) {
+			continue
+		}
+		if hook.FailurePolicy == nil || *hook.FailurePolicy != admissionregistrationv1alpha1.Fail {
+			fail := admissionregistrationv1alpha1.Fail
+			hook.FailurePolicy = &fail
+			changed = true
+		}
+		if len(c.caBundle) > 0 && c.servingCert != nil && !c.verifiesServingCert(hook.ClientConfig.CABundle) {
+			hook.ClientConfig.CABundle = c.caBundle
+			changed = true
+		}
+		if !hasNamespaceDeleteRule(*hook) {
+			hook.Rules = append(hook.Rules, admissionregistrationv1alpha1.RuleWithOperations{
+				Operations: []admissionregistrationv1alpha1.OperationType{admissionregistrationv1alpha1.Delete},
+				Rule: admissionregistrationv1alpha1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"namespaces"},
+				},
+			})
+			changed = true
+		}
+	}
+	return changed
+}
+
+// check inspects the webhook configuration, logging every discrepancy, repairing them if enabled, and
+// sets the namespace_guard_webhook_config_ok gauge. It returns the discrepancies found.
+func (c *webhookConfigChecker) check() []string {
+	hooks := clientset.AdmissionregistrationV1alpha1().ExternalAdmissionHookConfigurations()
+	config, err := hooks.Get(c.name, v1.GetOptions{})
+	if err != nil {
+		problems := []string{fmt.Sprintf("error retrieving the ExternalAdmissionHookConfiguration %s: %v", c.name, err)}
+		log.Errorf("Webhook configuration check failed: %s", problems[0])
+		webhookConfigOK.Set(0)
+		return problems
+	}
+
+	problems := c.problems(config)
+	for _, problem := range problems {
+		log.Errorf("Webhook configuration %s is misconfigured: %s", c.name, problem)
+	}
+	if len(problems) > 0 && c.repair && c.repairHooks(config) {
+		config, err = hooks.Update(config)
+		if err != nil {
+			log.Errorf("Error occurred while repairing the webhook configuration %s: %s", c.name, err.Error())
+		} else {
+			log.Warnf("Repaired the webhook configuration %s", c.name)
+			problems = c.problems(config)
+		}
+	}
+
+	if len(problems) == 0 {
+		webhookConfigOK.Set(1)
+	} else {
+		webhookConfigOK.Set(0)
+	}
+	return problems
+}
+
+// watch periodically checks the webhook configuration until the stop channel is closed
+func (c *webhookConfigChecker) watch(stopCh <-chan struct{}) {
+	c.check()
+	ticker := time.NewTicker(webhookConfigCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.check()
+		case <-stopCh:
+			return
+		}
+	}
+}
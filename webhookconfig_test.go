@@ -0,0 +1,169 @@
+// Copyright 2017 Yahoo Holdings Inc.
+// Licensed under the terms of the 3-Clause BSD License.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	admissionregistrationv1alpha1 "k8s.io/client-go/pkg/apis/admissionregistration/v1alpha1"
+)
+
+// newWebhookConfigChecker returns a checker of the test-webhook configuration with a generated
+// serving certificate and its PEM bundle
+func newWebhookConfigChecker(t *testing.T) (*webhookConfigChecker, []byte) {
+	bundle := generateCert(t, time.Now().Add(24*time.Hour))
+	block, _ := pem.Decode(bundle)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+	return &webhookConfigChecker{
+		name:        "test-webhook",
+		service:     "default/k8s-namespace-guard",
+		servingCert: cert,
+	}, bundle
+}
+
+// validWebhookConfig returns a webhook configuration without discrepancies for the CA bundle
+func validWebhookConfig(caBundle []byte) *admissionregistrationv1alpha1.ExternalAdmissionHookConfiguration {
+	fail := admissionregistrationv1alpha1.Fail
+	return &admissionregistrationv1alpha1.ExternalAdmissionHookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "test-webhook"},
+		ExternalAdmissionHooks: []admissionregistrationv1alpha1.ExternalAdmissionHook{
+			{
+				Name: "k8s-namespace-guard.yahoo.io",
+				ClientConfig: admissionregistrationv1alpha1.AdmissionHookClientConfig{
+					Service:  admissionregistrationv1alpha1.ServiceReference{Namespace: "default", Name: "k8s-namespace-guard"},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistrationv1alpha1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1alpha1.OperationType{admissionregistrationv1alpha1.Delete},
+						Rule: admissionregistrationv1alpha1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"namespaces"},
+						},
+					},
+				},
+				FailurePolicy: &fail,
+			},
+		},
+	}
+}
+
+func webhookConfigOKValue() float64 {
+	m := &dto.Metric{}
+	webhookConfigOK.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestWebhookConfigOK(t *testing.T) {
+	checker, bundle := newWebhookConfigChecker(t)
+	clientset = fake.NewSimpleClientset(validWebhookConfig(bundle))
+
+	assert.Empty(t, checker.check())
+	assert.Equal(t, float64(1), webhookConfigOKValue())
+}
+
+func TestWebhookConfigWithoutServingCert(t *testing.T) {
+	checker, _ := newWebhookConfigChecker(t)
+	_, otherBundle := newWebhookConfigChecker(t)
+	checker.servingCert = nil
+	checker.caBundle = otherBundle
+	checker.repair = true
+	clientset = fake.NewSimpleClientset(validWebhookConfig([]byte("mesh CA")))
+
+	assert.Empty(t, checker.check(), "should not check the caBundle without a serving certificate")
+	config, err := clientset.AdmissionregistrationV1alpha1().ExternalAdmissionHookConfigurations().Get("test-webhook", v1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("mesh CA"), config.ExternalAdmissionHooks[0].ClientConfig.CABundle, "should not repair the caBundle")
+}
+
+func TestWebhookConfigNotFound(t *testing.T) {
+	checker, _ := newWebhookConfigChecker(t)
+	clientset = fake.NewSimpleClientset()
+
+	problems := checker.check()
+	assert.Equal(t, 1, len(problems))
+	assert.Contains(t, problems[0], "error retrieving the ExternalAdmissionHookConfiguration test-webhook")
+	assert.Equal(t, float64(0), webhookConfigOKValue())
+}
+
+func TestWebhookConfigMisconfigurations(t *testing.T) {
+	checker, bundle := newWebhookConfigChecker(t)
+	_, otherBundle := newWebhookConfigChecker(t)
+	ignore := admissionregistrationv1alpha1.Ignore
+
+	tests := []struct {
+		name    string
+		mutate  func(hook *admissionregistrationv1alpha1.ExternalAdmissionHook)
+		problem string
+	}{
+		{
+			"ignore failure policy",
+			func(hook *admissionregistrationv1alpha1.ExternalAdmissionHook) { hook.FailurePolicy = &ignore },
+			"hook k8s-namespace-guard.yahoo.io does not have failurePolicy Fail, namespace deletions are allowed whenever the webhook is unreachable",
+		},
+		{
+			"unset failure policy",
+			func(hook *admissionregistrationv1alpha1.ExternalAdmissionHook) { hook.FailurePolicy = nil },
+			"hook k8s-namespace-guard.yahoo.io does not have failurePolicy Fail, namespace deletions are allowed whenever the webhook is unreachable",
+		},
+		{
+			"stale caBundle",
+			func(hook *admissionregistrationv1alpha1.ExternalAdmissionHook) {
+				hook.ClientConfig.CABundle = otherBundle
+			},
+			"hook k8s-namespace-guard.yahoo.io caBundle does not verify the serving certificate",
+		},
+		{
+			"wrong rules",
+			func(hook *admissionregistrationv1alpha1.ExternalAdmissionHook) {
+				hook.Rules[0].Operations = []admissionregistrationv1alpha1.OperationType{admissionregistrationv1alpha1.Create}
+			},
+			"hook k8s-namespace-guard.yahoo.io rules do not include DELETE on v1 namespaces",
+		},
+		{
+			"other service",
+			func(hook *admissionregistrationv1alpha1.ExternalAdmissionHook) {
+				hook.ClientConfig.Service.Name = "other"
+			},
+			"no admission hook references the service default/k8s-namespace-guard",
+		},
+	}
+	for _, test := range tests {
+		config := validWebhookConfig(bundle)
+		test.mutate(&config.ExternalAdmissionHooks[0])
+		clientset = fake.NewSimpleClientset(config)
+
+		assert.Equal(t, []string{test.problem}, checker.check(), test.name)
+		assert.Equal(t, float64(0), webhookConfigOKValue(), test.name)
+	}
+}
+
+func TestWebhookConfigRepair(t *testing.T) {
+	checker, bundle := newWebhookConfigChecker(t)
+	_, otherBundle := newWebhookConfigChecker(t)
+	checker.repair = true
+	checker.caBundle = bundle
+
+	config := validWebhookConfig(otherBundle)
+	config.ExternalAdmissionHooks[0].FailurePolicy = nil
+	config.ExternalAdmissionHooks[0].Rules = nil
+	clientset = fake.NewSimpleClientset(config)
+
+	assert.Empty(t, checker.check())
+	assert.Equal(t, float64(1), webhookConfigOKValue())
+
+	repaired, err := clientset.AdmissionregistrationV1alpha1().ExternalAdmissionHookConfigurations().Get("test-webhook", v1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, admissionregistrationv1alpha1.Fail, *repaired.ExternalAdmissionHooks[0].FailurePolicy)
+	assert.Equal(t, bundle, repaired.ExternalAdmissionHooks[0].ClientConfig.CABundle)
+	assert.True(t, hasNamespaceDeleteRule(repaired.ExternalAdmissionHooks[0]))
+}